@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/spf13/cobra"
+)
+
+var crdCmd = &cobra.Command{
+	Use:     "crd",
+	Aliases: []string{"print-crd"},
+	Short:   "Print the APIKey CustomResourceDefinition",
+	Long: `Print the CustomResourceDefinition for apikeys.auth.kgateway.dev.
+
+Bootstrap a cluster with:
+  apikey-manager-client crd | kubectl apply -f -`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, err := fmt.Fprint(cmd.OutOrStdout(), apikey.CRDYAML())
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(crdCmd)
+}
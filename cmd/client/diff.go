@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	diffDir        string
+	diffNamespace  string
+	diffKubeconfig string
+	diffContext    string
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare a directory of APIKey YAML with the cluster",
+	Long: `Compare the APIKey resources of the YAML and JSON files of a directory
+(desired) with those of a namespace in the cluster (actual), and report the
+keys applying the directory would add, update or delete. Keys only differ by
+their hashes and enabled state; resources without a namespace belong to
+--namespace.
+
+  apikey-manager-client diff --dir ./keys -n team-a
+
+Exits non-zero when the cluster differs from the directory.`,
+	Args: cobra.NoArgs,
+	RunE: runDiff,
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffDir, "dir", "", "Directory of APIKey YAML or JSON files, not read recursively (required)")
+	diffCmd.Flags().StringVarP(&diffNamespace, "namespace", "n", "default", "Namespace to compare")
+	diffCmd.Flags().StringVar(&diffKubeconfig, "kubeconfig", defaultKubeconfig(), "Path to kubeconfig file (empty = in-cluster config)")
+	diffCmd.Flags().StringVar(&diffContext, "kube-context", "", "Kubeconfig context to use (empty = current context)")
+	if err := diffCmd.MarkFlagRequired("dir"); err != nil {
+		panic(fmt.Sprintf("Failed to mark dir flag as required: %v", err))
+	}
+
+	rootCmd.AddCommand(diffCmd)
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	config, err := kube.RESTConfig(diffKubeconfig, diffContext)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	diffs, err := kube.DiffAPIKeys(cmd.Context(), client, diffDir, diffNamespace)
+	if err != nil {
+		return err
+	}
+
+	writeDiffs(cmd.OutOrStdout(), diffs)
+	if len(diffs) > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d keys differ", len(diffs))
+	}
+	return nil
+}
+
+// writeDiffs prints one line per difference, prefixed like a diff
+func writeDiffs(w io.Writer, diffs []kube.KeyDiff) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+	for _, diff := range diffs {
+		switch diff.Change {
+		case kube.KeyToAdd:
+			fmt.Fprintf(w, "+ %s\n", diff.Name)
+		case kube.KeyToDelete:
+			fmt.Fprintf(w, "- %s\n", diff.Name)
+		default:
+			fmt.Fprintf(w, "~ %s: %s\n", diff.Name, strings.Join(diff.Reasons, ", "))
+		}
+	}
+}
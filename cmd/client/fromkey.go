@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/spf13/cobra"
+)
+
+var providedKey string
+
+var fromKeyCmd = &cobra.Command{
+	Use:   "from-key",
+	Short: "Generate the APIKey resource for an existing key",
+	Long: `Generate the APIKey resource for a key generated by another system.
+
+The key is hashed and hinted exactly as a generated key would be, and only
+the YAML is printed; the key itself is never echoed. Use --key - to read the
+key from stdin instead of the command line:
+
+  apikey-manager-client from-key --key - -e user@example.com < key.txt | kubectl apply -f -`,
+	Args: cobra.NoArgs,
+	RunE: runFromKey,
+}
+
+func init() {
+	fromKeyCmd.Flags().StringVar(&providedKey, "key", "", "Existing API key, or - to read it from stdin (required)")
+	if err := fromKeyCmd.MarkFlagRequired("key"); err != nil {
+		panic(fmt.Sprintf("Failed to mark key flag as required: %v", err))
+	}
+	addSpecFlags(fromKeyCmd)
+	if err := fromKeyCmd.MarkFlagRequired("email"); err != nil {
+		panic(fmt.Sprintf("Failed to mark email flag as required: %v", err))
+	}
+	addKeySizeFlags(fromKeyCmd)
+
+	rootCmd.AddCommand(fromKeyCmd)
+}
+
+func runFromKey(cmd *cobra.Command, args []string) error {
+	if err := apikey.ValidateEmailWithOptions(email, emailOpts); err != nil {
+		return err
+	}
+	if err := validateNamespace(); err != nil {
+		return err
+	}
+
+	key := providedKey
+	if key == "-" {
+		line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read key from stdin: %w", err)
+		}
+		key = strings.TrimSpace(line)
+	}
+
+	if err := apikey.ValidateAPIKey(key, apikey.Config{NumBytes: keyBytes, MinKeyBits: minKeyBits}); err != nil {
+		return err
+	}
+
+	spec, err := buildSpec(cmd, key, email)
+	if err != nil {
+		return err
+	}
+
+	yaml, err := apikey.GenerateYAMLInNamespace(namespace, spec)
+	if err != nil {
+		return fmt.Errorf("failed to generate YAML: %w", err)
+	}
+	if err := verifyYAML(yaml, key); err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(cmd.OutOrStdout(), yaml)
+	return err
+}
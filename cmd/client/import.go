@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importFormat string
+	importFile   string
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Generate APIKey resources for keys exported from another system",
+	Long: `Generate APIKey resources from a CSV export of existing keys, with rows of
+email,keyHash,keyHint[,enabled]. The provided hashes are kept, so the keys
+keep working; no key is generated. The header row is optional and enabled
+defaults to true. Nothing is printed unless every row is valid; malformed
+rows are reported with their line numbers.
+
+  apikey-manager-client import --format csv --file keys.csv | kubectl apply -f -`,
+	Args: cobra.NoArgs,
+	RunE: runImport,
+}
+
+func init() {
+	importCmd.Flags().StringVar(&importFormat, "format", "csv", "Format of the import file (csv)")
+	importCmd.Flags().StringVarP(&importFile, "file", "f", "", "File to import, or - to read stdin (required)")
+	importCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace set in the metadata of the generated resources (empty = the namespace kubectl applies them to)")
+	if err := importCmd.MarkFlagRequired("file"); err != nil {
+		panic(fmt.Sprintf("Failed to mark file flag as required: %v", err))
+	}
+
+	rootCmd.AddCommand(importCmd)
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	if importFormat != "csv" {
+		return fmt.Errorf("unknown import format %q (expected csv)", importFormat)
+	}
+	if err := validateNamespace(); err != nil {
+		return err
+	}
+
+	var r io.Reader = cmd.InOrStdin()
+	if importFile != "-" {
+		f, err := os.Open(importFile)
+		if err != nil {
+			return fmt.Errorf("failed to open import file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	specs, err := apikey.ParseKeysCSV(r)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", importFile, err)
+	}
+
+	for _, spec := range specs {
+		yaml, err := apikey.GenerateYAMLInNamespace(namespace, spec)
+		if err != nil {
+			return fmt.Errorf("failed to generate YAML for %s: %w", spec.Email, err)
+		}
+		if _, err := fmt.Fprint(cmd.OutOrStdout(), yaml); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Imported %d keys\n", len(specs))
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/spf13/cobra"
+)
+
+var lintFile string
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check how the server would read APIKey YAML before applying it",
+	Long: `Read the APIKey resources of a YAML or JSON file with the server's own
+parser, without a cluster. For each key, the spec fields read, the defaults
+applied, the fields ignored and any problem are reported: resources the server
+skips, fields the CRD requires, malformed hashes, hints and active windows.
+Other documents, e.g. a consuming Secret, are left out.
+
+  apikey-manager-client lint --file apikey.yaml && kubectl apply -f apikey.yaml
+
+Exits non-zero when any key has a problem.`,
+	Args: cobra.NoArgs,
+	RunE: runLint,
+}
+
+func init() {
+	lintCmd.Flags().StringVarP(&lintFile, "file", "f", "", "APIKey YAML or JSON file, or - to read stdin (required)")
+	if err := lintCmd.MarkFlagRequired("file"); err != nil {
+		panic(fmt.Sprintf("Failed to mark file flag as required: %v", err))
+	}
+
+	rootCmd.AddCommand(lintCmd)
+}
+
+func runLint(cmd *cobra.Command, args []string) error {
+	var r io.Reader = cmd.InOrStdin()
+	if lintFile != "-" {
+		f, err := os.Open(lintFile)
+		if err != nil {
+			return fmt.Errorf("failed to open lint file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", lintFile, err)
+	}
+
+	lints, err := kube.LintAPIKeys(data)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", lintFile, err)
+	}
+
+	failed := 0
+	out := cmd.OutOrStdout()
+	for _, lint := range lints {
+		writeLint(out, lint)
+		if !lint.OK() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d of %d keys have problems", failed, len(lints))
+	}
+	return nil
+}
+
+// writeLint prints the report of one APIKey
+func writeLint(w io.Writer, lint kube.APIKeyLint) {
+	switch {
+	case lint.Skipped != nil:
+		fmt.Fprintf(w, "%s: skipped by the server: %v\n", lint.Name, lint.Skipped)
+	case len(lint.Problems) > 0:
+		fmt.Fprintf(w, "%s: served with problems\n", lint.Name)
+	default:
+		fmt.Fprintf(w, "%s: ok\n", lint.Name)
+	}
+	if len(lint.Read) > 0 {
+		fmt.Fprintf(w, "  read:     %s\n", strings.Join(lint.Read, ", "))
+	}
+	for _, value := range lint.Defaults {
+		fmt.Fprintf(w, "  default:  %s\n", value)
+	}
+	for _, field := range lint.Ignored {
+		fmt.Fprintf(w, "  ignored:  %s\n", field)
+	}
+	for _, problem := range lint.Problems {
+		fmt.Fprintf(w, "  problem:  %s\n", problem)
+	}
+}
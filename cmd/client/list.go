@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/efortin/batsign/internal/models"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	listNamespace  string
+	listKubeconfig string
+	listContext    string
+	listEnabled    string
+	listOutput     string
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List APIKey resources from the cluster",
+	Long: `List APIKey resources straight from Kubernetes, without the server's
+admin API. The kubeconfig defaults to $KUBECONFIG or ~/.kube/config; when
+neither exists, the in-cluster config is used, as by the server.`,
+	Args: cobra.NoArgs,
+	RunE: runList,
+}
+
+func init() {
+	listCmd.Flags().StringVarP(&listNamespace, "namespace", "n", "", "Namespace to list APIKeys from (empty = all namespaces)")
+	listCmd.Flags().StringVar(&listKubeconfig, "kubeconfig", defaultKubeconfig(), "Path to kubeconfig file (empty = in-cluster config)")
+	listCmd.Flags().StringVar(&listContext, "kube-context", "", "Kubeconfig context to use (empty = current context)")
+	listCmd.Flags().StringVar(&listEnabled, "enabled", "", "Only list enabled (true) or disabled (false) keys; --enabled alone means true (empty = all keys)")
+	listCmd.Flags().Lookup("enabled").NoOptDefVal = "true"
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", "table", "Output format (table, json)")
+
+	rootCmd.AddCommand(listCmd)
+}
+
+// defaultKubeconfig returns $KUBECONFIG, or ~/.kube/config when it exists
+func defaultKubeconfig() string {
+	if path := os.Getenv("KUBECONFIG"); path != "" {
+		return path
+	}
+	if _, err := os.Stat(clientcmd.RecommendedHomeFile); err == nil {
+		return clientcmd.RecommendedHomeFile
+	}
+	return ""
+}
+
+func runList(cmd *cobra.Command, args []string) error {
+	if listOutput != "table" && listOutput != "json" {
+		return fmt.Errorf("unknown output format %q (expected table or json)", listOutput)
+	}
+	var enabled *bool
+	if listEnabled != "" {
+		parsed, err := strconv.ParseBool(listEnabled)
+		if err != nil {
+			return fmt.Errorf("invalid --enabled %q (expected true or false)", listEnabled)
+		}
+		enabled = &parsed
+	}
+
+	config, err := kube.RESTConfig(listKubeconfig, listContext)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	entries, err := kube.ListAPIKeys(cmd.Context(), client, listNamespace)
+	if err != nil {
+		return err
+	}
+	if enabled != nil {
+		entries = kube.FilterEnabled(entries, *enabled)
+	}
+
+	if listOutput == "json" {
+		return writeKeysJSON(cmd.OutOrStdout(), entries)
+	}
+	return writeKeysTable(cmd.OutOrStdout(), entries)
+}
+
+// listedKey is the JSON view of a listed key; hashes are left out
+type listedKey struct {
+	Name           string                `json:"name"`
+	Email          string                `json:"email,omitempty"`
+	KeyHint        string                `json:"keyHint,omitempty"`
+	Description    string                `json:"description,omitempty"`
+	Enabled        bool                  `json:"enabled"`
+	AllowedMethods []string              `json:"allowedMethods,omitempty"`
+	ActiveWindows  []models.ActiveWindow `json:"activeWindows,omitempty"`
+	Notes          string                `json:"notes,omitempty"`
+}
+
+// writeKeysJSON prints the entries as an indented JSON array
+func writeKeysJSON(w io.Writer, entries []models.APIKeyEntry) error {
+	keys := make([]listedKey, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, listedKey{
+			Name:           entry.Name,
+			Email:          entry.Email,
+			KeyHint:        entry.KeyHint,
+			Description:    entry.Description,
+			Enabled:        entry.Enabled,
+			AllowedMethods: entry.AllowedMethods,
+			ActiveWindows:  entry.ActiveWindows,
+			Notes:          entry.Notes,
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(keys)
+}
+
+// writeKeysTable prints the entries as aligned columns
+func writeKeysTable(w io.Writer, entries []models.APIKeyEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tEMAIL\tHINT\tENABLED")
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\n", entry.Name, entry.Email, entry.KeyHint, entry.Enabled)
+	}
+	return tw.Flush()
+}
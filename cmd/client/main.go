@@ -3,16 +3,32 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/efortin/batsign/internal/apikey"
 	"github.com/efortin/batsign/internal/models"
+	"github.com/efortin/batsign/internal/version"
 	"github.com/spf13/cobra"
 )
 
 var (
-	email       string
-	description string
-	enabled     bool
+	email          string
+	description    string
+	enabled        bool
+	allowedMethods []string
+	templateFile   string
+	keyBytes       int
+	minKeyBits     int
+	randSource     string
+	emitSecret     bool
+	verify         bool
+	notes          string
+	hashAlgo       string
+	namespace      string
+	tenantsFile    string
+	keyOut         string
+	force          bool
+	emailOpts      apikey.ValidateEmailOptions
 )
 
 var rootCmd = &cobra.Command{
@@ -23,19 +39,46 @@ for use with the kgateway API authentication system.
 
 The tool generates a random API key, hashes it, creates a visual hint,
 and outputs Kubernetes-ready YAML for the APIKey custom resource.`,
-	RunE: run,
+	RunE:    run,
+	Version: version.String(),
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&email, "email", "e", "", "Email address of the API key owner (required)")
-	rootCmd.Flags().StringVarP(&description, "description", "d", "", "Description of the API key purpose")
-	rootCmd.Flags().BoolVar(&enabled, "enabled", true, "Whether the API key is enabled")
+	addSpecFlags(rootCmd)
+	addKeySizeFlags(rootCmd)
+	rootCmd.Flags().BoolVar(&emitSecret, "emit-secret", false, "Also output a Secret holding the raw key in stringData.apiKey (persists the key in the cluster)")
+	rootCmd.Flags().StringVar(&randSource, "rand-source", apikey.DefaultRandomSource, "Random source keys are generated from ("+strings.Join(apikey.RandomSources(), ", ")+")")
+	rootCmd.Flags().StringVar(&tenantsFile, "tenants", "", "YAML file listing tenants (prefix, count, email) to generate keys for in bulk, instead of a single key for --email")
+	rootCmd.Flags().StringVar(&keyOut, "key-out", "", "Write the raw key to this file, readable by its owner only, instead of printing it")
+	rootCmd.Flags().BoolVar(&force, "force", false, "Overwrite the --key-out file if it exists")
+	rootCmd.MarkFlagsOneRequired("email", "tenants")
+	rootCmd.MarkFlagsMutuallyExclusive("email", "tenants")
+}
 
-	// Mark email as required
-	if err := rootCmd.MarkFlagRequired("email"); err != nil {
-		// This should never happen unless there's a programming error
-		panic(fmt.Sprintf("Failed to mark email flag as required: %v", err))
-	}
+// addSpecFlags registers the flags describing the generated APIKey spec
+func addSpecFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&email, "email", "e", "", "Email address of the API key owner (required)")
+	cmd.Flags().StringVarP(&description, "description", "d", "", "Description of the API key purpose")
+	cmd.Flags().StringVar(&notes, "notes", "", "Free-text notes for humans, distinct from the description (never used for validation)")
+	cmd.Flags().BoolVar(&enabled, "enabled", true, "Whether the API key is enabled")
+	cmd.Flags().StringSliceVar(&allowedMethods, "allowed-methods", nil, "HTTP methods the key may be used with, e.g. GET,HEAD (empty = all methods)")
+	cmd.Flags().StringVar(&templateFile, "template", "", "YAML file with shared spec fields (description, enabled, allowedMethods); flags take precedence")
+	cmd.Flags().StringVar(&hashAlgo, "hash-algo", models.HashAlgoSHA256, "Algorithm of the stored key hash (sha256, argon2id); argon2id resists brute force if the resource leaks but is slower to verify")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace set in the metadata of the generated resources (empty = the namespace kubectl applies them to)")
+	cmd.Flags().BoolVar(&verify, "verify", false, "Check that the hash and hint in the generated YAML match the key before printing it")
+	addEmailFlags(cmd)
+}
+
+// addEmailFlags registers the flags relaxing email validation
+func addEmailFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&emailOpts.AllowNoTLD, "allow-no-tld", false, "Accept email addresses whose domain has no top-level domain, e.g. user@internal")
+	cmd.Flags().BoolVar(&emailOpts.AllowLocalhost, "allow-localhost", false, "Accept email addresses at localhost, e.g. user@localhost")
+}
+
+// addKeySizeFlags registers the flags controlling the key size
+func addKeySizeFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&keyBytes, "bytes", apikey.DefaultKeyBytes, "Number of random bytes in the key")
+	cmd.Flags().IntVar(&minKeyBits, "min-key-bits", apikey.DefaultMinKeyBits, "Minimum key entropy in bits; smaller --bytes values are rejected")
 }
 
 func main() {
@@ -45,56 +88,145 @@ func main() {
 }
 
 func run(cmd *cobra.Command, args []string) error {
+	if tenantsFile != "" {
+		return runTenants(cmd)
+	}
+
 	// Validate email format
-	if err := apikey.ValidateEmail(email); err != nil {
+	if err := apikey.ValidateEmailWithOptions(email, emailOpts); err != nil {
 		return err
 	}
-
-	// Set default description if not provided
-	if description == "" {
-		description = fmt.Sprintf("API key for %s", email)
+	if err := validateNamespace(); err != nil {
+		return err
 	}
 
 	// Generate a random API key
-	key, err := apikey.GenerateAPIKey()
+	reader, err := apikey.NewRandomSource(randSource)
+	if err != nil {
+		return err
+	}
+	keyConfig := apikey.Config{NumBytes: keyBytes, MinKeyBits: minKeyBits}
+	key, err := apikey.GenerateAPIKeyWithConfigContext(cmd.Context(), reader, keyConfig)
 	if err != nil {
 		return err
 	}
 
-	// Generate hash and hint
-	keyHash := apikey.HashAPIKey(key)
-	keyHint := apikey.GenerateHint(key)
-
-	// Create the spec
-	spec := models.APIKeySpec{
-		Email:       email,
-		KeyHash:     keyHash,
-		KeyHint:     keyHint,
-		Description: description,
-		Enabled:     enabled,
+	spec, err := buildSpec(cmd, key, email)
+	if err != nil {
+		return err
 	}
 
 	// Generate and output the YAML
-	yaml, err := apikey.GenerateYAML(spec)
+	yaml, err := apikey.GenerateYAMLInNamespace(namespace, spec)
 	if err != nil {
 		return fmt.Errorf("failed to generate YAML: %w", err)
 	}
-	fmt.Print(yaml)
-
-	// Print the actual API key to stderr so user can save it
-	fmt.Fprintln(os.Stderr, "")
-	fmt.Fprintln(os.Stderr, "╔════════════════════════════════════════════════════════════════╗")
-	fmt.Fprintln(os.Stderr, "║  IMPORTANT: Save this API key - it will not be shown again!   ║")
-	fmt.Fprintln(os.Stderr, "╚════════════════════════════════════════════════════════════════╝")
-	fmt.Fprintln(os.Stderr, "")
-	fmt.Fprintf(os.Stderr, "  API Key: %s\n", key)
-	fmt.Fprintln(os.Stderr, "")
-	fmt.Fprintln(os.Stderr, "To apply this APIKey resource, run:")
-	fmt.Fprintf(os.Stderr, "  kubectl apply -f - <<EOF\n%sEOF\n", yaml)
-	fmt.Fprintln(os.Stderr, "")
-	fmt.Fprintln(os.Stderr, "Or pipe directly:")
-	fmt.Fprintf(os.Stderr, "  apikey-manager-client -e %s -d \"%s\" 2>/dev/null | kubectl apply -f -\n", email, description)
-	fmt.Fprintln(os.Stderr, "")
+	if err := verifyYAML(yaml, key); err != nil {
+		return err
+	}
+	if emitSecret {
+		secretYAML, err := apikey.GenerateSecretYAMLInNamespace(namespace, email, key)
+		if err != nil {
+			return fmt.Errorf("failed to generate Secret YAML: %w", err)
+		}
+		yaml += secretYAML
+	}
+	// The key is saved before the resource is printed, so a resource is
+	// never applied for a key that was lost
+	if keyOut != "" {
+		if err := apikey.WriteKeyFile(keyOut, []byte(key+"\n"), force); err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(cmd.OutOrStdout(), yaml)
+
+	stderr := cmd.ErrOrStderr()
+	if emitSecret {
+		fmt.Fprintln(stderr, "")
+		fmt.Fprintln(stderr, "WARNING: --emit-secret stores the RAW API key in the Secret "+apikey.SecretName(email)+".")
+		fmt.Fprintln(stderr, "WARNING: anyone able to read Secrets in that namespace can use this key.")
+	}
 
+	fmt.Fprintln(stderr, "")
+	if keyOut != "" {
+		fmt.Fprintf(stderr, "  API key written to %s\n", keyOut)
+	} else {
+		// Print the actual API key to stderr so user can save it
+		fmt.Fprintln(stderr, "╔════════════════════════════════════════════════════════════════╗")
+		fmt.Fprintln(stderr, "║  IMPORTANT: Save this API key - it will not be shown again!   ║")
+		fmt.Fprintln(stderr, "╚════════════════════════════════════════════════════════════════╝")
+		fmt.Fprintln(stderr, "")
+		fmt.Fprintf(stderr, "  API Key: %s\n", key)
+	}
+	fmt.Fprintf(stderr, "  Entropy: %d bits\n", keyConfig.EntropyBits())
+	fmt.Fprintln(stderr, "")
+	fmt.Fprintln(stderr, "To apply this APIKey resource, run:")
+	// The delimiter is quoted so argon2id hashes are not expanded by the shell
+	fmt.Fprintf(stderr, "  kubectl apply -f - <<'EOF'\n%sEOF\n", yaml)
+	fmt.Fprintln(stderr, "")
+	fmt.Fprintln(stderr, "Or pipe directly:")
+	fmt.Fprintf(stderr, "  apikey-manager-client -e %s -d \"%s\" 2>/dev/null | kubectl apply -f -\n", email, spec.Description)
+	fmt.Fprintln(stderr, "")
+
+	return nil
+}
+
+// validateNamespace checks the --namespace flag when set
+func validateNamespace() error {
+	if namespace == "" {
+		return nil
+	}
+	return apikey.ValidateNamespace(namespace)
+}
+
+// verifyYAML checks the generated APIKey YAML against the key when --verify is set
+func verifyYAML(yaml, key string) error {
+	if !verify {
+		return nil
+	}
+	if err := apikey.VerifyYAML(yaml, key); err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
 	return nil
 }
+
+// buildSpec creates the APIKey spec for a key of owner from the template and
+// the flags that were explicitly set
+func buildSpec(cmd *cobra.Command, key, owner string) (models.APIKeySpec, error) {
+	var profile apikey.SpecTemplate
+	if templateFile != "" {
+		loaded, err := apikey.LoadSpecTemplate(templateFile)
+		if err != nil {
+			return models.APIKeySpec{}, err
+		}
+		profile = loaded
+	}
+	var overrides apikey.SpecTemplate
+	if cmd.Flags().Changed("description") {
+		overrides.Description = description
+	}
+	if cmd.Flags().Changed("enabled") {
+		overrides.Enabled = &enabled
+	}
+	if cmd.Flags().Changed("allowed-methods") {
+		overrides.AllowedMethods = allowedMethods
+	}
+	profile = profile.Merge(overrides)
+
+	spec, err := apikey.NewAPIKeySpecWithAlgo(key, owner, hashAlgo)
+	if err != nil {
+		return models.APIKeySpec{}, err
+	}
+
+	// Set default description if not provided
+	spec.Description = profile.Description
+	if spec.Description == "" {
+		spec.Description = fmt.Sprintf("API key for %s", owner)
+	}
+	spec.Enabled = profile.Enabled == nil || *profile.Enabled
+	spec.Notes = notes
+	for _, method := range profile.AllowedMethods {
+		spec.AllowedMethods = append(spec.AllowedMethods, strings.ToUpper(strings.TrimSpace(method)))
+	}
+	return spec, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/apikey/apikeytest"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files with the current output")
+
+// seededSource is a deterministic random source, registered by the tests
+// only: the client binary cannot select it
+const seededSource = "test-seed-42"
+
+func init() {
+	apikey.RegisterRandomSource(seededSource, func() (io.Reader, error) {
+		return apikeytest.DeterministicReader(42), nil
+	})
+}
+
+// execute runs the client with args, returning its standard output
+func execute(t *testing.T, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetErr(&stderr)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("client %v error = %v\n%s", args, err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestGenerateGolden(t *testing.T) {
+	args := []string{"-e", "golden@example.com", "-n", "team-a", "--emit-secret", "--rand-source", seededSource}
+	got := execute(t, args...)
+	if again := execute(t, args...); again != got {
+		t.Fatalf("same seed generated different output:\n%s\nthen:\n%s", got, again)
+	}
+
+	golden := filepath.Join("testdata", "generate.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read %s (run with -update to create it): %v", golden, err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s (run with -update if intended):\n%s", golden, got)
+	}
+}
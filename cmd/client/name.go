@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/spf13/cobra"
+)
+
+var nameEmail string
+
+var nameCmd = &cobra.Command{
+	Use:   "name",
+	Short: "Print the APIKey resource name derived from an email",
+	Long: `Print the resource name the generated APIKey gets for an email, to find
+it with kubectl without generating a key.
+
+Without --email, emails are read from stdin, one per line:
+  apikey-manager-client name < emails.txt`,
+	Args: cobra.NoArgs,
+	RunE: runName,
+}
+
+func init() {
+	nameCmd.Flags().StringVarP(&nameEmail, "email", "e", "", "Email address to derive the name from (empty = read emails from stdin)")
+	addEmailFlags(nameCmd)
+
+	rootCmd.AddCommand(nameCmd)
+}
+
+func runName(cmd *cobra.Command, args []string) error {
+	if nameEmail != "" {
+		return printResourceName(cmd.OutOrStdout(), nameEmail)
+	}
+	return printResourceNames(cmd.InOrStdin(), cmd.OutOrStdout())
+}
+
+// printResourceNames prints the resource name for each non-blank line of r
+func printResourceNames(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := printResourceName(w, line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read emails: %w", err)
+	}
+	return nil
+}
+
+// printResourceName validates an email and prints its resource name
+func printResourceName(w io.Writer, email string) error {
+	if err := apikey.ValidateEmailWithOptions(email, emailOpts); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w, apikey.ResourceName(email))
+	return err
+}
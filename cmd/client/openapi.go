@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/spf13/cobra"
+)
+
+var (
+	openapiHeader string
+	openapiBearer bool
+	openapiOutput string
+)
+
+var openapiCmd = &cobra.Command{
+	Use:   "openapi-security",
+	Short: "Print the OpenAPI security schemes enforced by the server",
+	Long: `Print an OpenAPI 3 fragment (components.securitySchemes and security)
+describing how clients pass API keys to the authorization server, ready to be
+merged into an API specification.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, err := apikey.GenerateOpenAPISecurity(openapiHeader, openapiBearer, openapiOutput)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(cmd.OutOrStdout(), out)
+		return err
+	},
+}
+
+func init() {
+	openapiCmd.Flags().StringVar(&openapiHeader, "header-name", apikey.HeaderAPIKey, "Header carrying the API key")
+	openapiCmd.Flags().BoolVar(&openapiBearer, "bearer", true, "Also emit the Authorization bearer scheme")
+	openapiCmd.Flags().StringVarP(&openapiOutput, "output", "o", "yaml", "Output format (yaml, json)")
+	rootCmd.AddCommand(openapiCmd)
+}
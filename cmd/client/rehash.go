@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/efortin/batsign/internal/models"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	rehashFrom       string
+	rehashTo         string
+	rehashKeysFile   string
+	rehashNamespace  string
+	rehashKubeconfig string
+	rehashContext    string
+)
+
+var rehashCmd = &cobra.Command{
+	Use:   "rehash",
+	Short: "Move existing APIKeys to another key hash algorithm",
+	Long: `Print the existing APIKey resources of raw keys with their hashes recomputed
+by another algorithm, e.g. to move them from sha256 to argon2id. Only raw keys
+can be rehashed, so they are read from a CSV file of email,key rows; the
+header row is optional. Each key is matched to the resources in the cluster
+that hold its --from hash and belong to its email, which are printed with
+keyHash and keyHashAlgo replaced; their labels and every other field,
+enabled included, are kept. Nothing is printed unless every key matched, and keys are never
+echoed.
+
+The server validates each resource with its own keyHashAlgo, so both
+algorithms are accepted side by side and keys move without downtime:
+
+  1. Run a server version supporting the target algorithm everywhere.
+  2. Rehash and apply the keys, all at once or in batches; each key keeps
+     working, as its resource holds either its old or its new hash.
+  3. Delete the raw keys file.
+
+  apikey-manager-client rehash --from sha256 --to argon2id --keys-file raw.csv | kubectl apply -f -`,
+	Args: cobra.NoArgs,
+	RunE: runRehash,
+}
+
+func init() {
+	rehashCmd.Flags().StringVar(&rehashFrom, "from", models.HashAlgoSHA256, "Algorithm the keys are hashed with in the cluster (sha256, argon2id)")
+	rehashCmd.Flags().StringVar(&rehashTo, "to", "", "Algorithm to hash the keys with (sha256, argon2id) (required)")
+	rehashCmd.Flags().StringVarP(&rehashKeysFile, "keys-file", "f", "", "CSV file of email,key rows, or - to read stdin (required)")
+	rehashCmd.Flags().StringVarP(&rehashNamespace, "namespace", "n", "", "Namespace of the keys to rehash (empty = all namespaces)")
+	rehashCmd.Flags().StringVar(&rehashKubeconfig, "kubeconfig", defaultKubeconfig(), "Path to kubeconfig file (empty = in-cluster config)")
+	rehashCmd.Flags().StringVar(&rehashContext, "kube-context", "", "Kubeconfig context to use (empty = current context)")
+	for _, name := range []string{"to", "keys-file"} {
+		if err := rehashCmd.MarkFlagRequired(name); err != nil {
+			panic(fmt.Sprintf("Failed to mark %s flag as required: %v", name, err))
+		}
+	}
+
+	rootCmd.AddCommand(rehashCmd)
+}
+
+func runRehash(cmd *cobra.Command, args []string) error {
+	if err := apikey.ValidateHashAlgo(rehashFrom); err != nil {
+		return fmt.Errorf("invalid --from: %w", err)
+	}
+	if err := apikey.ValidateHashAlgo(rehashTo); err != nil {
+		return fmt.Errorf("invalid --to: %w", err)
+	}
+
+	var r io.Reader = cmd.InOrStdin()
+	if rehashKeysFile != "-" {
+		f, err := os.Open(rehashKeysFile)
+		if err != nil {
+			return fmt.Errorf("failed to open keys file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	keys, err := apikey.ParseRawKeysCSV(r)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", rehashKeysFile, err)
+	}
+
+	config, err := kube.RESTConfig(rehashKubeconfig, rehashContext)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	resources, err := kube.RehashAPIKeys(cmd.Context(), client, rehashNamespace, keys, rehashFrom, rehashTo)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		doc, err := yaml.Marshal(resource.Object)
+		if err != nil {
+			return fmt.Errorf("failed to generate YAML for %s/%s: %w", resource.GetNamespace(), resource.GetName(), err)
+		}
+		if _, err := fmt.Fprint(cmd.OutOrStdout(), "---\n"+string(doc)); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Rehashed %d APIKeys from %s to %s\n", len(resources), rehashFrom, rehashTo)
+	return nil
+}
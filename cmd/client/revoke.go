@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	revokeDomain     string
+	revokeSelector   string
+	revokeNamespace  string
+	revokeKubeconfig string
+	revokeContext    string
+	revokeDryRun     bool
+)
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Disable every APIKey matching an email domain or a label",
+	Long: `Disable the APIKey resources owned by an email domain or matching a label
+selector, e.g. during an incident, by setting spec.enabled to false. The
+resources are kept, so the keys can be enabled again. When both are set, keys
+must match both.
+
+  apikey-manager-client revoke --email-domain contractor.example --dry-run
+  apikey-manager-client revoke -l batsign.io/tenant=acme -n load-test
+
+Exits non-zero when a key could not be disabled.`,
+	Args: cobra.NoArgs,
+	RunE: runRevoke,
+}
+
+func init() {
+	revokeCmd.Flags().StringVar(&revokeDomain, "email-domain", "", "Disable the keys of emails of this domain, not of its subdomains")
+	revokeCmd.Flags().StringVarP(&revokeSelector, "selector", "l", "", "Disable the keys matching this label selector, e.g. batsign.io/tenant=acme")
+	revokeCmd.Flags().StringVarP(&revokeNamespace, "namespace", "n", "", "Namespace of the keys to disable (empty = all namespaces)")
+	revokeCmd.Flags().StringVar(&revokeKubeconfig, "kubeconfig", defaultKubeconfig(), "Path to kubeconfig file (empty = in-cluster config)")
+	revokeCmd.Flags().StringVar(&revokeContext, "kube-context", "", "Kubeconfig context to use (empty = current context)")
+	revokeCmd.Flags().BoolVar(&revokeDryRun, "dry-run", false, "Only print the keys that would be disabled")
+	revokeCmd.MarkFlagsOneRequired("email-domain", "selector")
+
+	rootCmd.AddCommand(revokeCmd)
+}
+
+func runRevoke(cmd *cobra.Command, args []string) error {
+	config, err := kube.RESTConfig(revokeKubeconfig, revokeContext)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	filter := kube.RevokeFilter{EmailDomain: revokeDomain, LabelSelector: revokeSelector}
+	revocations, err := kube.RevokeAPIKeys(cmd.Context(), client, revokeNamespace, filter, revokeDryRun)
+	if err != nil {
+		return err
+	}
+
+	if failed := writeRevocations(cmd.OutOrStdout(), revocations, revokeDryRun); failed > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d keys could not be disabled", failed)
+	}
+	return nil
+}
+
+// writeRevocations prints one line per matched key and a summary, returning
+// how many keys could not be disabled
+func writeRevocations(w io.Writer, revocations []kube.Revocation, dryRun bool) int {
+	action, summary := "disabled", "disabled"
+	if dryRun {
+		action, summary = "would disable", "would be disabled"
+	}
+
+	disabled, skipped, failed := 0, 0, 0
+	for _, revocation := range revocations {
+		switch {
+		case revocation.AlreadyDisabled:
+			skipped++
+			fmt.Fprintf(w, "already disabled %s (%s)\n", revocation.Name, revocation.Email)
+		case revocation.Err != nil:
+			failed++
+			fmt.Fprintf(w, "FAILED %s (%s): %v\n", revocation.Name, revocation.Email, revocation.Err)
+		default:
+			disabled++
+			fmt.Fprintf(w, "%s %s (%s)\n", action, revocation.Name, revocation.Email)
+		}
+	}
+	fmt.Fprintf(w, "%d keys %s, %d already disabled, %d failed\n", disabled, summary, skipped, failed)
+	return failed
+}
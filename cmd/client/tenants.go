@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/spf13/cobra"
+)
+
+// runTenants generates the keys of every tenant of --tenants, printing their
+// labelled APIKey resources on stdout and the keys on stderr, or to the
+// --key-out file
+func runTenants(cmd *cobra.Command) error {
+	if emitSecret {
+		return errors.New("--emit-secret cannot be used with --tenants: the Secrets of a tenant's keys would share a name")
+	}
+	if err := validateNamespace(); err != nil {
+		return err
+	}
+	tenants, err := apikey.LoadTenants(tenantsFile)
+	if err != nil {
+		return err
+	}
+
+	reader, err := apikey.NewRandomSource(randSource)
+	if err != nil {
+		return err
+	}
+	keys, err := apikey.GenerateTenantKeys(cmd.Context(), reader, apikey.Config{NumBytes: keyBytes, MinKeyBits: minKeyBits}, tenants)
+	if err != nil {
+		return err
+	}
+
+	// Keys are only printed once every resource was generated
+	var out strings.Builder
+	for _, key := range keys {
+		spec, err := buildSpec(cmd, key.Key, key.Tenant.Email)
+		if err != nil {
+			return err
+		}
+		yaml, err := apikey.GenerateTenantYAML(namespace, key, spec)
+		if err != nil {
+			return fmt.Errorf("failed to generate YAML for %s: %w", key.Name, err)
+		}
+		if err := verifyYAML(yaml, key.Key); err != nil {
+			return err
+		}
+		out.WriteString(yaml)
+	}
+
+	var list strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&list, "%s %s\n", key.Name, key.Key)
+	}
+	if keyOut != "" {
+		if err := apikey.WriteKeyFile(keyOut, []byte(list.String()), force); err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(cmd.OutOrStdout(), out.String())
+
+	if keyOut != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Generated %d keys for %d tenants, written to %s\n", len(keys), len(tenants), keyOut)
+		return nil
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Generated %d keys for %d tenants; save them, they will not be shown again:\n", len(keys), len(tenants))
+	fmt.Fprint(cmd.ErrOrStderr(), list.String())
+	return nil
+}
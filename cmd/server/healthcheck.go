@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/efortin/batsign/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	probePort    int
+	probeTimeout time.Duration
+)
+
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Check that a local server is ready",
+	Long: `Query /ready on the local HTTP port and exit 0 when the server is ready,
+1 otherwise. Meant for Docker HEALTHCHECK and exec probes, so the image needs
+no curl:
+
+  HEALTHCHECK CMD ["/batsign-server", "healthcheck", "--http-port", "8080"]`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         runHealthcheck,
+}
+
+func init() {
+	healthcheckCmd.Flags().IntVarP(&probePort, "http-port", "p", 8080, "HTTP port of the server to check")
+	healthcheckCmd.Flags().DurationVar(&probeTimeout, "timeout", 3*time.Second, "Time to wait for the server to answer")
+
+	rootCmd.AddCommand(healthcheckCmd)
+}
+
+func runHealthcheck(cmd *cobra.Command, args []string) error {
+	ctx, cancel := context.WithTimeout(cmd.Context(), probeTimeout)
+	defer cancel()
+
+	return server.Probe(ctx, fmt.Sprintf("http://127.0.0.1:%d/ready", probePort))
+}
@@ -1,22 +1,77 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
+	// Embedded so --timezone works in the scratch image, which has no zoneinfo
+	_ "time/tzdata"
 
 	"github.com/efortin/batsign/internal/models"
 	"github.com/efortin/batsign/internal/server"
+	"github.com/efortin/batsign/internal/version"
 	"github.com/spf13/cobra"
 )
 
 var (
-	grpcPort   int
-	httpPort   int
-	namespace  string
-	kubeconfig string
-	logLevel   string
+	grpcPort    int
+	httpPort    int
+	namespace   string
+	kubeconfig  string
+	kubeContext string
+	logLevel    string
+	secretSel   string
+	inMemory    bool
+	keysFile    string
+	denyFormat  string
+	denyTmpl    string
+	denyURL     string
+	denyStatus  int
+	alertURL    string
+	alertOn     []string
+	extURL      string
+	extTimeout  time.Duration
+	extTTL      time.Duration
+	extFailOpen bool
+	tracingURL  string
+	reflection  bool
+	adminAPI    bool
+	adminToken  string
+	adminRate   float64
+	adminBurst  int
+	denialWin   time.Duration
+	cacheTTL    time.Duration
+	kubeQPS     float32
+	kubeBurst   int
+	kubeBackoff time.Duration
+	statsLog    time.Duration
+	failOpen    bool
+	maxKeyLen   int
+	keyPrefix   string
+	breakGlass  string
+	crdWait     time.Duration
+	reqHeaders  []string
+	emitEvents  bool
+	timezone    string
+	okHeaders   map[string]string
+	keyIDHeader string
+	maxChecks   int
+	verboseDeny bool
+	routeRules  string
+	trustedKey  string
+	perKey      bool
+	perKeyMax   int
+	checkRBAC   bool
+	breakerMax  int
+	breakerWait time.Duration
+	livenessMax time.Duration
 )
 
+// validateTimeout bounds the Kubernetes requests of validate-config
+const validateTimeout = 30 * time.Second
+
 var rootCmd = &cobra.Command{
 	Use:   "apikey-manager-server",
 	Short: "Authorization server for kgateway (replaces OPA)",
@@ -26,15 +81,79 @@ The server watches APIKey CRDs in Kubernetes and validates incoming requests
 by comparing SHA-256 hashes of provided API keys against stored hashes.
 
 This server replaces OPA for API key-based authentication.`,
-	RunE: run,
+	RunE:    run,
+	Version: version.String(),
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate-config",
+	Short: "Validate the configuration without starting the server",
+	Long: `Run the checks done at startup, plus those that would only fail once the
+server runs: the keys file loads, the kubeconfig reaches the API server, the
+watched namespaces exist and the APIKey CRD is installed.
+
+Takes the same flags as the server. Prints one line per check and exits
+non-zero if any failed, e.g. to catch misconfigurations in CI before a deploy.`,
+	Args: cobra.NoArgs,
+	RunE: validateConfig,
 }
 
 func init() {
-	rootCmd.Flags().IntVarP(&grpcPort, "grpc-port", "g", 9191, "gRPC port for Envoy ext_authz")
-	rootCmd.Flags().IntVarP(&httpPort, "http-port", "p", 8080, "HTTP port for health checks")
-	rootCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Kubernetes namespace to watch (empty = all namespaces)")
-	rootCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (empty = in-cluster config)")
-	rootCmd.Flags().StringVarP(&logLevel, "log-level", "l", "info", "Log level (debug, info, warn, error)")
+	// Persistent so validate-config accepts the same flags
+	flags := rootCmd.PersistentFlags()
+	flags.IntVarP(&grpcPort, "grpc-port", "g", 9191, "gRPC port for Envoy ext_authz")
+	flags.IntVarP(&httpPort, "http-port", "p", 8080, "HTTP port for health checks")
+	flags.StringVarP(&namespace, "namespace", "n", "", "Kubernetes namespace to watch (empty = all namespaces)")
+	flags.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (empty = in-cluster config)")
+	flags.StringVar(&kubeContext, "kube-context", "", "Kubeconfig context to use (empty = current context)")
+	flags.StringVarP(&logLevel, "log-level", "l", "info", "Log level (debug, info, warn, error), changeable at runtime with PUT /loglevel")
+	flags.StringVar(&secretSel, "secret-selector", "", "Label selector for Secrets holding hashed API keys (empty = disabled)")
+	flags.BoolVar(&inMemory, "in-memory", false, "Serve keys from --keys-file without Kubernetes, e.g. on hosts without a cluster")
+	flags.StringVar(&keysFile, "keys-file", "", "YAML/JSON list of keys served in --in-memory mode, reloaded on change")
+	flags.StringVar(&denyFormat, "deny-body-format", "plain", "Format of denied response bodies (plain, json, problem+json)")
+	flags.StringVar(&denyTmpl, "deny-body-template", "", "Go text/template for denied response bodies, with .Reason and .Status")
+	flags.StringVar(&denyURL, "deny-redirect-url", "", "Redirect requests with a missing, unknown, disabled or wrongly prefixed key there, e.g. a page to request a key, instead of answering 403 (empty = disabled)")
+	flags.IntVar(&denyStatus, "deny-redirect-status", models.DefaultDenyRedirectStatus, "Status of --deny-redirect-url redirects (301, 302, 303, 307, 308)")
+	flags.StringVar(&alertURL, "alert-webhook-url", "", "URL receiving a JSON POST for each denial with one of --alert-reasons, rate limited (empty = disabled)")
+	flags.StringVar(&extURL, "external-validator-url", "", "URL receiving a JSON POST with each key hash and deciding whether it is allowed, instead of the key store (empty = disabled)")
+	flags.DurationVar(&extTimeout, "external-validator-timeout", models.DefaultExternalValidatorTimeout, "Maximum time a call to --external-validator-url may take")
+	flags.DurationVar(&extTTL, "external-validator-cache-ttl", models.DefaultExternalValidatorCacheTTL, "How long decisions of --external-validator-url are cached; revoked keys keep working as long")
+	flags.BoolVar(&extFailOpen, "external-validator-fail-open", false, "Allow requests unauthenticated when --external-validator-url fails instead of denying them")
+	flags.StringSliceVar(&alertOn, "alert-reasons", []string{"disabled"}, "Deny reasons sent to --alert-webhook-url (missing, invalid, disabled, method, policy, header, schedule, validator, prefix)")
+	flags.StringVar(&tracingURL, "tracing-endpoint", "", "OTLP/gRPC collector URL for traces, e.g. http://otel-collector:4317 (empty = disabled)")
+	flags.BoolVar(&reflection, "grpc-reflection", false, "Register the gRPC reflection service (default: enabled only with --log-level debug)")
+	flags.BoolVar(&adminAPI, "admin-api", false, "Serve the token-protected key metadata endpoints (/keys) on the HTTP port")
+	flags.StringVar(&adminToken, "admin-token", "", "Bearer token required by the admin endpoints (empty = generated and logged at startup)")
+	flags.Float64Var(&adminRate, "admin-rate-limit", models.DefaultAdminRateLimit, "Admin requests per second allowed per client IP; excess requests get 429")
+	flags.IntVar(&adminBurst, "admin-rate-burst", models.DefaultAdminRateBurst, "Admin requests a client IP may send at once before --admin-rate-limit applies")
+	flags.DurationVar(&denialWin, "denial-window", 0, "Rolling window /denials counts over, e.g. 5m (0 = cumulative since startup)")
+	flags.DurationVar(&cacheTTL, "allow-cache-ttl", 0, "How long Envoy may cache an allow decision, at most 5m; revoked keys work until it expires (0 = no caching)")
+	flags.Float32Var(&kubeQPS, "kube-qps", 20, "Maximum sustained requests per second to the Kubernetes API server")
+	flags.IntVar(&kubeBurst, "kube-burst", 40, "Maximum burst of requests to the Kubernetes API server")
+	flags.DurationVar(&kubeBackoff, "kube-backoff-max", 30*time.Second, "Upper bound of the jittered backoff while the Kubernetes API server answers 429 (0 = no backoff)")
+	flags.DurationVar(&statsLog, "stats-log-interval", 0, "Log key counts and the last sync time at this interval, e.g. 5m (0 = disabled)")
+	flags.BoolVar(&failOpen, "fail-open-until-synced", false, "DANGEROUS: allow all requests unauthenticated until the keys have been synced once")
+	flags.IntVar(&maxKeyLen, "max-api-key-length", models.DefaultMaxAPIKeyLength, "Longest API key accepted in bytes; longer keys are rejected without being hashed")
+	flags.StringVar(&keyPrefix, "required-key-prefix", "", "Prefix every API key must start with, e.g. acme_; other keys are denied without being hashed (empty = any prefix)")
+	flags.StringVar(&breakGlass, "break-glass-key-hash", "", "SHA-256 hash (hex) of an emergency key allowed even when no keys are loaded; every use is logged (empty = disabled)")
+	flags.StringSliceVar(&reqHeaders, "required-headers", nil, "Headers that must be present on requests with a valid key, e.g. x-request-id (empty = no check)")
+	flags.BoolVar(&emitEvents, "emit-events", false, "Create Kubernetes Events when a source repeatedly fails validation or a disabled key is used (rate limited)")
+	flags.BoolVar(&verboseDeny, "verbose-deny-reasons", false, "Tell callers whether their key is unknown or disabled (lets anyone probe which keys exist)")
+	flags.IntVar(&maxChecks, "max-concurrent-checks", 0, "Maximum Check calls served at once; excess calls fail with ResourceExhausted (0 = unlimited)")
+	flags.StringToStringVar(&okHeaders, "allow-response-headers", nil, "Headers added to the upstream request on every allow, e.g. x-auth-gateway=batsign (overwrites client values)")
+	flags.StringVar(&keyIDHeader, "key-id-header", "", "Header added to the upstream request on allows with a valid key, holding the key's short ID, e.g. x-api-key-id (empty = disabled)")
+	flags.StringVar(&timezone, "timezone", "", "IANA time zone daily key active windows are evaluated in, e.g. Europe/Paris (empty = server local time)")
+	flags.StringVar(&trustedKey, "trusted-key-header", "", "Header read for the API key before authorization/x-api-key, e.g. injected by an mTLS sidecar; only safe if that hop overwrites client values (empty = disabled)")
+	flags.BoolVar(&perKey, "per-key-metrics", false, "Label allow/deny counts on /stats with the key name, up to --per-key-metrics-max-keys keys")
+	flags.IntVar(&perKeyMax, "per-key-metrics-max-keys", models.DefaultPerKeyMetricsMaxKeys, "Keys labelled by --per-key-metrics; later keys share the __other__ label")
+	flags.StringVar(&routeRules, "route-rules-configmap", "", "namespace/name of a ConfigMap listing paths that do not need an API key, watched for changes (empty = all paths need one)")
+	flags.BoolVar(&checkRBAC, "check-rbac", false, "Fail at startup unless the service account may list and watch APIKeys (and Secrets with --secret-selector)")
+	flags.DurationVar(&crdWait, "crd-wait-timeout", 0, "How long to wait for the APIKey CRD to be installed at startup, e.g. 5m (0 = fail immediately)")
+	flags.IntVar(&breakerMax, "watch-breaker-failures", 5, "Consecutive watch failures after which re-watches pause for --watch-breaker-cooldown, serving the cached keys (0 = retry forever)")
+	flags.DurationVar(&breakerWait, "watch-breaker-cooldown", models.DefaultWatchBreakerCooldown, "How long re-watches pause once the watch circuit breaker opened")
+	flags.DurationVar(&livenessMax, "watch-liveness-timeout", models.DefaultWatchLivenessTimeout, "How long a watch goroutine may go without running, e.g. because it died, before /livez fails (at least 30s)")
+
+	rootCmd.AddCommand(validateCmd)
 }
 
 func main() {
@@ -44,18 +163,89 @@ func main() {
 }
 
 func run(cmd *cobra.Command, args []string) error {
-	config := &models.Config{
+	srv, err := server.New(newConfig(cmd))
+	if err != nil {
+		return fmt.Errorf("failed to create server: %w", err)
+	}
+
+	return srv.Run()
+}
+
+// validateConfig reports every problem of the configuration at once
+func validateConfig(cmd *cobra.Command, args []string) error {
+	ctx, cancel := context.WithTimeout(cmd.Context(), validateTimeout)
+	defer cancel()
+
+	report := server.ValidateConfig(ctx, newConfig(cmd))
+	report.Write(cmd.OutOrStdout())
+	if !report.OK() {
+		// The report already explains the failures
+		cmd.SilenceErrors, cmd.SilenceUsage = true, true
+		return errors.New("invalid configuration")
+	}
+	return nil
+}
+
+// newConfig builds the server configuration from the flags
+func newConfig(cmd *cobra.Command) *models.Config {
+	// Reflection exposes the service surface, so only default it on when debugging
+	if !cmd.Flags().Changed("grpc-reflection") {
+		reflection = logLevel == "debug"
+	}
+
+	return &models.Config{
 		GRPCPort:   grpcPort,
 		HTTPPort:   httpPort,
 		Namespace:  namespace,
 		Kubeconfig: kubeconfig,
 		LogLevel:   logLevel,
-	}
 
-	srv, err := server.New(config)
-	if err != nil {
-		return fmt.Errorf("failed to create server: %w", err)
-	}
+		KubeContext:          kubeContext,
+		SecretLabelSelector:  secretSel,
+		InMemory:             inMemory,
+		KeysFile:             keysFile,
+		DenyBodyFormat:       denyFormat,
+		DenyBodyTemplate:     denyTmpl,
+		DenyRedirectURL:      denyURL,
+		DenyRedirectStatus:   denyStatus,
+		AlertWebhookURL:      alertURL,
+		AlertReasons:         alertOn,
+		TracingEndpoint:      tracingURL,
+		EnableReflection:     reflection,
+		AdminAPIEnabled:      adminAPI,
+		AdminToken:           adminToken,
+		AdminRateLimit:       adminRate,
+		AdminRateBurst:       adminBurst,
+		DenialWindow:         denialWin,
+		AllowCacheTTL:        cacheTTL,
+		KubeQPS:              kubeQPS,
+		KubeBurst:            kubeBurst,
+		KubeBackoffMax:       kubeBackoff,
+		StatsLogInterval:     statsLog,
+		FailOpenUntilSynced:  failOpen,
+		MaxAPIKeyLength:      maxKeyLen,
+		RequiredKeyPrefix:    keyPrefix,
+		BreakGlassKeyHash:    breakGlass,
+		CRDWaitTimeout:       crdWait,
+		RequiredHeaders:      reqHeaders,
+		EmitEvents:           emitEvents,
+		Timezone:             timezone,
+		AllowResponseHeaders: okHeaders,
+		KeyIDHeader:          keyIDHeader,
+		MaxConcurrentChecks:  maxChecks,
+		VerboseDenyReasons:   verboseDeny,
+		RouteRulesConfigMap:  routeRules,
+		TrustedKeyHeader:     trustedKey,
+		PerKeyMetrics:        perKey,
+		PerKeyMetricsMaxKeys: perKeyMax,
+		CheckRBAC:            checkRBAC,
+		WatchBreakerFailures: breakerMax,
+		WatchBreakerCooldown: breakerWait,
+		WatchLivenessTimeout: livenessMax,
 
-	return srv.Run()
+		ExternalValidatorURL:      extURL,
+		ExternalValidatorTimeout:  extTimeout,
+		ExternalValidatorCacheTTL: extTTL,
+		ExternalValidatorFailOpen: extFailOpen,
+	}
 }
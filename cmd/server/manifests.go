@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/spf13/cobra"
+)
+
+var (
+	manifestNamespace string
+	manifestImage     string
+	manifestReplicas  int
+)
+
+var manifestsCmd = &cobra.Command{
+	Use:   "manifests",
+	Short: "Print the Kubernetes objects deploying the server",
+	Long: `Print an apply-ready YAML bundle deploying the server: a ServiceAccount, a
+ClusterRole allowing it to get, list and watch APIKeys only, its binding, a
+Deployment and a Service. The ports and log level are those of --grpc-port,
+--http-port and --log-level; other server flags are not carried over, and
+features needing more permissions, e.g. --emit-events, need extra rules.
+
+  apikey-manager-server manifests -n auth --image ghcr.io/efortin/batsign:v1.2.3 | kubectl apply -f -`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         runManifests,
+}
+
+func init() {
+	// Local, shadowing the namespace the server watches
+	manifestsCmd.Flags().StringVarP(&manifestNamespace, "namespace", "n", "kgateway-system", "Namespace the server is deployed to")
+	manifestsCmd.Flags().StringVar(&manifestImage, "image", "ghcr.io/efortin/batsign:latest", "Image of the server container")
+	manifestsCmd.Flags().IntVar(&manifestReplicas, "replicas", 2, "Number of server Pods")
+
+	rootCmd.AddCommand(manifestsCmd)
+}
+
+func runManifests(cmd *cobra.Command, args []string) error {
+	out, err := kube.Manifests(kube.ManifestOptions{
+		Namespace: manifestNamespace,
+		Image:     manifestImage,
+		Replicas:  manifestReplicas,
+		GRPCPort:  grpcPort,
+		HTTPPort:  httpPort,
+		LogLevel:  logLevel,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(cmd.OutOrStdout(), out)
+	return nil
+}
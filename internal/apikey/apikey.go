@@ -1,15 +1,19 @@
 package apikey
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/efortin/batsign/internal/models"
+	"golang.org/x/net/idna"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/yaml"
 )
@@ -17,78 +21,379 @@ import (
 // randReader is the default random reader (crypto/rand.Reader)
 var randReader io.Reader = rand.Reader
 
+// Key generation defaults
+const (
+	// KeyPrefix precedes the encoded random bytes of every key
+	KeyPrefix = "sk-"
+	// DefaultKeyBytes is the number of random bytes in a generated key
+	DefaultKeyBytes = 32
+	// DefaultMinKeyBits is the minimum entropy a generated key must carry
+	DefaultMinKeyBits = 256
+)
+
+// maxKeyPrefixLength bounds custom key prefixes, which carry no entropy
+const maxKeyPrefixLength = 32
+
+// keyPrefixPattern matches the prefixes keys may start with: characters
+// safe in headers and never splitting a key (no commas), starting with a
+// letter or digit
+var keyPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// Config controls the size of generated keys
+type Config struct {
+	// NumBytes is the number of random bytes in a key (0 = DefaultKeyBytes)
+	NumBytes int
+	// MinKeyBits is the minimum accepted entropy in bits (0 = DefaultMinKeyBits)
+	MinKeyBits int
+	// Prefix precedes the encoded random bytes, e.g. a tenant's
+	// (empty = KeyPrefix)
+	Prefix string
+}
+
+// numBytes returns the configured byte count or the default
+func (c Config) numBytes() int {
+	if c.NumBytes == 0 {
+		return DefaultKeyBytes
+	}
+	return c.NumBytes
+}
+
+// prefix returns the configured key prefix or the default
+func (c Config) prefix() string {
+	if c.Prefix == "" {
+		return KeyPrefix
+	}
+	return c.Prefix
+}
+
+// minKeyBits returns the configured minimum entropy or the default
+func (c Config) minKeyBits() int {
+	if c.MinKeyBits == 0 {
+		return DefaultMinKeyBits
+	}
+	return c.MinKeyBits
+}
+
+// EntropyBits returns the entropy of the generated keys in bits
+func (c Config) EntropyBits() int {
+	return c.numBytes() * 8
+}
+
+// Validate checks that the generated keys meet the minimum entropy
+func (c Config) Validate() error {
+	if c.NumBytes < 0 {
+		return fmt.Errorf("invalid key size: %d bytes", c.NumBytes)
+	}
+	if c.EntropyBits() < c.minKeyBits() {
+		return fmt.Errorf("key entropy of %d bits (%d bytes) is below the minimum of %d bits", c.EntropyBits(), c.numBytes(), c.minKeyBits())
+	}
+	if c.Prefix != "" {
+		return ValidateKeyPrefix(c.Prefix)
+	}
+	return nil
+}
+
+// ValidateKeyPrefix checks that prefix may start generated keys, e.g. one a
+// server requires
+func ValidateKeyPrefix(prefix string) error {
+	if len(prefix) > maxKeyPrefixLength || !keyPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid key prefix %q: expected at most %d letters, digits, '-' or '_', starting with a letter or digit", prefix, maxKeyPrefixLength)
+	}
+	return nil
+}
+
 // GenerateAPIKey generates a secure random API key with format sk-<base64>
 func GenerateAPIKey() (string, error) {
 	return GenerateAPIKeyWithReader(randReader)
 }
 
-// GenerateAPIKeyWithReader generates an API key using the provided reader (exported for testing)
+// GenerateAPIKeyWithReader generates an API key using the provided reader
+// (exported for testing). apikeytest.DeterministicReader makes the keys
+// reproducible for golden tests and demos.
 func GenerateAPIKeyWithReader(reader io.Reader) (string, error) {
-	// Generate 32 random bytes
-	b := make([]byte, 32)
-	if _, err := reader.Read(b); err != nil {
-		return "", fmt.Errorf("error generating random key: %w", err)
+	return GenerateAPIKeyWithConfig(reader, Config{})
+}
+
+// ContextReader is a random source that honours cancellation and deadlines,
+// e.g. a network HSM or KMS client
+type ContextReader interface {
+	ReadContext(ctx context.Context, p []byte) (int, error)
+}
+
+// GenerateAPIKeyContext generates an API key, giving up when ctx is done if
+// the reader implements ContextReader
+func GenerateAPIKeyContext(ctx context.Context, reader io.Reader) (string, error) {
+	return GenerateAPIKeyWithConfigContext(ctx, reader, Config{})
+}
+
+// GenerateAPIKeyWithConfig generates an API key of the configured size,
+// refusing sizes below the minimum entropy
+func GenerateAPIKeyWithConfig(reader io.Reader, cfg Config) (string, error) {
+	return GenerateAPIKeyWithConfigContext(context.Background(), reader, cfg)
+}
+
+// GenerateAPIKeyWithConfigContext is GenerateAPIKeyWithConfig with a context.
+// Plain readers cannot be interrupted, so ctx is only checked before reading.
+func GenerateAPIKeyWithConfigContext(ctx context.Context, reader io.Reader, cfg Config) (string, error) {
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+
+	// Generate the random bytes
+	b := make([]byte, cfg.numBytes())
+	if err := readRandom(ctx, reader, b); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRandSource, err)
 	}
 
 	// Encode to base64 URL-safe without padding
 	encoded := base64.RawURLEncoding.EncodeToString(b)
 
-	return "sk-" + encoded, nil
+	return cfg.prefix() + encoded, nil
+}
+
+// readRandom fills b from reader, through ReadContext when supported. Short
+// reads, e.g. from an HSM returning a few bytes at a time, are continued
+// until b is full, so keys never keep zero bytes.
+func readRandom(ctx context.Context, reader io.Reader, b []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cr, ok := reader.(ContextReader)
+	if !ok {
+		_, err := io.ReadFull(reader, b)
+		return err
+	}
+	for filled := 0; filled < len(b); {
+		n, err := cr.ReadContext(ctx, b[filled:])
+		filled += n
+		switch {
+		case filled == len(b):
+			return nil
+		case err == io.EOF && filled > 0:
+			return io.ErrUnexpectedEOF
+		case err != nil:
+			return err
+		case n == 0:
+			return io.ErrNoProgress
+		}
+	}
+	return nil
+}
+
+// ValidateAPIKey checks that a key provided by another system has the shape
+// of a generated key: the configured prefix (sk- by default) followed by the
+// configured number of base64url-encoded random bytes
+func ValidateAPIKey(key string, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(key, cfg.prefix()) {
+		return fmt.Errorf("%w: missing %q prefix", ErrInvalidAPIKey, cfg.prefix())
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(key, cfg.prefix()))
+	if err != nil {
+		return fmt.Errorf("%w: not base64url encoded after the prefix", ErrInvalidAPIKey)
+	}
+	if len(raw) != cfg.numBytes() {
+		return fmt.Errorf("%w: %d random bytes, want %d", ErrInvalidAPIKey, len(raw), cfg.numBytes())
+	}
+	return nil
+}
+
+// NewAPIKeySpec creates an enabled spec for a key, with its hash and hint
+func NewAPIKeySpec(key, email string) models.APIKeySpec {
+	return models.APIKeySpec{
+		Email:   NormalizeEmail(email),
+		KeyHash: HashAPIKey(key),
+		KeyHint: GenerateHint(key),
+		Enabled: true,
+	}
+}
+
+// NewAPIKeySpecWithAlgo creates an enabled spec for a key, hashed with the
+// given algorithm (see models.HashAlgo*; empty = SHA-256)
+func NewAPIKeySpecWithAlgo(key, email, algo string) (models.APIKeySpec, error) {
+	spec := NewAPIKeySpec(key, email)
+	hash, err := HashAPIKeyWithAlgo(key, algo)
+	if err != nil {
+		return models.APIKeySpec{}, err
+	}
+	if algo == models.HashAlgoArgon2id {
+		spec.KeyHash, spec.KeyHashAlgo = hash, algo
+	}
+	return spec, nil
+}
+
+// HashAPIKeyWithAlgo hashes a key with the given algorithm (see
+// models.HashAlgo*; empty = SHA-256)
+func HashAPIKeyWithAlgo(key, algo string) (string, error) {
+	switch algo {
+	case "", models.HashAlgoSHA256:
+		return HashAPIKey(key), nil
+	case models.HashAlgoArgon2id:
+		return HashAPIKeyArgon2id(key)
+	default:
+		return "", fmt.Errorf("unknown key hash algorithm %q (%s, %s)", algo, models.HashAlgoSHA256, models.HashAlgoArgon2id)
+	}
 }
 
 // HashAPIKey generates a SHA-256 hash of the API key
 func HashAPIKey(apiKey string) string {
 	hash := sha256.Sum256([]byte(apiKey))
-	return fmt.Sprintf("%x", hash)
+	return hex.EncodeToString(hash[:])
 }
 
-// GenerateHint creates a hint showing first 6 and last 2 characters
+// keyIDLen is the number of hex characters of a key ID
+const keyIDLen = 12
+
+// KeyID returns the short ID of the key with the given stored hash, to
+// correlate its uses across systems without exposing the full hash: the
+// first 12 hex characters of a SHA-256 hash, or of the SHA-256 of any other
+// hash, e.g. a salted argon2id one
+func KeyID(keyHash string) string {
+	if !keyHashPattern.MatchString(keyHash) {
+		keyHash = HashAPIKey(keyHash)
+	}
+	return keyHash[:keyIDLen]
+}
+
+// GenerateHint creates a hint showing first 6 and last 2 characters.
+// Characters are counted as runes so multibyte prefixes are never split;
+// invalid UTF-8 is replaced with U+FFFD.
 func GenerateHint(apiKey string) string {
-	if len(apiKey) < 8 {
-		return apiKey
+	runes := []rune(apiKey)
+	if len(runes) < hintPrefixLen+hintSuffixLen {
+		return string(runes)
 	}
-	first6 := apiKey[:6]
-	last2 := apiKey[len(apiKey)-2:]
-	stars := strings.Repeat("*", 13)
+	first6 := string(runes[:hintPrefixLen])
+	last2 := string(runes[len(runes)-hintSuffixLen:])
+	stars := strings.Repeat("*", hintMaskLen)
 	return first6 + stars + last2
 }
 
-// SanitizeEmail converts email to a valid Kubernetes resource name
+// SanitizeEmail converts email to a valid Kubernetes resource name.
+// Internationalized domains are converted to punycode first.
 func SanitizeEmail(email string) string {
 	// Replace @ with -at- and dots with dashes
-	name := strings.ReplaceAll(email, "@", "-at-")
+	name := strings.ReplaceAll(NormalizeEmail(email), "@", "-at-")
 	name = strings.ReplaceAll(name, ".", "-")
 	return name
 }
 
-// ValidateEmail validates email format
+// emailRegex matches the ASCII emails the CRD accepts; top-level domains are
+// letters, or punycode for internationalized ones
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.([a-zA-Z]{2,}|xn--[a-zA-Z0-9-]+)$`)
+
+// bareHostEmailRegex matches ASCII emails whose domain is a single label,
+// e.g. user@localhost, which the CRD accepts too
+var bareHostEmailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9-]+$`)
+
+// ValidateEmailOptions relaxes ValidateEmail for internal deployments, whose
+// owners may have addresses without a top-level domain. The zero value is
+// strict.
+type ValidateEmailOptions struct {
+	// AllowNoTLD accepts any single-label domain, e.g. user@internal
+	AllowNoTLD bool
+	// AllowLocalhost accepts the localhost domain only, e.g. user@localhost
+	AllowLocalhost bool
+}
+
+// ValidateEmail validates email format. Internationalized domains, e.g.
+// user@münchen.de, are valid once converted to punycode.
 func ValidateEmail(email string) error {
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	if !emailRegex.MatchString(email) {
-		return fmt.Errorf("invalid email format: %s", email)
+	return ValidateEmailWithOptions(email, ValidateEmailOptions{})
+}
+
+// ValidateEmailWithOptions validates email format like ValidateEmail, also
+// accepting the domains without a top-level domain allowed by opts
+func ValidateEmailWithOptions(email string, opts ValidateEmailOptions) error {
+	normalized := NormalizeEmail(email)
+	if emailRegex.MatchString(normalized) {
+		return nil
 	}
-	return nil
+	if bareHostEmailRegex.MatchString(normalized) {
+		domain := normalized[strings.LastIndex(normalized, "@")+1:]
+		if opts.AllowNoTLD || (opts.AllowLocalhost && strings.EqualFold(domain, "localhost")) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidEmail, email)
+}
+
+// NormalizeEmail converts an internationalized domain of email to punycode,
+// e.g. user@münchen.de to user@xn--mnchen-3ya.de, as stored in APIKey specs.
+// Other emails, and ones whose domain cannot be converted, are returned
+// unchanged.
+func NormalizeEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 || isASCII(email[at+1:]) {
+		return email
+	}
+	domain, err := idna.Lookup.ToASCII(email[at+1:])
+	if err != nil {
+		return email
+	}
+	return email[:at+1] + domain
 }
 
+// isASCII reports whether s holds ASCII characters only
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// ManagedByLabel marks the resources generated by the client with
+// ManagedBy, e.g. to tell them from hand-written ones
+const (
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedBy      = "apikey-manager-client"
+)
+
 // GenerateYAML generates the Kubernetes YAML for an APIKey resource
 func GenerateYAML(spec models.APIKeySpec) (string, error) {
-	resourceName := SanitizeEmail(spec.Email)
+	return GenerateYAMLInNamespace("", spec)
+}
 
+// GenerateYAMLInNamespace generates the Kubernetes YAML for an APIKey
+// resource in the given namespace (empty = the namespace kubectl applies it
+// to)
+func GenerateYAMLInNamespace(namespace string, spec models.APIKeySpec) (string, error) {
+	return generateYAML(metav1.ObjectMeta{
+		Name:      ResourceName(spec.Email),
+		Namespace: namespace,
+		Labels:    map[string]string{ManagedByLabel: ManagedBy},
+	}, spec)
+}
+
+// GenerateNamedYAML generates the Kubernetes YAML for an APIKey resource
+// with the given name and namespace (empty = the namespace kubectl applies
+// it to), e.g. to export an existing resource
+func GenerateNamedYAML(namespace, resourceName string, spec models.APIKeySpec) (string, error) {
+	return generateYAML(metav1.ObjectMeta{Name: resourceName, Namespace: namespace}, spec)
+}
+
+// generateYAML generates the Kubernetes YAML for an APIKey resource. The
+// output only depends on its input: fields are sorted and empty optional
+// ones omitted, so applying it again, e.g. with kubectl apply --server-side,
+// changes nothing.
+func generateYAML(meta metav1.ObjectMeta, spec models.APIKeySpec) (string, error) {
 	apiKey := &models.APIKey{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "auth.kgateway.dev/v1alpha1",
 			Kind:       "APIKey",
 		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name: resourceName,
-		},
-		Spec: spec,
+		ObjectMeta: meta,
+		Spec:       spec,
 	}
 
 	// Marshal to YAML
 	yamlBytes, err := yaml.Marshal(apiKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal APIKey to YAML: %w", err)
+		return "", fmt.Errorf("%w: %w", ErrYAMLMarshal, err)
 	}
 
 	// Add document separator
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"unicode/utf8"
 
 	"github.com/efortin/batsign/internal/apikey"
 	"github.com/efortin/batsign/internal/models"
@@ -108,6 +109,22 @@ var _ = Describe("APIKey", func() {
 				Expect(hint).To(Equal("sk-123*************45"))
 			})
 		})
+
+		Context("with multibyte characters", func() {
+			It("should count runes rather than bytes", func() {
+				hint := apikey.GenerateHint("🔑🔑-abcdefghijklmnop😀✓")
+
+				Expect(hint).To(Equal("🔑🔑-abc*************😀✓"))
+				Expect(utf8.ValidString(hint)).To(BeTrue())
+			})
+
+			It("should keep accented prefixes intact", func() {
+				hint := apikey.GenerateHint("clé-éàüabcdefghijkç")
+
+				Expect(hint).To(Equal("clé-éà*************kç"))
+				Expect(utf8.ValidString(hint)).To(BeTrue())
+			})
+		})
 	})
 
 	Describe("SanitizeEmail", func() {
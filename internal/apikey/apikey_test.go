@@ -1,14 +1,22 @@
 package apikey
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/efortin/batsign/internal/models"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
 )
 
 // failingReader is a reader that always returns an error
@@ -18,6 +26,49 @@ func (f *failingReader) Read(p []byte) (n int, err error) {
 	return 0, errors.New("simulated random read failure")
 }
 
+// blockingContextReader blocks until the context is done
+type blockingContextReader struct {
+	plainReads int
+}
+
+func (r *blockingContextReader) Read(p []byte) (int, error) {
+	r.plainReads++
+	return len(p), nil
+}
+
+func (r *blockingContextReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+// shortReader returns at most one 0xff byte per read, up to limit bytes
+// (0 = unlimited), then io.EOF
+type shortReader struct {
+	limit int
+	read  int
+}
+
+func (r *shortReader) Read(p []byte) (int, error) {
+	if r.limit > 0 && r.read == r.limit {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = 0xff
+	r.read++
+	return 1, nil
+}
+
+// shortContextReader is a shortReader read through ReadContext
+type shortContextReader struct {
+	shortReader
+}
+
+func (r *shortContextReader) ReadContext(_ context.Context, p []byte) (int, error) {
+	return r.shortReader.Read(p)
+}
+
 func TestGenerateAPIKey(t *testing.T) {
 	tests := []struct {
 		name string
@@ -53,6 +104,233 @@ func TestGenerateAPIKey(t *testing.T) {
 	}
 }
 
+func TestGenerateAPIKeyWithConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantLength int
+		wantErr    bool
+	}{
+		{name: "Defaults", cfg: Config{}, wantLength: 46},
+		{name: "At minimum", cfg: Config{NumBytes: 32, MinKeyBits: 256}, wantLength: 46},
+		{name: "Above minimum", cfg: Config{NumBytes: 48}, wantLength: 67},
+		{name: "Below default minimum", cfg: Config{NumBytes: 31}, wantErr: true},
+		{name: "Below custom minimum", cfg: Config{NumBytes: 32, MinKeyBits: 384}, wantErr: true},
+		{name: "Lowered minimum", cfg: Config{NumBytes: 16, MinKeyBits: 128}, wantLength: 25},
+		{name: "Negative size", cfg: Config{NumBytes: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := GenerateAPIKeyWithConfig(randReader, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateAPIKeyWithConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(key) != tt.wantLength {
+				t.Errorf("GenerateAPIKeyWithConfig() key length = %d, want %d", len(key), tt.wantLength)
+			}
+		})
+	}
+}
+
+func TestConfig_EntropyBits(t *testing.T) {
+	if got := (Config{}).EntropyBits(); got != 256 {
+		t.Errorf("EntropyBits() = %d, want 256", got)
+	}
+	if got := (Config{NumBytes: 48}).EntropyBits(); got != 384 {
+		t.Errorf("EntropyBits() = %d, want 384", got)
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	generated, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() error = %v", err)
+	}
+	large, err := GenerateAPIKeyWithConfig(randReader, Config{NumBytes: 48})
+	if err != nil {
+		t.Fatalf("GenerateAPIKeyWithConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "Generated key", key: generated},
+		{name: "Generated key with custom size", key: large, cfg: Config{NumBytes: 48}},
+		{name: "Size mismatch", key: large, wantErr: true},
+		{name: "Missing prefix", key: strings.TrimPrefix(generated, "sk-"), wantErr: true},
+		{name: "Not base64", key: "sk-" + strings.Repeat("!", 43), wantErr: true},
+		{name: "Empty", key: "", wantErr: true},
+		{name: "Below minimum entropy", key: generated, cfg: Config{NumBytes: 16}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAPIKey(tt.key, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewAPIKeySpec_MatchesGeneration(t *testing.T) {
+	key, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() error = %v", err)
+	}
+
+	want, err := GenerateYAML(models.APIKeySpec{
+		Email:   "user@example.com",
+		KeyHash: HashAPIKey(key),
+		KeyHint: GenerateHint(key),
+		Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("GenerateYAML() error = %v", err)
+	}
+
+	got, err := GenerateYAML(NewAPIKeySpec(key, "user@example.com"))
+	if err != nil {
+		t.Fatalf("GenerateYAML() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("NewAPIKeySpec() YAML = %s, want %s", got, want)
+	}
+	if strings.Contains(got, key) {
+		t.Error("NewAPIKeySpec() YAML must not contain the key")
+	}
+}
+
+func TestGenerateAPIKeyContext(t *testing.T) {
+	t.Run("Context reader returns on cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		reader := &blockingContextReader{}
+		_, err := GenerateAPIKeyContext(ctx, reader)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("GenerateAPIKeyContext() error = %v, want deadline exceeded", err)
+		}
+		if reader.plainReads != 0 {
+			t.Errorf("GenerateAPIKeyContext() used Read instead of ReadContext")
+		}
+	})
+
+	t.Run("Plain reader is not read once cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := GenerateAPIKeyContext(ctx, &failingReader{})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("GenerateAPIKeyContext() error = %v, want context canceled", err)
+		}
+	})
+
+	t.Run("Plain reader with live context", func(t *testing.T) {
+		key, err := GenerateAPIKeyContext(context.Background(), randReader)
+		if err != nil {
+			t.Fatalf("GenerateAPIKeyContext() error = %v", err)
+		}
+		if len(key) != 46 {
+			t.Errorf("GenerateAPIKeyContext() key length = %d, want 46", len(key))
+		}
+	})
+}
+
+func TestGenerateAPIKeyShortReads(t *testing.T) {
+	tests := []struct {
+		name   string
+		reader io.Reader
+		// wantErr is the error expected, nil when the key must be full
+		wantErr error
+	}{
+		{"plain reader", &shortReader{}, nil},
+		{"context reader", &shortContextReader{}, nil},
+		{"plain reader ending early", &shortReader{limit: 10}, io.ErrUnexpectedEOF},
+		{"context reader ending early", &shortContextReader{shortReader{limit: 10}}, io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := GenerateAPIKeyContext(context.Background(), tt.reader)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !errors.Is(err, ErrRandSource) {
+					t.Errorf("GenerateAPIKeyContext() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateAPIKeyContext() error = %v", err)
+			}
+			raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(key, KeyPrefix))
+			if err != nil {
+				t.Fatalf("key is not base64url encoded: %v", err)
+			}
+			if len(raw) != DefaultKeyBytes || bytes.Count(raw, []byte{0xff}) != DefaultKeyBytes {
+				t.Errorf("GenerateAPIKeyContext() bytes = %x, want %d bytes all read from the reader", raw, DefaultKeyBytes)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	readErr := errors.New("simulated random read failure")
+
+	tests := []struct {
+		name    string
+		err     error
+		want    error
+		wantMsg string
+	}{
+		{
+			name:    "Invalid email",
+			err:     ValidateEmail("not-an-email"),
+			want:    ErrInvalidEmail,
+			wantMsg: "invalid email format: not-an-email",
+		},
+		{
+			name: "Random source failure",
+			err: func() error {
+				_, err := GenerateAPIKeyWithReader(&failingReader{})
+				return err
+			}(),
+			want:    ErrRandSource,
+			wantMsg: "error generating random key: " + readErr.Error(),
+		},
+		{
+			name:    "Invalid API key",
+			err:     ValidateAPIKey("pk-abc", Config{}),
+			want:    ErrInvalidAPIKey,
+			wantMsg: `invalid API key: missing "sk-" prefix`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false, want true", tt.err, tt.want)
+			}
+			if tt.err.Error() != tt.wantMsg {
+				t.Errorf("error message = %q, want %q", tt.err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestErrors_WrapCause(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := GenerateAPIKeyContext(ctx, randReader)
+	if !errors.Is(err, ErrRandSource) || !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateAPIKeyContext() error = %v, want ErrRandSource wrapping context.Canceled", err)
+	}
+}
+
 func TestGenerateAPIKey_Uniqueness(t *testing.T) {
 	// Generate multiple keys and ensure they're unique
 	keys := make(map[string]bool)
@@ -112,6 +390,25 @@ func TestHashAPIKey(t *testing.T) {
 	}
 }
 
+func TestKeyID(t *testing.T) {
+	sha := HashAPIKey("sk-test123")
+	if got := KeyID(sha); got != sha[:12] {
+		t.Errorf("KeyID(SHA-256) = %s, want the first 12 characters of the hash %s", got, sha)
+	}
+
+	argon, err := HashAPIKeyArgon2id("sk-test123")
+	if err != nil {
+		t.Fatalf("HashAPIKeyArgon2id() error = %v", err)
+	}
+	got := KeyID(argon)
+	if len(got) != 12 || strings.Trim(got, "0123456789abcdef") != "" {
+		t.Errorf("KeyID(argon2id) = %q, want 12 hex characters", got)
+	}
+	if again := KeyID(argon); again != got {
+		t.Errorf("KeyID(argon2id) = %s then %s, want a stable ID", got, again)
+	}
+}
+
 func TestGenerateHint(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -133,6 +430,26 @@ func TestGenerateHint(t *testing.T) {
 			apiKey: "sk-12345",
 			want:   "sk-123*************45",
 		},
+		{
+			name:   "Emoji prefix",
+			apiKey: "🔑🔑-abcdefghijklmnop😀✓",
+			want:   "🔑🔑-abc*************😀✓",
+		},
+		{
+			name:   "Accented prefix",
+			apiKey: "clé-éàüabcdefghijkç",
+			want:   "clé-éà*************kç",
+		},
+		{
+			name:   "Short multibyte key",
+			apiKey: "clé-é",
+			want:   "clé-é",
+		},
+		{
+			name:   "Invalid UTF-8",
+			apiKey: "sk-\xffabcdef\xfe",
+			want:   "sk-\uFFFDab*************f\uFFFD",
+		},
 	}
 
 	for _, tt := range tests {
@@ -141,6 +458,9 @@ func TestGenerateHint(t *testing.T) {
 			if got != tt.want {
 				t.Errorf("GenerateHint() = %v, want %v", got, tt.want)
 			}
+			if !utf8.ValidString(got) {
+				t.Errorf("GenerateHint() returned invalid UTF-8: %q", got)
+			}
 		})
 	}
 }
@@ -166,6 +486,11 @@ func TestSanitizeEmail(t *testing.T) {
 			email: "user+tag@domain.com",
 			want:  "user+tag-at-domain-com",
 		},
+		{
+			name:  "Internationalized domain",
+			email: "user@münchen.de",
+			want:  "user-at-xn--mnchen-3ya-de",
+		},
 	}
 
 	for _, tt := range tests {
@@ -224,6 +549,21 @@ func TestValidateEmail(t *testing.T) {
 			email:   "user name@example.com",
 			wantErr: true,
 		},
+		{
+			name:    "Valid email - internationalized domain",
+			email:   "user@münchen.de",
+			wantErr: false,
+		},
+		{
+			name:    "Valid email - internationalized TLD",
+			email:   "user@пример.рф",
+			wantErr: false,
+		},
+		{
+			name:    "Invalid email - invalid internationalized domain",
+			email:   "user@mü nchen.de",
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -236,6 +576,34 @@ func TestValidateEmail(t *testing.T) {
 	}
 }
 
+func TestValidateEmailWithOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		opts    ValidateEmailOptions
+		wantErr bool
+	}{
+		{name: "localhost rejected by default", email: "user@localhost", wantErr: true},
+		{name: "localhost allowed", email: "user@localhost", opts: ValidateEmailOptions{AllowLocalhost: true}},
+		{name: "localhost allowed without TLD", email: "user@LocalHost", opts: ValidateEmailOptions{AllowNoTLD: true}},
+		{name: "bare hostname rejected by default", email: "user@internal", wantErr: true},
+		{name: "bare hostname allowed", email: "user@internal", opts: ValidateEmailOptions{AllowNoTLD: true}},
+		{name: "bare hostname not localhost", email: "user@internal", opts: ValidateEmailOptions{AllowLocalhost: true}, wantErr: true},
+		{name: "TLD still accepted", email: "user@example.com", opts: ValidateEmailOptions{AllowNoTLD: true}},
+		{name: "no domain", email: "user@", opts: ValidateEmailOptions{AllowNoTLD: true}, wantErr: true},
+		{name: "invalid hostname", email: "user@in_ternal", opts: ValidateEmailOptions{AllowNoTLD: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEmailWithOptions(tt.email, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateEmailWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestGenerateYAML(t *testing.T) {
 	tests := []struct {
 		name string
@@ -255,6 +623,8 @@ func TestGenerateYAML(t *testing.T) {
 apiVersion: auth.kgateway.dev/v1alpha1
 kind: APIKey
 metadata:
+  labels:
+    app.kubernetes.io/managed-by: apikey-manager-client
   name: user-at-example-com
 spec:
   description: Test key
@@ -277,6 +647,8 @@ spec:
 apiVersion: auth.kgateway.dev/v1alpha1
 kind: APIKey
 metadata:
+  labels:
+    app.kubernetes.io/managed-by: apikey-manager-client
   name: admin-at-test-org
 spec:
   description: Admin key
@@ -302,6 +674,127 @@ spec:
 	}
 }
 
+func TestGenerateYAML_Stable(t *testing.T) {
+	spec := models.APIKeySpec{
+		Email:          "user@example.com",
+		KeyHash:        "hash123",
+		KeyHint:        "sk-abc*************de",
+		Enabled:        false,
+		AllowedMethods: []string{"GET", "HEAD"},
+		ActiveWindows:  []models.ActiveWindow{{Start: "08:00", End: "18:00", Days: []string{"Mon", "Tue"}}},
+		KeyHashes:      []string{"hash456"},
+	}
+
+	first, err := GenerateYAMLInNamespace("team-a", spec)
+	if err != nil {
+		t.Fatalf("GenerateYAMLInNamespace() error = %v", err)
+	}
+	for range 20 {
+		if got, _ := GenerateYAMLInNamespace("team-a", spec); got != first {
+			t.Fatalf("GenerateYAMLInNamespace() changed across runs:\n%s\nthen:\n%s", first, got)
+		}
+	}
+
+	// Empty optional fields are omitted, a disabled key is not, as the CRD
+	// defaults enabled to true
+	minimal, err := GenerateYAML(models.APIKeySpec{Email: "user@example.com", KeyHash: "hash123", KeyHint: "sk-abc*************de"})
+	if err != nil {
+		t.Fatalf("GenerateYAML() error = %v", err)
+	}
+	want := `spec:
+  email: user@example.com
+  enabled: false
+  keyHash: hash123
+  keyHint: sk-abc*************de
+`
+	if !strings.HasSuffix(minimal, want) || strings.Contains(minimal, "null") || strings.Contains(minimal, "creationTimestamp") {
+		t.Errorf("GenerateYAML() = %s, want the required fields and enabled only", minimal)
+	}
+}
+
+func TestGenerateYAML_AllowedMethods(t *testing.T) {
+	spec := models.APIKeySpec{
+		Email:          "user@example.com",
+		KeyHash:        "hash123",
+		KeyHint:        "sk-abc*************de",
+		Description:    "Read-only",
+		Enabled:        true,
+		AllowedMethods: []string{"GET", "HEAD"},
+	}
+
+	got, err := GenerateYAML(spec)
+	if err != nil {
+		t.Fatalf("GenerateYAML() error = %v", err)
+	}
+
+	if !strings.Contains(got, "allowedMethods:\n  - GET\n  - HEAD\n") {
+		t.Errorf("GenerateYAML() should contain allowedMethods, got %s", got)
+	}
+}
+
+func TestGenerateYAML_Notes(t *testing.T) {
+	tests := []struct {
+		name  string
+		notes string
+	}{
+		{name: "Multi-line notes", notes: "Rotated after the March incident.\nOwner: #platform"},
+		{name: "Notes looking like YAML", notes: "enabled: false"},
+		{name: "No notes", notes: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := models.APIKeySpec{
+				Email:       "user@example.com",
+				KeyHash:     "hash123",
+				KeyHint:     "sk-abc*************de",
+				Description: "Dashboard label",
+				Enabled:     true,
+				Notes:       tt.notes,
+			}
+
+			got, err := GenerateYAML(spec)
+			if err != nil {
+				t.Fatalf("GenerateYAML() error = %v", err)
+			}
+			if tt.notes == "" && strings.Contains(got, "notes:") {
+				t.Errorf("GenerateYAML() should omit empty notes, got %s", got)
+			}
+
+			var parsed models.APIKey
+			if err := yaml.Unmarshal([]byte(got), &parsed); err != nil {
+				t.Fatalf("GenerateYAML() output does not parse: %v", err)
+			}
+			if !reflect.DeepEqual(parsed.Spec, spec) {
+				t.Errorf("GenerateYAML() round trip = %+v, want %+v", parsed.Spec, spec)
+			}
+		})
+	}
+}
+
+func TestInternationalizedEmail(t *testing.T) {
+	for _, email := range []string{"user@münchen.de", "user@пример.рф"} {
+		t.Run(email, func(t *testing.T) {
+			if err := ValidateEmail(email); err != nil {
+				t.Fatalf("ValidateEmail() error = %v", err)
+			}
+			normalized := NormalizeEmail(email)
+			if err := ValidateEmail(normalized); err != nil {
+				t.Errorf("ValidateEmail(%s) error = %v", normalized, err)
+			}
+			if spec := NewAPIKeySpec("sk-test", email); spec.Email != normalized {
+				t.Errorf("spec email = %s, want %s", spec.Email, normalized)
+			}
+			if errs := validation.IsDNS1123Subdomain(ResourceName(email)); len(errs) > 0 {
+				t.Errorf("ResourceName() = %s, not a DNS-1123 name: %v", ResourceName(email), errs)
+			}
+			if SanitizeEmail(email) != SanitizeEmail(normalized) {
+				t.Errorf("SanitizeEmail() = %s, want the name of %s", SanitizeEmail(email), normalized)
+			}
+		})
+	}
+}
+
 func TestGenerateYAML_EmailSanitization(t *testing.T) {
 	spec := models.APIKeySpec{
 		Email:       "first.last@company.co.uk",
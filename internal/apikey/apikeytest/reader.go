@@ -0,0 +1,22 @@
+// Package apikeytest provides helpers for tests and demos of API key
+// generation. Nothing here is suitable for production keys.
+package apikeytest
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+)
+
+// DeterministicReader returns a reader yielding the same bytes for the same
+// seed, so that apikey.GenerateAPIKeyWithReader produces stable keys in
+// golden tests and reproducible demos:
+//
+//	key, _ := apikey.GenerateAPIKeyWithReader(apikeytest.DeterministicReader(42))
+//
+// Anyone knowing the seed can regenerate the keys; never use it for real ones.
+func DeterministicReader(seed int64) io.Reader {
+	var s [32]byte
+	binary.LittleEndian.PutUint64(s[:], uint64(seed))
+	return rand.NewChaCha8(s)
+}
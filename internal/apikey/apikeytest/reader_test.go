@@ -0,0 +1,29 @@
+package apikeytest
+
+import (
+	"testing"
+
+	"github.com/efortin/batsign/internal/apikey"
+)
+
+func TestDeterministicReader(t *testing.T) {
+	generate := func(seed int64) string {
+		t.Helper()
+		key, err := apikey.GenerateAPIKeyWithReader(DeterministicReader(seed))
+		if err != nil {
+			t.Fatalf("GenerateAPIKeyWithReader() error = %v", err)
+		}
+		return key
+	}
+
+	first := generate(42)
+	if err := apikey.ValidateAPIKey(first, apikey.Config{}); err != nil {
+		t.Errorf("ValidateAPIKey(%q) error = %v", first, err)
+	}
+	if again := generate(42); again != first {
+		t.Errorf("same seed generated %q, then %q", first, again)
+	}
+	if other := generate(43); other == first {
+		t.Errorf("seeds 42 and 43 both generated %q", first)
+	}
+}
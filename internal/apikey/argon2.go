@@ -0,0 +1,130 @@
+package apikey
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/efortin/batsign/internal/models"
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2Params are the argon2id cost parameters of a key hash
+type Argon2Params struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id: 19 MiB,
+// 2 passes and 1 lane, a few tens of milliseconds per verification
+var DefaultArgon2Params = Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+// maxArgon2Params bounds the costs accepted from stored hashes, so a
+// mistyped resource cannot make every verification exhaust the server
+var maxArgon2Params = Argon2Params{Memory: 256 * 1024, Iterations: 10, Parallelism: 16}
+
+// Salt and hash sizes of generated argon2id hashes
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// HashAPIKeyArgon2id hashes the API key with argon2id and a random salt,
+// in the PHC string format: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+func HashAPIKeyArgon2id(apiKey string) (string, error) {
+	return HashAPIKeyArgon2idWithParams(randReader, apiKey, DefaultArgon2Params)
+}
+
+// HashAPIKeyArgon2idWithParams hashes the API key with argon2id, reading the
+// salt from reader
+func HashAPIKeyArgon2idWithParams(reader io.Reader, apiKey string, params Argon2Params) (string, error) {
+	if err := params.validate(); err != nil {
+		return "", err
+	}
+	salt := make([]byte, argon2SaltLen)
+	if _, err := io.ReadFull(reader, salt); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRandSource, err)
+	}
+	hash := argon2.IDKey([]byte(apiKey), salt, params.Iterations, params.Memory, params.Parallelism, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifyArgon2id reports whether the API key matches an argon2id hash in
+// the PHC string format, re-hashing it with the stored parameters
+func VerifyArgon2id(apiKey, encoded string) (bool, error) {
+	params, salt, hash, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(apiKey), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(computed, hash) == 1, nil
+}
+
+// VerifyKeyHash reports whether the API key matches a hash of the given
+// algorithm (see models.HashAlgo*; empty = SHA-256)
+func VerifyKeyHash(apiKey, algo, hash string) (bool, error) {
+	switch algo {
+	case "", models.HashAlgoSHA256:
+		return subtle.ConstantTimeCompare([]byte(HashAPIKey(apiKey)), []byte(hash)) == 1, nil
+	case models.HashAlgoArgon2id:
+		return VerifyArgon2id(apiKey, hash)
+	default:
+		return false, fmt.Errorf("unknown key hash algorithm %q (%s, %s)", algo, models.HashAlgoSHA256, models.HashAlgoArgon2id)
+	}
+}
+
+// ValidateArgon2id checks that an argon2id hash is well formed and its
+// costs are within bounds
+func ValidateArgon2id(encoded string) error {
+	_, _, _, err := parseArgon2id(encoded)
+	return err
+}
+
+// parseArgon2id splits an argon2id PHC string into its parameters, salt
+// and hash
+func parseArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != models.HashAlgoArgon2id {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id hash: want $argon2id$v=19$m=...,t=...,p=...$<salt>$<hash>")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id hash: unsupported version %q", parts[2])
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id hash: parameters %q: %w", parts[3], err)
+	}
+	if err := params.validate(); err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < 8 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id hash: salt must be at least 8 base64 encoded bytes")
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(hash) < 16 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id hash: hash must be at least 16 base64 encoded bytes")
+	}
+	return params, salt, hash, nil
+}
+
+// validate checks that the costs are usable and within maxArgon2Params
+func (p Argon2Params) validate() error {
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 {
+		return fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	}
+	if p.Memory > maxArgon2Params.Memory || p.Iterations > maxArgon2Params.Iterations || p.Parallelism > maxArgon2Params.Parallelism {
+		return fmt.Errorf("argon2id parameters m=%d,t=%d,p=%d exceed the maximum m=%d,t=%d,p=%d",
+			p.Memory, p.Iterations, p.Parallelism, maxArgon2Params.Memory, maxArgon2Params.Iterations, maxArgon2Params.Parallelism)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/efortin/batsign/internal/apikey/apikeytest"
+	"github.com/efortin/batsign/internal/models"
+)
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	const key = "sk-high-value-key"
+
+	hash, err := HashAPIKeyArgon2id(key)
+	if err != nil {
+		t.Fatalf("HashAPIKeyArgon2id() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("HashAPIKeyArgon2id() = %q, want the default parameters in PHC format", hash)
+	}
+	if err := ValidateArgon2id(hash); err != nil {
+		t.Errorf("ValidateArgon2id() error = %v", err)
+	}
+
+	if ok, err := VerifyArgon2id(key, hash); err != nil || !ok {
+		t.Errorf("VerifyArgon2id(key) = %v, %v, want true", ok, err)
+	}
+	if ok, err := VerifyArgon2id(key+"x", hash); err != nil || ok {
+		t.Errorf("VerifyArgon2id(other key) = %v, %v, want false", ok, err)
+	}
+
+	// Salts are random, so hashing twice differs but both verify
+	again, err := HashAPIKeyArgon2id(key)
+	if err != nil {
+		t.Fatalf("HashAPIKeyArgon2id() error = %v", err)
+	}
+	if again == hash {
+		t.Error("HashAPIKeyArgon2id() reused a salt")
+	}
+}
+
+func TestArgon2idStoredParams(t *testing.T) {
+	const key = "sk-high-value-key"
+	params := Argon2Params{Memory: 64, Iterations: 1, Parallelism: 2}
+
+	hash, err := HashAPIKeyArgon2idWithParams(apikeytest.DeterministicReader(1), key, params)
+	if err != nil {
+		t.Fatalf("HashAPIKeyArgon2idWithParams() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=2$") {
+		t.Fatalf("HashAPIKeyArgon2idWithParams() = %q, want m=64,t=1,p=2", hash)
+	}
+	// Verification uses the parameters stored in the hash, not the defaults
+	if ok, err := VerifyArgon2id(key, hash); err != nil || !ok {
+		t.Errorf("VerifyArgon2id() = %v, %v, want true", ok, err)
+	}
+	// Changing a stored parameter changes the hash
+	if ok, _ := VerifyArgon2id(key, strings.Replace(hash, "t=1", "t=2", 1)); ok {
+		t.Error("VerifyArgon2id() = true with altered parameters")
+	}
+}
+
+func TestVerifyArgon2id_Invalid(t *testing.T) {
+	const salt, sum = "c2FsdHNhbHRzYWx0", "aGFzaGhhc2hoYXNoaGFzaA"
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"SHA-256 hash", HashAPIKey("sk-key")},
+		{"other algorithm", "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + sum},
+		{"old version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + sum},
+		{"missing parameter", "$argon2id$v=19$m=64,t=1$" + salt + "$" + sum},
+		{"zero iterations", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + sum},
+		{"excessive memory", "$argon2id$v=19$m=4194304,t=1,p=1$" + salt + "$" + sum},
+		{"parallelism overflow", "$argon2id$v=19$m=64,t=1,p=300$" + salt + "$" + sum},
+		{"short salt", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$" + sum},
+		{"bad hash encoding", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$not-base64!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok, err := VerifyArgon2id("sk-key", tt.encoded); err == nil || ok {
+				t.Errorf("VerifyArgon2id() = %v, %v, want an error", ok, err)
+			}
+		})
+	}
+}
+
+func TestNewAPIKeySpecWithAlgo(t *testing.T) {
+	const key = "sk-abcdefghijklmnop"
+
+	spec, err := NewAPIKeySpecWithAlgo(key, "user@example.com", "")
+	if err != nil || spec.KeyHash != HashAPIKey(key) || spec.KeyHashAlgo != "" {
+		t.Errorf("NewAPIKeySpecWithAlgo(default) = %+v, %v, want a SHA-256 spec", spec, err)
+	}
+
+	spec, err = NewAPIKeySpecWithAlgo(key, "user@example.com", models.HashAlgoArgon2id)
+	if err != nil {
+		t.Fatalf("NewAPIKeySpecWithAlgo(argon2id) error = %v", err)
+	}
+	if spec.KeyHashAlgo != models.HashAlgoArgon2id || spec.KeyHint != GenerateHint(key) {
+		t.Errorf("NewAPIKeySpecWithAlgo(argon2id) = %+v", spec)
+	}
+	yaml, err := GenerateYAML(spec)
+	if err != nil {
+		t.Fatalf("GenerateYAML() error = %v", err)
+	}
+	if err := VerifyYAML(yaml, key); err != nil {
+		t.Errorf("VerifyYAML() error = %v", err)
+	}
+	if err := VerifyYAML(yaml, key+"x"); err == nil {
+		t.Error("VerifyYAML() with another key: expected an error")
+	}
+
+	if _, err := NewAPIKeySpecWithAlgo(key, "user@example.com", "bcrypt"); err == nil {
+		t.Error("NewAPIKeySpecWithAlgo(bcrypt): expected an error")
+	}
+}
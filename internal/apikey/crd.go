@@ -0,0 +1,16 @@
+package apikey
+
+import (
+	_ "embed"
+)
+
+// crdYAML is the CustomResourceDefinition for APIKey resources.
+// It must be kept identical to deploy/apikey-crd.yaml.
+//
+//go:embed crd.yaml
+var crdYAML string
+
+// CRDYAML returns the CustomResourceDefinition YAML for apikeys.auth.kgateway.dev
+func CRDYAML() string {
+	return crdYAML
+}
@@ -0,0 +1,73 @@
+package apikey
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/efortin/batsign/internal/models"
+	"sigs.k8s.io/yaml"
+)
+
+func TestCRDYAML_Parses(t *testing.T) {
+	var crd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(CRDYAML()), &crd); err != nil {
+		t.Fatalf("CRDYAML() is not valid YAML: %v", err)
+	}
+
+	if crd["kind"] != "CustomResourceDefinition" {
+		t.Errorf("CRDYAML() kind = %v, want CustomResourceDefinition", crd["kind"])
+	}
+	metadata, _ := crd["metadata"].(map[string]interface{})
+	if metadata["name"] != "apikeys.auth.kgateway.dev" {
+		t.Errorf("CRDYAML() name = %v, want apikeys.auth.kgateway.dev", metadata["name"])
+	}
+}
+
+func TestCRDYAML_MatchesAPIKeySpec(t *testing.T) {
+	var crd struct {
+		Spec struct {
+			Versions []struct {
+				Name   string `json:"name"`
+				Schema struct {
+					OpenAPIV3Schema struct {
+						Properties struct {
+							Spec struct {
+								Properties map[string]interface{} `json:"properties"`
+							} `json:"spec"`
+						} `json:"properties"`
+					} `json:"openAPIV3Schema"`
+				} `json:"schema"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal([]byte(CRDYAML()), &crd); err != nil {
+		t.Fatalf("CRDYAML() is not valid YAML: %v", err)
+	}
+	if len(crd.Spec.Versions) == 0 {
+		t.Fatal("CRDYAML() has no versions")
+	}
+	properties := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties.Spec.Properties
+
+	specType := reflect.TypeOf(models.APIKeySpec{})
+	for i := 0; i < specType.NumField(); i++ {
+		name := strings.Split(specType.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if _, ok := properties[name]; !ok {
+			t.Errorf("CRD schema is missing APIKeySpec field %q", name)
+		}
+	}
+}
+
+func TestCRDYAML_MatchesDeployManifest(t *testing.T) {
+	manifest, err := os.ReadFile("../../deploy/apikey-crd.yaml")
+	if err != nil {
+		t.Fatalf("failed to read deploy manifest: %v", err)
+	}
+	if string(manifest) != CRDYAML() {
+		t.Error("internal/apikey/crd.yaml and deploy/apikey-crd.yaml have diverged")
+	}
+}
@@ -0,0 +1,117 @@
+package apikey
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/efortin/batsign/internal/models"
+)
+
+// keyHashPattern matches a lower-case hex encoded SHA-256 hash, as required
+// by the CRD
+var keyHashPattern = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// ParseKeysCSV reads keys exported from another system as CSV rows of
+// email,keyHash,keyHint[,enabled], e.g.
+//
+//	email,keyHash,keyHint,enabled
+//	user@example.com,<sha-256 hex of the key>,sk-abc*************de,true
+//
+// The header row is optional and enabled defaults to true. The provided
+// hashes are kept as is; no key is generated. Emails are unique by the
+// resource name they map to, since two of them mapping to one name would
+// overwrite each other. Every malformed row is reported with its line
+// number, and no spec is returned unless all rows are valid.
+func ParseKeysCSV(r io.Reader) ([]models.APIKeySpec, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var (
+		specs  []models.APIKeySpec
+		errs   []error
+		names  = make(map[string]int)
+		emails = make(map[string]string)
+		hashes = make(map[string]int)
+	)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Quoting errors leave the reader out of sync, so stop here
+			errs = append(errs, err)
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "email") {
+			continue
+		}
+
+		spec, err := parseKeyRecord(record)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		name := ResourceName(spec.Email)
+		if prev, ok := names[name]; ok {
+			if emails[name] == spec.Email {
+				errs = append(errs, fmt.Errorf("line %d: duplicate email %s (first on line %d)", line, spec.Email, prev))
+			} else {
+				errs = append(errs, fmt.Errorf("line %d: email %s maps to resource name %s, as %s does on line %d", line, spec.Email, name, emails[name], prev))
+			}
+			continue
+		}
+		if prev, ok := hashes[spec.KeyHash]; ok {
+			errs = append(errs, fmt.Errorf("line %d: duplicate keyHash (first on line %d)", line, prev))
+			continue
+		}
+		names[name], emails[name], hashes[spec.KeyHash] = line, spec.Email, line
+		specs = append(specs, spec)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return specs, nil
+}
+
+// parseKeyRecord validates one email,keyHash,keyHint[,enabled] record
+func parseKeyRecord(record []string) (models.APIKeySpec, error) {
+	if len(record) < 3 || len(record) > 4 {
+		return models.APIKeySpec{}, fmt.Errorf("%d fields, want email,keyHash,keyHint[,enabled]", len(record))
+	}
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+
+	spec := models.APIKeySpec{
+		Email:   record[0],
+		KeyHash: strings.ToLower(record[1]),
+		KeyHint: record[2],
+		Enabled: true,
+	}
+	if err := ValidateEmail(spec.Email); err != nil {
+		return models.APIKeySpec{}, err
+	}
+	if !keyHashPattern.MatchString(spec.KeyHash) {
+		return models.APIKeySpec{}, fmt.Errorf("keyHash must be a hex encoded SHA-256 hash")
+	}
+	if err := ValidateHint(spec.KeyHint); err != nil {
+		return models.APIKeySpec{}, err
+	}
+	if len(record) == 4 && record[3] != "" {
+		enabled, err := strconv.ParseBool(record[3])
+		if err != nil {
+			return models.APIKeySpec{}, fmt.Errorf("invalid enabled value %q", record[3])
+		}
+		spec.Enabled = enabled
+	}
+	return spec, nil
+}
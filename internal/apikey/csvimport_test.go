@@ -0,0 +1,117 @@
+package apikey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseKeysCSV(t *testing.T) {
+	hashA := strings.Repeat("a", 64)
+	hashB := strings.Repeat("b", 64)
+	const hint = "sk-abc*************de"
+
+	tests := []struct {
+		name        string
+		csv         string
+		wantEmails  []string
+		wantEnabled []bool
+		wantErrs    []string
+	}{
+		{
+			name:        "with header",
+			csv:         "email,keyHash,keyHint,enabled\nuser@example.com," + hashA + "," + hint + ",true\nold@example.com," + hashB + "," + hint + ",false\n",
+			wantEmails:  []string{"user@example.com", "old@example.com"},
+			wantEnabled: []bool{true, false},
+		},
+		{
+			name:        "without header or enabled column",
+			csv:         "user@example.com, " + strings.ToUpper(hashA) + " ," + hint + "\nold@example.com," + hashB + "," + hint + ",\n",
+			wantEmails:  []string{"user@example.com", "old@example.com"},
+			wantEnabled: []bool{true, true},
+		},
+		{
+			name: "malformed rows",
+			csv: "email,keyHash,keyHint,enabled\n" +
+				"not-an-email," + hashA + "," + hint + "\n" +
+				"user@example.com,sk-raw-key," + hint + "\n" +
+				"user@example.com," + hashA + ",sk-abcdefghij\n" +
+				"user@example.com," + hashA + "," + hint + ",yes please\n" +
+				"user@example.com," + hashA + "\n" +
+				"ok@example.com," + hashA + "," + hint + "\n" +
+				"ok@example.com," + hashB + "," + hint + "\n" +
+				"other@example.com," + hashA + "," + hint + "\n",
+			wantErrs: []string{
+				"line 2: invalid email format",
+				"line 3: keyHash",
+				"line 4: invalid key hint",
+				"line 5: invalid enabled value",
+				"line 6: 2 fields",
+				"line 8: duplicate email ok@example.com (first on line 7)",
+				"line 9: duplicate keyHash (first on line 7)",
+			},
+		},
+		{
+			name: "emails mapping to the same resource name",
+			csv:  "a.b@x.io," + hashA + "," + hint + "\na-b@x.io," + hashB + "," + hint + "\n",
+			wantErrs: []string{
+				"line 2: email a-b@x.io maps to resource name a-b-at-x-io, as a.b@x.io does on line 1",
+			},
+		},
+		{
+			name:     "broken quoting",
+			csv:      "user@example.com,\"" + hashA + "," + hint + "\n",
+			wantErrs: []string{"line 1"},
+		},
+		{
+			name: "empty file",
+			csv:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs, err := ParseKeysCSV(strings.NewReader(tt.csv))
+			if len(tt.wantErrs) > 0 {
+				if err == nil {
+					t.Fatalf("ParseKeysCSV() error = nil, want %v", tt.wantErrs)
+				}
+				if specs != nil {
+					t.Errorf("ParseKeysCSV() returned %d specs along with an error", len(specs))
+				}
+				lines := strings.Split(err.Error(), "\n")
+				if len(lines) != len(tt.wantErrs) {
+					t.Fatalf("ParseKeysCSV() reported %d errors, want %d:\n%v", len(lines), len(tt.wantErrs), err)
+				}
+				for i, want := range tt.wantErrs {
+					if !strings.Contains(lines[i], want) {
+						t.Errorf("ParseKeysCSV() error %d = %q, want it to contain %q", i, lines[i], want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeysCSV() error = %v", err)
+			}
+
+			if len(specs) != len(tt.wantEmails) {
+				t.Fatalf("ParseKeysCSV() returned %d specs, want %d", len(specs), len(tt.wantEmails))
+			}
+			for i, spec := range specs {
+				if spec.Email != tt.wantEmails[i] || spec.Enabled != tt.wantEnabled[i] {
+					t.Errorf("ParseKeysCSV() spec %d = %s enabled=%v, want %s enabled=%v", i, spec.Email, spec.Enabled, tt.wantEmails[i], tt.wantEnabled[i])
+				}
+				if !keyHashPattern.MatchString(spec.KeyHash) || spec.KeyHint != hint {
+					t.Errorf("ParseKeysCSV() spec %d hash/hint = %s/%s", i, spec.KeyHash, spec.KeyHint)
+				}
+			}
+		})
+	}
+}
+
+func TestParseKeysCSV_InvalidEmailIs(t *testing.T) {
+	_, err := ParseKeysCSV(strings.NewReader("bad," + strings.Repeat("a", 64) + ",sk-abc*************de\n"))
+	if !errors.Is(err, ErrInvalidEmail) {
+		t.Errorf("ParseKeysCSV() error = %v, want ErrInvalidEmail", err)
+	}
+}
@@ -0,0 +1,21 @@
+package apikey
+
+import "errors"
+
+// Errors returned by the apikey package, for use with errors.Is
+var (
+	// ErrInvalidEmail is returned by ValidateEmail for malformed addresses
+	ErrInvalidEmail = errors.New("invalid email format")
+	// ErrRandSource is returned when the random source fails to produce a key
+	ErrRandSource = errors.New("error generating random key")
+	// ErrYAMLMarshal is returned when an APIKey resource cannot be marshaled
+	ErrYAMLMarshal = errors.New("failed to marshal APIKey to YAML")
+	// ErrInvalidAPIKey is returned by ValidateAPIKey for malformed keys
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrInvalidHint is returned by ValidateHint and VerifyYAML for hints
+	// that are malformed or do not match the key
+	ErrInvalidHint = errors.New("invalid key hint")
+	// ErrInvalidNamespace is returned by ValidateNamespace for names that
+	// are not DNS-1123 labels
+	ErrInvalidNamespace = errors.New("invalid namespace")
+)
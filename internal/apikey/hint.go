@@ -0,0 +1,46 @@
+package apikey
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/efortin/batsign/internal/models"
+	"sigs.k8s.io/yaml"
+)
+
+// Hint layout: the first and last characters of the key around a fixed mask
+const (
+	hintPrefixLen = 6
+	hintSuffixLen = 2
+	hintMaskLen   = 13
+)
+
+// ValidateHint checks that a hint has the layout produced by GenerateHint
+// for keys of at least 8 characters
+func ValidateHint(hint string) error {
+	runes := []rune(hint)
+	if len(runes) != hintPrefixLen+hintMaskLen+hintSuffixLen {
+		return fmt.Errorf("%w: %d characters, want %d", ErrInvalidHint, len(runes), hintPrefixLen+hintMaskLen+hintSuffixLen)
+	}
+	visible := string(runes[:hintPrefixLen]) + string(runes[len(runes)-hintSuffixLen:])
+	if strings.Contains(visible, "*") || string(runes[hintPrefixLen:len(runes)-hintSuffixLen]) != strings.Repeat("*", hintMaskLen) {
+		return fmt.Errorf("%w: want %d characters, %d '*' and %d characters", ErrInvalidHint, hintPrefixLen, hintMaskLen, hintSuffixLen)
+	}
+	return nil
+}
+
+// VerifyYAML checks that the APIKey resource in data holds the hash and
+// hint of key, guarding against hint or hash drift in the generated YAML
+func VerifyYAML(data, key string) error {
+	var resource models.APIKey
+	if err := yaml.Unmarshal([]byte(data), &resource); err != nil {
+		return fmt.Errorf("failed to parse APIKey YAML: %w", err)
+	}
+	if ok, err := VerifyKeyHash(key, resource.Spec.KeyHashAlgo, resource.Spec.KeyHash); err != nil || !ok {
+		return fmt.Errorf("%w: keyHash does not match the key", ErrInvalidAPIKey)
+	}
+	if resource.Spec.KeyHint != GenerateHint(key) {
+		return fmt.Errorf("%w: keyHint %q does not match the key", ErrInvalidHint, resource.Spec.KeyHint)
+	}
+	return ValidateHint(resource.Spec.KeyHint)
+}
@@ -0,0 +1,64 @@
+package apikey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateHint(t *testing.T) {
+	tests := []struct {
+		name    string
+		hint    string
+		wantErr bool
+	}{
+		{name: "Generated hint", hint: GenerateHint("sk-abcdefghijklmnopqrstuvwxyz12345678")},
+		{name: "Multibyte characters", hint: "sk-äbc*************ü8"},
+		{name: "Empty", hint: "", wantErr: true},
+		{name: "Raw short key", hint: "sk-abc", wantErr: true},
+		{name: "Too few stars", hint: "sk-abc************78", wantErr: true},
+		{name: "Too many stars", hint: "sk-abc**************78", wantErr: true},
+		{name: "Star in the visible part", hint: "sk-ab**************78", wantErr: true},
+		{name: "Unmasked", hint: "sk-abcdefghijklmnopq78", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateHint(tt.hint)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateHint(%q) error = %v, wantErr %v", tt.hint, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidHint) {
+				t.Errorf("ValidateHint(%q) error = %v, want ErrInvalidHint", tt.hint, err)
+			}
+		})
+	}
+}
+
+func TestVerifyYAML(t *testing.T) {
+	key, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() error = %v", err)
+	}
+	out, err := GenerateYAML(NewAPIKeySpec(key, "user@example.com"))
+	if err != nil {
+		t.Fatalf("GenerateYAML() error = %v", err)
+	}
+
+	if err := VerifyYAML(out, key); err != nil {
+		t.Errorf("VerifyYAML() error = %v", err)
+	}
+
+	wrongHint := strings.Replace(out, GenerateHint(key), "sk-zzz*************zz", 1)
+	if err := VerifyYAML(wrongHint, key); !errors.Is(err, ErrInvalidHint) {
+		t.Errorf("VerifyYAML() with a wrong hint error = %v, want ErrInvalidHint", err)
+	}
+
+	other, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() error = %v", err)
+	}
+	if err := VerifyYAML(out, other); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("VerifyYAML() with another key error = %v, want ErrInvalidAPIKey", err)
+	}
+}
@@ -0,0 +1,43 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// KeyFileMode is the mode of the files raw keys are written to: readable by
+// their owner only
+const KeyFileMode fs.FileMode = 0o600
+
+// WriteKeyFile writes data holding raw keys to a file only its owner can
+// read. An existing file is replaced when force is set, and otherwise left
+// untouched with an error wrapping fs.ErrExist.
+func WriteKeyFile(path string, data []byte, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, KeyFileMode)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("key file %s already exists (use --force to overwrite it): %w", path, err)
+		}
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	// The mode only applies to created files: restrict a replaced one before
+	// writing the keys
+	if err := f.Chmod(KeyFileMode); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to restrict key file permissions: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package apikey
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.txt")
+	if err := WriteKeyFile(path, []byte("sk-first\n"), false); err != nil {
+		t.Fatalf("WriteKeyFile() error = %v", err)
+	}
+	assertKeyFile(t, path, "sk-first\n")
+
+	err := WriteKeyFile(path, []byte("sk-second\n"), false)
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("WriteKeyFile() on an existing file error = %v, want fs.ErrExist", err)
+	}
+	assertKeyFile(t, path, "sk-first\n")
+
+	// Forcing replaces the file and restricts a file readable by others
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyFile(path, []byte("sk-third\n"), true); err != nil {
+		t.Fatalf("WriteKeyFile(force) error = %v", err)
+	}
+	assertKeyFile(t, path, "sk-third\n")
+}
+
+// assertKeyFile checks the content of a key file and, where file modes
+// apply, that only its owner can read it
+func assertKeyFile(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("key file = %q, want %q", data, want)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != KeyFileMode {
+		t.Errorf("key file mode = %v, want %v", info.Mode().Perm(), KeyFileMode)
+	}
+}
@@ -0,0 +1,94 @@
+package apikey
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Headers the authorization server reads the API key from
+const (
+	// HeaderAPIKey carries the raw API key
+	HeaderAPIKey = "x-api-key"
+	// HeaderAuthorization carries the API key as a bearer token
+	HeaderAuthorization = "authorization"
+	// BearerPrefix precedes the API key in the Authorization header
+	BearerPrefix = "Bearer "
+)
+
+// OpenAPI security scheme names emitted by GenerateOpenAPISecurity
+const (
+	OpenAPIAPIKeyScheme = "ApiKeyAuth"
+	OpenAPIBearerScheme = "BearerAuth"
+)
+
+// OpenAPISecurityScheme is an OpenAPI 3 security scheme object
+type OpenAPISecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPISecurity is the fragment to merge into an OpenAPI 3 document
+type OpenAPISecurity struct {
+	Components struct {
+		SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+	} `json:"components"`
+	Security []map[string][]string `json:"security"`
+}
+
+// NewOpenAPISecurity returns the security schemes enforced by the server: an
+// apiKey scheme for headerName and, if bearer is set, an http bearer scheme
+// for the Authorization header. Either scheme satisfies the requirement.
+func NewOpenAPISecurity(headerName string, bearer bool) OpenAPISecurity {
+	if headerName == "" {
+		headerName = HeaderAPIKey
+	}
+
+	var sec OpenAPISecurity
+	sec.Components.SecuritySchemes = map[string]OpenAPISecurityScheme{
+		OpenAPIAPIKeyScheme: {
+			Type:        "apiKey",
+			In:          "header",
+			Name:        headerName,
+			Description: "API key generated by apikey-manager-client",
+		},
+	}
+	sec.Security = []map[string][]string{{OpenAPIAPIKeyScheme: {}}}
+
+	if bearer {
+		sec.Components.SecuritySchemes[OpenAPIBearerScheme] = OpenAPISecurityScheme{
+			Type:        "http",
+			Scheme:      "bearer",
+			Description: "API key sent as \"Authorization: Bearer <key>\"",
+		}
+		sec.Security = append(sec.Security, map[string][]string{OpenAPIBearerScheme: {}})
+	}
+
+	return sec
+}
+
+// GenerateOpenAPISecurity renders the security fragment as "yaml" or "json"
+func GenerateOpenAPISecurity(headerName string, bearer bool, format string) (string, error) {
+	sec := NewOpenAPISecurity(headerName, bearer)
+
+	switch format {
+	case "", "yaml":
+		out, err := yaml.Marshal(sec)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal security schemes to YAML: %w", err)
+		}
+		return string(out), nil
+	case "json":
+		out, err := json.MarshalIndent(sec, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal security schemes to JSON: %w", err)
+		}
+		return string(out) + "\n", nil
+	default:
+		return "", fmt.Errorf("unknown output format %q (expected yaml or json)", format)
+	}
+}
@@ -0,0 +1,81 @@
+package apikey
+
+import (
+	"encoding/json"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestGenerateOpenAPISecurity(t *testing.T) {
+	tests := []struct {
+		name        string
+		headerName  string
+		bearer      bool
+		format      string
+		wantHeader  string
+		wantSchemes int
+	}{
+		{
+			name:        "Default YAML with bearer",
+			format:      "yaml",
+			bearer:      true,
+			wantHeader:  "x-api-key",
+			wantSchemes: 2,
+		},
+		{
+			name:        "JSON without bearer",
+			format:      "json",
+			bearer:      false,
+			wantHeader:  "x-api-key",
+			wantSchemes: 1,
+		},
+		{
+			name:        "Custom header name",
+			headerName:  "x-custom-key",
+			format:      "yaml",
+			bearer:      true,
+			wantHeader:  "x-custom-key",
+			wantSchemes: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := GenerateOpenAPISecurity(tt.headerName, tt.bearer, tt.format)
+			if err != nil {
+				t.Fatalf("GenerateOpenAPISecurity() error = %v", err)
+			}
+
+			var got OpenAPISecurity
+			if tt.format == "json" {
+				err = json.Unmarshal([]byte(out), &got)
+			} else {
+				err = yaml.Unmarshal([]byte(out), &got)
+			}
+			if err != nil {
+				t.Fatalf("GenerateOpenAPISecurity() output does not parse: %v\n%s", err, out)
+			}
+
+			apiKeyScheme := got.Components.SecuritySchemes[OpenAPIAPIKeyScheme]
+			if apiKeyScheme.Type != "apiKey" || apiKeyScheme.In != "header" || apiKeyScheme.Name != tt.wantHeader {
+				t.Errorf("GenerateOpenAPISecurity() apiKey scheme = %+v, want header %s", apiKeyScheme, tt.wantHeader)
+			}
+			if len(got.Components.SecuritySchemes) != tt.wantSchemes {
+				t.Errorf("GenerateOpenAPISecurity() schemes = %d, want %d", len(got.Components.SecuritySchemes), tt.wantSchemes)
+			}
+			if len(got.Security) != tt.wantSchemes {
+				t.Errorf("GenerateOpenAPISecurity() security requirements = %d, want %d", len(got.Security), tt.wantSchemes)
+			}
+			if tt.bearer && got.Components.SecuritySchemes[OpenAPIBearerScheme].Scheme != "bearer" {
+				t.Errorf("GenerateOpenAPISecurity() missing bearer scheme")
+			}
+		})
+	}
+}
+
+func TestGenerateOpenAPISecurity_UnknownFormat(t *testing.T) {
+	if _, err := GenerateOpenAPISecurity("", true, "xml"); err == nil {
+		t.Error("GenerateOpenAPISecurity() with unknown format should return error")
+	}
+}
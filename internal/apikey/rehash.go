@@ -0,0 +1,102 @@
+package apikey
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/efortin/batsign/internal/models"
+)
+
+// ValidateHashAlgo checks that algo is a known key hash algorithm (see
+// models.HashAlgo*)
+func ValidateHashAlgo(algo string) error {
+	switch algo {
+	case models.HashAlgoSHA256, models.HashAlgoArgon2id:
+		return nil
+	default:
+		return fmt.Errorf("unknown key hash algorithm %q (%s, %s)", algo, models.HashAlgoSHA256, models.HashAlgoArgon2id)
+	}
+}
+
+// RawKey is a raw key read by ParseRawKeysCSV
+type RawKey struct {
+	// Line is the line the key was read from, to report errors about it
+	// without echoing it
+	Line  int
+	Email string
+	Key   string
+}
+
+// ParseRawKeysCSV reads raw keys as CSV rows of email,key, e.g.
+//
+//	email,key
+//	user@example.com,sk-...
+//
+// so existing APIKeys can be moved to another hash algorithm. The header row
+// is optional. Every malformed row is reported with its line number, never
+// with its key, and no key is returned unless all rows are valid.
+func ParseRawKeysCSV(r io.Reader) ([]RawKey, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var (
+		keys  []RawKey
+		errs  []error
+		lines = make(map[string]int)
+	)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Quoting errors leave the reader out of sync, so stop here
+			errs = append(errs, err)
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "email") {
+			continue
+		}
+
+		key, err := parseRawKeyRecord(record)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		// Keys are never echoed, only the line they were first seen on
+		if prev, ok := lines[key.Key]; ok {
+			errs = append(errs, fmt.Errorf("line %d: duplicate key (first on line %d)", line, prev))
+			continue
+		}
+		key.Line, lines[key.Key] = line, line
+		keys = append(keys, key)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return keys, nil
+}
+
+// parseRawKeyRecord validates one email,key record
+func parseRawKeyRecord(record []string) (RawKey, error) {
+	if len(record) != 2 {
+		return RawKey{}, fmt.Errorf("%d fields, want email,key", len(record))
+	}
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+
+	if err := ValidateEmail(record[0]); err != nil {
+		return RawKey{}, err
+	}
+	if record[1] == "" {
+		return RawKey{}, fmt.Errorf("%w: empty key", ErrInvalidAPIKey)
+	}
+	return RawKey{Email: record[0], Key: record[1]}, nil
+}
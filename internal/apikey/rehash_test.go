@@ -0,0 +1,89 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/efortin/batsign/internal/models"
+)
+
+func TestParseRawKeysCSV(t *testing.T) {
+	const (
+		keyA = "sk-rehash-known-key"
+		keyB = "sk-rehash-other-key"
+	)
+
+	t.Run("valid rows", func(t *testing.T) {
+		keys, err := ParseRawKeysCSV(strings.NewReader("email,key\nuser@example.com," + keyA + "\nold@example.com, " + keyB + " \n"))
+		if err != nil {
+			t.Fatalf("ParseRawKeysCSV() error = %v", err)
+		}
+		want := []RawKey{{Line: 2, Email: "user@example.com", Key: keyA}, {Line: 3, Email: "old@example.com", Key: keyB}}
+		if len(keys) != len(want) || keys[0] != want[0] || keys[1] != want[1] {
+			t.Errorf("ParseRawKeysCSV() = %+v, want %+v", keys, want)
+		}
+	})
+
+	t.Run("malformed rows", func(t *testing.T) {
+		_, err := ParseRawKeysCSV(strings.NewReader("email,key\n" +
+			"not-an-email," + keyA + "\n" +
+			"user@example.com,\n" +
+			"user@example.com," + keyA + ",true\n" +
+			"user@example.com\n" +
+			"ok@example.com," + keyA + "\n" +
+			"ok@example.com," + keyB + "\n" +
+			"other@example.com," + keyA + "\n"))
+		if err == nil {
+			t.Fatal("ParseRawKeysCSV() error = nil")
+		}
+		// Emails may repeat, e.g. for the keys of a tenant
+		wantErrs := []string{
+			"line 2: invalid email format",
+			"line 3: invalid API key: empty key",
+			"line 4: 3 fields, want email,key",
+			"line 5: 1 fields",
+			"line 8: duplicate key (first on line 6)",
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != len(wantErrs) {
+			t.Fatalf("ParseRawKeysCSV() reported %d errors, want %d:\n%v", len(lines), len(wantErrs), err)
+		}
+		for i, want := range wantErrs {
+			if !strings.Contains(lines[i], want) {
+				t.Errorf("ParseRawKeysCSV() error %d = %q, want it to contain %q", i, lines[i], want)
+			}
+			if strings.Contains(lines[i], keyA) {
+				t.Errorf("ParseRawKeysCSV() error %d echoes the key: %q", i, lines[i])
+			}
+		}
+	})
+}
+
+func TestHashAPIKeyWithAlgo(t *testing.T) {
+	const (
+		key = "sk-rehash-known-key"
+		// sha256Hash is the SHA-256 hash of key
+		sha256Hash = "56dbab98319a1b208d5043ffe6ea225295f8de5bb8648d6d6b7fd9e32cc79431"
+	)
+
+	for _, algo := range []string{"", models.HashAlgoSHA256} {
+		if hash, err := HashAPIKeyWithAlgo(key, algo); err != nil || hash != sha256Hash {
+			t.Errorf("HashAPIKeyWithAlgo(%q) = %s, %v, want %s", algo, hash, err, sha256Hash)
+		}
+	}
+
+	hash, err := HashAPIKeyWithAlgo(key, models.HashAlgoArgon2id)
+	if err != nil {
+		t.Fatalf("HashAPIKeyWithAlgo(argon2id) error = %v", err)
+	}
+	if ok, err := VerifyArgon2id(key, hash); err != nil || !ok {
+		t.Errorf("HashAPIKeyWithAlgo(argon2id) hash does not verify its key: %v", err)
+	}
+	if ok, _ := VerifyArgon2id(key+"x", hash); ok {
+		t.Error("HashAPIKeyWithAlgo(argon2id) hash verifies another key")
+	}
+
+	if _, err := HashAPIKeyWithAlgo(key, "sha512"); err == nil || !strings.Contains(err.Error(), `unknown key hash algorithm "sha512"`) {
+		t.Errorf("HashAPIKeyWithAlgo(sha512) error = %v, want an unknown algorithm error", err)
+	}
+}
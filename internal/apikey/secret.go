@@ -0,0 +1,91 @@
+package apikey
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// SecretKeyField is the Secret data field holding the raw API key
+const SecretKeyField = "apiKey"
+
+// maxResourceNameLength is the DNS-1123 subdomain length limit
+const maxResourceNameLength = 253
+
+// invalidNameChars matches characters not allowed in DNS-1123 names
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// secretManifest is the subset of a core/v1 Secret emitted by the client
+type secretManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Type       string            `json:"type"`
+	StringData map[string]string `json:"stringData"`
+}
+
+// ResourceName derives the DNS-1123 compliant APIKey resource name from an
+// email, as used by GenerateYAML
+func ResourceName(email string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(SanitizeEmail(email)), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > maxResourceNameLength {
+		name = strings.TrimRight(name[:maxResourceNameLength], "-")
+	}
+	return name
+}
+
+// SecretName derives a DNS-1123 compliant Secret name from an email
+func SecretName(email string) string {
+	name := ResourceName(email)
+
+	const suffix = "-apikey"
+	if len(name) > maxResourceNameLength-len(suffix) {
+		name = strings.TrimRight(name[:maxResourceNameLength-len(suffix)], "-")
+	}
+	return name + suffix
+}
+
+// ValidateNamespace checks that a namespace is a DNS-1123 label, as
+// Kubernetes requires
+func ValidateNamespace(namespace string) error {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return fmt.Errorf("%w %q: %s", ErrInvalidNamespace, namespace, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// GenerateSecretYAML generates the YAML for an opaque Secret holding the raw
+// API key in stringData.apiKey, for applications consuming the key
+func GenerateSecretYAML(email, key string) (string, error) {
+	return GenerateSecretYAMLInNamespace("", email, key)
+}
+
+// GenerateSecretYAMLInNamespace is GenerateSecretYAML for a Secret in the
+// given namespace (empty = the namespace kubectl applies it to)
+func GenerateSecretYAMLInNamespace(namespace, email, key string) (string, error) {
+	secret := &secretManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SecretName(email),
+			Namespace: namespace,
+			Labels:    map[string]string{ManagedByLabel: ManagedBy},
+		},
+		Type:       "Opaque",
+		StringData: map[string]string{SecretKeyField: key},
+	}
+
+	yamlBytes, err := yaml.Marshal(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal Secret to YAML: %w", err)
+	}
+
+	return "---\n" + string(yamlBytes), nil
+}
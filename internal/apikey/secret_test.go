@@ -0,0 +1,172 @@
+package apikey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/efortin/batsign/internal/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+func TestSecretName(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{"Simple email", "user@example.com", "user-at-example-com-apikey"},
+		{"Uppercase", "John.Doe@Example.COM", "john-doe-at-example-com-apikey"},
+		{"Plus and underscore", "first_last+ci@example.com", "first-last-ci-at-example-com-apikey"},
+		{"Leading symbol", "_svc@example.com", "svc-at-example-com-apikey"},
+		{"Very long", strings.Repeat("a", 300) + "@example.com", strings.Repeat("a", 246) + "-apikey"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SecretName(tt.email)
+			if got != tt.want {
+				t.Errorf("SecretName() = %q, want %q", got, tt.want)
+			}
+			if errs := validation.IsDNS1123Subdomain(got); len(errs) > 0 {
+				t.Errorf("SecretName() = %q is not DNS-1123 valid: %v", got, errs)
+			}
+		})
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{"Simple email", "user@example.com", "user-at-example-com"},
+		{"Subdomain", "ops@mail.example.co.uk", "ops-at-mail-example-co-uk"},
+		{"Uppercase", "John.Doe@Example.COM", "john-doe-at-example-com"},
+		{"Plus tag", "user+ci@example.com", "user-ci-at-example-com"},
+		{"Consecutive symbols", "a_%b@example.com", "a-b-at-example-com"},
+		{"Leading and trailing symbols", "_svc_@example.com", "svc--at-example-com"},
+		{"Very long", strings.Repeat("a", 300) + "@example.com", strings.Repeat("a", 253)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResourceName(tt.email)
+			if got != tt.want {
+				t.Errorf("ResourceName() = %q, want %q", got, tt.want)
+			}
+			if errs := validation.IsDNS1123Subdomain(got); len(errs) > 0 {
+				t.Errorf("ResourceName() = %q is not DNS-1123 valid: %v", got, errs)
+			}
+		})
+	}
+}
+
+func TestGenerateSecretYAML(t *testing.T) {
+	const key = "sk-test-key"
+
+	secretYAML, err := GenerateSecretYAML("user@example.com", key)
+	if err != nil {
+		t.Fatalf("GenerateSecretYAML() error = %v", err)
+	}
+	apiKeyYAML, err := GenerateYAML(models.APIKeySpec{Email: "user@example.com", KeyHash: HashAPIKey(key), Enabled: true})
+	if err != nil {
+		t.Fatalf("GenerateYAML() error = %v", err)
+	}
+
+	// Both documents are applied together, as the client emits them
+	docs := strings.Split(strings.TrimPrefix(apiKeyYAML+secretYAML, "---\n"), "---\n")
+	if len(docs) != 2 {
+		t.Fatalf("multi-document output has %d documents, want 2", len(docs))
+	}
+
+	var secret struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Type       string            `json:"type"`
+		StringData map[string]string `json:"stringData"`
+	}
+	if err := yaml.Unmarshal([]byte(docs[1]), &secret); err != nil {
+		t.Fatalf("Secret document does not parse: %v", err)
+	}
+
+	if secret.APIVersion != "v1" || secret.Kind != "Secret" || secret.Type != "Opaque" {
+		t.Errorf("Secret header = %s/%s type %s, want v1/Secret type Opaque", secret.APIVersion, secret.Kind, secret.Type)
+	}
+	if secret.Metadata.Name != "user-at-example-com-apikey" {
+		t.Errorf("Secret name = %q, want user-at-example-com-apikey", secret.Metadata.Name)
+	}
+	if secret.StringData[SecretKeyField] != key {
+		t.Errorf("Secret stringData.%s = %q, want %q", SecretKeyField, secret.StringData[SecretKeyField], key)
+	}
+}
+
+func TestGenerateYAMLInNamespace(t *testing.T) {
+	const key = "sk-test-key"
+
+	apiKeyYAML, err := GenerateYAMLInNamespace("team-a", models.APIKeySpec{Email: "user@example.com", KeyHash: HashAPIKey(key), Enabled: true})
+	if err != nil {
+		t.Fatalf("GenerateYAMLInNamespace() error = %v", err)
+	}
+	secretYAML, err := GenerateSecretYAMLInNamespace("team-a", "user@example.com", key)
+	if err != nil {
+		t.Fatalf("GenerateSecretYAMLInNamespace() error = %v", err)
+	}
+
+	docs := strings.Split(strings.TrimPrefix(apiKeyYAML+secretYAML, "---\n"), "---\n")
+	if len(docs) != 2 {
+		t.Fatalf("multi-document output has %d documents, want 2", len(docs))
+	}
+	for _, doc := range docs {
+		var parsed metav1.PartialObjectMetadata
+		if err := yaml.Unmarshal([]byte(doc), &parsed); err != nil {
+			t.Fatalf("document does not parse: %v", err)
+		}
+		if parsed.Namespace != "team-a" {
+			t.Errorf("%s namespace = %q, want team-a", parsed.Kind, parsed.Namespace)
+		}
+	}
+
+	// Without a namespace, kubectl picks it
+	apiKeyYAML, err = GenerateYAML(models.APIKeySpec{Email: "user@example.com", KeyHash: HashAPIKey(key)})
+	if err != nil {
+		t.Fatalf("GenerateYAML() error = %v", err)
+	}
+	if strings.Contains(apiKeyYAML, "namespace:") {
+		t.Errorf("GenerateYAML() should omit the namespace, got %s", apiKeyYAML)
+	}
+}
+
+func TestValidateNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		wantErr   bool
+	}{
+		{"Simple", "gateway", false},
+		{"With digits and dashes", "team-a-2", false},
+		{"Empty", "", true},
+		{"Uppercase", "Gateway", true},
+		{"Dots", "team.a", true},
+		{"Leading dash", "-gateway", true},
+		{"Too long", strings.Repeat("a", 64), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateNamespace(tt.namespace)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNamespace(%q) error = %v, wantErr %v", tt.namespace, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidNamespace) {
+				t.Errorf("ValidateNamespace(%q) error = %v, want ErrInvalidNamespace", tt.namespace, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultRandomSource is the name of the crypto/rand random source
+const DefaultRandomSource = "crypto"
+
+// RandomSourceFactory creates the reader keys are generated from
+type RandomSourceFactory func() (io.Reader, error)
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = map[string]RandomSourceFactory{
+		DefaultRandomSource: func() (io.Reader, error) { return rand.Reader, nil },
+	}
+)
+
+// RegisterRandomSource makes a random source selectable by name, e.g. a
+// KMS-backed one:
+//
+//	apikey.RegisterRandomSource("kms", func() (io.Reader, error) {
+//		return apikey.NewKMSReader(client), nil
+//	})
+func RegisterRandomSource(name string, factory RandomSourceFactory) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	sources[name] = factory
+}
+
+// NewRandomSource creates the random source registered under name
+// (empty = DefaultRandomSource)
+func NewRandomSource(name string) (io.Reader, error) {
+	if name == "" {
+		name = DefaultRandomSource
+	}
+
+	sourcesMu.RLock()
+	factory, ok := sources[name]
+	sourcesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown random source %q (available: %s)", name, strings.Join(RandomSources(), ", "))
+	}
+	return factory()
+}
+
+// RandomSources returns the names of the registered random sources
+func RandomSources() []string {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KMSClient is the part of a KMS or HSM API needed to draw random bytes,
+// e.g. AWS KMS GenerateRandom or Cloud KMS GenerateRandomBytes
+type KMSClient interface {
+	GenerateRandom(ctx context.Context, numBytes int) ([]byte, error)
+}
+
+// KMSReader is a ContextReader drawing its bytes from a KMS
+type KMSReader struct {
+	client KMSClient
+}
+
+var _ ContextReader = (*KMSReader)(nil)
+
+// NewKMSReader creates a reader backed by the given KMS client
+func NewKMSReader(client KMSClient) *KMSReader {
+	return &KMSReader{client: client}
+}
+
+// Read fills p with random bytes from the KMS
+func (r *KMSReader) Read(p []byte) (int, error) {
+	return r.ReadContext(context.Background(), p)
+}
+
+// ReadContext fills p with random bytes from the KMS, honouring ctx
+func (r *KMSReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	b, err := r.client.GenerateRandom(ctx, len(p))
+	if err != nil {
+		return 0, fmt.Errorf("KMS GenerateRandom failed: %w", err)
+	}
+	if len(b) != len(p) {
+		return 0, fmt.Errorf("KMS GenerateRandom returned %d bytes, want %d", len(b), len(p))
+	}
+	return copy(p, b), nil
+}
@@ -0,0 +1,132 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+)
+
+// fakeKMS returns a fixed byte pattern, or an error
+type fakeKMS struct {
+	fill     byte
+	short    bool
+	err      error
+	requests []int
+}
+
+func (f *fakeKMS) GenerateRandom(ctx context.Context, numBytes int) ([]byte, error) {
+	f.requests = append(f.requests, numBytes)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.short {
+		numBytes--
+	}
+	return bytes.Repeat([]byte{f.fill}, numBytes), nil
+}
+
+func TestKMSReader_GenerateAPIKey(t *testing.T) {
+	kms := &fakeKMS{fill: 0xAB}
+
+	key, err := GenerateAPIKeyWithReader(NewKMSReader(kms))
+	if err != nil {
+		t.Fatalf("GenerateAPIKeyWithReader() error = %v", err)
+	}
+
+	want := "sk-" + base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{0xAB}, 32))
+	if key != want {
+		t.Errorf("GenerateAPIKeyWithReader() = %s, want %s", key, want)
+	}
+	if len(kms.requests) != 1 || kms.requests[0] != 32 {
+		t.Errorf("KMS requests = %v, want [32]", kms.requests)
+	}
+}
+
+func TestKMSReader_Errors(t *testing.T) {
+	kmsErr := errors.New("kms unavailable")
+
+	tests := []struct {
+		name    string
+		kms     *fakeKMS
+		ctx     func() context.Context
+		wantErr error
+	}{
+		{
+			name:    "KMS failure",
+			kms:     &fakeKMS{err: kmsErr},
+			ctx:     context.Background,
+			wantErr: kmsErr,
+		},
+		{
+			name: "Short response",
+			kms:  &fakeKMS{short: true},
+			ctx:  context.Background,
+		},
+		{
+			name: "Cancelled context",
+			kms:  &fakeKMS{},
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			wantErr: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := GenerateAPIKeyContext(tt.ctx(), NewKMSReader(tt.kms))
+			if err == nil {
+				t.Fatal("GenerateAPIKeyContext() should return error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("GenerateAPIKeyContext() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewRandomSource(t *testing.T) {
+	for _, name := range []string{"", DefaultRandomSource} {
+		reader, err := NewRandomSource(name)
+		if err != nil {
+			t.Fatalf("NewRandomSource(%q) error = %v", name, err)
+		}
+		if reader != rand.Reader {
+			t.Errorf("NewRandomSource(%q) should return crypto/rand.Reader", name)
+		}
+	}
+
+	if _, err := NewRandomSource("unknown"); err == nil {
+		t.Error("NewRandomSource() with unknown name should return error")
+	}
+}
+
+func TestRegisterRandomSource(t *testing.T) {
+	kms := &fakeKMS{fill: 0x01}
+	RegisterRandomSource("fake-kms", func() (io.Reader, error) { return NewKMSReader(kms), nil })
+	t.Cleanup(func() {
+		sourcesMu.Lock()
+		delete(sources, "fake-kms")
+		sourcesMu.Unlock()
+	})
+
+	reader, err := NewRandomSource("fake-kms")
+	if err != nil {
+		t.Fatalf("NewRandomSource() error = %v", err)
+	}
+	if _, err := GenerateAPIKeyWithReader(reader); err != nil {
+		t.Fatalf("GenerateAPIKeyWithReader() error = %v", err)
+	}
+	if len(kms.requests) != 1 {
+		t.Errorf("registered source was not used")
+	}
+}
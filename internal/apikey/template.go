@@ -0,0 +1,54 @@
+package apikey
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// SpecTemplate is a partial APIKeySpec shared by generated keys, e.g.
+//
+//	description: CI pipeline key
+//	enabled: true
+//	allowedMethods: [GET, HEAD]
+//
+// The email, hash and hint are generated per key and cannot be templated.
+type SpecTemplate struct {
+	Description    string   `json:"description,omitempty"`
+	Enabled        *bool    `json:"enabled,omitempty"`
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+}
+
+// ParseSpecTemplate parses a YAML or JSON template, rejecting unknown fields
+func ParseSpecTemplate(data []byte) (SpecTemplate, error) {
+	var tmpl SpecTemplate
+	if err := yaml.UnmarshalStrict(data, &tmpl); err != nil {
+		return SpecTemplate{}, fmt.Errorf("invalid spec template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// LoadSpecTemplate reads and parses a template file
+func LoadSpecTemplate(path string) (SpecTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SpecTemplate{}, fmt.Errorf("failed to read spec template: %w", err)
+	}
+	return ParseSpecTemplate(data)
+}
+
+// Merge returns the template with the fields set in overrides taking precedence
+func (t SpecTemplate) Merge(overrides SpecTemplate) SpecTemplate {
+	merged := t
+	if overrides.Description != "" {
+		merged.Description = overrides.Description
+	}
+	if overrides.Enabled != nil {
+		merged.Enabled = overrides.Enabled
+	}
+	if overrides.AllowedMethods != nil {
+		merged.AllowedMethods = overrides.AllowedMethods
+	}
+	return merged
+}
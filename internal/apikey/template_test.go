@@ -0,0 +1,131 @@
+package apikey
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestParseSpecTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    SpecTemplate
+		wantErr bool
+	}{
+		{
+			name: "All fields",
+			data: "description: CI key\nenabled: false\nallowedMethods: [GET, HEAD]\n",
+			want: SpecTemplate{Description: "CI key", Enabled: boolPtr(false), AllowedMethods: []string{"GET", "HEAD"}},
+		},
+		{
+			name: "Partial template",
+			data: "description: CI key\n",
+			want: SpecTemplate{Description: "CI key"},
+		},
+		{
+			name:    "Unknown field",
+			data:    "descripton: CI key\n",
+			wantErr: true,
+		},
+		{
+			name:    "Email cannot be templated",
+			data:    "email: user@example.com\n",
+			wantErr: true,
+		},
+		{
+			name:    "Hash cannot be templated",
+			data:    "keyHash: abc\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSpecTemplate([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSpecTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSpecTemplate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpecTemplate_Merge(t *testing.T) {
+	template := SpecTemplate{
+		Description:    "Template description",
+		Enabled:        boolPtr(false),
+		AllowedMethods: []string{"GET"},
+	}
+
+	tests := []struct {
+		name      string
+		template  SpecTemplate
+		overrides SpecTemplate
+		want      SpecTemplate
+	}{
+		{
+			name:     "Template only",
+			template: template,
+			want:     template,
+		},
+		{
+			name:      "Flags only",
+			overrides: SpecTemplate{Description: "Flag description", Enabled: boolPtr(true)},
+			want:      SpecTemplate{Description: "Flag description", Enabled: boolPtr(true)},
+		},
+		{
+			name:      "Flags override template",
+			template:  template,
+			overrides: SpecTemplate{Description: "Flag description", Enabled: boolPtr(true), AllowedMethods: []string{"POST"}},
+			want:      SpecTemplate{Description: "Flag description", Enabled: boolPtr(true), AllowedMethods: []string{"POST"}},
+		},
+		{
+			name:      "Unset flags keep template values",
+			template:  template,
+			overrides: SpecTemplate{Description: "Flag description"},
+			want:      SpecTemplate{Description: "Flag description", Enabled: boolPtr(false), AllowedMethods: []string{"GET"}},
+		},
+		{
+			name:      "Empty method list clears template restriction",
+			template:  template,
+			overrides: SpecTemplate{AllowedMethods: []string{}},
+			want:      SpecTemplate{Description: "Template description", Enabled: boolPtr(false), AllowedMethods: []string{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.template.Merge(tt.overrides)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSpecTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.yaml")
+	if err := os.WriteFile(path, []byte("description: CI key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadSpecTemplate(path)
+	if err != nil {
+		t.Fatalf("LoadSpecTemplate() error = %v", err)
+	}
+	if got.Description != "CI key" {
+		t.Errorf("LoadSpecTemplate() description = %q, want %q", got.Description, "CI key")
+	}
+
+	if _, err := LoadSpecTemplate(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadSpecTemplate() with missing file should return error")
+	}
+}
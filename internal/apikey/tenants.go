@@ -0,0 +1,120 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/efortin/batsign/internal/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// TenantLabel labels the APIKey resources generated for a tenant with the
+// tenant's prefix
+const TenantLabel = "batsign.io/tenant"
+
+// Tenant describes the keys generated for a tenant, listed in a tenants
+// file, e.g.
+//
+//	[{prefix: acme_, count: 100, email: loadtest@acme.example}]
+type Tenant struct {
+	// Prefix starts each key of the tenant
+	Prefix string `json:"prefix"`
+	// Count is the number of keys generated
+	Count int `json:"count"`
+	// Email owns the keys of the tenant
+	Email string `json:"email"`
+}
+
+// name returns the base name of the tenant's APIKey resources
+func (t Tenant) name() string {
+	return strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(t.Prefix), "-"), "-")
+}
+
+// TenantKey is a key generated for a tenant
+type TenantKey struct {
+	Tenant Tenant
+	// Name is the name of the key's APIKey resource, <prefix>-<n>
+	Name string
+	Key  string
+}
+
+// ParseTenants parses a YAML or JSON list of tenants, rejecting unknown
+// fields, invalid prefixes or emails, counts below 1 and tenants whose
+// resource names would collide
+func ParseTenants(data []byte) ([]Tenant, error) {
+	var tenants []Tenant
+	if err := yaml.UnmarshalStrict(data, &tenants); err != nil {
+		return nil, fmt.Errorf("invalid tenants: %w", err)
+	}
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("invalid tenants: no tenant defined")
+	}
+
+	names := make(map[string]string, len(tenants))
+	for i, tenant := range tenants {
+		if tenant.Prefix == "" {
+			return nil, fmt.Errorf("invalid tenant %d: empty prefix", i+1)
+		}
+		if err := (Config{Prefix: tenant.Prefix}).Validate(); err != nil {
+			return nil, fmt.Errorf("invalid tenant %d: %w", i+1, err)
+		}
+		if tenant.Count < 1 {
+			return nil, fmt.Errorf("invalid tenant %s: count %d is below 1", tenant.Prefix, tenant.Count)
+		}
+		if err := ValidateEmail(tenant.Email); err != nil {
+			return nil, fmt.Errorf("invalid tenant %s: %w", tenant.Prefix, err)
+		}
+		if other, ok := names[tenant.name()]; ok {
+			return nil, fmt.Errorf("invalid tenant %s: its resources would be named like those of %s", tenant.Prefix, other)
+		}
+		names[tenant.name()] = tenant.Prefix
+	}
+	return tenants, nil
+}
+
+// LoadTenants reads and parses a tenants file
+func LoadTenants(path string) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants: %w", err)
+	}
+	return ParseTenants(data)
+}
+
+// GenerateTenantKeys generates the keys of every tenant, in order, each of
+// the size of cfg and starting with its tenant's prefix
+func GenerateTenantKeys(ctx context.Context, reader io.Reader, cfg Config, tenants []Tenant) ([]TenantKey, error) {
+	var keys []TenantKey
+	for _, tenant := range tenants {
+		tenantCfg := cfg
+		tenantCfg.Prefix = tenant.Prefix
+		for i := 1; i <= tenant.Count; i++ {
+			key, err := GenerateAPIKeyWithConfigContext(ctx, reader, tenantCfg)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", tenant.Prefix, err)
+			}
+			keys = append(keys, TenantKey{Tenant: tenant, Name: tenant.name() + "-" + strconv.Itoa(i), Key: key})
+		}
+	}
+	return keys, nil
+}
+
+// GenerateTenantYAML generates the Kubernetes YAML for the APIKey resource
+// of a tenant key, labelled with TenantLabel and ManagedByLabel, in the given
+// namespace (empty = the namespace kubectl applies it to)
+func GenerateTenantYAML(namespace string, key TenantKey, spec models.APIKeySpec) (string, error) {
+	return generateYAML(metav1.ObjectMeta{
+		Name:      key.Name,
+		Namespace: namespace,
+		// Label values must start and end with a letter or digit
+		Labels: map[string]string{
+			TenantLabel:    strings.TrimRight(key.Tenant.Prefix, "-_"),
+			ManagedByLabel: ManagedBy,
+		},
+	}, spec)
+}
@@ -0,0 +1,109 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/efortin/batsign/internal/models"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseTenants(t *testing.T) {
+	tenants, err := ParseTenants([]byte("- prefix: acme_\n  count: 3\n  email: ops@acme.example\n- prefix: Globex-\n  count: 1\n  email: ops@globex.example\n"))
+	if err != nil {
+		t.Fatalf("ParseTenants() error = %v", err)
+	}
+	if len(tenants) != 2 || tenants[0] != (Tenant{Prefix: "acme_", Count: 3, Email: "ops@acme.example"}) {
+		t.Errorf("ParseTenants() = %+v", tenants)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "empty", data: "[]", wantErr: "no tenant"},
+		{name: "unknown field", data: "- prefix: a\n  count: 1\n  email: a@b.co\n  weight: 2\n", wantErr: "weight"},
+		{name: "no prefix", data: "- count: 1\n  email: a@b.co\n", wantErr: "empty prefix"},
+		{name: "invalid prefix", data: "- prefix: 'a,b'\n  count: 1\n  email: a@b.co\n", wantErr: "invalid key prefix"},
+		{name: "zero count", data: "- prefix: a\n  email: a@b.co\n", wantErr: "count 0"},
+		{name: "invalid email", data: "- prefix: a\n  count: 1\n  email: nobody\n", wantErr: "invalid email"},
+		{name: "colliding names", data: "- prefix: acme_\n  count: 1\n  email: a@b.co\n- prefix: ACME-\n  count: 1\n  email: a@b.co\n", wantErr: "named like those of acme_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTenants([]byte(tt.data)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseTenants() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateTenantKeys(t *testing.T) {
+	tenants := []Tenant{
+		{Prefix: "acme_", Count: 3, Email: "ops@acme.example"},
+		{Prefix: "Globex-", Count: 2, Email: "ops@globex.example"},
+	}
+	keys, err := GenerateTenantKeys(context.Background(), rand.Reader, Config{}, tenants)
+	if err != nil {
+		t.Fatalf("GenerateTenantKeys() error = %v", err)
+	}
+
+	counts := map[string]int{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		counts[key.Tenant.Prefix]++
+		if !strings.HasPrefix(key.Key, key.Tenant.Prefix) {
+			t.Errorf("key %s does not start with %q", key.Key, key.Tenant.Prefix)
+		}
+		if err := ValidateAPIKey(key.Key, Config{Prefix: key.Tenant.Prefix}); err != nil {
+			t.Errorf("ValidateAPIKey(%s) error = %v", key.Name, err)
+		}
+		if seen[key.Name] || seen[key.Key] {
+			t.Errorf("duplicate name or key for %s", key.Name)
+		}
+		seen[key.Name], seen[key.Key] = true, true
+	}
+	if counts["acme_"] != 3 || counts["Globex-"] != 2 {
+		t.Errorf("keys per tenant = %v, want acme_: 3, Globex-: 2", counts)
+	}
+	if keys[0].Name != "acme-1" || keys[4].Name != "globex-2" {
+		t.Errorf("names = %s..%s, want acme-1..globex-2", keys[0].Name, keys[4].Name)
+	}
+}
+
+func TestGenerateTenantYAML(t *testing.T) {
+	key := TenantKey{Tenant: Tenant{Prefix: "acme_", Count: 1, Email: "ops@acme.example"}, Name: "acme-1", Key: "acme_abc"}
+	out, err := GenerateTenantYAML("tenants", key, NewAPIKeySpec(key.Key, key.Tenant.Email))
+	if err != nil {
+		t.Fatalf("GenerateTenantYAML() error = %v", err)
+	}
+
+	var resource models.APIKey
+	if err := yaml.Unmarshal([]byte(strings.TrimPrefix(out, "---\n")), &resource); err != nil {
+		t.Fatalf("invalid YAML: %v", err)
+	}
+	if resource.Name != "acme-1" || resource.Namespace != "tenants" || resource.Labels[TenantLabel] != "acme" {
+		t.Errorf("metadata = %+v, want acme-1 in tenants labelled acme", resource.ObjectMeta)
+	}
+	if resource.Spec.KeyHash != HashAPIKey("acme_abc") {
+		t.Errorf("keyHash = %s, want the hash of the key", resource.Spec.KeyHash)
+	}
+}
+
+func TestConfigPrefix(t *testing.T) {
+	key, err := GenerateAPIKeyWithConfig(rand.Reader, Config{Prefix: "acme_"})
+	if err != nil || !strings.HasPrefix(key, "acme_") {
+		t.Fatalf("GenerateAPIKeyWithConfig() = %q, %v, want an acme_ key", key, err)
+	}
+	if err := ValidateAPIKey(key, Config{}); err == nil {
+		t.Errorf("ValidateAPIKey() accepted an acme_ key without its prefix configured")
+	}
+	for _, prefix := range []string{"-a", "a b", "a,b", strings.Repeat("a", 33)} {
+		if err := (Config{Prefix: prefix}).Validate(); err == nil {
+			t.Errorf("Validate() accepted prefix %q", prefix)
+		}
+	}
+}
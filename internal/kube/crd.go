@@ -0,0 +1,101 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// CRDGVR identifies CustomResourceDefinitions
+var CRDGVR = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// APIKeyCRDName is the name of the APIKey CustomResourceDefinition
+var APIKeyCRDName = APIKeyGVR.Resource + "." + APIKeyGVR.Group
+
+// SpecFields lists the APIKey spec fields ParseAPIKey reads, nested fields
+// as dotted paths. The API server prunes fields its CRD schema does not
+// declare, so a key relying on a missing one would silently lose it.
+var SpecFields = []string{
+	"email",
+	"keyHash",
+	"keyHashes",
+	"keyHashAlgo",
+	"keyHint",
+	"description",
+	"notes",
+	"enabled",
+	"allowedMethods",
+	"activeWindows",
+	"activeWindows.start",
+	"activeWindows.end",
+	"activeWindows.days",
+}
+
+// MissingSpecFields fetches the installed APIKey CRD and returns the
+// SpecFields its schema for APIKeyGVR.Version does not declare
+func MissingSpecFields(ctx context.Context, client dynamic.Interface) ([]string, error) {
+	crd, err := client.Resource(CRDGVR).Get(ctx, APIKeyCRDName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CRD %s: %w", APIKeyCRDName, err)
+	}
+
+	spec, err := specSchema(crd)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, field := range SpecFields {
+		if !hasField(spec, strings.Split(field, ".")) {
+			missing = append(missing, field)
+		}
+	}
+	return missing, nil
+}
+
+// specSchema returns the OpenAPI schema of the APIKey spec for
+// APIKeyGVR.Version
+func specSchema(crd *unstructured.Unstructured) (map[string]interface{}, error) {
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	for _, item := range versions {
+		version, _ := item.(map[string]interface{})
+		if name, _, _ := unstructured.NestedString(version, "name"); name != APIKeyGVR.Version {
+			continue
+		}
+		spec, found, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema", "properties", "spec")
+		if !found {
+			return nil, fmt.Errorf("CRD %s has no spec schema for version %s", APIKeyCRDName, APIKeyGVR.Version)
+		}
+		return spec, nil
+	}
+	return nil, fmt.Errorf("CRD %s does not define version %s", APIKeyCRDName, APIKeyGVR.Version)
+}
+
+// hasField reports whether the schema declares the field at path. Array
+// schemas are looked through to their items, and objects preserving unknown
+// fields keep anything below them.
+func hasField(s map[string]interface{}, path []string) bool {
+	for _, name := range path {
+		if items, found, _ := unstructured.NestedMap(s, "items"); found {
+			s = items
+		}
+		if preserve, _, _ := unstructured.NestedBool(s, "x-kubernetes-preserve-unknown-fields"); preserve {
+			return true
+		}
+		field, found, _ := unstructured.NestedMap(s, "properties", name)
+		if !found {
+			return false
+		}
+		s = field
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// newCRDObject returns the embedded APIKey CRD, modified by edit
+func newCRDObject(t *testing.T, edit func(spec map[string]interface{})) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(apikey.CRDYAML()), &obj.Object); err != nil {
+		t.Fatalf("failed to parse the embedded CRD: %v", err)
+	}
+	versions, _, _ := unstructured.NestedSlice(obj.Object, "spec", "versions")
+	spec, _, _ := unstructured.NestedMap(versions[0].(map[string]interface{}), "schema", "openAPIV3Schema", "properties", "spec")
+	edit(spec)
+	if err := unstructured.SetNestedMap(versions[0].(map[string]interface{}), spec, "schema", "openAPIV3Schema", "properties", "spec"); err != nil {
+		t.Fatalf("failed to set the spec schema: %v", err)
+	}
+	if err := unstructured.SetNestedSlice(obj.Object, versions, "spec", "versions"); err != nil {
+		t.Fatalf("failed to set the versions: %v", err)
+	}
+	return obj
+}
+
+// newFakeCRDClient returns a fake dynamic client serving the given CRDs
+func newFakeCRDClient(t *testing.T, objects ...*unstructured.Unstructured) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{CRDGVR: "CustomResourceDefinitionList"},
+	)
+	for _, obj := range objects {
+		if err := client.Tracker().Create(CRDGVR, obj, ""); err != nil {
+			t.Fatalf("failed to create %s: %v", obj.GetName(), err)
+		}
+	}
+	return client
+}
+
+func TestMissingSpecFields(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(spec map[string]interface{})
+		want []string
+	}{
+		{
+			name: "Embedded CRD",
+			edit: func(map[string]interface{}) {},
+			want: nil,
+		},
+		{
+			name: "Older CRD",
+			edit: func(spec map[string]interface{}) {
+				unstructured.RemoveNestedField(spec, "properties", "enabled")
+				unstructured.RemoveNestedField(spec, "properties", "activeWindows")
+			},
+			want: []string{"enabled", "activeWindows", "activeWindows.start", "activeWindows.end", "activeWindows.days"},
+		},
+		{
+			name: "Missing nested field",
+			edit: func(spec map[string]interface{}) {
+				unstructured.RemoveNestedField(spec, "properties", "activeWindows", "items", "properties", "days")
+			},
+			want: []string{"activeWindows.days"},
+		},
+		{
+			name: "Unknown fields preserved",
+			edit: func(spec map[string]interface{}) {
+				delete(spec, "properties")
+				spec["x-kubernetes-preserve-unknown-fields"] = true
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeCRDClient(t, newCRDObject(t, tt.edit))
+			got, err := MissingSpecFields(context.Background(), client)
+			if err != nil {
+				t.Fatalf("MissingSpecFields() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MissingSpecFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingSpecFields_Errors(t *testing.T) {
+	if _, err := MissingSpecFields(context.Background(), newFakeCRDClient(t)); err == nil {
+		t.Error("MissingSpecFields() without a CRD: expected an error")
+	}
+
+	otherVersion := newCRDObject(t, func(map[string]interface{}) {})
+	versions, _, _ := unstructured.NestedSlice(otherVersion.Object, "spec", "versions")
+	versions[0].(map[string]interface{})["name"] = "v1beta1"
+	if err := unstructured.SetNestedSlice(otherVersion.Object, versions, "spec", "versions"); err != nil {
+		t.Fatalf("failed to set the versions: %v", err)
+	}
+	if _, err := MissingSpecFields(context.Background(), newFakeCRDClient(t, otherVersion)); err == nil {
+		t.Error("MissingSpecFields() without the served version: expected an error")
+	}
+}
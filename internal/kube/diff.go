@@ -0,0 +1,146 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/efortin/batsign/internal/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+)
+
+// KeyChange is what applying the desired APIKeys does to a resource
+type KeyChange string
+
+// Key changes reported by DiffAPIKeys
+const (
+	KeyToAdd    KeyChange = "add"
+	KeyToUpdate KeyChange = "update"
+	KeyToDelete KeyChange = "delete"
+)
+
+// KeyDiff is a difference between the desired and the actual APIKeys
+type KeyDiff struct {
+	Change KeyChange
+	// Name is the namespace/name of the resource
+	Name string
+	// Reasons describe what an update changes
+	Reasons []string
+}
+
+// DiffAPIKeys compares the APIKey resources of the YAML and JSON files of
+// dir (desired) with those of namespace in the cluster (actual), sorted by
+// name. Resources only differ by their hashes and enabled state; desired
+// resources without a namespace belong to namespace.
+func DiffAPIKeys(ctx context.Context, client dynamic.Interface, dir, namespace string) ([]KeyDiff, error) {
+	desired, err := ReadAPIKeyDir(dir, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := client.Resource(APIKeyGVR).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list APIKeys: %w", err)
+	}
+	actual := make(map[string]*models.APIKeyEntry, len(list.Items))
+	for i := range list.Items {
+		actual[list.Items[i].GetNamespace()+"/"+list.Items[i].GetName()] = ParseAPIKey(&list.Items[i])
+	}
+
+	var diffs []KeyDiff
+	for name, want := range desired {
+		got, ok := actual[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, KeyDiff{Change: KeyToAdd, Name: name})
+		case got == nil:
+			diffs = append(diffs, KeyDiff{Change: KeyToUpdate, Name: name, Reasons: []string{"spec added"}})
+		default:
+			if reasons := entryChanges(got, want); len(reasons) > 0 {
+				diffs = append(diffs, KeyDiff{Change: KeyToUpdate, Name: name, Reasons: reasons})
+			}
+		}
+	}
+	for name := range actual {
+		if _, ok := desired[name]; !ok {
+			diffs = append(diffs, KeyDiff{Change: KeyToDelete, Name: name})
+		}
+	}
+
+	slices.SortFunc(diffs, func(a, b KeyDiff) int { return strings.Compare(a.Name, b.Name) })
+	return diffs, nil
+}
+
+// entryChanges describes how the desired entry differs from the actual one
+func entryChanges(got, want *models.APIKeyEntry) []string {
+	var reasons []string
+	if got.KeyHash != want.KeyHash {
+		reasons = append(reasons, "keyHash changed")
+	}
+	if !slices.Equal(slices.Sorted(slices.Values(got.KeyHashes)), slices.Sorted(slices.Values(want.KeyHashes))) {
+		reasons = append(reasons, "keyHashes changed")
+	}
+	if got.KeyHashAlgo != want.KeyHashAlgo {
+		reasons = append(reasons, fmt.Sprintf("keyHashAlgo %q -> %q", got.KeyHashAlgo, want.KeyHashAlgo))
+	}
+	if got.Enabled != want.Enabled {
+		reasons = append(reasons, fmt.Sprintf("enabled %v -> %v", got.Enabled, want.Enabled))
+	}
+	return reasons
+}
+
+// ReadAPIKeyDir reads the APIKey resources of the .yaml, .yml and .json files
+// of dir, not recursing, by namespace/name. Resources without a namespace
+// belong to namespace; those of another namespace, without a spec or
+// defined twice are rejected.
+func ReadAPIKeyDir(dir, namespace string) (map[string]*models.APIKeyEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	entries := make(map[string]*models.APIKeyEntry)
+	for _, file := range files {
+		switch filepath.Ext(file.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if file.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		objs, err := decodeAPIKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+
+		for _, obj := range objs {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(namespace)
+			}
+			name := obj.GetNamespace() + "/" + obj.GetName()
+			if obj.GetNamespace() != namespace {
+				return nil, fmt.Errorf("%s: APIKey %s is not in namespace %q", path, name, namespace)
+			}
+			if _, ok := entries[name]; ok {
+				return nil, fmt.Errorf("%s: APIKey %s is defined twice", path, name)
+			}
+			entry := ParseAPIKey(obj)
+			if entry == nil {
+				return nil, fmt.Errorf("%s: APIKey %s: %w", path, name, ErrNoSpec)
+			}
+			entries[name] = entry
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,115 @@
+package kube
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeKeyFile writes an APIKey YAML file to dir
+func writeKeyFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+// apiKeyYAML returns an APIKey document of the default namespace
+func apiKeyYAML(name, keyHash string, enabled bool) string {
+	return "apiVersion: auth.kgateway.dev/v1alpha1\nkind: APIKey\nmetadata:\n  name: " + name +
+		"\nspec:\n  email: " + name + "@example.com\n  keyHash: " + keyHash + "\n  enabled: " + strconv.FormatBool(enabled) + "\n"
+}
+
+func TestDiffAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "team.yaml", apiKeyYAML("unchanged", "aaaa", true)+"---\n"+apiKeyYAML("disabled", "bbbb", false))
+	writeKeyFile(t, dir, "rotated.yml", apiKeyYAML("rotated", "cccc", true))
+	writeKeyFile(t, dir, "added.json", `{"apiVersion":"auth.kgateway.dev/v1alpha1","kind":"APIKey","metadata":{"name":"added"},"spec":{"keyHash":"dddd"}}`)
+	writeKeyFile(t, dir, "README.md", "not a manifest")
+
+	client := newFakeClient(t,
+		newAPIKeyObject("default", "unchanged", map[string]interface{}{"keyHash": "aaaa"}),
+		newAPIKeyObject("default", "disabled", map[string]interface{}{"keyHash": "bbbb", "enabled": true}),
+		newAPIKeyObject("default", "rotated", map[string]interface{}{"keyHash": "0000"}),
+		newAPIKeyObject("default", "removed", map[string]interface{}{"keyHash": "eeee"}),
+		newAPIKeyObject("other", "elsewhere", map[string]interface{}{"keyHash": "ffff"}),
+	)
+
+	diffs, err := DiffAPIKeys(context.Background(), client, dir, "default")
+	if err != nil {
+		t.Fatalf("DiffAPIKeys() error = %v", err)
+	}
+	want := []KeyDiff{
+		{Change: KeyToAdd, Name: "default/added"},
+		{Change: KeyToUpdate, Name: "default/disabled", Reasons: []string{"enabled true -> false"}},
+		{Change: KeyToDelete, Name: "default/removed"},
+		{Change: KeyToUpdate, Name: "default/rotated", Reasons: []string{"keyHash changed"}},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("DiffAPIKeys() = %+v, want %+v", diffs, want)
+	}
+}
+
+func TestDiffAPIKeys_KeyHashes(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "family.yaml", apiKeyYAML("family", "aaaa", true)+"  keyHashes: [bbbb, cccc]\n")
+
+	client := newFakeClient(t, newAPIKeyObject("default", "family", map[string]interface{}{
+		"keyHash":   "aaaa",
+		"keyHashes": []interface{}{"cccc", "bbbb"},
+	}))
+	diffs, err := DiffAPIKeys(context.Background(), client, dir, "default")
+	if err != nil || len(diffs) != 0 {
+		t.Fatalf("DiffAPIKeys() = %+v, %v, want no difference for reordered hashes", diffs, err)
+	}
+
+	writeKeyFile(t, dir, "family.yaml", apiKeyYAML("family", "aaaa", true)+"  keyHashes: [bbbb]\n")
+	diffs, err = DiffAPIKeys(context.Background(), client, dir, "default")
+	if err != nil || len(diffs) != 1 || !reflect.DeepEqual(diffs[0].Reasons, []string{"keyHashes changed"}) {
+		t.Errorf("DiffAPIKeys() = %+v, %v, want keyHashes changed", diffs, err)
+	}
+}
+
+func TestReadAPIKeyDir_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "other namespace",
+			files:   map[string]string{"a.yaml": strings.Replace(apiKeyYAML("a", "aaaa", true), "name: a", "name: a\n  namespace: prod", 1)},
+			wantErr: `not in namespace "default"`,
+		},
+		{
+			name:    "defined twice",
+			files:   map[string]string{"a.yaml": apiKeyYAML("a", "aaaa", true), "b.yaml": apiKeyYAML("a", "bbbb", true)},
+			wantErr: "defined twice",
+		},
+		{
+			name:    "no spec",
+			files:   map[string]string{"a.yaml": "apiVersion: auth.kgateway.dev/v1alpha1\nkind: APIKey\nmetadata:\n  name: a\n"},
+			wantErr: "no spec",
+		},
+		{
+			name:    "malformed document",
+			files:   map[string]string{"a.yaml": "kind: [APIKey\n"},
+			wantErr: "invalid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeKeyFile(t, dir, name, content)
+			}
+			if _, err := ReadAPIKeyDir(dir, "default"); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadAPIKeyDir() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+// Package kube holds the Kubernetes plumbing shared by the server and the
+// client: client configuration and APIKey resource extraction
+package kube
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// APIKeyGVR identifies the APIKey custom resource
+var APIKeyGVR = schema.GroupVersionResource{
+	Group:    "auth.kgateway.dev",
+	Version:  "v1alpha1",
+	Resource: "apikeys",
+}
+
+// SecretGVR identifies core Secrets
+var SecretGVR = schema.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "secrets",
+}
+
+// RESTConfig loads the client configuration from a kubeconfig file, or from
+// the in-cluster environment when kubeconfig is empty. kubeContext selects a
+// context of the kubeconfig (empty = its current context).
+func RESTConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		if kubeContext != "" {
+			return nil, fmt.Errorf("kube context %q requires a kubeconfig", kubeContext)
+		}
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
+		}
+		return config, nil
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build config from kubeconfig: %w", err)
+	}
+	return config, nil
+}
+
+// ListAPIKeys lists the APIKey resources in namespace (empty = all namespaces),
+// skipping resources without a spec
+func ListAPIKeys(ctx context.Context, client dynamic.Interface, namespace string) ([]models.APIKeyEntry, error) {
+	var resource dynamic.ResourceInterface = client.Resource(APIKeyGVR)
+	if namespace != "" {
+		resource = client.Resource(APIKeyGVR).Namespace(namespace)
+	}
+
+	list, err := resource.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list APIKeys: %w", err)
+	}
+
+	entries := make([]models.APIKeyEntry, 0, len(list.Items))
+	for i := range list.Items {
+		if entry := ParseAPIKey(&list.Items[i]); entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+// ParseAPIKey extracts an APIKeyEntry from an APIKey resource, returning nil
+// when it has no spec
+func ParseAPIKey(obj *unstructured.Unstructured) *models.APIKeyEntry {
+	entry, _ := parseAPIKey(obj)
+	return entry
+}
+
+// parseAPIKey is ParseAPIKey, also returning the reader that recorded the
+// spec fields read and ignored
+func parseAPIKey(obj *unstructured.Unstructured) (*models.APIKeyEntry, *specReader) {
+	spec, found, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil || !found {
+		return nil, nil
+	}
+
+	entry := &models.APIKeyEntry{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+		Source:    models.SourceAPIKey,
+	}
+	r := &specReader{spec: spec}
+
+	if email, found := r.string("email"); found {
+		entry.Email = email
+	}
+	if keyHash, found := r.string("keyHash"); found {
+		entry.KeyHash = keyHash
+	}
+	if keyHashes, found := r.stringSlice("keyHashes"); found {
+		entry.KeyHash, entry.KeyHashes = familyHashes(entry.KeyHash, keyHashes)
+	}
+	if keyHashAlgo, found := r.string("keyHashAlgo"); found {
+		entry.KeyHashAlgo = keyHashAlgo
+	}
+	if keyHint, found := r.string("keyHint"); found {
+		entry.KeyHint = keyHint
+	}
+	if description, found := r.string("description"); found {
+		entry.Description = description
+	}
+	if notes, found := r.string("notes"); found {
+		entry.Notes = notes
+	}
+	if enabled, found := r.bool("enabled"); found {
+		entry.Enabled = enabled
+	} else {
+		entry.Enabled = true // Default to enabled
+	}
+	if methods, found := r.stringSlice("allowedMethods"); found {
+		entry.AllowedMethods = NormalizeMethods(methods)
+	}
+	if windows, found := r.slice("activeWindows"); found {
+		entry.ActiveWindows = parseActiveWindows(windows)
+	}
+	if entry.KeyHash != "" {
+		entry.KeyID = apikey.KeyID(entry.KeyHash)
+	}
+
+	return entry, r
+}
+
+// specReader reads the top-level fields of an APIKey spec, recording which
+// were read and which were ignored for having the wrong type
+type specReader struct {
+	spec    map[string]interface{}
+	read    []string
+	invalid map[string]error
+}
+
+// string reads a string field
+func (r *specReader) string(field string) (string, bool) {
+	value, found, err := unstructured.NestedString(r.spec, field)
+	return value, r.record(field, found, err)
+}
+
+// bool reads a boolean field
+func (r *specReader) bool(field string) (bool, bool) {
+	value, found, err := unstructured.NestedBool(r.spec, field)
+	return value, r.record(field, found, err)
+}
+
+// stringSlice reads a list of strings
+func (r *specReader) stringSlice(field string) ([]string, bool) {
+	value, found, err := unstructured.NestedStringSlice(r.spec, field)
+	return value, r.record(field, found, err)
+}
+
+// slice reads a list
+func (r *specReader) slice(field string) ([]interface{}, bool) {
+	value, found, err := unstructured.NestedSlice(r.spec, field)
+	return value, r.record(field, found, err)
+}
+
+// record notes the outcome of reading field and returns whether it was found
+func (r *specReader) record(field string, found bool, err error) bool {
+	if err != nil {
+		if r.invalid == nil {
+			r.invalid = make(map[string]error)
+		}
+		r.invalid[field] = err
+	}
+	if found {
+		r.read = append(r.read, field)
+	}
+	return found
+}
+
+// parseActiveWindows extracts the active windows of an APIKey spec. Windows
+// are kept even when malformed, so the key is denied during them rather than
+// allowed at any time.
+func parseActiveWindows(items []interface{}) []models.ActiveWindow {
+	windows := make([]models.ActiveWindow, 0, len(items))
+	for _, item := range items {
+		fields, _ := item.(map[string]interface{})
+		var window models.ActiveWindow
+		window.Start, _, _ = unstructured.NestedString(fields, "start")
+		window.End, _, _ = unstructured.NestedString(fields, "end")
+		window.Days, _, _ = unstructured.NestedStringSlice(fields, "days")
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// familyHashes merges the keyHash and keyHashes of a spec into a primary
+// hash and the distinct others. The first of keyHashes becomes the primary
+// when keyHash is empty.
+func familyHashes(keyHash string, keyHashes []string) (string, []string) {
+	var others []string
+	for _, hash := range keyHashes {
+		if hash == "" || hash == keyHash || slices.Contains(others, hash) {
+			continue
+		}
+		if keyHash == "" {
+			keyHash = hash
+			continue
+		}
+		others = append(others, hash)
+	}
+	return keyHash, others
+}
+
+// NormalizeMethods upper-cases and trims HTTP methods, dropping empty values
+func NormalizeMethods(methods []string) []string {
+	normalized := make([]string, 0, len(methods))
+	for _, method := range methods {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			normalized = append(normalized, method)
+		}
+	}
+	return normalized
+}
+
+// FilterEnabled returns the entries whose enabled state is enabled
+func FilterEnabled(entries []models.APIKeyEntry, enabled bool) []models.APIKeyEntry {
+	filtered := make([]models.APIKeyEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Enabled == enabled {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
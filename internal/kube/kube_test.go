@@ -0,0 +1,229 @@
+package kube
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// newAPIKeyObject builds an unstructured APIKey resource
+func newAPIKeyObject(namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "auth.kgateway.dev/v1alpha1",
+		"kind":       "APIKey",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+	}}
+	if spec != nil {
+		obj.Object["spec"] = spec
+	}
+	return obj
+}
+
+// newFakeClient returns a fake dynamic client serving the given APIKeys
+func newFakeClient(t *testing.T, objects ...*unstructured.Unstructured) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{APIKeyGVR: "APIKeyList"},
+	)
+	for _, obj := range objects {
+		if err := client.Tracker().Create(APIKeyGVR, obj, obj.GetNamespace()); err != nil {
+			t.Fatalf("failed to create %s: %v", obj.GetName(), err)
+		}
+	}
+	return client
+}
+
+func TestListAPIKeys(t *testing.T) {
+	client := newFakeClient(t,
+		newAPIKeyObject("team-a", "alice", map[string]interface{}{
+			"email":          "alice@example.com",
+			"keyHash":        "aaaa",
+			"keyHint":        "sk-abc*************de",
+			"allowedMethods": []interface{}{"get"},
+			"notes":          "Rotated yearly",
+		}),
+		newAPIKeyObject("team-b", "bob", map[string]interface{}{
+			"email":   "bob@example.com",
+			"keyHash": "bbbb",
+			"enabled": false,
+		}),
+		newAPIKeyObject("team-b", "broken", nil),
+	)
+
+	tests := []struct {
+		name      string
+		namespace string
+		want      []string
+	}{
+		{name: "All namespaces", namespace: "", want: []string{"alice", "bob"}},
+		{name: "Single namespace", namespace: "team-b", want: []string{"bob"}},
+		{name: "Empty namespace", namespace: "team-c", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ListAPIKeys(context.Background(), client, tt.namespace)
+			if err != nil {
+				t.Fatalf("ListAPIKeys() error = %v", err)
+			}
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, entry.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListAPIKeys() names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	entries, err := ListAPIKeys(context.Background(), client, "team-a")
+	if err != nil {
+		t.Fatalf("ListAPIKeys() error = %v", err)
+	}
+	want := models.APIKeyEntry{
+		Name:           "alice",
+		Email:          "alice@example.com",
+		KeyHash:        "aaaa",
+		KeyHint:        "sk-abc*************de",
+		Enabled:        true,
+		Source:         models.SourceAPIKey,
+		Namespace:      "team-a",
+		AllowedMethods: []string{"GET"},
+		Notes:          "Rotated yearly",
+		KeyID:          apikey.KeyID("aaaa"),
+	}
+	if !reflect.DeepEqual(entries, []models.APIKeyEntry{want}) {
+		t.Errorf("ListAPIKeys() = %+v, want %+v", entries, want)
+	}
+}
+
+func TestFilterEnabled(t *testing.T) {
+	entries := []models.APIKeyEntry{
+		{Name: "on", Enabled: true},
+		{Name: "off", Enabled: false},
+	}
+
+	if got := FilterEnabled(entries, true); len(got) != 1 || got[0].Name != "on" {
+		t.Errorf("FilterEnabled(true) = %+v, want [on]", got)
+	}
+	if got := FilterEnabled(entries, false); len(got) != 1 || got[0].Name != "off" {
+		t.Errorf("FilterEnabled(false) = %+v, want [off]", got)
+	}
+}
+
+func TestParseAPIKey_KeyHashes(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       map[string]interface{}
+		wantHash   string
+		wantHashes []string
+	}{
+		{
+			name:     "Single hash",
+			spec:     map[string]interface{}{"keyHash": "aaaa"},
+			wantHash: "aaaa",
+		},
+		{
+			name:       "Both fields",
+			spec:       map[string]interface{}{"keyHash": "aaaa", "keyHashes": []interface{}{"bbbb", "cccc"}},
+			wantHash:   "aaaa",
+			wantHashes: []string{"bbbb", "cccc"},
+		},
+		{
+			name:       "Duplicates dropped",
+			spec:       map[string]interface{}{"keyHash": "aaaa", "keyHashes": []interface{}{"aaaa", "bbbb", "", "bbbb"}},
+			wantHash:   "aaaa",
+			wantHashes: []string{"bbbb"},
+		},
+		{
+			name:       "Only keyHashes",
+			spec:       map[string]interface{}{"keyHashes": []interface{}{"bbbb", "cccc"}},
+			wantHash:   "bbbb",
+			wantHashes: []string{"cccc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := ParseAPIKey(newAPIKeyObject("", "family", tt.spec))
+			if entry.KeyHash != tt.wantHash || !reflect.DeepEqual(entry.KeyHashes, tt.wantHashes) {
+				t.Errorf("ParseAPIKey() hashes = %q, %q, want %q, %q", entry.KeyHash, entry.KeyHashes, tt.wantHash, tt.wantHashes)
+			}
+		})
+	}
+}
+
+func TestNormalizeMethods(t *testing.T) {
+	got := NormalizeMethods([]string{"get", " Head ", ""})
+	if want := []string{"GET", "HEAD"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeMethods() = %v, want %v", got, want)
+	}
+}
+
+func TestRESTConfig_Context(t *testing.T) {
+	const kubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: user
+- name: prod
+  context:
+    cluster: prod
+    user: user
+users:
+- name: user
+  user:
+    token: test-token
+`
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatalf("failed to write kubeconfig: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		context    string
+		wantServer string
+	}{
+		{"Current context", "", "https://dev.example.com:6443"},
+		{"Selected context", "prod", "https://prod.example.com:6443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := RESTConfig(path, tt.context)
+			if err != nil {
+				t.Fatalf("RESTConfig() error = %v", err)
+			}
+			if config.Host != tt.wantServer {
+				t.Errorf("RESTConfig() host = %q, want %q", config.Host, tt.wantServer)
+			}
+		})
+	}
+
+	if _, err := RESTConfig(path, "staging"); err == nil {
+		t.Error("RESTConfig() with an unknown context should return error")
+	}
+	if _, err := RESTConfig("", "prod"); err == nil {
+		t.Error("RESTConfig() with a context but no kubeconfig should return error")
+	}
+}
@@ -0,0 +1,215 @@
+package kube
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Reasons the server skips an APIKey resource
+var (
+	ErrNoSpec           = errors.New("no spec")
+	ErrEmptyKeyHash     = errors.New("empty keyHash")
+	ErrMalformedKeyHash = errors.New("keyHash is not a hex encoded SHA-256 hash")
+)
+
+// requiredSpecFields are the spec fields the CRD requires
+var requiredSpecFields = []string{"email", "keyHash", "keyHint"}
+
+// sha256Hex matches a hex encoded SHA-256 hash, as the CRD requires
+var sha256Hex = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// CheckAPIKey returns the entry the server serves for an APIKey resource,
+// or the reason it is skipped, along with the problems the server logs about
+// it: keys that are served but partly or entirely unusable
+func CheckAPIKey(obj *unstructured.Unstructured) (*models.APIKeyEntry, []string, error) {
+	entry := ParseAPIKey(obj)
+	if entry == nil {
+		return nil, nil, ErrNoSpec
+	}
+	if entry.KeyHash == "" {
+		return nil, nil, ErrEmptyKeyHash
+	}
+	if !validKeyHash(entry) {
+		return nil, nil, ErrMalformedKeyHash
+	}
+	return entry, entryProblems(entry), nil
+}
+
+// validKeyHash reports whether the keyHash of a SHA-256 entry is well-formed.
+// A truncated or otherwise malformed hash never matches any key. Argon2id
+// hashes are checked by entryProblems.
+func validKeyHash(entry *models.APIKeyEntry) bool {
+	switch entry.KeyHashAlgo {
+	case "", models.HashAlgoSHA256:
+		return IsSHA256Hash(entry.KeyHash)
+	default:
+		return true
+	}
+}
+
+// IsSHA256Hash reports whether hash is a lower-case hex encoded SHA-256
+// hash, as stored in keyHash
+func IsSHA256Hash(hash string) bool {
+	return sha256Hex.MatchString(hash)
+}
+
+// entryProblems lists what makes a served key partly or entirely unusable
+func entryProblems(entry *models.APIKeyEntry) []string {
+	var problems []string
+	for _, hash := range entry.KeyHashes {
+		if !sha256Hex.MatchString(hash) {
+			problems = append(problems, fmt.Sprintf("hash %q is not a hex encoded SHA-256 hash", hash))
+		}
+	}
+	if entry.KeyHint != "" {
+		if err := apikey.ValidateHint(entry.KeyHint); err != nil {
+			problems = append(problems, fmt.Sprintf("has a malformed keyHint %q: %v", entry.KeyHint, err))
+		}
+	}
+	for i, window := range entry.ActiveWindows {
+		if err := window.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("has an invalid active window %d, never active: %v", i, err))
+		}
+	}
+	switch entry.KeyHashAlgo {
+	case "", models.HashAlgoSHA256:
+	case models.HashAlgoArgon2id:
+		if err := apikey.ValidateArgon2id(entry.KeyHash); err != nil {
+			problems = append(problems, fmt.Sprintf("never validates: %v", err))
+		} else if apikey.ValidateHint(entry.KeyHint) != nil {
+			problems = append(problems, "never validates: argon2id keys are found by their keyHint, which is missing or malformed")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("never validates: unknown keyHashAlgo %q", entry.KeyHashAlgo))
+	}
+	return problems
+}
+
+// APIKeyLint describes how the server reads an APIKey resource
+type APIKeyLint struct {
+	// Name is the namespace/name of the resource, or its name without a
+	// namespace
+	Name string
+	// Entry is what the server serves (nil when skipped)
+	Entry *models.APIKeyEntry
+	// Read lists the spec fields the server read
+	Read []string
+	// Defaults describes the values applied for absent fields
+	Defaults []string
+	// Ignored describes the spec fields the server does not read
+	Ignored []string
+	// Skipped is why the server ignores the whole resource
+	Skipped error
+	// Problems are mistakes the server or the API server would act on
+	Problems []string
+}
+
+// OK reports whether the resource is served without problems
+func (l *APIKeyLint) OK() bool {
+	return l.Skipped == nil && len(l.Problems) == 0
+}
+
+// LintAPIKeys reads the APIKey resources of a YAML or JSON stream, possibly
+// holding several documents, the way the server does. Documents of other
+// kinds, e.g. a consuming Secret, are left out.
+func LintAPIKeys(data []byte) ([]APIKeyLint, error) {
+	objs, err := decodeAPIKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) == 0 {
+		return nil, errors.New("no APIKey resources found")
+	}
+	lints := make([]APIKeyLint, 0, len(objs))
+	for _, obj := range objs {
+		lints = append(lints, LintAPIKey(obj))
+	}
+	return lints, nil
+}
+
+// decodeAPIKeys returns the APIKey resources of a YAML or JSON stream,
+// leaving out the documents of other kinds
+func decodeAPIKeys(data []byte) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	var objs []*unstructured.Unstructured
+	for i := 0; ; i++ {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+			return nil, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		if obj.Object == nil || obj.GetKind() != "APIKey" {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// LintAPIKey reads an APIKey resource like the server, reporting the fields
+// read, the defaults applied and the problems found
+func LintAPIKey(obj *unstructured.Unstructured) APIKeyLint {
+	lint := APIKeyLint{Name: obj.GetName()}
+	if obj.GetNamespace() != "" {
+		lint.Name = obj.GetNamespace() + "/" + lint.Name
+	}
+	if obj.GetAPIVersion() != APIKeyGVR.GroupVersion().String() {
+		lint.Skipped = fmt.Errorf("apiVersion %q is not watched, expected %s", obj.GetAPIVersion(), APIKeyGVR.GroupVersion())
+		return lint
+	}
+
+	entry, r := parseAPIKey(obj)
+	if entry == nil {
+		lint.Skipped = ErrNoSpec
+		return lint
+	}
+	lint.Read = r.read
+
+	for _, field := range requiredSpecFields {
+		if !slices.Contains(r.read, field) {
+			lint.Problems = append(lint.Problems, fmt.Sprintf("lacks %s, which the CRD requires: the API server rejects it", field))
+		}
+	}
+	for _, field := range slices.Sorted(maps.Keys(r.spec)) {
+		if err, ok := r.invalid[field]; ok {
+			lint.Ignored = append(lint.Ignored, fmt.Sprintf("%s: %v", field, err))
+			lint.Problems = append(lint.Problems, fmt.Sprintf("%s has the wrong type and is ignored", field))
+		} else if !slices.Contains(SpecFields, field) {
+			lint.Ignored = append(lint.Ignored, field+": unknown field, dropped by the API server")
+		}
+	}
+
+	if !slices.Contains(r.read, "enabled") {
+		lint.Defaults = append(lint.Defaults, "enabled: true")
+	}
+	if !slices.Contains(r.read, "keyHashAlgo") {
+		lint.Defaults = append(lint.Defaults, "keyHashAlgo: "+models.HashAlgoSHA256)
+	}
+	if keyHash, _, _ := unstructured.NestedString(r.spec, "keyHash"); keyHash == "" && entry.KeyHash != "" {
+		lint.Defaults = append(lint.Defaults, "keyHash: the first of keyHashes")
+	}
+
+	if entry.KeyHash == "" {
+		lint.Skipped = ErrEmptyKeyHash
+		return lint
+	}
+	if !validKeyHash(entry) {
+		lint.Skipped = ErrMalformedKeyHash
+		lint.Problems = append(lint.Problems, fmt.Sprintf("hash %q is not a hex encoded SHA-256 hash", entry.KeyHash))
+		return lint
+	}
+	lint.Entry = entry
+	lint.Problems = append(lint.Problems, entryProblems(entry)...)
+	return lint
+}
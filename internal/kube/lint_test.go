@@ -0,0 +1,187 @@
+package kube
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// validAPIKeyYAML is an APIKey as the client generates it
+const validAPIKeyYAML = `apiVersion: auth.kgateway.dev/v1alpha1
+kind: APIKey
+metadata:
+  name: alice
+  namespace: default
+spec:
+  email: alice@example.com
+  keyHash: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+  keyHint: sk-abc*************de
+`
+
+func TestLintAPIKeys_WellFormed(t *testing.T) {
+	secret := "---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: alice-apikey\n"
+	lints, err := LintAPIKeys([]byte(validAPIKeyYAML + secret))
+	if err != nil {
+		t.Fatalf("LintAPIKeys() error = %v", err)
+	}
+	if len(lints) != 1 {
+		t.Fatalf("LintAPIKeys() = %d lints, want the APIKey only", len(lints))
+	}
+
+	lint := lints[0]
+	if !lint.OK() || lint.Name != "default/alice" || lint.Entry == nil || !lint.Entry.Enabled {
+		t.Errorf("LintAPIKeys() = %+v, want an enabled key without problems", lint)
+	}
+	if want := []string{"email", "keyHash", "keyHint"}; !reflect.DeepEqual(lint.Read, want) {
+		t.Errorf("Read = %q, want %q", lint.Read, want)
+	}
+	if want := []string{"enabled: true", "keyHashAlgo: sha256"}; !reflect.DeepEqual(lint.Defaults, want) {
+		t.Errorf("Defaults = %q, want %q", lint.Defaults, want)
+	}
+	if len(lint.Ignored) != 0 {
+		t.Errorf("Ignored = %q, want none", lint.Ignored)
+	}
+}
+
+func TestLintAPIKeys_Malformed(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		wantSkipped error
+		wantProblem string
+		wantIgnored string
+	}{
+		{
+			name:        "no spec",
+			yaml:        "apiVersion: auth.kgateway.dev/v1alpha1\nkind: APIKey\nmetadata:\n  name: a\n",
+			wantSkipped: ErrNoSpec,
+		},
+		{
+			name:        "empty keyHash",
+			yaml:        "apiVersion: auth.kgateway.dev/v1alpha1\nkind: APIKey\nmetadata:\n  name: a\nspec:\n  email: a@example.com\n  keyHint: sk-abc*************de\n",
+			wantSkipped: ErrEmptyKeyHash,
+			wantProblem: "lacks keyHash",
+		},
+		{
+			name:        "wrong apiVersion",
+			yaml:        strings.Replace(validAPIKeyYAML, "v1alpha1", "v1beta1", 1),
+			wantSkipped: errors.New(`apiVersion "auth.kgateway.dev/v1beta1" is not watched, expected auth.kgateway.dev/v1alpha1`),
+		},
+		{
+			name:        "missing hint",
+			yaml:        strings.Replace(validAPIKeyYAML, "  keyHint: sk-abc*************de\n", "", 1),
+			wantProblem: "lacks keyHint, which the CRD requires",
+		},
+		{
+			name:        "enabled as a string",
+			yaml:        validAPIKeyYAML + "  enabled: \"false\"\n",
+			wantProblem: "enabled has the wrong type and is ignored",
+			wantIgnored: "enabled: ",
+		},
+		{
+			name:        "unknown field",
+			yaml:        validAPIKeyYAML + "  owner: data-team\n",
+			wantIgnored: "owner: unknown field",
+		},
+		{
+			name:        "upper-case hash",
+			yaml:        strings.Replace(validAPIKeyYAML, "aaaa", "AAAA", 1),
+			wantSkipped: ErrMalformedKeyHash,
+			wantProblem: "is not a hex encoded SHA-256 hash",
+		},
+		{
+			name:        "truncated hash",
+			yaml:        strings.Replace(validAPIKeyYAML, "aaaa\n", "\n", 1),
+			wantSkipped: ErrMalformedKeyHash,
+		},
+		{
+			name:        "malformed hash in a family",
+			yaml:        validAPIKeyYAML + "  keyHashes:\n    - not-a-hash\n",
+			wantProblem: `hash "not-a-hash" is not a hex encoded SHA-256 hash`,
+		},
+		{
+			name:        "malformed hint",
+			yaml:        strings.Replace(validAPIKeyYAML, "sk-abc*************de", "sk-abcdef", 1),
+			wantProblem: "has a malformed keyHint",
+		},
+		{
+			name:        "invalid window",
+			yaml:        validAPIKeyYAML + "  activeWindows:\n    - start: \"25:00\"\n      end: \"18:00\"\n",
+			wantProblem: "has an invalid active window 0, never active",
+		},
+		{
+			name:        "unknown hash algorithm",
+			yaml:        validAPIKeyYAML + "  keyHashAlgo: bcrypt\n",
+			wantProblem: `never validates: unknown keyHashAlgo "bcrypt"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lints, err := LintAPIKeys([]byte(tt.yaml))
+			if err != nil {
+				t.Fatalf("LintAPIKeys() error = %v", err)
+			}
+			lint := lints[0]
+			if lint.OK() {
+				if tt.wantSkipped != nil || tt.wantProblem != "" {
+					t.Fatalf("LintAPIKeys() = %+v, want it flagged", lint)
+				}
+			}
+			if tt.wantSkipped != nil && (lint.Skipped == nil || lint.Skipped.Error() != tt.wantSkipped.Error()) {
+				t.Errorf("Skipped = %v, want %v", lint.Skipped, tt.wantSkipped)
+			}
+			if tt.wantProblem != "" && !strings.Contains(strings.Join(lint.Problems, "\n"), tt.wantProblem) {
+				t.Errorf("Problems = %q, want one containing %q", lint.Problems, tt.wantProblem)
+			}
+			if tt.wantIgnored != "" && !strings.Contains(strings.Join(lint.Ignored, "\n"), tt.wantIgnored) {
+				t.Errorf("Ignored = %q, want one containing %q", lint.Ignored, tt.wantIgnored)
+			}
+		})
+	}
+}
+
+func TestCheckAPIKey_KeyHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		algo    string
+		keyHash string
+		wantErr error
+	}{
+		{"SHA-256", "", strings.Repeat("0123456789abcdef", 4), nil},
+		{"Wrong length", "", strings.Repeat("a", 63), ErrMalformedKeyHash},
+		{"Too long", "", strings.Repeat("a", 65), ErrMalformedKeyHash},
+		{"Non-hex", "", strings.Repeat("g", 64), ErrMalformedKeyHash},
+		{"Upper-case hex", "sha256", strings.Repeat("A", 64), ErrMalformedKeyHash},
+		{"Empty", "", "", ErrEmptyKeyHash},
+		// Served with a problem: argon2id hashes are not hex
+		{"Argon2id", "argon2id", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$aGFzaA", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "a"},
+				"spec":     map[string]interface{}{"keyHash": tt.keyHash, "keyHashAlgo": tt.algo},
+			}}
+			entry, _, err := CheckAPIKey(obj)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckAPIKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if (entry != nil) != (tt.wantErr == nil) {
+				t.Errorf("CheckAPIKey() entry = %+v, want one only when served", entry)
+			}
+		})
+	}
+}
+
+func TestLintAPIKeys_InvalidInput(t *testing.T) {
+	for _, data := range []string{"", "apiVersion: v1\nkind: Secret\n", "spec: [unclosed"} {
+		if _, err := LintAPIKeys([]byte(data)); err == nil {
+			t.Errorf("LintAPIKeys(%q): expected an error", data)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package kube
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ServerName names the server's Kubernetes objects and labels its Pods
+const ServerName = "apikey-manager-server"
+
+// ManifestOptions configures the objects deploying the server
+type ManifestOptions struct {
+	// Namespace the server is deployed to
+	Namespace string
+	// Image of the server container
+	Image string
+	// Replicas is the number of server Pods
+	Replicas int
+	// GRPCPort and HTTPPort are the ports the server listens on
+	GRPCPort int
+	HTTPPort int
+	// LogLevel is passed to the server
+	LogLevel string
+}
+
+// Manifests returns the YAML of the objects deploying the server: its
+// ServiceAccount, a ClusterRole allowing it to get, list and watch APIKeys
+// in every namespace and nothing else, the binding between them, a
+// Deployment and a Service exposing both ports
+func Manifests(opts ManifestOptions) (string, error) {
+	if opts.Namespace == "" || opts.Image == "" {
+		return "", fmt.Errorf("namespace and image are required")
+	}
+	if opts.Replicas < 1 {
+		return "", fmt.Errorf("replicas %d is below 1", opts.Replicas)
+	}
+
+	labels := map[string]interface{}{"app": ServerName}
+	metadata := map[string]interface{}{"name": ServerName, "namespace": opts.Namespace, "labels": labels}
+	objects := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   metadata,
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRole",
+			"metadata":   map[string]interface{}{"name": ServerName, "labels": labels},
+			"rules": []interface{}{map[string]interface{}{
+				"apiGroups": []interface{}{APIKeyGVR.Group},
+				"resources": []interface{}{APIKeyGVR.Resource},
+				"verbs":     []interface{}{"get", "list", "watch"},
+			}},
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   map[string]interface{}{"name": ServerName, "labels": labels},
+			"roleRef": map[string]interface{}{
+				"apiGroup": "rbac.authorization.k8s.io",
+				"kind":     "ClusterRole",
+				"name":     ServerName,
+			},
+			"subjects": []interface{}{map[string]interface{}{
+				"kind":      "ServiceAccount",
+				"name":      ServerName,
+				"namespace": opts.Namespace,
+			}},
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"replicas": opts.Replicas,
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": labels},
+					"spec":     podSpec(opts),
+				},
+			},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"type":     "ClusterIP",
+				"selector": labels,
+				"ports": []interface{}{
+					map[string]interface{}{"name": "grpc", "port": opts.GRPCPort, "targetPort": "grpc", "protocol": "TCP", "appProtocol": "grpc"},
+					map[string]interface{}{"name": "http", "port": opts.HTTPPort, "targetPort": "http", "protocol": "TCP"},
+				},
+			},
+		},
+	}
+
+	var out strings.Builder
+	for _, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal %s: %w", object["kind"], err)
+		}
+		out.WriteString("---\n")
+		out.Write(data)
+	}
+	return out.String(), nil
+}
+
+// podSpec returns the spec of the server's Pods, hardened like
+// deploy/apikey-manager-server.yaml
+func podSpec(opts ManifestOptions) map[string]interface{} {
+	fieldEnv := func(name, path string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "valueFrom": map[string]interface{}{"fieldRef": map[string]interface{}{"fieldPath": path}}}
+	}
+	probe := func(path string, period int) map[string]interface{} {
+		return map[string]interface{}{
+			"httpGet":             map[string]interface{}{"path": path, "port": "http"},
+			"initialDelaySeconds": 5,
+			"periodSeconds":       period,
+		}
+	}
+
+	return map[string]interface{}{
+		"serviceAccountName": ServerName,
+		"securityContext":    map[string]interface{}{"fsGroup": 65534},
+		"containers": []interface{}{map[string]interface{}{
+			"name":  "server",
+			"image": opts.Image,
+			"args": []interface{}{
+				"--grpc-port=" + strconv.Itoa(opts.GRPCPort),
+				"--http-port=" + strconv.Itoa(opts.HTTPPort),
+				"--log-level=" + opts.LogLevel,
+			},
+			"ports": []interface{}{
+				map[string]interface{}{"name": "grpc", "containerPort": opts.GRPCPort, "protocol": "TCP"},
+				map[string]interface{}{"name": "http", "containerPort": opts.HTTPPort, "protocol": "TCP"},
+			},
+			// Events, if enabled, are attached to the server's Pod
+			"env": []interface{}{
+				fieldEnv("POD_NAME", "metadata.name"),
+				fieldEnv("POD_NAMESPACE", "metadata.namespace"),
+			},
+			"livenessProbe":  probe("/livez", 10),
+			"readinessProbe": probe("/ready", 5),
+			"resources": map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "100m", "memory": "128Mi"},
+				"limits":   map[string]interface{}{"cpu": "500m", "memory": "256Mi"},
+			},
+			"securityContext": map[string]interface{}{
+				"runAsNonRoot":             true,
+				"runAsUser":                65534,
+				"readOnlyRootFilesystem":   true,
+				"allowPrivilegeEscalation": false,
+				"capabilities":             map[string]interface{}{"drop": []interface{}{"ALL"}},
+			},
+		}},
+	}
+}
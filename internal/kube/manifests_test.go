@@ -0,0 +1,92 @@
+package kube
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func TestManifests(t *testing.T) {
+	out, err := Manifests(ManifestOptions{Namespace: "auth", Image: "ghcr.io/efortin/batsign:v1.2.3", Replicas: 3, GRPCPort: 9000, HTTPPort: 9001, LogLevel: "warn"})
+	if err != nil {
+		t.Fatalf("Manifests() error = %v", err)
+	}
+
+	objects := map[string]*unstructured.Unstructured{}
+	var kinds []string
+	for _, doc := range strings.Split(out, "---\n")[1:] {
+		var object unstructured.Unstructured
+		data, err := yaml.YAMLToJSON([]byte(doc))
+		if err == nil {
+			err = object.UnmarshalJSON(data)
+		}
+		if err != nil {
+			t.Fatalf("invalid YAML: %v\n%s", err, doc)
+		}
+		if object.GetName() != ServerName {
+			t.Errorf("%s name = %q, want %q", object.GetKind(), object.GetName(), ServerName)
+		}
+		kinds = append(kinds, object.GetKind())
+		objects[object.GetKind()] = &object
+	}
+	if want := []string{"ServiceAccount", "ClusterRole", "ClusterRoleBinding", "Deployment", "Service"}; !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("kinds = %v, want %v", kinds, want)
+	}
+
+	for _, kind := range []string{"ServiceAccount", "Deployment", "Service"} {
+		if namespace := objects[kind].GetNamespace(); namespace != "auth" {
+			t.Errorf("%s namespace = %q, want auth", kind, namespace)
+		}
+	}
+	subjects, _, _ := unstructured.NestedSlice(objects["ClusterRoleBinding"].Object, "subjects")
+	if namespace := subjects[0].(map[string]interface{})["namespace"]; namespace != "auth" {
+		t.Errorf("bound service account namespace = %v, want auth", namespace)
+	}
+
+	rules, _, _ := unstructured.NestedSlice(objects["ClusterRole"].Object, "rules")
+	wantRules := []interface{}{map[string]interface{}{
+		"apiGroups": []interface{}{"auth.kgateway.dev"},
+		"resources": []interface{}{"apikeys"},
+		"verbs":     []interface{}{"get", "list", "watch"},
+	}}
+	if !reflect.DeepEqual(rules, wantRules) {
+		t.Errorf("rules = %v, want get, list and watch on apikeys only", rules)
+	}
+
+	containers, _, _ := unstructured.NestedSlice(objects["Deployment"].Object, "spec", "template", "spec", "containers")
+	container := containers[0].(map[string]interface{})
+	if container["image"] != "ghcr.io/efortin/batsign:v1.2.3" {
+		t.Errorf("image = %v", container["image"])
+	}
+	if args := container["args"]; !reflect.DeepEqual(args, []interface{}{"--grpc-port=9000", "--http-port=9001", "--log-level=warn"}) {
+		t.Errorf("args = %v", args)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(objects["Deployment"].Object, "spec", "replicas"); replicas != 3 {
+		t.Errorf("replicas = %d, want 3", replicas)
+	}
+	ports, _, _ := unstructured.NestedSlice(objects["Service"].Object, "spec", "ports")
+	if len(ports) != 2 || ports[0].(map[string]interface{})["port"] != int64(9000) || ports[1].(map[string]interface{})["port"] != int64(9001) {
+		t.Errorf("service ports = %v, want 9000 and 9001", ports)
+	}
+}
+
+func TestManifestsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ManifestOptions
+	}{
+		{name: "no namespace", opts: ManifestOptions{Image: "batsign", Replicas: 1}},
+		{name: "no image", opts: ManifestOptions{Namespace: "auth", Replicas: 1}},
+		{name: "no replica", opts: ManifestOptions{Namespace: "auth", Image: "batsign"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Manifests(tt.opts); err == nil {
+				t.Error("Manifests() succeeded, want an error")
+			}
+		})
+	}
+}
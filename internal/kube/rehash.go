@@ -0,0 +1,131 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// RehashAPIKeys moves the APIKeys of raw keys to another hash algorithm. It
+// lists the APIKeys of namespace (empty = all namespaces), matches each key
+// to the resources whose keyHash was computed from it with from, and returns
+// them with keyHash recomputed with to and keyHashAlgo set to it. Their
+// labels and every other spec field, enabled included, are kept as they
+// are, so applying them changes nothing but the hash. Keys matching no
+// resource, or one of another email, are reported with their line, and
+// nothing is returned unless every key matched.
+func RehashAPIKeys(ctx context.Context, client dynamic.Interface, namespace string, keys []apikey.RawKey, from, to string) ([]*unstructured.Unstructured, error) {
+	for _, algo := range []string{from, to} {
+		if err := apikey.ValidateHashAlgo(algo); err != nil {
+			return nil, err
+		}
+	}
+
+	var resource dynamic.ResourceInterface = client.Resource(APIKeyGVR)
+	if namespace != "" {
+		resource = client.Resource(APIKeyGVR).Namespace(namespace)
+	}
+	list, err := resource.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list APIKeys: %w", err)
+	}
+
+	// Only resources hashed with from can match. SHA-256 hashes are looked
+	// up; salted argon2id ones are verified against the keys of their hint.
+	var (
+		entries  = make(map[*unstructured.Unstructured]*models.APIKeyEntry)
+		byHash   = make(map[string][]*unstructured.Unstructured)
+		byHint   = make(map[string][]*unstructured.Unstructured)
+		rehashed []*unstructured.Unstructured
+		errs     []error
+	)
+	for i := range list.Items {
+		obj := &list.Items[i]
+		entry := ParseAPIKey(obj)
+		// keyHash itself, not a hash moved up from keyHashes
+		keyHash, _, _ := unstructured.NestedString(obj.Object, "spec", "keyHash")
+		if entry == nil || keyHash == "" || hashAlgo(entry.KeyHashAlgo) != from {
+			continue
+		}
+		entries[obj] = entry
+		if from == models.HashAlgoSHA256 {
+			byHash[keyHash] = append(byHash[keyHash], obj)
+		} else {
+			byHint[entry.KeyHint] = append(byHint[entry.KeyHint], obj)
+		}
+	}
+
+	for _, key := range keys {
+		var matches []*unstructured.Unstructured
+		if from == models.HashAlgoSHA256 {
+			matches = byHash[apikey.HashAPIKey(key.Key)]
+		} else {
+			for _, obj := range byHint[apikey.GenerateHint(key.Key)] {
+				if ok, err := apikey.VerifyKeyHash(key.Key, from, entries[obj].KeyHash); err == nil && ok {
+					matches = append(matches, obj)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			errs = append(errs, fmt.Errorf("line %d: no APIKey holds the %s hash of the key", key.Line, from))
+			continue
+		}
+
+		for _, obj := range matches {
+			if email := entries[obj].Email; !strings.EqualFold(apikey.NormalizeEmail(email), apikey.NormalizeEmail(key.Email)) {
+				errs = append(errs, fmt.Errorf("line %d: the key belongs to APIKey %s/%s of %s, not %s", key.Line, obj.GetNamespace(), obj.GetName(), email, key.Email))
+				continue
+			}
+			out, err := rehashedAPIKey(obj, key.Key, to)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", key.Line, err))
+				continue
+			}
+			rehashed = append(rehashed, out)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return rehashed, nil
+}
+
+// rehashedAPIKey returns a copy of an APIKey resource with its key hashed
+// with algo, keeping only the metadata to apply it: name, namespace and
+// labels
+func rehashedAPIKey(obj *unstructured.Unstructured, key, algo string) (*unstructured.Unstructured, error) {
+	keyHash, err := apikey.HashAPIKeyWithAlgo(key, algo)
+	if err != nil {
+		return nil, err
+	}
+	spec, _, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	// Set even for SHA-256, so applying the spec replaces the previous algorithm
+	spec["keyHash"], spec["keyHashAlgo"] = keyHash, algo
+
+	out := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	out.SetAPIVersion(obj.GetAPIVersion())
+	out.SetKind(obj.GetKind())
+	out.SetName(obj.GetName())
+	out.SetNamespace(obj.GetNamespace())
+	out.SetLabels(obj.GetLabels())
+	return out, nil
+}
+
+// hashAlgo returns the algorithm of a keyHashAlgo value (empty = SHA-256)
+func hashAlgo(algo string) string {
+	if algo == "" {
+		return models.HashAlgoSHA256
+	}
+	return algo
+}
@@ -0,0 +1,146 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRehashAPIKeys(t *testing.T) {
+	const (
+		aliceKey = "sk-rehash-alice"
+		acmeKey  = "sk-rehash-acme"
+		vaultKey = "sk-rehash-vault"
+	)
+	vaultHash, err := apikey.HashAPIKeyArgon2id(vaultKey)
+	if err != nil {
+		t.Fatalf("HashAPIKeyArgon2id() error = %v", err)
+	}
+
+	alice := newAPIKeyObject("team-a", "alice", map[string]interface{}{
+		"email":          "alice@example.com",
+		"keyHash":        apikey.HashAPIKey(aliceKey),
+		"keyHint":        apikey.GenerateHint(aliceKey),
+		"keyHashes":      []interface{}{apikey.HashAPIKey("sk-rehash-alice-us")},
+		"description":    "Billing",
+		"enabled":        false,
+		"allowedMethods": []interface{}{"GET"},
+		"activeWindows":  []interface{}{map[string]interface{}{"start": "09:00", "end": "18:00"}},
+		"notes":          "Rotated yearly",
+	})
+	alice.SetLabels(map[string]string{"batsign.io/tenant": "billing"})
+	alice.SetResourceVersion("42")
+	client := newFakeClient(t,
+		alice,
+		// Tenant keys are not named after their email
+		newAPIKeyObject("team-b", "acme-0", map[string]interface{}{
+			"email":   "ops@acme.io",
+			"keyHash": apikey.HashAPIKey(acmeKey),
+			"keyHint": apikey.GenerateHint(acmeKey),
+		}),
+		newAPIKeyObject("team-b", "vault", map[string]interface{}{
+			"email":       "vault@example.com",
+			"keyHash":     vaultHash,
+			"keyHashAlgo": models.HashAlgoArgon2id,
+			"keyHint":     apikey.GenerateHint(vaultKey),
+		}),
+	)
+	rehash := func(namespace, from, to string, keys ...apikey.RawKey) ([]*unstructured.Unstructured, error) {
+		return RehashAPIKeys(context.Background(), client, namespace, keys, from, to)
+	}
+
+	t.Run("sha256 to argon2id", func(t *testing.T) {
+		got, err := rehash("", models.HashAlgoSHA256, models.HashAlgoArgon2id,
+			apikey.RawKey{Line: 1, Email: "alice@example.com", Key: aliceKey},
+			apikey.RawKey{Line: 2, Email: "OPS@acme.io", Key: acmeKey},
+		)
+		if err != nil {
+			t.Fatalf("RehashAPIKeys() error = %v", err)
+		}
+		if len(got) != 2 || got[0].GetName() != "alice" || got[1].GetNamespace() != "team-b" || got[1].GetName() != "acme-0" {
+			t.Fatalf("RehashAPIKeys() = %v, want team-a/alice and team-b/acme-0", got)
+		}
+
+		for i, key := range []string{aliceKey, acmeKey} {
+			keyHash, _, _ := unstructured.NestedString(got[i].Object, "spec", "keyHash")
+			if ok, err := apikey.VerifyArgon2id(key, keyHash); err != nil || !ok {
+				t.Errorf("resource %d hash does not verify its key: %v", i, err)
+			}
+			if algo, _, _ := unstructured.NestedString(got[i].Object, "spec", "keyHashAlgo"); algo != models.HashAlgoArgon2id {
+				t.Errorf("resource %d keyHashAlgo = %q, want argon2id", i, algo)
+			}
+		}
+
+		// Everything but the hash is kept, and only the metadata to apply it
+		wantSpec, _, _ := unstructured.NestedMap(alice.Object, "spec")
+		gotSpec, _, _ := unstructured.NestedMap(got[0].Object, "spec")
+		delete(wantSpec, "keyHash")
+		delete(gotSpec, "keyHash")
+		delete(gotSpec, "keyHashAlgo")
+		if !reflect.DeepEqual(gotSpec, wantSpec) {
+			t.Errorf("RehashAPIKeys() spec = %v, want %v", gotSpec, wantSpec)
+		}
+		if got[0].GetAPIVersion() != alice.GetAPIVersion() || got[0].GetKind() != "APIKey" {
+			t.Errorf("RehashAPIKeys() type = %s %s", got[0].GetAPIVersion(), got[0].GetKind())
+		}
+		if !reflect.DeepEqual(got[0].GetLabels(), alice.GetLabels()) || got[0].GetResourceVersion() != "" {
+			t.Errorf("RehashAPIKeys() metadata = %v", got[0].Object["metadata"])
+		}
+	})
+
+	t.Run("argon2id to sha256", func(t *testing.T) {
+		got, err := rehash("team-b", models.HashAlgoArgon2id, models.HashAlgoSHA256, apikey.RawKey{Line: 1, Email: "vault@example.com", Key: vaultKey})
+		if err != nil {
+			t.Fatalf("RehashAPIKeys() error = %v", err)
+		}
+		if len(got) != 1 || got[0].GetName() != "vault" {
+			t.Fatalf("RehashAPIKeys() = %v, want team-b/vault", got)
+		}
+		spec, _, _ := unstructured.NestedStringMap(got[0].Object, "spec")
+		if spec["keyHash"] != apikey.HashAPIKey(vaultKey) || spec["keyHashAlgo"] != models.HashAlgoSHA256 {
+			t.Errorf("RehashAPIKeys() spec = %v, want the SHA-256 hash of the key", spec)
+		}
+	})
+
+	t.Run("unmatched keys", func(t *testing.T) {
+		got, err := rehash("", models.HashAlgoSHA256, models.HashAlgoArgon2id,
+			apikey.RawKey{Line: 1, Email: "alice@example.com", Key: aliceKey},
+			apikey.RawKey{Line: 2, Email: "nobody@example.com", Key: "sk-rehash-unknown"},
+			apikey.RawKey{Line: 3, Email: "bob@example.com", Key: acmeKey},
+			// Hashed with argon2id, not --from
+			apikey.RawKey{Line: 4, Email: "vault@example.com", Key: vaultKey},
+		)
+		if got != nil {
+			t.Errorf("RehashAPIKeys() returned %d resources along with an error", len(got))
+		}
+		wantErrs := []string{
+			"line 2: no APIKey holds the sha256 hash of the key",
+			"line 3: the key belongs to APIKey team-b/acme-0 of ops@acme.io, not bob@example.com",
+			"line 4: no APIKey holds the sha256 hash of the key",
+		}
+		if err == nil {
+			t.Fatalf("RehashAPIKeys() error = nil, want %v", wantErrs)
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if !reflect.DeepEqual(lines, wantErrs) {
+			t.Errorf("RehashAPIKeys() errors = %q, want %q", lines, wantErrs)
+		}
+	})
+
+	t.Run("other namespace", func(t *testing.T) {
+		if _, err := rehash("team-b", models.HashAlgoSHA256, models.HashAlgoArgon2id, apikey.RawKey{Line: 1, Email: "alice@example.com", Key: aliceKey}); err == nil {
+			t.Error("RehashAPIKeys() error = nil, want the key of another namespace unmatched")
+		}
+	})
+
+	t.Run("unknown algorithm", func(t *testing.T) {
+		if _, err := rehash("", models.HashAlgoSHA256, "sha512"); err == nil || !strings.Contains(err.Error(), `unknown key hash algorithm "sha512"`) {
+			t.Errorf("RehashAPIKeys() error = %v, want an unknown algorithm error", err)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/efortin/batsign/internal/apikey"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// disablePatch is the merge patch disabling an APIKey
+var disablePatch = []byte(`{"spec":{"enabled":false}}`)
+
+// RevokeFilter selects the APIKeys to disable. At least one field must be
+// set, so a mistyped command never disables every key.
+type RevokeFilter struct {
+	// EmailDomain matches the keys owned by an email of this domain, not
+	// of its subdomains, case-insensitively (empty = any)
+	EmailDomain string
+	// LabelSelector matches the resources by label, e.g.
+	// batsign.io/tenant=acme (empty = any)
+	LabelSelector string
+}
+
+// Revocation is an APIKey matched by RevokeAPIKeys
+type Revocation struct {
+	// Name is the namespace/name of the resource
+	Name  string
+	Email string
+	// AlreadyDisabled is set for keys that were disabled before and left
+	// untouched
+	AlreadyDisabled bool
+	// Err is why the key could not be disabled
+	Err error
+}
+
+// RevokeAPIKeys disables the APIKeys of namespace (empty = all namespaces)
+// matching filter, sorted by name, by patching spec.enabled to false. With
+// dryRun, the matching keys are only reported. A key that fails to be
+// disabled does not stop the others; its error is recorded.
+func RevokeAPIKeys(ctx context.Context, client dynamic.Interface, namespace string, filter RevokeFilter, dryRun bool) ([]Revocation, error) {
+	if filter.EmailDomain == "" && filter.LabelSelector == "" {
+		return nil, errors.New("an email domain or a label selector is required")
+	}
+	// Internationalized domains match in their punycode form, as stored
+	domain := strings.ToLower(domainOf(apikey.NormalizeEmail("@" + strings.TrimPrefix(filter.EmailDomain, "@"))))
+
+	var resource dynamic.ResourceInterface = client.Resource(APIKeyGVR)
+	if namespace != "" {
+		resource = client.Resource(APIKeyGVR).Namespace(namespace)
+	}
+	list, err := resource.List(ctx, metav1.ListOptions{LabelSelector: filter.LabelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list APIKeys: %w", err)
+	}
+
+	var revocations []Revocation
+	for i := range list.Items {
+		obj := &list.Items[i]
+		entry := ParseAPIKey(obj)
+		if entry == nil {
+			continue
+		}
+		if domain != "" && strings.ToLower(domainOf(apikey.NormalizeEmail(entry.Email))) != domain {
+			continue
+		}
+
+		revocation := Revocation{Name: obj.GetNamespace() + "/" + obj.GetName(), Email: entry.Email, AlreadyDisabled: !entry.Enabled}
+		if entry.Enabled && !dryRun {
+			_, err := client.Resource(APIKeyGVR).Namespace(obj.GetNamespace()).Patch(ctx, obj.GetName(), types.MergePatchType, disablePatch, metav1.PatchOptions{})
+			if err != nil {
+				revocation.Err = err
+			}
+		}
+		revocations = append(revocations, revocation)
+	}
+
+	slices.SortFunc(revocations, func(a, b Revocation) int { return strings.Compare(a.Name, b.Name) })
+	return revocations, nil
+}
+
+// domainOf returns the domain of an email, "" when it has none
+func domainOf(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return email[at+1:]
+}
@@ -0,0 +1,119 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newRevokeClient returns a fake client serving keys of several domains,
+// one of them labelled with a tenant
+func newRevokeClient(t *testing.T) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	tenant := newAPIKeyObject("team-a", "carol", map[string]interface{}{"email": "carol@Contractor.example", "keyHash": "cccc", "enabled": true})
+	tenant.SetLabels(map[string]string{"batsign.io/tenant": "acme"})
+	return newFakeClient(t,
+		newAPIKeyObject("team-a", "alice", map[string]interface{}{"email": "alice@example.com", "keyHash": "aaaa"}),
+		tenant,
+		newAPIKeyObject("team-b", "dave", map[string]interface{}{"email": "dave@contractor.example", "keyHash": "dddd"}),
+		newAPIKeyObject("team-b", "erin", map[string]interface{}{"email": "erin@contractor.example", "keyHash": "eeee", "enabled": false}),
+		newAPIKeyObject("team-b", "frank", map[string]interface{}{"email": "frank@sub.contractor.example", "keyHash": "ffff"}),
+	)
+}
+
+// enabledKeys returns the names of the enabled keys of client
+func enabledKeys(t *testing.T, client *dynamicfake.FakeDynamicClient) []string {
+	t.Helper()
+	entries, err := ListAPIKeys(context.Background(), client, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range FilterEnabled(entries, true) {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func TestRevokeAPIKeys(t *testing.T) {
+	client := newRevokeClient(t)
+
+	revocations, err := RevokeAPIKeys(context.Background(), client, "", RevokeFilter{EmailDomain: "@CONTRACTOR.example"}, false)
+	if err != nil {
+		t.Fatalf("RevokeAPIKeys() error = %v", err)
+	}
+	want := []Revocation{
+		{Name: "team-a/carol", Email: "carol@Contractor.example"},
+		{Name: "team-b/dave", Email: "dave@contractor.example"},
+		{Name: "team-b/erin", Email: "erin@contractor.example", AlreadyDisabled: true},
+	}
+	if !reflect.DeepEqual(revocations, want) {
+		t.Errorf("RevokeAPIKeys() = %+v, want %+v", revocations, want)
+	}
+	if got := enabledKeys(t, client); !reflect.DeepEqual(got, []string{"alice", "frank"}) {
+		t.Errorf("enabled keys = %v, want alice and frank", got)
+	}
+}
+
+func TestRevokeAPIKeys_Filters(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		filter    RevokeFilter
+		want      []string
+	}{
+		{name: "label", filter: RevokeFilter{LabelSelector: "batsign.io/tenant=acme"}, want: []string{"team-a/carol"}},
+		{name: "label and domain", filter: RevokeFilter{LabelSelector: "batsign.io/tenant=acme", EmailDomain: "example.com"}},
+		{name: "namespace", namespace: "team-b", filter: RevokeFilter{EmailDomain: "contractor.example"}, want: []string{"team-b/dave", "team-b/erin"}},
+		{name: "subdomain", filter: RevokeFilter{EmailDomain: "sub.contractor.example"}, want: []string{"team-b/frank"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newRevokeClient(t)
+			revocations, err := RevokeAPIKeys(context.Background(), client, tt.namespace, tt.filter, true)
+			if err != nil {
+				t.Fatalf("RevokeAPIKeys() error = %v", err)
+			}
+			var names []string
+			for _, revocation := range revocations {
+				names = append(names, revocation.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("RevokeAPIKeys() matched %v, want %v", names, tt.want)
+			}
+			// Dry runs change nothing
+			if got := enabledKeys(t, client); len(got) != 4 {
+				t.Errorf("enabled keys = %v, want all 4", got)
+			}
+		})
+	}
+}
+
+func TestRevokeAPIKeys_Errors(t *testing.T) {
+	client := newRevokeClient(t)
+	if _, err := RevokeAPIKeys(context.Background(), client, "", RevokeFilter{}, false); err == nil {
+		t.Error("RevokeAPIKeys() without a filter succeeded, want an error")
+	}
+
+	client.PrependReactor("patch", "apikeys", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.PatchAction).GetName() == "carol" {
+			return true, nil, errors.New("forbidden")
+		}
+		return false, nil, nil
+	})
+	revocations, err := RevokeAPIKeys(context.Background(), client, "", RevokeFilter{EmailDomain: "contractor.example"}, false)
+	if err != nil {
+		t.Fatalf("RevokeAPIKeys() error = %v", err)
+	}
+	if revocations[0].Err == nil || revocations[1].Err != nil {
+		t.Errorf("errors = %v, %v, want carol's only", revocations[0].Err, revocations[1].Err)
+	}
+	if got := enabledKeys(t, client); !reflect.DeepEqual(got, []string{"alice", "carol", "frank"}) {
+		t.Errorf("enabled keys = %v, want dave disabled despite carol's failure", got)
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"strings"
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -18,10 +21,47 @@ type APIKeySpec struct {
 	Email       string `json:"email"`
 	KeyHash     string `json:"keyHash"`
 	KeyHint     string `json:"keyHint"`
-	Description string `json:"description"`
-	Enabled     bool   `json:"enabled"`
+	Description string `json:"description,omitempty"`
+	// Enabled is never omitted: the CRD defaults it to true
+	Enabled bool `json:"enabled"`
+	// AllowedMethods restricts the HTTP methods the key may be used with
+	// (empty = all methods)
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	// Notes is free text for humans, unlike Description which may feed
+	// dashboards. It never affects validation.
+	Notes string `json:"notes,omitempty"`
+	// ActiveWindows restricts when the key may be used, e.g. business hours
+	// (empty = always)
+	ActiveWindows []ActiveWindow `json:"activeWindows,omitempty"`
+	// KeyHashes lists further keys sharing this identity, e.g. one per
+	// region; each validates like KeyHash
+	KeyHashes []string `json:"keyHashes,omitempty"`
+	// KeyHashAlgo is the algorithm of KeyHash (empty = HashAlgoSHA256)
+	KeyHashAlgo string `json:"keyHashAlgo,omitempty"`
 }
 
+// Key hash algorithms of APIKeySpec.KeyHashAlgo
+const (
+	// HashAlgoSHA256 is a hex encoded SHA-256 hash, looked up directly
+	HashAlgoSHA256 = "sha256"
+	// HashAlgoArgon2id is an argon2id hash in the PHC string format,
+	// verified by re-hashing the presented key, for high-value keys whose
+	// hash must resist brute force if leaked
+	HashAlgoArgon2id = "argon2id"
+)
+
+// Sources an APIKeyEntry can be loaded from
+const (
+	// SourceAPIKey marks entries loaded from APIKey custom resources
+	SourceAPIKey = "APIKey"
+	// SourceSecret marks entries loaded from labeled Kubernetes Secrets
+	SourceSecret = "Secret"
+	// SourceFile marks entries loaded from a local keys file
+	SourceFile = "File"
+	// SourceExternal marks entries allowed by the external validator
+	SourceExternal = "External"
+)
+
 // APIKeyEntry holds metadata about an API key in memory
 type APIKeyEntry struct {
 	Name        string
@@ -30,4 +70,59 @@ type APIKeyEntry struct {
 	KeyHint     string
 	Description string
 	Enabled     bool
+	Source      string
+	// Namespace is the namespace of the resource the entry was loaded from
+	// (empty = not loaded from Kubernetes)
+	Namespace string
+	// AllowedMethods restricts the HTTP methods the key may be used with
+	// (empty = all methods)
+	AllowedMethods []string
+	// Notes is free text for humans, never used for validation
+	Notes string
+	// ActiveWindows restricts when the key may be used (empty = always)
+	ActiveWindows []ActiveWindow
+	// KeyHashes holds the other hashes of a key family, each validating to
+	// this entry; it never repeats KeyHash
+	KeyHashes []string
+	// KeyHashAlgo is the algorithm of KeyHash (empty = HashAlgoSHA256)
+	KeyHashAlgo string
+	// KeyID is a short ID derived from KeyHash (see apikey.KeyID), logged
+	// and forwarded upstream instead of the hash
+	KeyID string
+	// LoadedAt is when the server cached this version of the entry (zero =
+	// not tracked by the store)
+	LoadedAt time.Time
+}
+
+// Hashes returns every hash validating to the entry, KeyHash first
+func (e *APIKeyEntry) Hashes() []string {
+	return append([]string{e.KeyHash}, e.KeyHashes...)
+}
+
+// AllowsMethod reports whether the key may be used with the HTTP method
+func (e *APIKeyEntry) AllowsMethod(method string) bool {
+	if len(e.AllowedMethods) == 0 {
+		return true
+	}
+	for _, allowed := range e.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveAt reports whether the key may be used at t, i.e. it has no active
+// windows or t falls within one of them. Daily windows are evaluated in t's
+// location.
+func (e *APIKeyEntry) ActiveAt(t time.Time) bool {
+	if len(e.ActiveWindows) == 0 {
+		return true
+	}
+	for _, window := range e.ActiveWindows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
 }
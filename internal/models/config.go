@@ -1,5 +1,60 @@
 package models
 
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxAllowCacheTTL bounds how long a revoked key may keep working because
+// its allow decision was cached
+const MaxAllowCacheTTL = 5 * time.Minute
+
+// DefaultMaxAPIKeyLength is the longest API key header value accepted when
+// MaxAPIKeyLength is not set
+const DefaultMaxAPIKeyLength = 4096
+
+// DefaultPerKeyMetricsMaxKeys is the number of keys labelled by per-key
+// metrics when PerKeyMetricsMaxKeys is not set
+const DefaultPerKeyMetricsMaxKeys = 100
+
+// DefaultWatchBreakerCooldown is how long the watch circuit breaker stays
+// open when WatchBreakerCooldown is not set
+const DefaultWatchBreakerCooldown = 30 * time.Second
+
+// DefaultWatchLivenessTimeout is how long a watch goroutine may go without
+// running before /livez fails when WatchLivenessTimeout is not set
+const DefaultWatchLivenessTimeout = 2 * time.Minute
+
+// MinWatchLivenessTimeout is the shortest WatchLivenessTimeout accepted;
+// running watches record a heartbeat every 10 seconds
+const MinWatchLivenessTimeout = 30 * time.Second
+
+// DefaultDenyRedirectStatus is the status of deny redirects when
+// DenyRedirectStatus is not set (302 Found)
+const DefaultDenyRedirectStatus = 302
+
+// DefaultAdminRateLimit is the number of admin requests per second allowed
+// per client IP when AdminRateLimit is not set
+const DefaultAdminRateLimit = 1.0
+
+// DefaultAdminRateBurst is the number of admin requests a client IP may send
+// at once when AdminRateBurst is not set
+const DefaultAdminRateBurst = 10
+
+// DefaultExternalValidatorTimeout bounds a call to the external validator
+// when ExternalValidatorTimeout is not set
+const DefaultExternalValidatorTimeout = 2 * time.Second
+
+// DefaultExternalValidatorCacheTTL is how long decisions of the external
+// validator are cached when ExternalValidatorCacheTTL is not set
+const DefaultExternalValidatorCacheTTL = 30 * time.Second
+
+// sha256Hex matches a lower-case hex encoded SHA-256 hash
+var sha256Hex = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
 // Config holds the server configuration
 type Config struct {
 	// GRPCPort is the port for the gRPC server (Envoy ext_authz)
@@ -14,6 +69,332 @@ type Config struct {
 	// Kubeconfig path (empty = in-cluster config)
 	Kubeconfig string
 
+	// KubeContext selects a context of the kubeconfig (empty = its current
+	// context)
+	KubeContext string
+
 	// LogLevel for the server (debug, info, warn, error)
 	LogLevel string
+
+	// SecretLabelSelector selects Secrets holding hashed API keys as a
+	// secondary key source (empty = Secrets are not watched)
+	SecretLabelSelector string
+
+	// InMemory serves keys from KeysFile instead of Kubernetes, e.g. for
+	// standalone deployments. Without KeysFile the store is empty, which is
+	// only useful for tests.
+	InMemory bool
+
+	// KeysFile is the YAML/JSON list of keys served in in-memory mode,
+	// reloaded whenever it changes
+	KeysFile string
+
+	// DenyBodyFormat is the format of denied response bodies (plain, json,
+	// problem+json)
+	DenyBodyFormat string
+
+	// DenyBodyTemplate is an optional Go text/template for denied response
+	// bodies, rendered with .Reason and .Status (empty = default body)
+	DenyBodyTemplate string
+
+	// DenyRedirectURL redirects requests denied for a missing, unknown,
+	// disabled or wrongly prefixed key there, e.g. to the API docs or a page
+	// to request a key, instead of answering 403 with a body (empty =
+	// disabled)
+	DenyRedirectURL string
+
+	// DenyRedirectStatus is the 3xx status of DenyRedirectURL redirects
+	// (0 = DefaultDenyRedirectStatus)
+	DenyRedirectStatus int
+
+	// AlertWebhookURL receives a JSON alert for each denial with one of
+	// AlertReasons, sent in the background and rate limited
+	// (empty = disabled)
+	AlertWebhookURL string
+
+	// AlertReasons are the deny reasons alerted on, e.g. disabled
+	// (empty = disabled keys only)
+	AlertReasons []string
+
+	// ExternalValidatorURL receives a JSON POST with the hash of each key
+	// and answers whether it is allowed, replacing the key store lookup
+	// (empty = disabled)
+	ExternalValidatorURL string
+
+	// ExternalValidatorTimeout bounds a call to the external validator
+	// (0 = DefaultExternalValidatorTimeout)
+	ExternalValidatorTimeout time.Duration
+
+	// ExternalValidatorCacheTTL is how long decisions of the external
+	// validator are cached; a revoked key keeps working as long
+	// (0 = DefaultExternalValidatorCacheTTL)
+	ExternalValidatorCacheTTL time.Duration
+
+	// ExternalValidatorFailOpen allows requests when the external validator
+	// fails or times out (false = deny them)
+	ExternalValidatorFailOpen bool
+
+	// TracingEndpoint is the OTLP/gRPC collector URL traces are exported to,
+	// e.g. http://otel-collector:4317 (empty = tracing disabled)
+	TracingEndpoint string
+
+	// EnableReflection registers the gRPC reflection service, which lets any
+	// client reaching the gRPC port list the exposed services (debugging aid)
+	EnableReflection bool
+
+	// AdminAPIEnabled serves the key metadata endpoints (/keys) on the HTTP
+	// port, protected by AdminToken
+	AdminAPIEnabled bool
+
+	// AdminToken is the bearer token required on admin endpoints
+	// (empty = a token is generated and logged at startup)
+	AdminToken string
+
+	// AdminRateLimit is the number of admin requests per second allowed per
+	// client IP; excess requests get 429 (0 = DefaultAdminRateLimit)
+	AdminRateLimit float64
+
+	// AdminRateBurst is the number of admin requests a client IP may send at
+	// once before AdminRateLimit applies (0 = DefaultAdminRateBurst)
+	AdminRateBurst int
+
+	// DenialWindow is the rolling window /denials counts over
+	// (0 = cumulative since startup)
+	DenialWindow time.Duration
+
+	// AllowCacheTTL is advertised to Envoy on allow decisions so they can be
+	// cached per key. A revoked key keeps working for up to this long.
+	// (0 = no caching hint)
+	AllowCacheTTL time.Duration
+
+	// KubeQPS and KubeBurst rate limit requests to the Kubernetes API server
+	// (0 = client-go defaults)
+	KubeQPS   float32
+	KubeBurst int
+
+	// KubeBackoffMax bounds the jittered backoff applied while the Kubernetes
+	// API server answers 429 Too Many Requests (0 = no backoff)
+	KubeBackoffMax time.Duration
+
+	// StatsLogInterval is how often key counts are logged, for operators
+	// without a metrics stack (0 = never)
+	StatsLogInterval time.Duration
+
+	// FailOpenUntilSynced allows every request, unauthenticated, until the
+	// keys have been synced once. Anyone can use the protected services while
+	// Kubernetes is unreachable at startup. (false = deny until synced)
+	FailOpenUntilSynced bool
+
+	// MaxAPIKeyLength is the longest API key accepted; longer keys are
+	// treated as missing without being hashed (0 = DefaultMaxAPIKeyLength)
+	MaxAPIKeyLength int
+
+	// RequiredKeyPrefix is the prefix every API key must start with, e.g.
+	// a tenant's; other keys are denied without being hashed or looked up
+	// (empty = any prefix)
+	RequiredKeyPrefix string
+
+	// BreakGlassKeyHash is the SHA-256 hash (hex) of an emergency key that is
+	// always allowed, even with an empty store. It is a deliberate backdoor
+	// for disaster recovery; every use is logged. (empty = disabled)
+	BreakGlassKeyHash string
+
+	// RequiredHeaders must all be present on requests with a valid key,
+	// e.g. x-request-id for traceability (empty = no check)
+	RequiredHeaders []string
+
+	// EmitEvents creates Kubernetes Events when a source repeatedly fails
+	// validation or a disabled key is used, rate limited (false = disabled)
+	EmitEvents bool
+
+	// VerboseDenyReasons tells callers whether their key is unknown or
+	// disabled instead of a shared message. It lets anyone probe which keys
+	// exist, so it only suits internal deployments. (false = shared message)
+	VerboseDenyReasons bool
+
+	// MaxConcurrentChecks bounds the Check calls served at once; excess calls
+	// fail with ResourceExhausted (0 = unlimited)
+	MaxConcurrentChecks int
+
+	// AllowResponseHeaders are added to the upstream request on every
+	// allow, overwriting client-sent values, e.g. to pass static metadata
+	// (empty = none)
+	AllowResponseHeaders map[string]string
+
+	// KeyIDHeader is added to the upstream request on allows with a valid
+	// key, holding the key's short ID, e.g. x-api-key-id, to correlate
+	// decisions across systems without the key hash (empty = disabled)
+	KeyIDHeader string
+
+	// Timezone is the IANA time zone daily key active windows are evaluated
+	// in, e.g. Europe/Paris (empty = server local time)
+	Timezone string
+
+	// CRDWaitTimeout is how long the initial sync waits for the APIKey CRD
+	// to be installed before failing (0 = fail immediately)
+	CRDWaitTimeout time.Duration
+
+	// WatchBreakerFailures is the number of consecutive failures to
+	// establish a watch after which re-watches pause for
+	// WatchBreakerCooldown, the cache serving the last synced keys
+	// (0 = retry forever)
+	WatchBreakerFailures int
+
+	// WatchBreakerCooldown is how long re-watches pause once the breaker
+	// opened (0 = DefaultWatchBreakerCooldown)
+	WatchBreakerCooldown time.Duration
+
+	// WatchLivenessTimeout is how long a watch goroutine may go without
+	// running, e.g. because it died, before /livez fails so the Pod is
+	// restarted (0 = DefaultWatchLivenessTimeout)
+	WatchLivenessTimeout time.Duration
+
+	// TrustedKeyHeader is read for the API key before authorization and
+	// x-api-key, e.g. a header a mesh sidecar injects after mTLS. Clients
+	// can set it too, so it is only safe when the hop injecting it
+	// overwrites any client value. (empty = disabled)
+	TrustedKeyHeader string
+
+	// PerKeyMetrics labels allow and deny counts with the key name. Only the
+	// first PerKeyMetricsMaxKeys keys seen get their own label, the others
+	// share one, bounding the metrics cardinality. (false = disabled)
+	PerKeyMetrics bool
+
+	// PerKeyMetricsMaxKeys caps the key labels of PerKeyMetrics
+	// (0 = DefaultPerKeyMetricsMaxKeys)
+	PerKeyMetricsMaxKeys int
+
+	// CheckRBAC verifies at startup, with SelfSubjectAccessReviews, that the
+	// server may list and watch the resources it syncs, and fails otherwise
+	// instead of serving a cache that stops updating (false = no check)
+	CheckRBAC bool
+
+	// RouteRulesConfigMap is the namespace/name of a ConfigMap listing the
+	// request paths that do not need an API key, watched for changes
+	// (empty = every path needs one)
+	RouteRulesConfigMap string
+}
+
+// Validate checks that the configuration is sane before any listener is started
+func (c *Config) Validate() error {
+	if err := validatePort("grpc-port", c.GRPCPort); err != nil {
+		return err
+	}
+	if err := validatePort("http-port", c.HTTPPort); err != nil {
+		return err
+	}
+	if c.GRPCPort == c.HTTPPort {
+		return fmt.Errorf("invalid config: grpc-port and http-port must differ (both set to %d)", c.GRPCPort)
+	}
+	if c.KubeContext != "" && c.Kubeconfig == "" {
+		return fmt.Errorf("invalid config: kube-context requires kubeconfig")
+	}
+	if c.KeysFile != "" && !c.InMemory {
+		return fmt.Errorf("invalid config: keys-file requires in-memory mode")
+	}
+	if c.AllowCacheTTL < 0 || c.AllowCacheTTL > MaxAllowCacheTTL {
+		return fmt.Errorf("invalid config: allow-cache-ttl %s is out of range (0-%s)", c.AllowCacheTTL, MaxAllowCacheTTL)
+	}
+	if c.DenialWindow < 0 {
+		return fmt.Errorf("invalid config: denial-window %s must not be negative", c.DenialWindow)
+	}
+	if c.KubeQPS < 0 || c.KubeBurst < 0 {
+		return fmt.Errorf("invalid config: kube-qps %g and kube-burst %d must not be negative", c.KubeQPS, c.KubeBurst)
+	}
+	if c.KubeBackoffMax < 0 {
+		return fmt.Errorf("invalid config: kube-backoff-max %s must not be negative", c.KubeBackoffMax)
+	}
+	if c.EmitEvents && c.InMemory {
+		return fmt.Errorf("invalid config: emit-events requires the Kubernetes key store, not in-memory mode")
+	}
+	if c.TrustedKeyHeader != "" && strings.ContainsAny(c.TrustedKeyHeader, " \t\r\n\x00:") {
+		return fmt.Errorf("invalid config: trusted-key-header %q must be a header name", c.TrustedKeyHeader)
+	}
+	if c.RouteRulesConfigMap != "" {
+		if namespace, name, ok := strings.Cut(c.RouteRulesConfigMap, "/"); !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid config: route-rules-configmap %q must be namespace/name", c.RouteRulesConfigMap)
+		}
+		if c.InMemory {
+			return fmt.Errorf("invalid config: route-rules-configmap requires the Kubernetes key store, not in-memory mode")
+		}
+	}
+	if c.CRDWaitTimeout < 0 {
+		return fmt.Errorf("invalid config: crd-wait-timeout %s must not be negative", c.CRDWaitTimeout)
+	}
+	if c.WatchBreakerFailures < 0 || c.WatchBreakerCooldown < 0 {
+		return fmt.Errorf("invalid config: watch-breaker-failures %d and watch-breaker-cooldown %s must not be negative",
+			c.WatchBreakerFailures, c.WatchBreakerCooldown)
+	}
+	for name, value := range c.AllowResponseHeaders {
+		if name == "" || strings.ContainsAny(name, " \t\r\n\x00:") || strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("invalid config: allow-response-headers %q: names must be non-empty tokens and values must not contain CR, LF or NUL", name)
+		}
+		if c.KeyIDHeader != "" && strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(c.KeyIDHeader)) {
+			return fmt.Errorf("invalid config: key-id-header %q is also set by allow-response-headers", c.KeyIDHeader)
+		}
+	}
+	if c.KeyIDHeader != "" && strings.ContainsAny(strings.TrimSpace(c.KeyIDHeader), " \t\r\n\x00:") {
+		return fmt.Errorf("invalid config: key-id-header %q must be a header name", c.KeyIDHeader)
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return fmt.Errorf("invalid config: timezone %q: %w", c.Timezone, err)
+		}
+	}
+	if c.DenyRedirectURL != "" {
+		if u, err := url.Parse(c.DenyRedirectURL); err != nil || (!u.IsAbs() && !strings.HasPrefix(c.DenyRedirectURL, "/")) ||
+			(u.IsAbs() && u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid config: deny-redirect-url %q must be an http(s) URL or an absolute path", c.DenyRedirectURL)
+		}
+	}
+	if c.AlertWebhookURL != "" {
+		if u, err := url.Parse(c.AlertWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid config: alert-webhook-url %q must be an http(s) URL", c.AlertWebhookURL)
+		}
+	}
+	if c.ExternalValidatorURL != "" {
+		if u, err := url.Parse(c.ExternalValidatorURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid config: external-validator-url %q must be an http(s) URL", c.ExternalValidatorURL)
+		}
+	}
+	if c.ExternalValidatorTimeout < 0 || c.ExternalValidatorCacheTTL < 0 {
+		return fmt.Errorf("invalid config: external-validator-timeout %s and external-validator-cache-ttl %s must not be negative",
+			c.ExternalValidatorTimeout, c.ExternalValidatorCacheTTL)
+	}
+	switch c.DenyRedirectStatus {
+	case 0, 301, 302, 303, 307, 308:
+	default:
+		return fmt.Errorf("invalid config: deny-redirect-status %d must be a redirect status (301, 302, 303, 307, 308)", c.DenyRedirectStatus)
+	}
+	if c.WatchLivenessTimeout != 0 && c.WatchLivenessTimeout < MinWatchLivenessTimeout {
+		return fmt.Errorf("invalid config: watch-liveness-timeout %s must be 0 or at least %s", c.WatchLivenessTimeout, MinWatchLivenessTimeout)
+	}
+	if c.AdminRateLimit < 0 || c.AdminRateBurst < 0 {
+		return fmt.Errorf("invalid config: admin-rate-limit %g and admin-rate-burst %d must not be negative", c.AdminRateLimit, c.AdminRateBurst)
+	}
+	if c.StatsLogInterval < 0 {
+		return fmt.Errorf("invalid config: stats-log-interval %s must not be negative", c.StatsLogInterval)
+	}
+	if c.BreakGlassKeyHash != "" && !sha256Hex.MatchString(c.BreakGlassKeyHash) {
+		return fmt.Errorf("invalid config: break-glass-key-hash must be a hex encoded SHA-256 hash, not a raw key")
+	}
+	if c.MaxConcurrentChecks < 0 {
+		return fmt.Errorf("invalid config: max-concurrent-checks %d must not be negative", c.MaxConcurrentChecks)
+	}
+	if c.PerKeyMetricsMaxKeys < 0 {
+		return fmt.Errorf("invalid config: per-key-metrics-max-keys %d must not be negative", c.PerKeyMetricsMaxKeys)
+	}
+	if c.MaxAPIKeyLength < 0 {
+		return fmt.Errorf("invalid config: max-api-key-length %d must not be negative", c.MaxAPIKeyLength)
+	}
+
+	return nil
+}
+
+// validatePort checks that a port number is within the valid TCP range
+func validatePort(name string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid config: %s %d is out of range (1-65535)", name, port)
+	}
+	return nil
 }
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActiveWindow is a period during which a key may be used. Start and End are
+// either both RFC3339 timestamps, a one-off window such as a maintenance, or
+// both HH:MM wall-clock times of a daily window, optionally limited to Days
+// (e.g. [Mon-Fri] or [Sat, Sun]). A daily window ending before it starts
+// runs overnight and belongs to the day it starts on; End may be 24:00.
+// Start is inclusive, End exclusive.
+type ActiveWindow struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Days  []string `json:"days,omitempty"`
+}
+
+// weekdays maps the three-letter day names accepted in ActiveWindow.Days
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Validate reports whether the window is well formed
+func (w ActiveWindow) Validate() error {
+	_, err := w.compile()
+	return err
+}
+
+// Contains reports whether t falls within the window. Daily windows follow
+// the wall clock of t's location, so they are an hour shorter or longer when
+// daylight saving time starts or ends during them. Malformed windows never
+// contain any time.
+func (w ActiveWindow) Contains(t time.Time) bool {
+	c, err := w.compile()
+	if err != nil {
+		return false
+	}
+	return c.contains(t)
+}
+
+// compiledWindow is the parsed form of an ActiveWindow
+type compiledWindow struct {
+	// from and to bound a one-off window (daily = false)
+	from, to time.Time
+
+	daily bool
+	// start and end are minutes since midnight, end up to 24*60
+	start, end int
+	// days holds a bit per time.Weekday the window may start on
+	days uint8
+}
+
+// compile parses and checks the window
+func (w ActiveWindow) compile() (compiledWindow, error) {
+	from, fromErr := time.Parse(time.RFC3339, w.Start)
+	to, toErr := time.Parse(time.RFC3339, w.End)
+	if fromErr == nil && toErr == nil {
+		if len(w.Days) > 0 {
+			return compiledWindow{}, fmt.Errorf("days only apply to daily HH:MM windows")
+		}
+		if !to.After(from) {
+			return compiledWindow{}, fmt.Errorf("end %s must be after start %s", w.End, w.Start)
+		}
+		return compiledWindow{from: from, to: to}, nil
+	}
+
+	start, err := parseClock(w.Start, false)
+	if err != nil {
+		return compiledWindow{}, fmt.Errorf("start: %w", err)
+	}
+	end, err := parseClock(w.End, true)
+	if err != nil {
+		return compiledWindow{}, fmt.Errorf("end: %w", err)
+	}
+	if start == end {
+		return compiledWindow{}, fmt.Errorf("start and end must differ")
+	}
+	days, err := parseDays(w.Days)
+	if err != nil {
+		return compiledWindow{}, err
+	}
+	return compiledWindow{daily: true, start: start, end: end, days: days}, nil
+}
+
+// contains reports whether t falls within the compiled window
+func (c compiledWindow) contains(t time.Time) bool {
+	if !c.daily {
+		return !t.Before(c.from) && t.Before(c.to)
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	if c.start < c.end {
+		return c.startsOn(today) && minute >= c.start && minute < c.end
+	}
+	// Overnight: the evening part belongs to today, the morning part to the
+	// window started yesterday. Weekdays are used rather than t.AddDate so
+	// DST changes cannot shift the day.
+	yesterday := (today + 6) % 7
+	return (c.startsOn(today) && minute >= c.start) || (c.startsOn(yesterday) && minute < c.end)
+}
+
+// startsOn reports whether the window may start on the given day
+func (c compiledWindow) startsOn(day time.Weekday) bool {
+	return c.days == 0 || c.days&(1<<day) != 0
+}
+
+// parseClock parses an HH:MM time into minutes since midnight; 24:00 is
+// only accepted as an end
+func parseClock(value string, end bool) (int, error) {
+	if len(value) != 5 || value[2] != ':' || !isDigits(value[:2]) || !isDigits(value[3:]) {
+		return 0, fmt.Errorf("%q is neither an RFC3339 timestamp nor an HH:MM time", value)
+	}
+	hour := int(value[0]-'0')*10 + int(value[1]-'0')
+	minute := int(value[3]-'0')*10 + int(value[4]-'0')
+	if end && hour == 24 && minute == 0 {
+		return 24 * 60, nil
+	}
+	if hour > 23 || minute > 59 {
+		return 0, fmt.Errorf("%q is out of range (00:00-23:59)", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// isDigits reports whether s only holds ASCII digits
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDays parses day names (Mon) and ranges (Mon-Fri, Fri-Mon) into a bit
+// set of weekdays; no days means every day
+func parseDays(days []string) (uint8, error) {
+	var set uint8
+	for _, day := range days {
+		first, last, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(day)), "-")
+		from, ok := weekdays[first]
+		if !ok {
+			return 0, fmt.Errorf("unknown day %q (use Mon, Tue, ... or a range like Mon-Fri)", day)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdays[last]; !ok {
+				return 0, fmt.Errorf("unknown day %q (use Mon, Tue, ... or a range like Mon-Fri)", day)
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			set |= 1 << d
+			if d == to {
+				break
+			}
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActiveWindow_Contains(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	// at returns a time on June 2025; June 2 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, time.June, day, hour, minute, 0, 0, paris)
+	}
+
+	businessHours := ActiveWindow{Start: "09:00", End: "18:00", Days: []string{"Mon-Fri"}}
+	overnight := ActiveWindow{Start: "22:00", End: "06:00", Days: []string{"Fri"}}
+	weekend := ActiveWindow{Start: "00:00", End: "24:00", Days: []string{"Sat", "sun"}}
+	wrappingDays := ActiveWindow{Start: "00:00", End: "24:00", Days: []string{"Fri-Mon"}}
+	oneOff := ActiveWindow{Start: "2025-06-02T20:00:00Z", End: "2025-06-02T22:00:00Z"}
+
+	tests := []struct {
+		name   string
+		window ActiveWindow
+		t      time.Time
+		want   bool
+	}{
+		{"daily start is inclusive", businessHours, at(2, 9, 0), true},
+		{"daily before start", businessHours, at(2, 8, 59), false},
+		{"daily last minute", businessHours, at(2, 17, 59), true},
+		{"daily end is exclusive", businessHours, at(2, 18, 0), false},
+		{"daily on excluded day", businessHours, at(7, 12, 0), false},
+		{"daily without days", ActiveWindow{Start: "09:00", End: "18:00"}, at(8, 12, 0), true},
+		{"overnight evening on start day", overnight, at(6, 22, 0), true},
+		{"overnight before midnight", overnight, at(6, 23, 59), true},
+		{"overnight morning after start day", overnight, at(7, 5, 59), true},
+		{"overnight end is exclusive", overnight, at(7, 6, 0), false},
+		{"overnight evening of next day", overnight, at(7, 22, 0), false},
+		{"overnight morning of start day", overnight, at(6, 5, 0), false},
+		{"overnight across week end", ActiveWindow{Start: "22:00", End: "02:00", Days: []string{"Sun"}}, at(2, 1, 0), true},
+		{"24:00 covers the last minute", weekend, at(8, 23, 59), true},
+		{"24:00 ends at midnight", weekend, at(9, 0, 0), false},
+		{"wrapping day range", wrappingDays, at(2, 12, 0), true},
+		{"outside wrapping day range", wrappingDays, at(3, 12, 0), false},
+		{"one-off start is inclusive", oneOff, time.Date(2025, time.June, 2, 20, 0, 0, 0, time.UTC), true},
+		{"one-off in another zone", oneOff, at(2, 23, 30), true},
+		{"one-off end is exclusive", oneOff, time.Date(2025, time.June, 2, 22, 0, 0, 0, time.UTC), false},
+		{"malformed never matches", ActiveWindow{Start: "9:00", End: "18:00"}, at(2, 12, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.t); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActiveWindow_Contains_DST(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	window := ActiveWindow{Start: "01:00", End: "04:00", Days: []string{"Sun"}}
+
+	// Clocks jump from 02:00 to 03:00 on 2025-03-30: the window lasts 2h
+	springStart := time.Date(2025, time.March, 30, 0, 0, 0, 0, time.UTC) // 01:00 CET
+	if !window.Contains(springStart.In(paris)) {
+		t.Error("Contains() = false at the start of the shortened window")
+	}
+	if !window.Contains(springStart.Add(time.Hour).In(paris)) { // 03:00 CEST
+		t.Error("Contains() = false after the spring-forward jump")
+	}
+	if window.Contains(springStart.Add(2 * time.Hour).In(paris)) { // 04:00 CEST
+		t.Error("Contains() = true 2h after the start of the shortened window")
+	}
+
+	// Clocks go back from 03:00 to 02:00 on 2025-10-26: the window lasts 4h
+	fallStart := time.Date(2025, time.October, 25, 23, 0, 0, 0, time.UTC)        // 01:00 CEST
+	if !window.Contains(fallStart.Add(3*time.Hour + 59*time.Minute).In(paris)) { // 03:59 CET
+		t.Error("Contains() = false during the repeated hour")
+	}
+	if window.Contains(fallStart.Add(4 * time.Hour).In(paris)) { // 04:00 CET
+		t.Error("Contains() = true 4h after the start of the lengthened window")
+	}
+
+	// The weekday comes from the wall clock, not from UTC
+	if window.Contains(time.Date(2025, time.March, 29, 0, 30, 0, 0, time.UTC).In(paris)) { // Sat 01:30 CET
+		t.Error("Contains() = true on a Saturday")
+	}
+}
+
+func TestActiveWindow_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  ActiveWindow
+		wantErr bool
+	}{
+		{"daily", ActiveWindow{Start: "09:00", End: "18:00", Days: []string{"Mon-Fri"}}, false},
+		{"overnight", ActiveWindow{Start: "22:00", End: "06:00"}, false},
+		{"full day", ActiveWindow{Start: "00:00", End: "24:00"}, false},
+		{"full day names rejected", ActiveWindow{Start: "00:00", End: "24:00", Days: []string{"Monday"}}, true},
+		{"one-off", ActiveWindow{Start: "2025-06-01T20:00:00Z", End: "2025-06-02T02:00:00+02:00"}, false},
+		{"one-off ending before start", ActiveWindow{Start: "2025-06-02T20:00:00Z", End: "2025-06-02T19:00:00Z"}, true},
+		{"one-off with days", ActiveWindow{Start: "2025-06-01T20:00:00Z", End: "2025-06-02T02:00:00Z", Days: []string{"Mon"}}, true},
+		{"mixed formats", ActiveWindow{Start: "2025-06-01T20:00:00Z", End: "22:00"}, true},
+		{"single digit hour", ActiveWindow{Start: "9:00", End: "18:00"}, true},
+		{"24:00 as start", ActiveWindow{Start: "24:00", End: "06:00"}, true},
+		{"out of range minute", ActiveWindow{Start: "09:60", End: "18:00"}, true},
+		{"empty window", ActiveWindow{Start: "09:00", End: "09:00"}, true},
+		{"missing end", ActiveWindow{Start: "09:00"}, true},
+		{"unknown day", ActiveWindow{Start: "09:00", End: "18:00", Days: []string{"Mon-Fry"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.window.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIKeyEntry_ActiveAt(t *testing.T) {
+	now := time.Date(2025, time.June, 2, 12, 0, 0, 0, time.UTC)
+
+	always := APIKeyEntry{}
+	if !always.ActiveAt(now) {
+		t.Error("ActiveAt() = false without active windows")
+	}
+
+	scheduled := APIKeyEntry{ActiveWindows: []ActiveWindow{
+		{Start: "bogus", End: "18:00"},
+		{Start: "08:00", End: "09:00"},
+		{Start: "11:00", End: "13:00"},
+	}}
+	if !scheduled.ActiveAt(now) {
+		t.Error("ActiveAt() = false within the second valid window")
+	}
+	if scheduled.ActiveAt(now.Add(2 * time.Hour)) {
+		t.Error("ActiveAt() = true outside every window")
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuth requires the admin token as a bearer token
+func adminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), apikey.BearerPrefix)
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// keyMetadata is the admin API view of a key; the hash is never exposed
+type keyMetadata struct {
+	Name           string                `json:"name"`
+	KeyID          string                `json:"keyId,omitempty"`
+	Email          string                `json:"email,omitempty"`
+	KeyHint        string                `json:"keyHint,omitempty"`
+	Description    string                `json:"description,omitempty"`
+	Enabled        bool                  `json:"enabled"`
+	Source         string                `json:"source,omitempty"`
+	AllowedMethods []string              `json:"allowedMethods,omitempty"`
+	ActiveWindows  []models.ActiveWindow `json:"activeWindows,omitempty"`
+	Notes          string                `json:"notes,omitempty"`
+	// LoadedAt is when the server cached the current version of the key,
+	// e.g. to check that it picked up an edit
+	LoadedAt *time.Time `json:"loadedAt,omitempty"`
+}
+
+// newKeyMetadata strips the hash from an entry
+func newKeyMetadata(entry models.APIKeyEntry) keyMetadata {
+	metadata := keyMetadata{
+		Name:           entry.Name,
+		KeyID:          entry.KeyID,
+		Email:          entry.Email,
+		KeyHint:        entry.KeyHint,
+		Description:    entry.Description,
+		Enabled:        entry.Enabled,
+		Source:         entry.Source,
+		AllowedMethods: entry.AllowedMethods,
+		ActiveWindows:  entry.ActiveWindows,
+		Notes:          entry.Notes,
+	}
+	if !entry.LoadedAt.IsZero() {
+		metadata.LoadedAt = &entry.LoadedAt
+	}
+	return metadata
+}
+
+// listKeysHandler returns the metadata of the loaded keys, optionally
+// filtered by email, enabled state and hint, e.g.
+// GET /keys?hint=sk-abc*************78
+func (s *Server) listKeysHandler(c *gin.Context) {
+	query := KeyQuery{
+		Email: c.Query("email"),
+		// Hints are partial, so several keys may share one
+		Hint: c.Query("hint"),
+	}
+
+	if raw, ok := c.GetQuery("enabled"); ok {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid enabled filter: " + raw})
+			return
+		}
+		query.Enabled = &parsed
+	}
+
+	keys := []keyMetadata{}
+	for _, entry := range s.store.Search(query) {
+		keys = append(keys, newKeyMetadata(entry))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// logLevelRequest is the body of PUT /loglevel
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// getLogLevelHandler returns the active log level
+func (s *Server) getLogLevelHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"level": LogLevel()})
+}
+
+// setLogLevelHandler changes the log level without a restart, e.g.
+// PUT /loglevel {"level":"debug"} while investigating an incident
+func (s *Server) setLogLevelHandler(c *gin.Context) {
+	var req logLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+		return
+	}
+
+	previous := LogLevel()
+	if err := SetLogLevel(strings.ToLower(strings.TrimSpace(req.Level))); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Log level changed from %s to %s", previous, LogLevel())
+	c.JSON(http.StatusOK, gin.H{"level": LogLevel(), "previous": previous})
+}
+
+// exportHandler returns the loaded keys as APIKey resources, sorted by name,
+// e.g. to back them up or diff them against the cluster. Keys from the
+// cluster keep their namespace, so same-named keys of different namespaces
+// do not overwrite each other when applied. Raw keys are never known to the
+// server; hashes are included, as in the resources.
+func (s *Server) exportHandler(c *gin.Context) {
+	var b strings.Builder
+	for _, entry := range s.store.Search(KeyQuery{}) {
+		doc, err := apikey.GenerateNamedYAML(entry.Namespace, exportName(entry), models.APIKeySpec{
+			Email:          entry.Email,
+			KeyHash:        entry.KeyHash,
+			KeyHint:        entry.KeyHint,
+			Description:    entry.Description,
+			Enabled:        entry.Enabled,
+			AllowedMethods: entry.AllowedMethods,
+			Notes:          entry.Notes,
+			ActiveWindows:  entry.ActiveWindows,
+			KeyHashes:      entry.KeyHashes,
+			KeyHashAlgo:    entry.KeyHashAlgo,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export " + entry.Name})
+			return
+		}
+		b.WriteString(doc)
+	}
+	c.Data(http.StatusOK, "application/yaml", []byte(b.String()))
+}
+
+// exportName is the resource name of an exported entry. APIKeys keep their
+// name; keys from Secrets and files get the name generated for their email.
+func exportName(entry models.APIKeyEntry) string {
+	if entry.Source != models.SourceSecret && entry.Source != models.SourceFile {
+		return entry.Name
+	}
+	if entry.Email != "" {
+		return apikey.ResourceName(entry.Email)
+	}
+	return apikey.ResourceName(entry.Name)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminLimiterSweepSize is the number of tracked clients above which full
+// buckets are dropped, bounding memory when many addresses hit the endpoints
+const adminLimiterSweepSize = 1024
+
+// adminLimiter rate limits the admin endpoints per client IP with token
+// buckets, so the admin token cannot be brute-forced and the expensive
+// listings cannot be hammered
+type adminLimiter struct {
+	mu sync.Mutex
+	// rate is the number of tokens refilled per second, burst the size of
+	// each bucket
+	rate  float64
+	burst float64
+	// buckets holds the bucket of each client IP seen recently
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+	// rejected counts the requests refused with 429
+	rejected atomic.Int64
+}
+
+// tokenBucket holds the tokens of a client as of updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// newAdminLimiter creates a limiter allowing rate requests per second per
+// client IP, in bursts of up to burst requests
+func newAdminLimiter(rate float64, burst int) *adminLimiter {
+	return &adminLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of client, returning how long until
+// one is available when it is empty
+func (l *adminLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.buckets) >= adminLimiterSweepSize {
+		l.sweepLocked(now)
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = l.tokensLocked(bucket, now)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// tokensLocked returns the tokens of bucket refilled up to now
+func (l *adminLimiter) tokensLocked(bucket *tokenBucket, now time.Time) float64 {
+	elapsed := max(now.Sub(bucket.updated), 0)
+	return min(bucket.tokens+elapsed.Seconds()*l.rate, l.burst)
+}
+
+// sweepLocked drops the buckets refilled to the full burst, which are the
+// same as new ones
+func (l *adminLimiter) sweepLocked(now time.Time) {
+	for client, bucket := range l.buckets {
+		if l.tokensLocked(bucket, now) >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// middleware rejects requests with 429 Too Many Requests once their client
+// IP exhausted its bucket. The IP is that of the connection: headers like
+// X-Forwarded-For are set by clients too, which could then pick a fresh
+// bucket for each request.
+func (l *adminLimiter) middleware(c *gin.Context) {
+	if ok, wait := l.allow(c.RemoteIP()); !ok {
+		l.rejected.Add(1)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Admin rate limit", func() {
+	const (
+		adminToken = "test-admin-token"
+		burst      = 3
+	)
+
+	var (
+		srv    *Server
+		router http.Handler
+		now    time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		srv, err = NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080, AdminAPIEnabled: true, AdminToken: adminToken, AdminRateLimit: 0.5, AdminRateBurst: burst}, NewInMemoryStore())
+		Expect(err).ToNot(HaveOccurred())
+
+		now = time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+		srv.adminLimiter.now = func() time.Time { return now }
+		router = srv.setupRouter()
+	})
+
+	// get requests path from addr, with the admin token unless token is empty
+	get := func(path, addr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = addr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("should answer 429 once a client exceeded its burst", func() {
+		for range burst {
+			Expect(get("/keys", "10.0.0.1:1234", adminToken).Code).To(Equal(http.StatusOK))
+		}
+
+		rec := get("/keys", "10.0.0.1:1234", adminToken)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("2"))
+		Expect(rec.Body.String()).To(MatchJSON(`{"error": "too many requests"}`))
+		Expect(srv.adminLimiter.rejected.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should count unauthorized requests, so the token cannot be brute-forced", func() {
+		for range burst {
+			Expect(get("/export", "10.0.0.1:1234", "guess").Code).To(Equal(http.StatusUnauthorized))
+		}
+		Expect(get("/export", "10.0.0.1:1234", adminToken).Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should refill the bucket over time", func() {
+		for range burst {
+			get("/loglevel", "10.0.0.1:1234", adminToken)
+		}
+		Expect(get("/loglevel", "10.0.0.1:1234", adminToken).Code).To(Equal(http.StatusTooManyRequests))
+
+		now = now.Add(2 * time.Second)
+		Expect(get("/loglevel", "10.0.0.1:1234", adminToken).Code).To(Equal(http.StatusOK))
+		Expect(get("/loglevel", "10.0.0.1:1234", adminToken).Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should limit each client IP separately, whatever the forwarded headers", func() {
+		for range burst {
+			get("/keys", "10.0.0.1:1234", adminToken)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+		req.RemoteAddr = "10.0.0.1:5678"
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		req.Header.Set("X-Forwarded-For", "192.0.2.1")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+
+		Expect(get("/keys", "10.0.0.2:1234", adminToken).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not limit the public endpoints", func() {
+		for range burst + 1 {
+			Expect(get("/health", "10.0.0.1:1234", "").Code).To(Equal(http.StatusOK))
+		}
+		Expect(get("/keys", "10.0.0.1:1234", adminToken).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should forget clients whose bucket refilled", func() {
+		limiter := newAdminLimiter(1, 1)
+		limiter.now = func() time.Time { return now }
+		for i := range adminLimiterSweepSize {
+			limiter.allow(string(rune(i)))
+		}
+		now = now.Add(time.Second)
+		limiter.allow("latecomer")
+		Expect(limiter.buckets).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// alertQPS and alertBurst bound the alerts sent, so a flood of denials
+	// cannot flood the webhook too
+	alertQPS   = 1
+	alertBurst = 10
+	// alertAttempts is the number of times an alert is posted before it is
+	// given up, waiting alertBackoff, then twice as long, between attempts
+	alertAttempts = 3
+	alertBackoff  = time.Second
+	// alertTimeout bounds a single post
+	alertTimeout = 5 * time.Second
+)
+
+// alertReasons are the deny reasons alerts may be sent for
+var alertReasons = []string{DenyReasonMissing, DenyReasonInvalid, DenyReasonDisabled, DenyReasonMethod, DenyReasonPolicy, DenyReasonHeader, DenyReasonSchedule, DenyReasonValidator, DenyReasonPrefix}
+
+// denialAlert is the JSON payload posted to the alert webhook
+type denialAlert struct {
+	Reason string `json:"reason"`
+	// Hint is the hint of the presented keys, comma-separated (empty when
+	// none was)
+	Hint      string    `json:"hint,omitempty"`
+	SourceIP  string    `json:"sourceIp,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// alertNotifier posts denials with selected reasons to a webhook, e.g. to
+// page a security team on disabled keys being used. Alerts are sent in the
+// background, never delaying Check, and rate limited; alerts over the rate
+// are dropped.
+type alertNotifier struct {
+	url     string
+	reasons map[string]bool
+	client  *http.Client
+	limiter flowcontrol.RateLimiter
+	// backoff is the delay before the first retry, replaced in tests
+	backoff time.Duration
+	// dropped counts the alerts not sent because of the rate limit
+	dropped atomic.Int64
+	// wg tracks the alerts being sent
+	wg sync.WaitGroup
+}
+
+// newAlertNotifier creates a notifier posting denials with the given reasons
+// (empty = disabled keys being used) to url
+func newAlertNotifier(url string, reasons []string) (*alertNotifier, error) {
+	if len(reasons) == 0 {
+		reasons = []string{DenyReasonDisabled}
+	}
+	known := make(map[string]bool, len(alertReasons))
+	for _, reason := range alertReasons {
+		known[reason] = true
+	}
+	selected := make(map[string]bool, len(reasons))
+	for _, reason := range reasons {
+		if !known[reason] {
+			return nil, fmt.Errorf("unknown deny reason %q, expected one of %v", reason, alertReasons)
+		}
+		selected[reason] = true
+	}
+
+	return &alertNotifier{
+		url:     url,
+		reasons: selected,
+		client:  &http.Client{Timeout: alertTimeout},
+		limiter: flowcontrol.NewTokenBucketRateLimiter(alertQPS, alertBurst),
+		backoff: alertBackoff,
+	}, nil
+}
+
+// wants reports whether denials with reason are alerted on
+func (n *alertNotifier) wants(reason string) bool {
+	return n.reasons[reason]
+}
+
+// send posts an alert in the background, unless the rate is exceeded
+func (n *alertNotifier) send(alert denialAlert) {
+	if !n.limiter.TryAccept() {
+		n.dropped.Add(1)
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.post(alert)
+	}()
+}
+
+// post posts an alert, retrying failed attempts with an exponential backoff
+func (n *alertNotifier) post(alert denialAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		warnf("Warning: failed to encode %s denial alert: %v", alert.Reason, err)
+		return
+	}
+
+	delay := n.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.postOnce(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == alertAttempts {
+			warnf("Warning: failed to send %s denial alert after %d attempts: %v", alert.Reason, attempt, err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postOnce posts an alert body once, reporting whether a failure may succeed
+// on retry: network errors, 429 and 5xx may, other statuses will not
+func (n *alertNotifier) postOnce(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook answered %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Denial alerts", func() {
+	const (
+		validKey    = "sk-valid-key-for-alerts"
+		disabledKey = "sk-disabled-key-for-alerts"
+	)
+
+	var (
+		webhook  *httptest.Server
+		mu       sync.Mutex
+		received []denialAlert
+		// failures is the number of requests the webhook fails before
+		// accepting alerts
+		failures atomic.Int32
+		attempts atomic.Int32
+		authz    *AuthorizationServer
+		now      time.Time
+	)
+
+	BeforeEach(func() {
+		received = nil
+		failures.Store(0)
+		attempts.Store(0)
+		webhook = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			if failures.Add(-1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			defer GinkgoRecover()
+			var alert denialAlert
+			Expect(json.NewDecoder(r.Body).Decode(&alert)).To(Succeed())
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			mu.Lock()
+			received = append(received, alert)
+			mu.Unlock()
+		}))
+		DeferCleanup(webhook.Close)
+	})
+
+	// newAuthz creates an authorization server alerting on reasons
+	newAuthz := func(reasons ...string) {
+		var err error
+		authz, err = NewAuthorizationServer(NewInMemoryStore(
+			models.APIKeyEntry{Name: "valid", KeyHash: apikey.HashAPIKey(validKey), Enabled: true},
+			models.APIKeyEntry{Name: "disabled", KeyHash: apikey.HashAPIKey(disabledKey), Enabled: false},
+		), &models.Config{AlertWebhookURL: webhook.URL, AlertReasons: reasons})
+		Expect(err).ToNot(HaveOccurred())
+		authz.alerts.backoff = time.Millisecond
+		now = time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+		authz.now = func() time.Time { return now }
+	}
+
+	// check sends a request with key from source
+	check := func(key, source string) {
+		_, err := authz.Check(context.Background(), newSourceCheckRequest(key, source))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	// alerts waits for pending alerts and returns the received ones
+	alerts := func() []denialAlert {
+		authz.alerts.wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+
+	It("should post disabled keys being used by default", func() {
+		newAuthz()
+		check(disabledKey, "10.0.0.1")
+		check("sk-unknown-key-for-alerts", "10.0.0.2")
+		check(validKey, "10.0.0.3")
+
+		Expect(alerts()).To(Equal([]denialAlert{{
+			Reason:    DenyReasonDisabled,
+			Hint:      apikey.GenerateHint(disabledKey),
+			SourceIP:  "10.0.0.1",
+			Timestamp: now,
+		}}))
+	})
+
+	It("should post the configured reasons only", func() {
+		newAuthz(DenyReasonInvalid, DenyReasonMissing)
+		check(disabledKey, "10.0.0.1")
+		check("sk-unknown-key-for-alerts", "10.0.0.2")
+		check("", "10.0.0.3")
+
+		Expect(alerts()).To(ConsistOf(
+			HaveField("Reason", DenyReasonInvalid),
+			And(HaveField("Reason", DenyReasonMissing), HaveField("Hint", BeEmpty())),
+		))
+	})
+
+	It("should retry failed posts", func() {
+		newAuthz()
+		failures.Store(alertAttempts - 1)
+		check(disabledKey, "10.0.0.1")
+
+		Expect(alerts()).To(HaveLen(1))
+		Expect(attempts.Load()).To(BeEquivalentTo(alertAttempts))
+	})
+
+	It("should give up after the last attempt", func() {
+		newAuthz()
+		failures.Store(alertAttempts)
+		check(disabledKey, "10.0.0.1")
+
+		Expect(alerts()).To(BeEmpty())
+		Expect(attempts.Load()).To(BeEquivalentTo(alertAttempts))
+	})
+
+	It("should drop alerts over the rate limit", func() {
+		newAuthz()
+		for range alertBurst + 5 {
+			check(disabledKey, "10.0.0.1")
+		}
+
+		Expect(alerts()).To(HaveLen(alertBurst))
+		Expect(authz.alerts.dropped.Load()).To(BeEquivalentTo(5))
+	})
+
+	It("should reject unknown reasons", func() {
+		_, err := NewAuthorizationServer(NewInMemoryStore(), &models.Config{AlertWebhookURL: webhook.URL, AlertReasons: []string{"ip-block"}})
+		Expect(err).To(MatchError(ContainSubstring(`unknown deny reason "ip-block"`)))
+	})
+})
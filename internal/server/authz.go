@@ -2,88 +2,786 @@ package server
 
 import (
 	"context"
-	"log"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
 	envoy_api_v3_core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// AllowCacheTTLMetadataKey is the dynamic metadata field holding the number
+// of seconds an allow decision may be cached for
+const AllowCacheTTLMetadataKey = "allow_cache_ttl_seconds"
+
+// maxAPIKeyCandidates is the most comma-separated keys tried per request
+const maxAPIKeyCandidates = 4
+
+// Deny messages of unknown and disabled keys. By default both get
+// denyMessageInvalidKey so callers cannot probe which keys exist.
+const (
+	denyMessageInvalidKey  = "Invalid or disabled API key"
+	denyMessageUnknownKey  = "Unknown API key"
+	denyMessageDisabledKey = "API key disabled, contact an administrator"
+)
+
+// DenyReasonMetadataKey is the dynamic metadata field holding the
+// machine-readable reason of a deny decision (see DenyReason*). It is only
+// visible to Envoy, e.g. in access logs, so callers still cannot tell
+// unknown keys from disabled ones.
+const DenyReasonMetadataKey = "deny_reason"
+
 // AuthorizationServer implements the Envoy ext_authz gRPC service
 type AuthorizationServer struct {
-	store *APIKeyStore
+	store   KeyStore
+	deny    *denyRenderer
+	tracer  trace.Tracer
+	denials *denialCounter
+	// redirect and redirectStatus answer denials for a missing, unknown,
+	// disabled or wrongly prefixed key with a redirect instead of a body
+	// (empty = disabled)
+	redirect       string
+	redirectStatus int
+	// failOpen allows every request until the store has synced once
+	failOpen bool
+	// failOpenAllows counts the requests allowed unchecked by failOpen
+	failOpenAllows atomic.Int64
+	// maxKeyLength bounds the work spent on a single key header
+	maxKeyLength int
+	// keyPrefix is the prefix every key must start with (empty = any)
+	keyPrefix string
+	// trustedKeyHeader, when present, is read instead of the client key
+	// headers (lowercase, as Envoy passes header names; empty = disabled)
+	trustedKeyHeader string
+	// verboseDeny tells callers whether their key is unknown or disabled
+	verboseDeny bool
+	// breakGlassHash is the hash of the emergency key (empty = disabled)
+	breakGlassHash string
+	// breakGlassAllows counts the uses of the emergency key
+	breakGlassAllows atomic.Int64
+	// requiredHeaders must be present once a key validated (lowercase, as
+	// Envoy passes header names)
+	requiredHeaders []string
+	// decisionHook is consulted after a key validated
+	decisionHook DecisionHook
+	// events reports repeated failures and disabled keys as Kubernetes
+	// Events (nil = disabled)
+	events *eventEmitter
+	// alerts posts selected denials to a webhook (nil = disabled)
+	alerts *alertNotifier
+	// validator validates keys instead of the store (nil = disabled)
+	validator *externalValidator
+	// routes tells which paths need an API key (nil = all of them)
+	routes *routeRules
+	// publicAllows counts the requests allowed on paths needing no key
+	publicAllows atomic.Int64
+	// keyMetrics counts decisions per key name (nil = disabled)
+	keyMetrics *keyMetrics
+	// slowHashes caches the argon2id keys that verified recently
+	slowHashes *slowHashCache
+	// location is the time zone daily active windows are evaluated in
+	location *time.Location
+	// now returns the current time, replaced in tests
+	now func() time.Time
+	// okResp allows requests that were not checked against a key, e.g.
+	// break-glass and fail-open ones, without a cache TTL
+	okResp *envoy_service_auth_v3.CheckResponse
+	// allowResp is shared by every allow decision of a valid key; it
+	// advertises the allow cache TTL so Envoy may cache it
+	allowResp *envoy_service_auth_v3.CheckResponse
+	// keyIDHeader, when set, forwards the ID of the allowed key upstream
+	keyIDHeader string
 }
 
 // NewAuthorizationServer creates a new authorization server
-func NewAuthorizationServer(store *APIKeyStore) *AuthorizationServer {
-	return &AuthorizationServer{
-		store: store,
+func NewAuthorizationServer(store KeyStore, config *models.Config) (*AuthorizationServer, error) {
+	deny, err := newDenyRenderer(config.DenyBodyFormat, config.DenyBodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny body configuration: %w", err)
+	}
+
+	maxKeyLength := config.MaxAPIKeyLength
+	if maxKeyLength == 0 {
+		maxKeyLength = models.DefaultMaxAPIKeyLength
+	}
+
+	location := time.Local
+	if config.Timezone != "" {
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+
+	requiredHeaders := make([]string, 0, len(config.RequiredHeaders))
+	for _, name := range config.RequiredHeaders {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			requiredHeaders = append(requiredHeaders, name)
+		}
+	}
+
+	var perKey *keyMetrics
+	if config.PerKeyMetrics {
+		maxKeys := config.PerKeyMetricsMaxKeys
+		if maxKeys == 0 {
+			maxKeys = models.DefaultPerKeyMetricsMaxKeys
+		}
+		perKey = newKeyMetrics(maxKeys)
+	}
+
+	redirectStatus := config.DenyRedirectStatus
+	if redirectStatus == 0 {
+		redirectStatus = models.DefaultDenyRedirectStatus
+	}
+
+	var alerts *alertNotifier
+	if config.AlertWebhookURL != "" {
+		if alerts, err = newAlertNotifier(config.AlertWebhookURL, config.AlertReasons); err != nil {
+			return nil, fmt.Errorf("invalid config: alert-reasons: %w", err)
+		}
 	}
+
+	if config.RequiredKeyPrefix != "" {
+		if err := apikey.ValidateKeyPrefix(config.RequiredKeyPrefix); err != nil {
+			return nil, fmt.Errorf("invalid config: required-key-prefix: %w", err)
+		}
+	}
+
+	var validator *externalValidator
+	if config.ExternalValidatorURL != "" {
+		validator = newExternalValidator(config.ExternalValidatorURL, config.ExternalValidatorTimeout,
+			config.ExternalValidatorCacheTTL, config.ExternalValidatorFailOpen)
+	}
+
+	return &AuthorizationServer{
+		store:          store,
+		deny:           deny,
+		redirect:       config.DenyRedirectURL,
+		redirectStatus: redirectStatus,
+		tracer:         otel.Tracer(tracerName),
+		denials:        newDenialCounter(config.DenialWindow),
+		alerts:         alerts,
+		validator:      validator,
+		failOpen:       config.FailOpenUntilSynced,
+
+		maxKeyLength:     maxKeyLength,
+		keyPrefix:        config.RequiredKeyPrefix,
+		trustedKeyHeader: strings.ToLower(strings.TrimSpace(config.TrustedKeyHeader)),
+		verboseDeny:      config.VerboseDenyReasons,
+		breakGlassHash:   config.BreakGlassKeyHash,
+		decisionHook:     AllowAllDecisionHook,
+		okResp:           newOKResponse(config.AllowResponseHeaders),
+		allowResp:        newAllowResponse(config.AllowCacheTTL, config.AllowResponseHeaders),
+		keyIDHeader:      strings.ToLower(strings.TrimSpace(config.KeyIDHeader)),
+		requiredHeaders:  requiredHeaders,
+		slowHashes:       newSlowHashCache(),
+		keyMetrics:       perKey,
+		location:         location,
+		now:              time.Now,
+	}, nil
 }
 
 // Check implements the ext_authz Check method
 func (a *AuthorizationServer) Check(ctx context.Context, req *envoy_service_auth_v3.CheckRequest) (*envoy_service_auth_v3.CheckResponse, error) {
+	ctx, span := a.tracer.Start(ctx, "authz.Check")
+	defer span.End()
+
+	d := a.decide(ctx, req, false)
+	a.record(req, d)
+	// Building span attributes allocates, so skip it when not tracing
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String(attrDecision, d.kind))
+		if d.kind == decisionDeny {
+			span.SetAttributes(attribute.String(attrDenyReason, d.message))
+		}
+	}
+
+	switch d.kind {
+	case decisionDeny:
+		return a.denyResponse(d.reason, d.message), nil
+	case decisionAllow:
+		return a.allowResponse(d.entry), nil
+	default:
+		return a.okResp, nil
+	}
+}
+
+// Decision kinds, also recorded as the authz.decision span attribute
+const (
+	decisionPublic     = "public"
+	decisionBreakGlass = "break_glass"
+	decisionFailOpen   = "fail_open"
+	decisionAllow      = "allow"
+	decisionDeny       = "deny"
+)
+
+// decision is the outcome of checking a request
+type decision struct {
+	// kind is how the request was decided (see decision*)
+	kind string
+	// entry is the key found, if any, and keyHash the hash it was found by
+	entry   models.APIKeyEntry
+	keyHash string
+	// reason and message explain deny decisions (see DenyReason*)
+	reason  string
+	message string
+}
+
+// decide checks a request without counting or reporting the decision, so
+// that Check and the debug endpoint cannot diverge. Dry runs are not logged
+// and never call the external validator, so they leave no audit trail.
+func (a *AuthorizationServer) decide(ctx context.Context, req *envoy_service_auth_v3.CheckRequest, dryRun bool) decision {
+	// Public routes need no key at all
+	if a.routes != nil && !a.routes.requiresKey(req.GetAttributes().GetRequest().GetHttp().GetPath()) {
+		if debugEnabled() && !dryRun {
+			httpReq := req.GetAttributes().GetRequest().GetHttp()
+			debugf("Allowed without API key: public route %s %s%s", httpReq.GetMethod(), httpReq.GetHost(), httpReq.GetPath())
+		}
+		return decision{kind: decisionPublic}
+	}
+
+	// The emergency key bypasses the store entirely; every use is logged,
+	// dry runs included, so whoever holds it cannot test it unnoticed
+	if a.isBreakGlass(req) {
+		httpReq := req.GetAttributes().GetRequest().GetHttp()
+		mark := ""
+		if dryRun {
+			mark = " in a dry run (POST /debug/check)"
+		}
+		warnf("WARNING: BREAK-GLASS key used%s: %s %s%s from %s",
+			mark, httpReq.GetMethod(), httpReq.GetHost(), httpReq.GetPath(), sourceAddress(req))
+		return decision{kind: decisionBreakGlass}
+	}
+
+	// Without any synced keys every request would be denied; teams that
+	// prefer an open gateway to an outage opt into allowing them unchecked
+	if a.failOpen && a.store.LastSync().IsZero() {
+		auditWarnf(dryRun, "WARNING: Allowed without authentication: keys not synced yet (fail-open)")
+		return decision{kind: decisionFailOpen}
+	}
+
+	if debugEnabled() && !dryRun {
+		httpReq := req.GetAttributes().GetRequest().GetHttp()
+		debugf("Check: %s %s%s from %s", httpReq.GetMethod(), httpReq.GetHost(), httpReq.GetPath(), sourceAddress(req))
+	}
+	entry, keyHash, reason, message := a.authorize(ctx, req, dryRun)
+	if reason != "" {
+		return decision{kind: decisionDeny, entry: entry, keyHash: keyHash, reason: reason, message: message}
+	}
+	return decision{kind: decisionAllow, entry: entry, keyHash: keyHash}
+}
+
+// record counts a decision of Check and reports it to the events, alerts
+// and per-key metrics
+func (a *AuthorizationServer) record(req *envoy_service_auth_v3.CheckRequest, d decision) {
+	switch d.kind {
+	case decisionPublic:
+		a.publicAllows.Add(1)
+		return
+	case decisionBreakGlass:
+		a.breakGlassAllows.Add(1)
+		return
+	case decisionFailOpen:
+		a.failOpenAllows.Add(1)
+		return
+	}
+
+	// Only known keys are labelled, whatever decided
+	if a.keyMetrics != nil && d.keyHash != "" {
+		a.keyMetrics.record(d.entry.Name, d.reason == "")
+	}
+	if a.events != nil {
+		if d.reason == DenyReasonDisabled && d.keyHash != "" {
+			a.events.disabledKeyUsed(d.entry.Name, sourceAddress(req))
+		}
+		switch d.reason {
+		case DenyReasonInvalid, DenyReasonDisabled:
+			a.events.failure(sourceAddress(req))
+		case "":
+			a.events.success(sourceAddress(req))
+		}
+	}
+	if d.reason == "" {
+		return
+	}
+
+	a.denials.record(d.reason)
+	if a.alerts != nil && a.alerts.wants(d.reason) {
+		headers := req.GetAttributes().GetRequest().GetHttp().GetHeaders()
+		a.alerts.send(denialAlert{
+			Reason:    d.reason,
+			Hint:      keyHints(extractAPIKeys(headers, a.trustedKeyHeader, a.maxKeyLength)),
+			SourceIP:  sourceAddress(req),
+			Timestamp: a.now().UTC(),
+		})
+	}
+}
+
+// allowResponse returns the response allowing a request with the key of
+// entry: the shared one, or a copy also forwarding the key ID upstream.
+// Requests allowed without any key matching, e.g. because the external
+// validator failed open, get the uncached response.
+func (a *AuthorizationServer) allowResponse(entry models.APIKeyEntry) *envoy_service_auth_v3.CheckResponse {
+	if entry.KeyHash == "" {
+		return a.okResp
+	}
+	if a.keyIDHeader == "" || entry.KeyID == "" {
+		return a.allowResp
+	}
+	resp := proto.Clone(a.allowResp).(*envoy_service_auth_v3.CheckResponse)
+	ok := resp.GetOkResponse()
+	ok.Headers = append(ok.Headers, &envoy_api_v3_core.HeaderValueOption{
+		Header:       &envoy_api_v3_core.HeaderValue{Key: a.keyIDHeader, Value: entry.KeyID},
+		AppendAction: envoy_api_v3_core.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	})
+	return resp
+}
+
+// DenialCounts returns the number of denials per reason (see DenyReason*)
+func (a *AuthorizationServer) DenialCounts() map[string]int {
+	return a.denials.counts()
+}
+
+// BreakGlassAllows returns the number of requests allowed with the
+// break-glass key
+func (a *AuthorizationServer) BreakGlassAllows() int64 {
+	return a.breakGlassAllows.Load()
+}
+
+// isBreakGlass reports whether the request carries the break-glass key
+func (a *AuthorizationServer) isBreakGlass(req *envoy_service_auth_v3.CheckRequest) bool {
+	if a.breakGlassHash == "" {
+		return false
+	}
+	// The break-glass key is not exempt from the required prefix: keys
+	// lacking it are never hashed, even to compare them with this one
+	keys, _ := a.candidateKeys(req)
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(apikey.HashAPIKey(key)), []byte(a.breakGlassHash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// PublicAllows returns the number of requests allowed on paths the route
+// rules exempt from needing an API key
+func (a *AuthorizationServer) PublicAllows() int64 {
+	return a.publicAllows.Load()
+}
+
+// FailOpenAllows returns the number of requests allowed unchecked because
+// the store had not synced yet
+func (a *AuthorizationServer) FailOpenAllows() int64 {
+	return a.failOpenAllows.Load()
+}
+
+// authorize validates the request and returns the entry of the key found,
+// if any, with its hash, and the deny reason and message, or empty strings
+// if the request is allowed
+func (a *AuthorizationServer) authorize(ctx context.Context, req *envoy_service_auth_v3.CheckRequest, dryRun bool) (entry models.APIKeyEntry, keyHash, reason, message string) {
 	// Extract headers
 	headers := req.GetAttributes().GetRequest().GetHttp().GetHeaders()
 
-	// Try to get API key from headers
-	apiKey := extractAPIKey(headers)
-	if apiKey == "" {
-		log.Printf("Denied: No API key provided")
-		return denyResponse("Missing API key"), nil
+	// Try to get API keys from headers
+	apiKeys, wrongPrefix := a.candidateKeys(req)
+	if wrongPrefix && len(apiKeys) == 0 {
+		auditf(dryRun, "Denied: API key without the required prefix %s", a.keyPrefix)
+		if a.verboseDeny {
+			return entry, keyHash, DenyReasonPrefix, denyMessageUnknownKey
+		}
+		return entry, keyHash, DenyReasonPrefix, denyMessageInvalidKey
+	}
+	if len(apiKeys) == 0 {
+		auditf(dryRun, "Denied: No API key provided")
+		return entry, keyHash, DenyReasonMissing, "Missing API key"
+	}
+
+	// Validate against store, the first valid key wins
+	// Unknown and disabled keys share one message so callers cannot probe
+	// which keys exist, unless verbose deny reasons were asked for
+	var ok bool
+	if a.validator != nil {
+		var err error
+		if entry, keyHash, ok, err = a.validator.lookupFirstValid(ctx, apiKeys, dryRun); err != nil {
+			if errors.Is(err, errValidatorDryRun) {
+				return entry, keyHash, DenyReasonValidator, "API key not in the external validator cache, which dry runs do not call"
+			}
+			if a.validator.failOpen {
+				a.validator.failOpenAllows.Add(1)
+				auditWarnf(dryRun, "WARNING: Allowed without authentication: external validator failed (fail-open): %v", err)
+				return entry, keyHash, "", ""
+			}
+			auditf(dryRun, "Denied: External validator failed: %v", err)
+			return entry, keyHash, DenyReasonValidator, "API key validation unavailable"
+		}
+		if !ok {
+			auditf(dryRun, "Denied: API key refused by the external validator (hint: %s)", keyHints(apiKeys))
+		}
+	} else if entry, keyHash, ok = a.lookupFirstValid(apiKeys); !ok {
+		auditf(dryRun, "Denied: Invalid or disabled API key (hint: %s)", keyHints(apiKeys))
+	}
+	if !ok {
+		switch {
+		case !a.verboseDeny && keyHash != "":
+			return entry, keyHash, DenyReasonDisabled, denyMessageInvalidKey
+		case !a.verboseDeny:
+			return entry, keyHash, DenyReasonInvalid, denyMessageInvalidKey
+		case keyHash != "":
+			return entry, keyHash, DenyReasonDisabled, denyMessageDisabledKey
+		default:
+			return entry, keyHash, DenyReasonInvalid, denyMessageUnknownKey
+		}
+	}
+
+	// Enforce per-key schedules, e.g. business hours only; most keys have
+	// none, so the clock is only read when needed
+	if len(entry.ActiveWindows) > 0 && !entry.ActiveAt(a.now().In(a.location)) {
+		auditf(dryRun, "Denied: API key %s (id: %s) used outside its active windows", entry.Name, entry.KeyID)
+		return entry, keyHash, DenyReasonSchedule, "API key not active at this time"
+	}
+
+	// Enforce per-key method restrictions
+	method := req.GetAttributes().GetRequest().GetHttp().GetMethod()
+	if !entry.AllowsMethod(method) {
+		auditf(dryRun, "Denied: Method %s not allowed for API key %s (id: %s)", method, entry.Name, entry.KeyID)
+		return entry, keyHash, DenyReasonMethod, "Method not allowed for API key"
+	}
+
+	// Enforce the presence of required headers, e.g. for traceability
+	for _, name := range a.requiredHeaders {
+		if _, ok := headers[name]; !ok {
+			auditf(dryRun, "Denied: Missing required header %s (API key %s, id: %s)", name, entry.Name, entry.KeyID)
+			return entry, keyHash, DenyReasonHeader, "Missing required header: " + name
+		}
+	}
+
+	// Custom policy only sees requests with a valid key
+	if allow, message := a.decisionHook(ctx, req, entry); !allow {
+		if message == "" {
+			message = defaultPolicyDenyMessage
+		}
+		auditf(dryRun, "Denied: %s (API key %s, id: %s)", message, entry.Name, entry.KeyID)
+		return entry, keyHash, DenyReasonPolicy, message
 	}
 
-	// Hash the provided API key
-	keyHash := apikey.HashAPIKey(apiKey)
+	auditf(dryRun, "Allowed: Valid API key %s (id: %s)", entry.Name, entry.KeyID)
+	return entry, keyHash, "", ""
+}
+
+// candidateKeys returns the candidate API keys of a request with the
+// required prefix, if any, and whether others were dropped for lacking it.
+// Keys of another prefix cannot be valid, so they are never hashed, which
+// keeps keys sprayed by scanners cheap.
+func (a *AuthorizationServer) candidateKeys(req *envoy_service_auth_v3.CheckRequest) ([]string, bool) {
+	keys := extractAPIKeys(req.GetAttributes().GetRequest().GetHttp().GetHeaders(), a.trustedKeyHeader, a.maxKeyLength)
+	if a.keyPrefix == "" {
+		return keys, false
+	}
+	n := len(keys)
+	keys = slices.DeleteFunc(keys, func(key string) bool { return !strings.HasPrefix(key, a.keyPrefix) })
+	return keys, len(keys) < n
+}
+
+// auditf logs a decision, unless it is a dry run
+func auditf(dryRun bool, format string, args ...interface{}) {
+	if !dryRun {
+		infof(format, args...)
+	}
+}
+
+// auditWarnf logs a decision at the warn level, unless it is a dry run
+func auditWarnf(dryRun bool, format string, args ...interface{}) {
+	if !dryRun {
+		warnf(format, args...)
+	}
+}
+
+// keyHints returns the hints of the given keys, comma-separated
+func keyHints(apiKeys []string) string {
+	hints := make([]string, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		hints[i] = apikey.GenerateHint(apiKey)
+	}
+	return strings.Join(hints, ", ")
+}
+
+// sourceAddress returns the address of the downstream peer of the request
+func sourceAddress(req *envoy_service_auth_v3.CheckRequest) string {
+	return req.GetAttributes().GetSource().GetAddress().GetSocketAddress().GetAddress()
+}
+
+// lookupFirstValid returns the entry and hash of the first enabled key among
+// the candidates. When none is, it returns the hash of the first disabled
+// one, if any.
+func (a *AuthorizationServer) lookupFirstValid(apiKeys []string) (models.APIKeyEntry, string, bool) {
+	var (
+		disabled     models.APIKeyEntry
+		disabledHash string
+	)
+	for _, apiKey := range apiKeys {
+		keyHash := apikey.HashAPIKey(apiKey)
+		entry, found := a.store.Lookup(keyHash)
+		if !found {
+			entry, found = a.lookupSlowHash(apiKey, keyHash)
+		}
+		if found && entry.Enabled {
+			return entry, keyHash, true
+		}
+		if found && disabledHash == "" {
+			disabled, disabledHash = entry, keyHash
+		}
+	}
+
+	return disabled, disabledHash, false
+}
+
+// lookupSlowHash finds the argon2id entry matching a key that has no SHA-256
+// entry. Verifying is expensive, so only the argon2id entries sharing the
+// key's hint are tried, and successes are cached by the key's SHA-256.
+func (a *AuthorizationServer) lookupSlowHash(apiKey, sha string) (models.APIKeyEntry, bool) {
+	if keyHash, ok := a.slowHashes.get(sha); ok {
+		// The entry may have been deleted or rehashed since
+		if entry, found := a.store.Lookup(keyHash); found {
+			return entry, true
+		}
+	}
+
+	for _, entry := range a.store.Search(KeyQuery{Hint: apikey.GenerateHint(apiKey)}) {
+		if entry.KeyHashAlgo != models.HashAlgoArgon2id {
+			continue
+		}
+		if VerifyKey(entry, apiKey) {
+			a.slowHashes.put(sha, entry.KeyHash)
+			return entry, true
+		}
+	}
+	return models.APIKeyEntry{}, false
+}
 
-	// Validate against store
-	if !a.store.ValidateKey(keyHash) {
-		hint := apikey.GenerateHint(apiKey)
-		log.Printf("Denied: Invalid or disabled API key (hint: %s)", hint)
-		return denyResponse("Invalid or disabled API key"), nil
+// extractAPIKeys returns the candidate API keys of a request. Proxies may
+// join repeated headers with commas (x-api-key: sk-a, sk-b), so the value is
+// split and each part trimmed, keeping at most maxAPIKeyCandidates to bound
+// the hashing done per request. Generated keys are base64url encoded and
+// never contain commas.
+//
+// When present, trustedHeader (empty = none) is the only header read: a
+// trusted hop injected it, so the client's own key headers must not win.
+func extractAPIKeys(headers map[string]string, trustedHeader string, maxLen int) []string {
+	var key string
+	if value, ok := headers[trustedHeader]; ok && trustedHeader != "" {
+		key = sanitizeAPIKey(value, maxLen)
+	} else {
+		key = extractAPIKey(headers, maxLen)
+	}
+	if key == "" {
+		return nil
+	}
+	if !strings.Contains(key, ",") {
+		return []string{key}
 	}
 
-	log.Printf("Allowed: Valid API key (hash: %s...)", keyHash[:12])
-	return allowResponse(), nil
+	var keys []string
+	for _, part := range strings.Split(key, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			keys = append(keys, part)
+			if len(keys) == maxAPIKeyCandidates {
+				break
+			}
+		}
+	}
+	return keys
 }
 
 // extractAPIKey extracts the API key from request headers
 // Supports both "Authorization: Bearer <key>" and "x-api-key: <key>"
-func extractAPIKey(headers map[string]string) string {
+// Keys containing control characters or longer than maxLen bytes are
+// rejected as malformed.
+func extractAPIKey(headers map[string]string, maxLen int) string {
 	// Try Authorization header first
-	if auth, ok := headers["authorization"]; ok {
-		if strings.HasPrefix(auth, "Bearer ") {
-			return strings.TrimPrefix(auth, "Bearer ")
+	if auth, ok := headerValue(headers, apikey.HeaderAuthorization); ok {
+		if strings.HasPrefix(auth, apikey.BearerPrefix) {
+			return sanitizeAPIKey(strings.TrimPrefix(auth, apikey.BearerPrefix), maxLen)
 		}
 	}
 
 	// Try x-api-key header
-	if key, ok := headers["x-api-key"]; ok {
-		return key
+	if key, ok := headerValue(headers, apikey.HeaderAPIKey); ok {
+		return sanitizeAPIKey(key, maxLen)
 	}
 
 	return ""
 }
 
-// allowResponse returns a response that allows the request
-func allowResponse() *envoy_service_auth_v3.CheckResponse {
+// headerValue returns the value of the header name, given in lowercase with
+// hyphens. Envoy lowercases header names, but not every HTTP/2 stack does,
+// and some clients send underscores (x_api_key), so when the exact name is
+// absent, names differing only in case are tried, then those also spelling
+// hyphens as underscores. Within a rank, the lowest name wins, so the result
+// never depends on map order.
+func headerValue(headers map[string]string, name string) (string, bool) {
+	if value, ok := headers[name]; ok {
+		return value, true
+	}
+
+	var (
+		match     string
+		matchRank int
+	)
+	for key := range headers {
+		rank := headerNameRank(key, name)
+		if rank == 0 || (matchRank != 0 && (rank > matchRank || (rank == matchRank && key > match))) {
+			continue
+		}
+		match, matchRank = key, rank
+	}
+	if matchRank == 0 {
+		return "", false
+	}
+	return headers[match], true
+}
+
+// headerNameRank compares a header name to a lowercase hyphenated one: 0
+// when they differ, 1 when they only differ in case, 2 when underscores also
+// stand for hyphens
+func headerNameRank(key, name string) int {
+	if len(key) != len(name) {
+		return 0
+	}
+	rank := 1
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c == '_' && name[i] == '-' {
+			c, rank = '-', 2
+		}
+		if c != name[i] {
+			return 0
+		}
+	}
+	return rank
+}
+
+// sanitizeAPIKey returns the key unchanged, or an empty string if it is
+// longer than maxLen bytes, or contains control characters or invalid UTF-8
+func sanitizeAPIKey(key string, maxLen int) string {
+	// Checked first so oversized keys are never scanned or hashed
+	if len(key) > maxLen {
+		return ""
+	}
+	if !utf8.ValidString(key) {
+		return ""
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return ""
+		}
+	}
+	return key
+}
+
+// newOKResponse returns a response that allows the request, adding the
+// given headers to the upstream request. Responses are only read once
+// returned, so allow responses are built once and shared by every Check call
+// instead of being allocated per request; they must never be modified.
+func newOKResponse(headers map[string]string) *envoy_service_auth_v3.CheckResponse {
 	return &envoy_service_auth_v3.CheckResponse{
 		Status: &status.Status{
 			Code: int32(codes.OK),
 		},
 		HttpResponse: &envoy_service_auth_v3.CheckResponse_OkResponse{
-			OkResponse: &envoy_service_auth_v3.OkHttpResponse{},
+			OkResponse: &envoy_service_auth_v3.OkHttpResponse{
+				Headers: allowHeaders(headers),
+			},
 		},
 	}
 }
 
-// denyResponse returns a response that denies the request
-func denyResponse(message string) *envoy_service_auth_v3.CheckResponse {
+// allowHeaders converts the configured allow headers, sorted by name. They
+// overwrite headers of the same name sent by the client, so callers cannot
+// spoof them.
+func allowHeaders(headers map[string]string) []*envoy_api_v3_core.HeaderValueOption {
+	if len(headers) == 0 {
+		return nil
+	}
+	options := make([]*envoy_api_v3_core.HeaderValueOption, 0, len(headers))
+	for name, value := range headers {
+		options = append(options, &envoy_api_v3_core.HeaderValueOption{
+			Header: &envoy_api_v3_core.HeaderValue{
+				Key:   strings.ToLower(name),
+				Value: value,
+			},
+			AppendAction: envoy_api_v3_core.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].GetHeader().GetKey() < options[j].GetHeader().GetKey()
+	})
+	return options
+}
+
+// newAllowResponse returns a response that allows the request, carrying the
+// allow cache TTL as dynamic metadata when configured
+func newAllowResponse(cacheTTL time.Duration, headers map[string]string) *envoy_service_auth_v3.CheckResponse {
+	resp := newOKResponse(headers)
+	if cacheTTL > 0 {
+		resp.DynamicMetadata = &structpb.Struct{
+			Fields: map[string]*structpb.Value{
+				AllowCacheTTLMetadataKey: structpb.NewNumberValue(cacheTTL.Seconds()),
+			},
+		}
+	}
+
+	return resp
+}
+
+// denyResponse returns a response that denies the request, carrying the
+// deny reason as dynamic metadata. Requests without a valid key are
+// redirected when a redirect URL is configured.
+func (a *AuthorizationServer) denyResponse(reason, message string) *envoy_service_auth_v3.CheckResponse {
+	code := envoy_type_v3.StatusCode_Forbidden
+	var body string
+	var headers []*envoy_api_v3_core.HeaderValueOption
+	// Disabled keys and keys without the required prefix are redirected
+	// too, or the redirect would tell them apart from unknown ones
+	if a.redirect != "" && (reason == DenyReasonMissing || reason == DenyReasonInvalid || reason == DenyReasonDisabled || reason == DenyReasonPrefix) {
+		code = envoy_type_v3.StatusCode(a.redirectStatus)
+		headers = []*envoy_api_v3_core.HeaderValueOption{
+			{Header: &envoy_api_v3_core.HeaderValue{Key: "location", Value: a.redirect}},
+		}
+	} else {
+		var contentType string
+		body, contentType = a.deny.render(message, http.StatusForbidden)
+		headers = []*envoy_api_v3_core.HeaderValueOption{
+			{Header: &envoy_api_v3_core.HeaderValue{Key: "content-type", Value: contentType}},
+		}
+	}
+
 	return &envoy_service_auth_v3.CheckResponse{
+		DynamicMetadata: &structpb.Struct{
+			Fields: map[string]*structpb.Value{
+				DenyReasonMetadataKey: structpb.NewStringValue(reason),
+			},
+		},
 		Status: &status.Status{
 			Code:    int32(codes.PermissionDenied),
 			Message: message,
@@ -91,17 +789,10 @@ func denyResponse(message string) *envoy_service_auth_v3.CheckResponse {
 		HttpResponse: &envoy_service_auth_v3.CheckResponse_DeniedResponse{
 			DeniedResponse: &envoy_service_auth_v3.DeniedHttpResponse{
 				Status: &envoy_type_v3.HttpStatus{
-					Code: envoy_type_v3.StatusCode_Forbidden,
-				},
-				Body: message,
-				Headers: []*envoy_api_v3_core.HeaderValueOption{
-					{
-						Header: &envoy_api_v3_core.HeaderValue{
-							Key:   "content-type",
-							Value: "text/plain",
-						},
-					},
+					Code: code,
 				},
+				Body:    body,
+				Headers: headers,
 			},
 		},
 	}
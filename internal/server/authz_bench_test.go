@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+)
+
+// newBenchmarkCheckRequest builds an ext_authz GET CheckRequest
+func newBenchmarkCheckRequest(headers map[string]string) *envoy_service_auth_v3.CheckRequest {
+	return &envoy_service_auth_v3.CheckRequest{
+		Attributes: &envoy_service_auth_v3.AttributeContext{
+			Request: &envoy_service_auth_v3.AttributeContext_Request{
+				Http: &envoy_service_auth_v3.AttributeContext_HttpRequest{
+					Method:  "GET",
+					Path:    "/v1/models",
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+func BenchmarkCheck(b *testing.B) {
+	// Logging is I/O bound and would hide the cost of Check itself
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	const key = "sk-benchmark-key-0123456789abcdef"
+	store := newBenchmarkStore(10000)
+	store.Add(models.APIKeyEntry{Name: "bench", KeyHash: apikey.HashAPIKey(key), Enabled: true})
+
+	authz, err := NewAuthorizationServer(store, &models.Config{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"allow x-api-key", map[string]string{"x-api-key": key}},
+		{"allow bearer", map[string]string{"authorization": "Bearer " + key}},
+		{"deny unknown", map[string]string{"x-api-key": "sk-unknown-key-0123456789abcdef"}},
+		{"deny missing", map[string]string{}},
+	}
+
+	ctx := context.Background()
+	for _, c := range cases {
+		req := newBenchmarkCheckRequest(c.headers)
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := authz.Check(ctx, req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
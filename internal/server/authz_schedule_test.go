@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+)
+
+var _ = Describe("Active windows", func() {
+	const scheduledKey = "sk-scheduled-key"
+
+	var (
+		authz *AuthorizationServer
+		now   time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		authz, err = NewAuthorizationServer(NewInMemoryStore(models.APIKeyEntry{
+			Name:    "office-hours",
+			KeyHash: apikey.HashAPIKey(scheduledKey),
+			Enabled: true,
+			ActiveWindows: []models.ActiveWindow{
+				{Start: "09:00", End: "18:00", Days: []string{"Mon-Fri"}},
+			},
+		}), &models.Config{Timezone: "America/New_York"})
+		Expect(err).ToNot(HaveOccurred())
+		authz.now = func() time.Time { return now }
+	})
+
+	// check returns the status code and deny reason for the scheduled key
+	check := func() (codes.Code, string) {
+		resp, err := authz.Check(context.Background(), newSourceCheckRequest(scheduledKey, "10.0.0.1"))
+		Expect(err).ToNot(HaveOccurred())
+		return codes.Code(resp.GetStatus().GetCode()), resp.GetDynamicMetadata().GetFields()[DenyReasonMetadataKey].GetStringValue()
+	}
+
+	DescribeTable("should evaluate windows in the configured timezone",
+		func(at time.Time, allowed bool) {
+			now = at
+			code, reason := check()
+			if allowed {
+				Expect(code).To(Equal(codes.OK))
+			} else {
+				Expect(code).To(Equal(codes.PermissionDenied))
+				Expect(reason).To(Equal(DenyReasonSchedule))
+			}
+		},
+		// Monday 2025-06-02, New York is UTC-4
+		Entry("at the start of the window", time.Date(2025, 6, 2, 13, 0, 0, 0, time.UTC), true),
+		Entry("just before the window", time.Date(2025, 6, 2, 12, 59, 0, 0, time.UTC), false),
+		Entry("at the end of the window", time.Date(2025, 6, 2, 22, 0, 0, 0, time.UTC), false),
+		Entry("on a Saturday", time.Date(2025, 6, 7, 14, 0, 0, 0, time.UTC), false),
+	)
+
+	It("should count schedule denials", func() {
+		now = time.Date(2025, 6, 7, 14, 0, 0, 0, time.UTC)
+		check()
+		Expect(authz.DenialCounts()).To(HaveKeyWithValue(DenyReasonSchedule, 1))
+	})
+
+	It("should reject an unknown timezone", func() {
+		_, err := NewAuthorizationServer(NewInMemoryStore(), &models.Config{Timezone: "Mars/Olympus"})
+		Expect(err).To(MatchError(ContainSubstring("invalid timezone")))
+	})
+})
@@ -0,0 +1,976 @@
+package server_test
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	"github.com/efortin/batsign/internal/server"
+	envoy_api_v3_core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/codes"
+)
+
+// newCheckRequest builds an ext_authz GET CheckRequest carrying the given headers
+func newCheckRequest(headers map[string]string) *envoy_service_auth_v3.CheckRequest {
+	return newCheckRequestWithMethod("GET", headers)
+}
+
+// newCheckRequestWithMethod builds an ext_authz CheckRequest for the given method
+func newCheckRequestWithMethod(method string, headers map[string]string) *envoy_service_auth_v3.CheckRequest {
+	return &envoy_service_auth_v3.CheckRequest{
+		Attributes: &envoy_service_auth_v3.AttributeContext{
+			Request: &envoy_service_auth_v3.AttributeContext_Request{
+				Http: &envoy_service_auth_v3.AttributeContext_HttpRequest{
+					Method:  method,
+					Path:    "/v1/models",
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+// syncingStore is an InMemoryStore reporting no sync until synced is set
+type syncingStore struct {
+	*server.InMemoryStore
+	synced bool
+}
+
+// LastSync returns the zero time until the store is marked as synced
+func (s *syncingStore) LastSync() time.Time {
+	if !s.synced {
+		return time.Time{}
+	}
+	return s.InMemoryStore.LastSync()
+}
+
+// lookupCountingStore is an InMemoryStore counting the lookups by hash
+type lookupCountingStore struct {
+	*server.InMemoryStore
+	lookups int
+}
+
+// Lookup counts the lookup and delegates it
+func (s *lookupCountingStore) Lookup(keyHash string) (models.APIKeyEntry, bool) {
+	s.lookups++
+	return s.InMemoryStore.Lookup(keyHash)
+}
+
+var _ = Describe("AuthorizationServer", func() {
+	const (
+		validKey    = "sk-valid-key-for-tests"
+		disabledKey = "sk-disabled-key-for-tests"
+	)
+
+	var (
+		store *server.InMemoryStore
+		authz *server.AuthorizationServer
+	)
+
+	BeforeEach(func() {
+		store = server.NewInMemoryStore(
+			models.APIKeyEntry{
+				Name:    "valid",
+				Email:   "valid@example.com",
+				KeyHash: apikey.HashAPIKey(validKey),
+				Enabled: true,
+			},
+			models.APIKeyEntry{
+				Name:    "disabled",
+				Email:   "disabled@example.com",
+				KeyHash: apikey.HashAPIKey(disabledKey),
+				Enabled: false,
+			},
+		)
+		var err error
+		authz, err = server.NewAuthorizationServer(store, &models.Config{})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	check := func(headers map[string]string) *envoy_service_auth_v3.CheckResponse {
+		resp, err := authz.Check(context.Background(), newCheckRequest(headers))
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	Describe("Check", func() {
+		It("should allow a valid key in the Authorization header", func() {
+			resp := check(map[string]string{"authorization": "Bearer " + validKey})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(resp.GetOkResponse()).ToNot(BeNil())
+		})
+
+		It("should allow a valid key in the x-api-key header", func() {
+			resp := check(map[string]string{"x-api-key": validKey})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		DescribeTable("should match the key header names loosely",
+			func(headers map[string]string) {
+				Expect(check(headers).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			},
+			Entry("mixed case", map[string]string{"X-Api-Key": validKey}),
+			Entry("underscores", map[string]string{"x_api_key": validKey}),
+			Entry("upper case underscores", map[string]string{"X_API_KEY": validKey}),
+			Entry("mixed case authorization", map[string]string{"Authorization": "Bearer " + validKey}),
+			Entry("exact name over a variant", map[string]string{"x-api-key": validKey, "X-Api-Key": "sk-unknown"}),
+			Entry("case variant over an underscore one", map[string]string{"x_api_key": "sk-unknown", "X-API-KEY": validKey}),
+		)
+
+		It("should not match other headers", func() {
+			for _, name := range []string{"x-api-keys", "xapikey", "x.api.key", "x-api_key-id"} {
+				Expect(check(map[string]string{name: validKey}).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)), name)
+			}
+		})
+
+		It("should deny a request without a key", func() {
+			resp := check(map[string]string{})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Missing API key"))
+		})
+
+		It("should deny an unknown key", func() {
+			resp := check(map[string]string{"x-api-key": "sk-unknown"})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Invalid or disabled API key"))
+		})
+
+		It("should deny a disabled key", func() {
+			resp := check(map[string]string{"x-api-key": disabledKey})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+		})
+
+		It("should follow keys added to and removed from the store", func() {
+			addedKey := "sk-added-at-runtime"
+			store.Add(models.APIKeyEntry{KeyHash: apikey.HashAPIKey(addedKey), Enabled: true})
+			Expect(check(map[string]string{"x-api-key": addedKey}).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+
+			store.Remove(apikey.HashAPIKey(addedKey))
+			Expect(check(map[string]string{"x-api-key": addedKey}).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+		})
+	})
+
+	Describe("verbose deny reasons", func() {
+		DescribeTable("should tell unknown and disabled keys apart only when enabled",
+			func(verbose bool, key, reason, body string) {
+				authz, err := server.NewAuthorizationServer(store, &models.Config{VerboseDenyReasons: verbose})
+				Expect(err).ToNot(HaveOccurred())
+
+				resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": key}))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+				Expect(resp.GetDeniedResponse().GetBody()).To(Equal(body))
+				Expect(resp.GetDynamicMetadata().GetFields()[server.DenyReasonMetadataKey].GetStringValue()).To(Equal(reason))
+			},
+			Entry("unknown key by default", false, "sk-unknown", server.DenyReasonInvalid, "Invalid or disabled API key"),
+			Entry("disabled key by default", false, disabledKey, server.DenyReasonDisabled, "Invalid or disabled API key"),
+			Entry("unknown key when verbose", true, "sk-unknown", server.DenyReasonInvalid, "Unknown API key"),
+			Entry("disabled key when verbose", true, disabledKey, server.DenyReasonDisabled, "API key disabled, contact an administrator"),
+			Entry("disabled among unknown keys when verbose", true, "sk-unknown, "+disabledKey, server.DenyReasonDisabled, "API key disabled, contact an administrator"),
+		)
+	})
+
+	Describe("method restrictions", func() {
+		const readOnlyKey = "sk-read-only-key-for-tests"
+
+		BeforeEach(func() {
+			store.Add(models.APIKeyEntry{
+				Name:           "read-only",
+				KeyHash:        apikey.HashAPIKey(readOnlyKey),
+				Enabled:        true,
+				AllowedMethods: []string{"GET", "HEAD"},
+			})
+		})
+
+		checkMethod := func(method, key string) *envoy_service_auth_v3.CheckResponse {
+			resp, err := authz.Check(context.Background(), newCheckRequestWithMethod(method, map[string]string{"x-api-key": key}))
+			Expect(err).ToNot(HaveOccurred())
+			return resp
+		}
+
+		It("should allow a listed method", func() {
+			Expect(checkMethod("GET", readOnlyKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(checkMethod("HEAD", readOnlyKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		It("should compare methods case-insensitively", func() {
+			Expect(checkMethod("get", readOnlyKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		It("should deny an unlisted method with a distinct reason", func() {
+			resp := checkMethod("POST", readOnlyKey)
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Method not allowed for API key"))
+		})
+
+		It("should allow any method for an unrestricted key", func() {
+			Expect(checkMethod("DELETE", validKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+	})
+
+	Describe("allow cache TTL", func() {
+		checkWith := func(config *models.Config, headers map[string]string) *envoy_service_auth_v3.CheckResponse {
+			authz, err := server.NewAuthorizationServer(store, config)
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := authz.Check(context.Background(), newCheckRequest(headers))
+			Expect(err).ToNot(HaveOccurred())
+			return resp
+		}
+
+		It("should not advertise a TTL by default", func() {
+			resp := checkWith(&models.Config{}, map[string]string{"x-api-key": validKey})
+			Expect(resp.GetDynamicMetadata()).To(BeNil())
+		})
+
+		It("should advertise the TTL on allow decisions", func() {
+			resp := checkWith(&models.Config{AllowCacheTTL: 30 * time.Second}, map[string]string{"x-api-key": validKey})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(resp.GetDynamicMetadata().GetFields()).To(HaveKey(server.AllowCacheTTLMetadataKey))
+			Expect(resp.GetDynamicMetadata().GetFields()[server.AllowCacheTTLMetadataKey].GetNumberValue()).To(Equal(30.0))
+		})
+
+		It("should never advertise a TTL on deny decisions", func() {
+			resp := checkWith(&models.Config{AllowCacheTTL: 30 * time.Second}, map[string]string{"x-api-key": disabledKey})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(resp.GetDynamicMetadata().GetFields()).ToNot(HaveKey(server.AllowCacheTTLMetadataKey))
+		})
+	})
+
+	Describe("allow response headers", func() {
+		var configured *server.AuthorizationServer
+
+		BeforeEach(func() {
+			var err error
+			configured, err = server.NewAuthorizationServer(store, &models.Config{
+				AllowResponseHeaders: map[string]string{"X-Gateway": "batsign", "x-env": "prod"},
+				BreakGlassKeyHash:    apikey.HashAPIKey("sk-break-glass"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		// okHeaders returns the headers added by an allow as name: value
+		okHeaders := func(resp *envoy_service_auth_v3.CheckResponse) []string {
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			var headers []string
+			for _, option := range resp.GetOkResponse().GetHeaders() {
+				Expect(option.GetAppendAction()).To(Equal(envoy_api_v3_core.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD))
+				headers = append(headers, option.GetHeader().GetKey()+": "+option.GetHeader().GetValue())
+			}
+			return headers
+		}
+
+		It("should add the configured headers on allow", func() {
+			resp, err := configured.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey, "x-env": "spoofed"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(okHeaders(resp)).To(Equal([]string{"x-env: prod", "x-gateway: batsign"}))
+		})
+
+		It("should add them to break-glass allows too", func() {
+			resp, err := configured.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": "sk-break-glass"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(okHeaders(resp)).To(Equal([]string{"x-env: prod", "x-gateway: batsign"}))
+		})
+
+		It("should add no headers by default", func() {
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(okHeaders(resp)).To(BeEmpty())
+		})
+	})
+
+	Describe("key ID", func() {
+		var (
+			configured *server.AuthorizationServer
+			logs       *gbytes.Buffer
+			// validID is the ID of validKey
+			validID = apikey.KeyID(apikey.HashAPIKey(validKey))
+		)
+
+		BeforeEach(func() {
+			var err error
+			configured, err = server.NewAuthorizationServer(store, &models.Config{
+				AllowResponseHeaders: map[string]string{"x-env": "prod"},
+				KeyIDHeader:          "X-API-Key-ID",
+				BreakGlassKeyHash:    apikey.HashAPIKey("sk-break-glass"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			logs = gbytes.NewBuffer()
+			log.SetOutput(logs)
+			DeferCleanup(log.SetOutput, os.Stderr)
+		})
+
+		// headerValues returns the values of the given header added by resp
+		headerValues := func(resp *envoy_service_auth_v3.CheckResponse, name string) []string {
+			var values []string
+			for _, option := range resp.GetOkResponse().GetHeaders() {
+				if option.GetHeader().GetKey() == name {
+					values = append(values, option.GetHeader().GetValue())
+				}
+			}
+			return values
+		}
+
+		It("should be the same in the logs and the upstream header of a matched key", func() {
+			Expect(validID).To(MatchRegexp(`^[a-f0-9]{12}$`))
+			for range 2 {
+				resp, err := configured.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey, "x-api-key-id": "spoofed"}))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+				Expect(headerValues(resp, "x-api-key-id")).To(Equal([]string{validID}))
+				Expect(headerValues(resp, "x-env")).To(Equal([]string{"prod"}))
+				Expect(logs).To(gbytes.Say(`Allowed: Valid API key valid \(id: ` + validID + `\)`))
+			}
+		})
+
+		It("should be logged on denials of a matched key", func() {
+			store.Add(models.APIKeyEntry{Name: "valid", KeyHash: apikey.HashAPIKey(validKey), Enabled: true, AllowedMethods: []string{"POST"}})
+
+			resp, err := configured.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(logs).To(gbytes.Say(`not allowed for API key valid \(id: ` + validID + `\)`))
+		})
+
+		It("should not be forwarded without a matched key", func() {
+			resp, err := configured.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": "sk-break-glass"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(headerValues(resp, "x-api-key-id")).To(BeEmpty())
+		})
+
+		It("should not be forwarded by default", func() {
+			resp := check(map[string]string{"x-api-key": validKey})
+			Expect(headerValues(resp, "x-api-key-id")).To(BeEmpty())
+		})
+	})
+
+	Describe("required headers", func() {
+		BeforeEach(func() {
+			var err error
+			authz, err = server.NewAuthorizationServer(store, &models.Config{RequiredHeaders: []string{"X-Request-Id", "x-tenant"}})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should allow a valid key with every required header", func() {
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{
+				"x-api-key": validKey, "x-request-id": "abc", "x-tenant": "",
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		It("should deny a valid key missing a required header with a distinct reason", func() {
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{
+				"x-api-key": validKey, "x-request-id": "abc",
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Missing required header: x-tenant"))
+			Expect(authz.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonHeader, 1))
+		})
+
+		It("should validate the key first", func() {
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": "sk-unknown"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Invalid or disabled API key"))
+			Expect(authz.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonInvalid, 1))
+		})
+
+		It("should not require any header by default", func() {
+			authz, err := server.NewAuthorizationServer(store, &models.Config{})
+			Expect(err).ToNot(HaveOccurred())
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+	})
+
+	Describe("decision hook", func() {
+		// denyFromOutside denies requests not marked as internal
+		denyFromOutside := func(_ context.Context, req *envoy_service_auth_v3.CheckRequest, entry models.APIKeyEntry) (bool, string) {
+			if req.GetAttributes().GetRequest().GetHttp().GetHeaders()["x-internal"] != "true" {
+				return false, "Key " + entry.Name + " is internal only"
+			}
+			return true, ""
+		}
+
+		It("should allow valid keys with the default hook", func() {
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		It("should deny with the hook's reason", func() {
+			authz.SetDecisionHook(denyFromOutside)
+
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Key valid is internal only"))
+			Expect(authz.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonPolicy, 1))
+
+			resp, err = authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey, "x-internal": "true"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		It("should only consult the hook for valid keys", func() {
+			called := false
+			authz.SetDecisionHook(func(context.Context, *envoy_service_auth_v3.CheckRequest, models.APIKeyEntry) (bool, string) {
+				called = true
+				return true, ""
+			})
+
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": disabledKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(called).To(BeFalse())
+		})
+
+		It("should use a default reason when the hook gives none", func() {
+			authz.SetDecisionHook(func(context.Context, *envoy_service_auth_v3.CheckRequest, models.APIKeyEntry) (bool, string) {
+				return false, ""
+			})
+
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Denied by policy"))
+		})
+	})
+
+	Describe("comma-separated keys", func() {
+		check := func(value string) *envoy_service_auth_v3.CheckResponse {
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": value}))
+			Expect(err).ToNot(HaveOccurred())
+			return resp
+		}
+
+		It("should allow a single key with surrounding separators", func() {
+			Expect(check(" , " + validKey + " ,").GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		It("should allow when any of multiple keys is valid", func() {
+			Expect(check("sk-unknown, " + validKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(check(disabledKey + "," + validKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		It("should deny when all keys are invalid", func() {
+			resp := check("sk-unknown-a, sk-unknown-b")
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Invalid or disabled API key"))
+			Expect(authz.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonInvalid, 1))
+		})
+
+		It("should report a disabled key among invalid ones", func() {
+			Expect(check("sk-unknown, " + disabledKey).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(authz.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonDisabled, 1))
+		})
+
+		It("should only try the first few keys", func() {
+			Expect(check("sk-a, sk-b, sk-c, sk-d, " + validKey).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+		})
+
+		It("should treat separators alone as a missing key", func() {
+			check(" , ,")
+			Expect(authz.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonMissing, 1))
+		})
+	})
+
+	Describe("trusted key header", func() {
+		var trusted *server.AuthorizationServer
+
+		BeforeEach(func() {
+			var err error
+			trusted, err = server.NewAuthorizationServer(store, &models.Config{TrustedKeyHeader: "X-Mesh-Api-Key"})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		check := func(headers map[string]string) int32 {
+			resp, err := trusted.Check(context.Background(), newCheckRequest(headers))
+			Expect(err).ToNot(HaveOccurred())
+			return resp.GetStatus().GetCode()
+		}
+
+		It("should take precedence over the client key headers", func() {
+			Expect(check(map[string]string{"x-mesh-api-key": validKey, "authorization": "Bearer sk-unknown"})).To(Equal(int32(codes.OK)))
+			Expect(check(map[string]string{"x-mesh-api-key": validKey, "x-api-key": "sk-unknown"})).To(Equal(int32(codes.OK)))
+		})
+
+		It("should win even if authorization holds a valid key", func() {
+			Expect(check(map[string]string{"x-mesh-api-key": "sk-unknown", "authorization": "Bearer " + validKey})).To(Equal(int32(codes.PermissionDenied)))
+			Expect(check(map[string]string{"x-mesh-api-key": "", "authorization": "Bearer " + validKey})).To(Equal(int32(codes.PermissionDenied)))
+			Expect(trusted.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonInvalid, 1))
+			Expect(trusted.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonMissing, 1))
+		})
+
+		It("should fall back to the client key headers when absent", func() {
+			Expect(check(map[string]string{"authorization": "Bearer " + validKey})).To(Equal(int32(codes.OK)))
+		})
+	})
+
+	Describe("key length limit", func() {
+		It("should reject an oversized key as missing, before hashing it", func() {
+			oversized := "sk-" + strings.Repeat("a", models.DefaultMaxAPIKeyLength)
+			store.Add(models.APIKeyEntry{KeyHash: apikey.HashAPIKey(oversized), Enabled: true})
+
+			resp := check(map[string]string{"x-api-key": oversized})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(authz.DenialCounts()[server.DenyReasonMissing]).To(Equal(1))
+			Expect(authz.DenialCounts()[server.DenyReasonInvalid]).To(BeZero())
+
+			resp = check(map[string]string{"authorization": "Bearer " + oversized})
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(authz.DenialCounts()[server.DenyReasonMissing]).To(Equal(2))
+		})
+
+		It("should honor a configured maximum", func() {
+			limited, err := server.NewAuthorizationServer(store, &models.Config{MaxAPIKeyLength: len(validKey) - 1})
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := limited.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(limited.DenialCounts()[server.DenyReasonMissing]).To(Equal(1))
+		})
+
+		It("should accept a key at the maximum", func() {
+			limited, err := server.NewAuthorizationServer(store, &models.Config{MaxAPIKeyLength: len(validKey)})
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := limited.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+	})
+
+	Describe("required key prefix", func() {
+		const tenantKey = "acme_valid-key-for-tests"
+
+		var counting *lookupCountingStore
+
+		// newPrefixAuthz creates a server requiring prefix, counting lookups
+		newPrefixAuthz := func(prefix string) *server.AuthorizationServer {
+			store.Add(models.APIKeyEntry{Name: "tenant", KeyHash: apikey.HashAPIKey(tenantKey), Enabled: true})
+			counting = &lookupCountingStore{InMemoryStore: store}
+			prefixed, err := server.NewAuthorizationServer(counting, &models.Config{RequiredKeyPrefix: prefix})
+			Expect(err).ToNot(HaveOccurred())
+			return prefixed
+		}
+
+		checkWith := func(a *server.AuthorizationServer, key string) *envoy_service_auth_v3.CheckResponse {
+			resp, err := a.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": key}))
+			Expect(err).ToNot(HaveOccurred())
+			return resp
+		}
+
+		It("should allow keys with the prefix", func() {
+			prefixed := newPrefixAuthz("acme_")
+			Expect(checkWith(prefixed, tenantKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(counting.lookups).To(Equal(1))
+		})
+
+		It("should deny other keys without looking them up", func() {
+			prefixed := newPrefixAuthz("acme_")
+			resp := checkWith(prefixed, validKey)
+			Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Invalid or disabled API key"))
+			Expect(prefixed.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonPrefix, 1))
+			Expect(prefixed.DenialCounts()[server.DenyReasonInvalid]).To(BeZero())
+			Expect(counting.lookups).To(BeZero())
+		})
+
+		It("should only look up the candidates with the prefix", func() {
+			prefixed := newPrefixAuthz("acme_")
+			Expect(checkWith(prefixed, validKey+", "+tenantKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(counting.lookups).To(Equal(1))
+		})
+
+		It("should accept any prefix when empty", func() {
+			prefixed := newPrefixAuthz("")
+			Expect(checkWith(prefixed, validKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(checkWith(prefixed, tenantKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		})
+
+		It("should require the prefix of the break-glass key too", func() {
+			const prefixedBreakGlass = "acme_break-glass-for-tests"
+			prefixed, err := server.NewAuthorizationServer(store, &models.Config{
+				RequiredKeyPrefix: "acme_",
+				BreakGlassKeyHash: apikey.HashAPIKey("sk-break-glass-for-tests"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checkWith(prefixed, "sk-break-glass-for-tests").GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(prefixed.BreakGlassAllows()).To(BeZero())
+			Expect(prefixed.DenialCounts()).To(HaveKeyWithValue(server.DenyReasonPrefix, 1))
+
+			prefixed, err = server.NewAuthorizationServer(store, &models.Config{
+				RequiredKeyPrefix: "acme_",
+				BreakGlassKeyHash: apikey.HashAPIKey(prefixedBreakGlass),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checkWith(prefixed, prefixedBreakGlass).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(prefixed.BreakGlassAllows()).To(BeEquivalentTo(1))
+		})
+
+		It("should reject a prefix keys cannot start with", func() {
+			_, err := server.NewAuthorizationServer(store, &models.Config{RequiredKeyPrefix: "acme,"})
+			Expect(err).To(MatchError(ContainSubstring("required-key-prefix")))
+		})
+	})
+
+	Describe("break-glass key", func() {
+		const breakGlassKey = "sk-break-glass-for-tests"
+
+		var emergency *server.AuthorizationServer
+
+		BeforeEach(func() {
+			var err error
+			emergency, err = server.NewAuthorizationServer(server.NewInMemoryStore(), &models.Config{
+				BreakGlassKeyHash: apikey.HashAPIKey(breakGlassKey),
+				AllowCacheTTL:     30 * time.Second,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		checkEmergency := func(method string, headers map[string]string) *envoy_service_auth_v3.CheckResponse {
+			resp, err := emergency.Check(context.Background(), newCheckRequestWithMethod(method, headers))
+			Expect(err).ToNot(HaveOccurred())
+			return resp
+		}
+
+		It("should allow the break-glass key with an empty store", func() {
+			Expect(checkEmergency("GET", map[string]string{"x-api-key": breakGlassKey}).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(checkEmergency("DELETE", map[string]string{"authorization": "Bearer " + breakGlassKey}).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(emergency.BreakGlassAllows()).To(Equal(int64(2)))
+		})
+
+		It("should never let Envoy cache a break-glass allow", func() {
+			Expect(checkEmergency("GET", map[string]string{"x-api-key": breakGlassKey}).GetDynamicMetadata()).To(BeNil())
+		})
+
+		It("should keep denying other keys", func() {
+			Expect(checkEmergency("GET", map[string]string{"x-api-key": validKey}).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(checkEmergency("GET", map[string]string{}).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(emergency.BreakGlassAllows()).To(BeZero())
+		})
+
+		It("should be disabled when unset", func() {
+			Expect(check(map[string]string{"x-api-key": breakGlassKey}).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			Expect(authz.BreakGlassAllows()).To(BeZero())
+		})
+	})
+
+	Describe("fail-open until synced", func() {
+		var unsynced *syncingStore
+
+		BeforeEach(func() {
+			unsynced = &syncingStore{InMemoryStore: store}
+		})
+
+		checkWith := func(config *models.Config) (*server.AuthorizationServer, func(map[string]string) int32) {
+			authz, err := server.NewAuthorizationServer(unsynced, config)
+			Expect(err).ToNot(HaveOccurred())
+			return authz, func(headers map[string]string) int32 {
+				resp, err := authz.Check(context.Background(), newCheckRequest(headers))
+				Expect(err).ToNot(HaveOccurred())
+				return resp.GetStatus().GetCode()
+			}
+		}
+
+		It("should deny requests before the first sync by default", func() {
+			authz, check := checkWith(&models.Config{})
+
+			Expect(check(map[string]string{})).To(Equal(int32(codes.PermissionDenied)))
+			Expect(check(map[string]string{"x-api-key": "sk-unknown"})).To(Equal(int32(codes.PermissionDenied)))
+			Expect(check(map[string]string{"x-api-key": validKey})).To(Equal(int32(codes.OK)))
+			Expect(authz.FailOpenAllows()).To(BeZero())
+		})
+
+		It("should allow and count every request before the first sync when enabled", func() {
+			authz, check := checkWith(&models.Config{FailOpenUntilSynced: true, AllowCacheTTL: 30 * time.Second})
+
+			Expect(check(map[string]string{})).To(Equal(int32(codes.OK)))
+			Expect(check(map[string]string{"x-api-key": disabledKey})).To(Equal(int32(codes.OK)))
+			Expect(authz.FailOpenAllows()).To(Equal(int64(2)))
+			Expect(authz.DenialCounts()[server.DenyReasonMissing]).To(BeZero())
+
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetDynamicMetadata()).To(BeNil(), "fail-open allows must not be cached")
+		})
+
+		It("should enforce API keys once synced when enabled", func() {
+			authz, check := checkWith(&models.Config{FailOpenUntilSynced: true})
+			unsynced.synced = true
+
+			Expect(check(map[string]string{})).To(Equal(int32(codes.PermissionDenied)))
+			Expect(check(map[string]string{"x-api-key": disabledKey})).To(Equal(int32(codes.PermissionDenied)))
+			Expect(check(map[string]string{"x-api-key": validKey})).To(Equal(int32(codes.OK)))
+			Expect(authz.FailOpenAllows()).To(BeZero())
+		})
+	})
+
+	Describe("deny reason metadata", func() {
+		BeforeEach(func() {
+			store.Add(models.APIKeyEntry{
+				Name:           "read-only",
+				KeyHash:        apikey.HashAPIKey("sk-read-only-key-for-tests"),
+				Enabled:        true,
+				AllowedMethods: []string{"GET"},
+			})
+		})
+
+		DescribeTable("should carry the reason of each deny",
+			func(method string, headers map[string]string, hook server.DecisionHook, want string) {
+				if hook != nil {
+					authz.SetDecisionHook(hook)
+				}
+				resp, err := authz.Check(context.Background(), newCheckRequestWithMethod(method, headers))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+				Expect(resp.GetDynamicMetadata().GetFields()[server.DenyReasonMetadataKey].GetStringValue()).To(Equal(want))
+			},
+			Entry("missing key", "GET", map[string]string{}, nil, server.DenyReasonMissing),
+			Entry("malformed key", "GET", map[string]string{"x-api-key": "sk-\x00"}, nil, server.DenyReasonMissing),
+			Entry("unknown key", "GET", map[string]string{"x-api-key": "sk-unknown"}, nil, server.DenyReasonInvalid),
+			Entry("disabled key", "GET", map[string]string{"x-api-key": disabledKey}, nil, server.DenyReasonDisabled),
+			Entry("unlisted method", "POST", map[string]string{"x-api-key": "sk-read-only-key-for-tests"}, nil, server.DenyReasonMethod),
+			Entry("policy hook", "GET", map[string]string{"x-api-key": validKey},
+				server.DecisionHook(func(context.Context, *envoy_service_auth_v3.CheckRequest, models.APIKeyEntry) (bool, string) {
+					return false, "Outside business hours"
+				}), server.DenyReasonPolicy),
+		)
+
+		It("should not reveal the reason to the caller", func() {
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": disabledKey}))
+			Expect(err).ToNot(HaveOccurred())
+			for _, header := range resp.GetDeniedResponse().GetHeaders() {
+				Expect(header.GetHeader().GetValue()).ToNot(ContainSubstring(server.DenyReasonDisabled))
+			}
+			Expect(resp.GetDeniedResponse().GetBody()).To(Equal("Invalid or disabled API key"))
+		})
+
+		It("should carry no metadata on allow decisions by default", func() {
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.GetDynamicMetadata()).To(BeNil())
+		})
+	})
+
+	Describe("denial counts", func() {
+		It("should count denials per reason", func() {
+			store.Add(models.APIKeyEntry{KeyHash: apikey.HashAPIKey("sk-post-only"), Enabled: true, AllowedMethods: []string{"POST"}})
+
+			check(map[string]string{})
+			check(map[string]string{"x-api-key": "sk-unknown"})
+			check(map[string]string{"x-api-key": "sk-unknown-too"})
+			check(map[string]string{"x-api-key": disabledKey})
+			check(map[string]string{"x-api-key": "sk-post-only"})
+			check(map[string]string{"x-api-key": validKey})
+
+			Expect(authz.DenialCounts()).To(Equal(map[string]int{
+				server.DenyReasonMissing:  1,
+				server.DenyReasonInvalid:  2,
+				server.DenyReasonDisabled: 1,
+				server.DenyReasonMethod:   1,
+			}))
+		})
+
+		It("should keep the same message for unknown and disabled keys", func() {
+			unknown := check(map[string]string{"x-api-key": "sk-unknown"})
+			disabled := check(map[string]string{"x-api-key": disabledKey})
+			Expect(disabled.GetDeniedResponse().GetBody()).To(Equal(unknown.GetDeniedResponse().GetBody()))
+		})
+	})
+
+	Describe("tracing", func() {
+		var (
+			recorder *tracetest.SpanRecorder
+			traced   *server.AuthorizationServer
+		)
+
+		BeforeEach(func() {
+			previous := otel.GetTracerProvider()
+			recorder = tracetest.NewSpanRecorder()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+			DeferCleanup(func() { otel.SetTracerProvider(previous) })
+
+			var err error
+			traced, err = server.NewAuthorizationServer(store, &models.Config{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		attributes := func(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+			attrs := map[attribute.Key]string{}
+			for _, kv := range span.Attributes() {
+				attrs[kv.Key] = kv.Value.AsString()
+			}
+			return attrs
+		}
+
+		It("should record the allow decision", func() {
+			_, err := traced.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": validKey}))
+			Expect(err).ToNot(HaveOccurred())
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name()).To(Equal("authz.Check"))
+			Expect(attributes(spans[0])).To(Equal(map[attribute.Key]string{"authz.decision": "allow"}))
+		})
+
+		It("should record the deny reason", func() {
+			_, err := traced.Check(context.Background(), newCheckRequest(map[string]string{"x-api-key": disabledKey}))
+			Expect(err).ToNot(HaveOccurred())
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(1))
+			Expect(attributes(spans[0])).To(Equal(map[attribute.Key]string{
+				"authz.decision":    "deny",
+				"authz.deny_reason": "Invalid or disabled API key",
+			}))
+		})
+
+		It("should continue the incoming trace", func() {
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "envoy")
+			_, err := traced.Check(ctx, newCheckRequest(map[string]string{}))
+			Expect(err).ToNot(HaveOccurred())
+			parent.End()
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(2))
+			Expect(spans[0].Parent().SpanID()).To(Equal(parent.SpanContext().SpanID()))
+			Expect(spans[0].SpanContext().TraceID()).To(Equal(parent.SpanContext().TraceID()))
+		})
+	})
+
+	Describe("deny body", func() {
+		denyWith := func(config *models.Config) *envoy_service_auth_v3.DeniedHttpResponse {
+			authz, err := server.NewAuthorizationServer(store, config)
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := authz.Check(context.Background(), newCheckRequest(map[string]string{}))
+			Expect(err).ToNot(HaveOccurred())
+			return resp.GetDeniedResponse()
+		}
+
+		contentType := func(denied *envoy_service_auth_v3.DeniedHttpResponse) string {
+			for _, h := range denied.GetHeaders() {
+				if h.GetHeader().GetKey() == "content-type" {
+					return h.GetHeader().GetValue()
+				}
+			}
+			return ""
+		}
+
+		It("should return the plain reason by default", func() {
+			denied := denyWith(&models.Config{})
+			Expect(denied.GetBody()).To(Equal("Missing API key"))
+			Expect(contentType(denied)).To(Equal("text/plain"))
+		})
+
+		It("should return a JSON error in json format", func() {
+			denied := denyWith(&models.Config{DenyBodyFormat: server.DenyBodyFormatJSON})
+			Expect(denied.GetBody()).To(MatchJSON(`{"error":{"code":"forbidden","message":"Missing API key"}}`))
+			Expect(contentType(denied)).To(Equal("application/json"))
+		})
+
+		DescribeTable("should return problem details in problem+json format",
+			func(method string, headers map[string]string, detail string) {
+				authz, err := server.NewAuthorizationServer(store, &models.Config{DenyBodyFormat: server.DenyBodyFormatProblemJSON})
+				Expect(err).ToNot(HaveOccurred())
+				store.Add(models.APIKeyEntry{Name: "read-only", KeyHash: apikey.HashAPIKey("sk-read-only"), Enabled: true, AllowedMethods: []string{"GET"}})
+
+				resp, err := authz.Check(context.Background(), newCheckRequestWithMethod(method, headers))
+				Expect(err).ToNot(HaveOccurred())
+				denied := resp.GetDeniedResponse()
+				Expect(contentType(denied)).To(Equal("application/problem+json"))
+				Expect(denied.GetBody()).To(MatchJSON(`{"type":"about:blank","title":"Forbidden","status":403,"detail":` + strconv.Quote(detail) + `}`))
+			},
+			Entry("missing key", "GET", map[string]string{}, "Missing API key"),
+			Entry("unknown key", "GET", map[string]string{"x-api-key": "sk-unknown"}, "Invalid or disabled API key"),
+			Entry("disabled key", "GET", map[string]string{"x-api-key": disabledKey}, "Invalid or disabled API key"),
+			Entry("method not allowed", "POST", map[string]string{"x-api-key": "sk-read-only"}, "Method not allowed for API key"),
+		)
+
+		It("should render a plain template", func() {
+			denied := denyWith(&models.Config{DenyBodyTemplate: "Access denied ({{ .Status }})"})
+			Expect(denied.GetBody()).To(Equal("Access denied (403)"))
+			Expect(contentType(denied)).To(Equal("text/plain"))
+		})
+
+		It("should render a JSON template", func() {
+			denied := denyWith(&models.Config{
+				DenyBodyFormat:   server.DenyBodyFormatJSON,
+				DenyBodyTemplate: `{"status":{{ .Status }},"detail":{{ json .Reason }}}`,
+			})
+			Expect(denied.GetBody()).To(MatchJSON(`{"status":403,"detail":"Missing API key"}`))
+			Expect(contentType(denied)).To(Equal("application/json"))
+		})
+
+		location := func(denied *envoy_service_auth_v3.DeniedHttpResponse) string {
+			for _, h := range denied.GetHeaders() {
+				if h.GetHeader().GetKey() == "location" {
+					return h.GetHeader().GetValue()
+				}
+			}
+			return ""
+		}
+
+		DescribeTable("should redirect requests without a valid key when configured",
+			func(config *models.Config, headers map[string]string, status envoy_type_v3.StatusCode) {
+				authz, err := server.NewAuthorizationServer(store, config)
+				Expect(err).ToNot(HaveOccurred())
+
+				resp, err := authz.Check(context.Background(), newCheckRequest(headers))
+				Expect(err).ToNot(HaveOccurred())
+				denied := resp.GetDeniedResponse()
+				Expect(denied.GetStatus().GetCode()).To(Equal(status))
+				Expect(location(denied)).To(Equal("https://docs.example.com/keys"))
+				Expect(denied.GetBody()).To(BeEmpty())
+				Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+			},
+			Entry("missing key", &models.Config{DenyRedirectURL: "https://docs.example.com/keys"}, map[string]string{}, envoy_type_v3.StatusCode_Found),
+			Entry("unknown key", &models.Config{DenyRedirectURL: "https://docs.example.com/keys"}, map[string]string{"x-api-key": "sk-unknown"}, envoy_type_v3.StatusCode_Found),
+			Entry("disabled key", &models.Config{DenyRedirectURL: "https://docs.example.com/keys"}, map[string]string{"x-api-key": disabledKey}, envoy_type_v3.StatusCode_Found),
+			Entry("key without the required prefix", &models.Config{DenyRedirectURL: "https://docs.example.com/keys", RequiredKeyPrefix: "sk-"}, map[string]string{"x-api-key": "pk-unknown"}, envoy_type_v3.StatusCode_Found),
+			Entry("configured status", &models.Config{DenyRedirectURL: "https://docs.example.com/keys", DenyRedirectStatus: 303}, map[string]string{}, envoy_type_v3.StatusCode_SeeOther),
+		)
+
+		It("should still answer 403 to keys denied for other reasons", func() {
+			authz, err := server.NewAuthorizationServer(store, &models.Config{DenyRedirectURL: "https://docs.example.com/keys"})
+			Expect(err).ToNot(HaveOccurred())
+			store.Add(models.APIKeyEntry{Name: "read-only", KeyHash: apikey.HashAPIKey("sk-read-only"), Enabled: true, AllowedMethods: []string{"GET"}})
+
+			resp, err := authz.Check(context.Background(), newCheckRequestWithMethod("POST", map[string]string{"x-api-key": "sk-read-only"}))
+			Expect(err).ToNot(HaveOccurred())
+			denied := resp.GetDeniedResponse()
+			Expect(denied.GetStatus().GetCode()).To(Equal(envoy_type_v3.StatusCode_Forbidden))
+			Expect(location(denied)).To(BeEmpty())
+			Expect(denied.GetBody()).To(Equal("Method not allowed for API key"))
+		})
+
+		DescribeTable("should reject invalid configuration",
+			func(config *models.Config) {
+				_, err := server.NewAuthorizationServer(store, config)
+				Expect(err).To(MatchError(ContainSubstring("invalid deny body configuration")))
+			},
+			Entry("unknown format", &models.Config{DenyBodyFormat: "xml"}),
+			Entry("unparsable template", &models.Config{DenyBodyTemplate: "{{ .Reason "}),
+			Entry("unknown template field", &models.Config{DenyBodyTemplate: "{{ .Unknown }}"}),
+		)
+	})
+})
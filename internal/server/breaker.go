@@ -0,0 +1,102 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Watch breaker states, as reported on /stats
+const (
+	breakerClosed   = 0
+	breakerHalfOpen = 1
+	breakerOpen     = 2
+)
+
+// breakerProbeWait is how often a watcher checks back while another one
+// probes a half-open breaker
+const breakerProbeWait = time.Second
+
+// watchBreaker stops the watchers from re-establishing their watch while the
+// API server keeps failing. After threshold consecutive failures it opens for
+// cooldown, then lets a single attempt through (half-open): success closes it,
+// failure opens it again. The cache keeps serving the last synced keys
+// meanwhile.
+type watchBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state    int
+	failures int
+	openedAt time.Time
+	// probing is set while the half-open attempt is in flight
+	probing bool
+	trips   int
+}
+
+// newWatchBreaker creates a breaker opening after threshold consecutive
+// failures (0 = never)
+func newWatchBreaker(threshold int, cooldown time.Duration) *watchBreaker {
+	return &watchBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow returns how long to wait before asking again, or 0 when a watch may
+// be attempted now
+func (b *watchBreaker) allow() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if remaining := b.openedAt.Add(b.cooldown).Sub(b.now()); remaining > 0 {
+			return remaining
+		}
+		infof("Watch circuit breaker half-open, probing the API server")
+		b.state, b.probing = breakerHalfOpen, true
+		return 0
+	case breakerHalfOpen:
+		if b.probing {
+			return breakerProbeWait
+		}
+		b.probing = true
+		return 0
+	default:
+		return 0
+	}
+}
+
+// success records an established watch, closing the breaker
+func (b *watchBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		infof("Watch circuit breaker closed, the API server recovered")
+	}
+	b.state, b.failures, b.probing = breakerClosed, 0, false
+}
+
+// failure records a failed watch attempt, opening the breaker once the
+// threshold is reached or when the half-open probe failed
+func (b *watchBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.threshold <= 0 || (b.state == breakerClosed && b.failures < b.threshold) {
+		return
+	}
+	b.state, b.openedAt, b.probing = breakerOpen, b.now(), false
+	b.trips++
+	warnf("WARNING: watch circuit breaker open after %d consecutive failures, retrying in %s; "+
+		"serving the cached keys, which are stale until the watch recovers", b.failures, b.cooldown)
+}
+
+// stats returns the breaker state and how many times it opened
+func (b *watchBreaker) stats() (state, trips int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state, b.trips
+}
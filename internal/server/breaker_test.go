@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("watchBreaker", func() {
+	var (
+		breaker *watchBreaker
+		now     time.Time
+		logs    *gbytes.Buffer
+	)
+
+	BeforeEach(func() {
+		breaker = newWatchBreaker(3, time.Minute)
+		now = time.Now()
+		breaker.now = func() time.Time { return now }
+		logs = gbytes.NewBuffer()
+		log.SetOutput(logs)
+		DeferCleanup(log.SetOutput, os.Stderr)
+	})
+
+	// fail records n consecutive failures, each after an allowed attempt
+	fail := func(n int) {
+		for range n {
+			Expect(breaker.allow()).To(BeZero())
+			breaker.failure()
+		}
+	}
+
+	It("should open after the threshold and recover through half-open", func() {
+		fail(2)
+		state, _ := breaker.stats()
+		Expect(state).To(Equal(breakerClosed))
+
+		fail(1)
+		state, trips := breaker.stats()
+		Expect(state).To(Equal(breakerOpen))
+		Expect(trips).To(Equal(1))
+		Expect(logs).To(gbytes.Say("open after 3 consecutive failures.*stale"))
+		Expect(breaker.allow()).To(Equal(time.Minute))
+
+		now = now.Add(time.Minute)
+		Expect(breaker.allow()).To(BeZero())
+		state, _ = breaker.stats()
+		Expect(state).To(Equal(breakerHalfOpen))
+		// A single probe at a time
+		Expect(breaker.allow()).To(Equal(breakerProbeWait))
+
+		breaker.success()
+		state, _ = breaker.stats()
+		Expect(state).To(Equal(breakerClosed))
+		Expect(logs).To(gbytes.Say("closed"))
+		Expect(breaker.allow()).To(BeZero())
+	})
+
+	It("should reopen when the half-open probe fails", func() {
+		fail(3)
+		now = now.Add(time.Minute)
+		fail(1)
+
+		state, trips := breaker.stats()
+		Expect(state).To(Equal(breakerOpen))
+		Expect(trips).To(Equal(2))
+		Expect(breaker.allow()).To(Equal(time.Minute))
+	})
+
+	It("should reset the count on success", func() {
+		fail(2)
+		breaker.success()
+		fail(2)
+		state, _ := breaker.stats()
+		Expect(state).To(Equal(breakerClosed))
+	})
+
+	It("should never open without a threshold", func() {
+		breaker = newWatchBreaker(0, time.Minute)
+		fail(100)
+		state, _ := breaker.stats()
+		Expect(state).To(Equal(breakerClosed))
+	})
+
+	It("should pause the store watch and keep serving the cache", func() {
+		cachedHash := apikey.HashAPIKey("sk-cached")
+		client := newFakeDynamicClient(newAPIKeyObject("cached", cachedHash, true))
+		var down atomic.Bool
+		var watches atomic.Int32
+		down.Store(true)
+		client.PrependWatchReactor("apikeys", func(k8stesting.Action) (bool, watch.Interface, error) {
+			watches.Add(1)
+			if down.Load() {
+				return true, nil, apierrors.NewServiceUnavailable("etcd is down")
+			}
+			return true, watch.NewFake(), nil
+		})
+		store := newAPIKeyStore(client, &models.Config{WatchBreakerFailures: 3, WatchBreakerCooldown: 500 * time.Millisecond})
+		DeferCleanup(store.Stop)
+
+		Expect(store.Start(context.Background())).To(Succeed())
+		Eventually(func() int { return store.GetStats()["watchBreakerState"] }).Should(Equal(breakerOpen))
+		Consistently(watches.Load, 100*time.Millisecond).Should(Equal(int32(3)))
+		Expect(store.ValidateKey(cachedHash)).To(BeTrue())
+
+		down.Store(false)
+		Eventually(func() int { return store.GetStats()["watchBreakerState"] }).Should(Equal(breakerClosed))
+		Expect(watches.Load()).To(Equal(int32(4)))
+		Expect(store.GetStats()["watchBreakerTrips"]).To(Equal(1))
+	})
+})
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	envoy_api_v3_core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/gin-gonic/gin"
+)
+
+// debugCheckRequest is the body of POST /debug/check: the parts of an
+// ext_authz CheckRequest that decisions depend on
+type debugCheckRequest struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Host     string            `json:"host,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	SourceIP string            `json:"sourceIp,omitempty"`
+}
+
+// debugCheckResponse is the decision returned by POST /debug/check
+type debugCheckResponse struct {
+	// Decision is public, break_glass, fail_open, allow or deny
+	Decision string `json:"decision"`
+	Allowed  bool   `json:"allowed"`
+	// Reason and Message explain denials; the message is the one callers
+	// get
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Key is the key the request was decided with, if any
+	Key *keyMetadata `json:"key,omitempty"`
+}
+
+// newCheckRequest builds the CheckRequest Envoy would send for the request.
+// Envoy lowercases header names, so they are lowercased here too.
+func (r debugCheckRequest) newCheckRequest() *envoy_service_auth_v3.CheckRequest {
+	headers := make(map[string]string, len(r.Headers))
+	for name, value := range r.Headers {
+		headers[strings.ToLower(name)] = value
+	}
+	return &envoy_service_auth_v3.CheckRequest{
+		Attributes: &envoy_service_auth_v3.AttributeContext{
+			Source: &envoy_service_auth_v3.AttributeContext_Peer{
+				Address: &envoy_api_v3_core.Address{
+					Address: &envoy_api_v3_core.Address_SocketAddress{
+						SocketAddress: &envoy_api_v3_core.SocketAddress{Address: r.SourceIP},
+					},
+				},
+			},
+			Request: &envoy_service_auth_v3.AttributeContext_Request{
+				Http: &envoy_service_auth_v3.AttributeContext_HttpRequest{
+					Method:  strings.ToUpper(r.Method),
+					Host:    r.Host,
+					Path:    r.Path,
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+// debugCheckHandler decides on a synthetic request as the ext_authz Check
+// would, e.g. to validate method, header or route rules from integration
+// tests without a gRPC client:
+// POST /debug/check {"method":"GET","path":"/api","headers":{"x-api-key":"sk-..."}}
+// The decision is not counted, and the external validator is only read from
+// its cache, so denials, alerts, events and per-key metrics only reflect real
+// traffic. Only break-glass matches are logged, marked as a dry run; other
+// decisions stay out of the audit log.
+func (s *Server) debugCheckHandler(c *gin.Context) {
+	var req debugCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+		return
+	}
+	if req.Method == "" || !strings.HasPrefix(req.Path, "/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "method and path (starting with /) are required"})
+		return
+	}
+
+	d := s.authz.decide(c.Request.Context(), req.newCheckRequest(), true)
+	resp := debugCheckResponse{
+		Decision: d.kind,
+		Allowed:  d.kind != decisionDeny,
+		Reason:   d.reason,
+		Message:  d.message,
+	}
+	if d.entry.KeyHash != "" {
+		key := newKeyMetadata(d.entry)
+		resp.Key = &key
+	}
+	c.JSON(http.StatusOK, resp)
+}
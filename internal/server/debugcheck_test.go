@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Debug check endpoint", func() {
+	const (
+		adminToken    = "operator-token"
+		validKey      = "sk-debug-check-valid"
+		disabledKey   = "sk-debug-check-disabled"
+		readOnlyKey   = "sk-debug-check-read-only"
+		scheduledKey  = "sk-debug-check-scheduled"
+		breakGlassKey = "sk-debug-check-break-glass"
+	)
+
+	var srv *Server
+
+	BeforeEach(func() {
+		var err error
+		srv, err = NewWithStore(&models.Config{
+			GRPCPort:          9191,
+			HTTPPort:          8080,
+			LogLevel:          "info",
+			AdminAPIEnabled:   true,
+			AdminToken:        adminToken,
+			BreakGlassKeyHash: apikey.HashAPIKey(breakGlassKey),
+		}, NewInMemoryStore(
+			models.APIKeyEntry{Name: "valid", KeyHash: apikey.HashAPIKey(validKey), KeyID: "0123456789ab", Enabled: true},
+			models.APIKeyEntry{Name: "disabled", KeyHash: apikey.HashAPIKey(disabledKey), Enabled: false},
+			models.APIKeyEntry{Name: "read-only", KeyHash: apikey.HashAPIKey(readOnlyKey), Enabled: true, AllowedMethods: []string{"GET"}},
+			models.APIKeyEntry{
+				Name: "office-hours", KeyHash: apikey.HashAPIKey(scheduledKey), Enabled: true,
+				ActiveWindows: []models.ActiveWindow{{Start: "09:00", End: "18:00", Days: []string{"Mon-Fri"}}},
+			},
+		))
+		Expect(err).ToNot(HaveOccurred())
+		// A Sunday
+		srv.authz.now = func() time.Time { return time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC) }
+	})
+
+	// post sends POST /debug/check with the body and headers
+	post := func(body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/debug/check", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// check returns the decision for a request with the admin token
+	check := func(req debugCheckRequest) debugCheckResponse {
+		body, err := json.Marshal(req)
+		Expect(err).ToNot(HaveOccurred())
+		rec := post(string(body), map[string]string{"Authorization": "Bearer " + adminToken})
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+
+		var resp debugCheckResponse
+		Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+		return resp
+	}
+
+	// withKey returns a request from 10.0.0.1 with key in X-Api-Key
+	withKey := func(method, key string) debugCheckRequest {
+		return debugCheckRequest{
+			Method:   method,
+			Path:     "/api/orders",
+			Headers:  map[string]string{"X-Api-Key": key},
+			SourceIP: "10.0.0.1",
+		}
+	}
+
+	It("should allow a valid key, returning its metadata", func() {
+		resp := check(withKey("GET", validKey))
+		Expect(resp.Decision).To(Equal(decisionAllow))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Reason).To(BeEmpty())
+		Expect(resp.Key).ToNot(BeNil())
+		Expect(resp.Key.Name).To(Equal("valid"))
+		Expect(resp.Key.KeyID).To(Equal("0123456789ab"))
+	})
+
+	DescribeTable("should deny as the ext_authz check does",
+		func(req debugCheckRequest, reason, message string) {
+			resp := check(req)
+			Expect(resp.Decision).To(Equal(decisionDeny))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Reason).To(Equal(reason))
+			Expect(resp.Message).To(Equal(message))
+		},
+		Entry("missing key", debugCheckRequest{Method: "GET", Path: "/api/orders"}, DenyReasonMissing, "Missing API key"),
+		Entry("unknown key", withKey("GET", "sk-debug-check-unknown"), DenyReasonInvalid, denyMessageInvalidKey),
+		Entry("disabled key", withKey("GET", disabledKey), DenyReasonDisabled, denyMessageInvalidKey),
+		Entry("method not allowed", withKey("delete", readOnlyKey), DenyReasonMethod, "Method not allowed for API key"),
+		Entry("outside the active windows", withKey("GET", scheduledKey), DenyReasonSchedule, "API key not active at this time"),
+	)
+
+	It("should match the decision of the gRPC check", func() {
+		for _, req := range []debugCheckRequest{withKey("GET", validKey), withKey("POST", readOnlyKey), withKey("GET", disabledKey)} {
+			resp, err := srv.authz.Check(context.Background(), req.newCheckRequest())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(check(req).Allowed).To(Equal(resp.GetOkResponse() != nil), "%s %s", req.Method, req.Headers)
+		}
+	})
+
+	It("should enforce required headers, whatever their case", func() {
+		srv.authz.requiredHeaders = []string{"x-request-id"}
+		Expect(check(withKey("GET", validKey)).Reason).To(Equal(DenyReasonHeader))
+
+		req := withKey("GET", validKey)
+		req.Headers["X-Request-Id"] = "debug-1"
+		Expect(check(req).Allowed).To(BeTrue())
+	})
+
+	It("should apply the decision hook", func() {
+		srv.authz.SetDecisionHook(func(_ context.Context, req *envoy_service_auth_v3.CheckRequest, _ models.APIKeyEntry) (bool, string) {
+			return req.GetAttributes().GetSource().GetAddress().GetSocketAddress().GetAddress() != "10.0.0.1", "Source not allowed"
+		})
+		resp := check(withKey("GET", validKey))
+		Expect(resp.Reason).To(Equal(DenyReasonPolicy))
+		Expect(resp.Message).To(Equal("Source not allowed"))
+
+		req := withKey("GET", validKey)
+		req.SourceIP = "10.0.0.2"
+		Expect(check(req).Allowed).To(BeTrue())
+	})
+
+	It("should allow public routes without a key", func() {
+		requireKey := false
+		srv.authz.routes = &routeRules{}
+		srv.authz.routes.set([]routeRule{{Path: "/public/*", RequireKey: &requireKey}})
+
+		resp := check(debugCheckRequest{Method: "GET", Path: "/public/status"})
+		Expect(resp.Decision).To(Equal(decisionPublic))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(check(debugCheckRequest{Method: "GET", Path: "/public/../api"}).Reason).To(Equal(DenyReasonMissing))
+	})
+
+	It("should allow the break-glass key", func() {
+		resp := check(withKey("DELETE", breakGlassKey))
+		Expect(resp.Decision).To(Equal(decisionBreakGlass))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Key).To(BeNil())
+	})
+
+	It("should not count the decisions", func() {
+		check(withKey("GET", "sk-debug-check-unknown"))
+		check(withKey("DELETE", breakGlassKey))
+		Expect(srv.authz.DenialCounts()).To(HaveKeyWithValue(DenyReasonInvalid, 0))
+		Expect(srv.authz.BreakGlassAllows()).To(BeZero())
+	})
+
+	It("should only write audit logs for the break-glass key, marked as a dry run", func() {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		DeferCleanup(log.SetOutput, os.Stderr)
+
+		check(withKey("GET", validKey))
+		check(withKey("GET", "sk-debug-check-unknown"))
+		check(withKey("delete", readOnlyKey))
+		Expect(logs.String()).ToNot(ContainSubstring("Allowed:"))
+		Expect(logs.String()).ToNot(ContainSubstring("Denied:"))
+
+		check(withKey("DELETE", breakGlassKey))
+		Expect(logs.String()).To(ContainSubstring("WARNING: BREAK-GLASS key used in a dry run (POST /debug/check): DELETE /api/orders from 10.0.0.1"))
+	})
+
+	It("should only read the external validator's cache", func() {
+		var calls atomic.Int32
+		validator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			defer GinkgoRecover()
+			Expect(json.NewEncoder(w).Encode(validatorResponse{Allow: true, Name: "partner"})).To(Succeed())
+		}))
+		DeferCleanup(validator.Close)
+		srv.authz.validator = newExternalValidator(validator.URL, 0, 0, false)
+
+		resp := check(withKey("GET", validKey))
+		Expect(resp.Reason).To(Equal(DenyReasonValidator))
+		Expect(calls.Load()).To(BeZero())
+
+		// A real check caches the decision, which dry runs then use
+		_, err := srv.authz.Check(context.Background(), withKey("GET", validKey).newCheckRequest())
+		Expect(err).ToNot(HaveOccurred())
+		resp = check(withKey("GET", validKey))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Key.Name).To(Equal("partner"))
+		Expect(calls.Load()).To(BeEquivalentTo(1))
+		Expect(srv.authz.validator.cacheHits.Load()).To(BeZero())
+	})
+
+	DescribeTable("should reject invalid requests",
+		func(body string) {
+			Expect(post(body, map[string]string{"Authorization": "Bearer " + adminToken}).Code).To(Equal(http.StatusBadRequest))
+		},
+		Entry("not JSON", `GET /api`),
+		Entry("no method", `{"path":"/api"}`),
+		Entry("relative path", `{"method":"GET","path":"api"}`),
+	)
+
+	It("should require the admin token", func() {
+		Expect(post(`{"method":"GET","path":"/api"}`, nil).Code).To(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Deny reasons counted by the denial tracker
+const (
+	DenyReasonMissing  = "missing"
+	DenyReasonInvalid  = "invalid"
+	DenyReasonDisabled = "disabled"
+	DenyReasonMethod   = "method"
+)
+
+// Deny reasons of optional checks, only reported once recorded
+const (
+	// DenyReasonPolicy counts denials by a DecisionHook
+	DenyReasonPolicy = "policy"
+	// DenyReasonHeader counts requests lacking a required header
+	DenyReasonHeader = "header"
+	// DenyReasonSchedule counts keys used outside their active windows
+	DenyReasonSchedule = "schedule"
+	// DenyReasonValidator counts requests denied because the external
+	// validator failed
+	DenyReasonValidator = "validator"
+	// DenyReasonPrefix counts keys lacking the required prefix
+	DenyReasonPrefix = "prefix"
+)
+
+// denialBuckets is the number of buckets a rolling window is split into
+const denialBuckets = 60
+
+// denialBucket holds the denials recorded during one slice of the window
+type denialBucket struct {
+	start  time.Time
+	counts map[string]int
+}
+
+// denialCounter counts denials per reason, either since startup
+// (window = 0) or over a rolling window
+type denialCounter struct {
+	mu      sync.Mutex
+	window  time.Duration
+	now     func() time.Time
+	total   map[string]int
+	buckets []denialBucket
+}
+
+// newDenialCounter creates a counter; a zero window counts cumulatively
+func newDenialCounter(window time.Duration) *denialCounter {
+	return &denialCounter{
+		window: window,
+		now:    time.Now,
+		total:  make(map[string]int),
+	}
+}
+
+// record counts one denial for the given reason
+func (d *denialCounter) record(reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.window <= 0 {
+		d.total[reason]++
+		return
+	}
+
+	now := d.now()
+	start := now.Truncate(d.bucketSize())
+	if n := len(d.buckets); n == 0 || !d.buckets[n-1].start.Equal(start) {
+		d.buckets = append(d.buckets, denialBucket{start: start, counts: make(map[string]int)})
+	}
+	d.buckets[len(d.buckets)-1].counts[reason]++
+	d.pruneLocked(now)
+}
+
+// counts returns the denials per reason, including reasons never recorded
+func (d *denialCounter) counts() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	counts := map[string]int{
+		DenyReasonMissing:  0,
+		DenyReasonInvalid:  0,
+		DenyReasonDisabled: 0,
+		DenyReasonMethod:   0,
+	}
+
+	if d.window <= 0 {
+		for reason, n := range d.total {
+			counts[reason] += n
+		}
+		return counts
+	}
+
+	d.pruneLocked(d.now())
+	for _, bucket := range d.buckets {
+		for reason, n := range bucket.counts {
+			counts[reason] += n
+		}
+	}
+	return counts
+}
+
+// bucketSize is the duration covered by one bucket
+func (d *denialCounter) bucketSize() time.Duration {
+	size := d.window / denialBuckets
+	if size <= 0 {
+		size = time.Nanosecond
+	}
+	return size
+}
+
+// pruneLocked drops the buckets that fell out of the window
+func (d *denialCounter) pruneLocked(now time.Time) {
+	cutoff := now.Add(-d.window)
+	i := 0
+	for i < len(d.buckets) && !d.buckets[i].start.After(cutoff) {
+		i++
+	}
+	d.buckets = d.buckets[i:]
+}
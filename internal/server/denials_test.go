@@ -0,0 +1,49 @@
+package server
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("denialCounter", func() {
+	var now time.Time
+
+	BeforeEach(func() {
+		now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	newCounter := func(window time.Duration) *denialCounter {
+		counter := newDenialCounter(window)
+		counter.now = func() time.Time { return now }
+		return counter
+	}
+
+	It("should count cumulatively without a window", func() {
+		counter := newCounter(0)
+		counter.record(DenyReasonMissing)
+		now = now.Add(24 * time.Hour)
+		counter.record(DenyReasonMissing)
+
+		Expect(counter.counts()[DenyReasonMissing]).To(Equal(2))
+	})
+
+	It("should forget denials older than the window", func() {
+		counter := newCounter(time.Minute)
+		counter.record(DenyReasonInvalid)
+		now = now.Add(30 * time.Second)
+		counter.record(DenyReasonInvalid)
+		counter.record(DenyReasonDisabled)
+
+		Expect(counter.counts()[DenyReasonInvalid]).To(Equal(2))
+
+		now = now.Add(45 * time.Second)
+		counts := counter.counts()
+		Expect(counts[DenyReasonInvalid]).To(Equal(1))
+		Expect(counts[DenyReasonDisabled]).To(Equal(1))
+
+		now = now.Add(time.Minute)
+		Expect(counter.counts()[DenyReasonInvalid]).To(BeZero())
+	})
+})
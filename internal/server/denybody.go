@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// Deny body formats
+const (
+	// DenyBodyFormatPlain returns the deny reason as text/plain (default)
+	DenyBodyFormatPlain = "plain"
+	// DenyBodyFormatJSON returns {"error":{"code":...,"message":...}} as application/json
+	DenyBodyFormatJSON = "json"
+	// DenyBodyFormatProblemJSON returns an RFC 9457 problem details object
+	// as application/problem+json
+	DenyBodyFormatProblemJSON = "problem+json"
+)
+
+// denyBodyData is the data available to deny body templates
+type denyBodyData struct {
+	Reason string
+	Status int
+}
+
+// denyBodyFuncs are the functions available to deny body templates
+var denyBodyFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. {"message": {{ json .Reason }}}
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// denyRenderer renders the body of denied responses
+type denyRenderer struct {
+	format string
+	tmpl   *template.Template
+}
+
+// newDenyRenderer validates the deny body format and template
+func newDenyRenderer(format, tmpl string) (*denyRenderer, error) {
+	switch format {
+	case "":
+		format = DenyBodyFormatPlain
+	case DenyBodyFormatPlain, DenyBodyFormatJSON, DenyBodyFormatProblemJSON:
+	default:
+		return nil, fmt.Errorf("unknown deny body format %q (expected %s, %s or %s)", format,
+			DenyBodyFormatPlain, DenyBodyFormatJSON, DenyBodyFormatProblemJSON)
+	}
+
+	r := &denyRenderer{format: format}
+	if tmpl != "" {
+		parsed, err := template.New("deny-body").Funcs(denyBodyFuncs).Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse deny body template: %w", err)
+		}
+		// Execute once against sample data to catch references to unknown fields
+		if err := parsed.Execute(&bytes.Buffer{}, denyBodyData{Reason: "sample", Status: http.StatusForbidden}); err != nil {
+			return nil, fmt.Errorf("failed to execute deny body template: %w", err)
+		}
+		r.tmpl = parsed
+	}
+
+	return r, nil
+}
+
+// render returns the body and content type for a denied response
+func (r *denyRenderer) render(reason string, status int) (string, string) {
+	contentType := "text/plain"
+	switch r.format {
+	case DenyBodyFormatJSON:
+		contentType = "application/json"
+	case DenyBodyFormatProblemJSON:
+		contentType = "application/problem+json"
+	}
+
+	if r.tmpl != nil {
+		var buf bytes.Buffer
+		err := r.tmpl.Execute(&buf, denyBodyData{Reason: reason, Status: status})
+		if err == nil {
+			return buf.String(), contentType
+		}
+		log.Printf("Failed to render deny body template, using default body: %v", err)
+	}
+
+	switch r.format {
+	case DenyBodyFormatJSON:
+		body, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]string{
+				"code":    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+				"message": reason,
+			},
+		})
+		return string(body), contentType
+	case DenyBodyFormatProblemJSON:
+		// about:blank keeps the type the same for every deny reason, so the
+		// body tells no more than the plain reason does
+		body, _ := json.Marshal(map[string]interface{}{
+			"type":   "about:blank",
+			"title":  http.StatusText(status),
+			"status": status,
+			"detail": reason,
+		})
+		return string(body), contentType
+	}
+
+	return reason, contentType
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Kubernetes Event reasons emitted for suspicious key usage
+const (
+	// EventReasonRepeatedFailures is emitted when a source crosses
+	// eventFailureThreshold consecutive failed validations
+	EventReasonRepeatedFailures = "RepeatedFailedValidations"
+	// EventReasonDisabledKeyUsed is emitted when a disabled key is presented
+	EventReasonDisabledKeyUsed = "DisabledKeyUsed"
+)
+
+const (
+	// eventFailureThreshold is the number of consecutive failed validations
+	// from one source that triggers an event
+	eventFailureThreshold = 10
+	// eventInterval is the minimum delay between two events about the same
+	// source or key
+	eventInterval = 5 * time.Minute
+	// eventQPS and eventBurst bound the events created across all subjects
+	eventQPS   = 1
+	eventBurst = 5
+	// eventMaxSources bounds the sources tracked for consecutive failures
+	eventMaxSources = 10000
+	// eventTimeout bounds a single event creation
+	eventTimeout = 10 * time.Second
+	// eventComponent identifies the server as the source of its events
+	eventComponent = "batsign-server"
+	// serviceAccountNamespaceFile holds the pod namespace in a cluster
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+var eventGVR = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+
+// eventEmitter creates Kubernetes Events about suspicious key usage, e.g.
+// for `kubectl get events`. Events are attached to the server's Pod and
+// rate limited so abuse cannot turn into API server load.
+type eventEmitter struct {
+	client    dynamic.Interface
+	namespace string
+	pod       string
+	limiter   flowcontrol.RateLimiter
+	now       func() time.Time
+
+	mu sync.Mutex
+	// failures counts consecutive failed validations per source address
+	failures map[string]int
+	// lastEvent is when an event was last emitted per subject
+	lastEvent map[string]time.Time
+	// wg tracks the events being created
+	wg sync.WaitGroup
+}
+
+// newEventEmitter creates an emitter attaching events to the given Pod
+func newEventEmitter(client dynamic.Interface, namespace, pod string) *eventEmitter {
+	return &eventEmitter{
+		client:    client,
+		namespace: namespace,
+		pod:       pod,
+		limiter:   flowcontrol.NewTokenBucketRateLimiter(eventQPS, eventBurst),
+		now:       time.Now,
+		failures:  make(map[string]int),
+		lastEvent: make(map[string]time.Time),
+	}
+}
+
+// podIdentity returns the namespace and name of the server's Pod, from the
+// POD_NAMESPACE and POD_NAME variables set through the downward API, or else
+// from the service account and the hostname
+func podIdentity() (string, string) {
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		pod, _ = os.Hostname()
+	}
+	return namespace, pod
+}
+
+// failure records a failed validation from source, emitting an event when
+// the source reaches the threshold
+func (e *eventEmitter) failure(source string) {
+	e.mu.Lock()
+	if _, tracked := e.failures[source]; !tracked && len(e.failures) >= eventMaxSources {
+		// Bound memory under address spraying; counts restart from zero
+		e.failures = make(map[string]int)
+	}
+	e.failures[source]++
+	count := e.failures[source]
+	if count < eventFailureThreshold {
+		e.mu.Unlock()
+		return
+	}
+	delete(e.failures, source)
+	e.mu.Unlock()
+
+	e.emit("source/"+source, EventReasonRepeatedFailures,
+		fmt.Sprintf("%d consecutive failed API key validations from %s", count, source))
+}
+
+// success resets the consecutive failures of source
+func (e *eventEmitter) success(source string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.failures, source)
+}
+
+// disabledKeyUsed emits an event for a disabled key being presented
+func (e *eventEmitter) disabledKeyUsed(name, source string) {
+	e.emit("key/"+name, EventReasonDisabledKeyUsed,
+		fmt.Sprintf("Disabled API key %s used from %s", name, source))
+}
+
+// emit creates a Warning event in the background, unless one was emitted
+// for the same subject recently or the global rate is exceeded
+func (e *eventEmitter) emit(subject, reason, message string) {
+	e.mu.Lock()
+	now := e.now()
+	if last, ok := e.lastEvent[subject]; ok && now.Sub(last) < eventInterval {
+		e.mu.Unlock()
+		return
+	}
+	if !e.limiter.TryAccept() {
+		e.mu.Unlock()
+		return
+	}
+	e.lastEvent[subject] = now
+	for s, last := range e.lastEvent {
+		if now.Sub(last) >= eventInterval {
+			delete(e.lastEvent, s)
+		}
+	}
+	e.mu.Unlock()
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.create(reason, message, now)
+	}()
+}
+
+// create creates a Warning event attached to the server's Pod
+func (e *eventEmitter) create(reason, message string, now time.Time) {
+	timestamp := now.UTC().Format(time.RFC3339)
+	event := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			"generateName": e.pod + ".",
+			"namespace":    e.namespace,
+		},
+		"involvedObject": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"name":       e.pod,
+			"namespace":  e.namespace,
+		},
+		"type":               "Warning",
+		"reason":             reason,
+		"message":            message,
+		"count":              int64(1),
+		"firstTimestamp":     timestamp,
+		"lastTimestamp":      timestamp,
+		"source":             map[string]interface{}{"component": eventComponent},
+		"reportingComponent": eventComponent,
+		"reportingInstance":  e.pod,
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+	if _, err := e.client.Resource(eventGVR).Namespace(e.namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		warnf("Warning: failed to create %s event: %v", reason, err)
+	}
+}
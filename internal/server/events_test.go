@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	envoy_api_v3_core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newSourceCheckRequest builds a CheckRequest with a key from a source address
+func newSourceCheckRequest(key, source string) *envoy_service_auth_v3.CheckRequest {
+	return &envoy_service_auth_v3.CheckRequest{
+		Attributes: &envoy_service_auth_v3.AttributeContext{
+			Source: &envoy_service_auth_v3.AttributeContext_Peer{
+				Address: &envoy_api_v3_core.Address{
+					Address: &envoy_api_v3_core.Address_SocketAddress{
+						SocketAddress: &envoy_api_v3_core.SocketAddress{Address: source},
+					},
+				},
+			},
+			Request: &envoy_service_auth_v3.AttributeContext_Request{
+				Http: &envoy_service_auth_v3.AttributeContext_HttpRequest{
+					Method:  "GET",
+					Headers: map[string]string{"x-api-key": key},
+				},
+			},
+		},
+	}
+}
+
+var _ = Describe("Kubernetes events", func() {
+	const (
+		validKey    = "sk-valid-key-for-events"
+		disabledKey = "sk-disabled-key-for-events"
+	)
+
+	var (
+		client  *dynamicfake.FakeDynamicClient
+		emitter *eventEmitter
+		authz   *AuthorizationServer
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		client = newFakeDynamicClient()
+		emitter = newEventEmitter(client, "batsign", "batsign-server-0")
+		now = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+		emitter.now = func() time.Time { return now }
+
+		var err error
+		authz, err = NewAuthorizationServer(NewInMemoryStore(
+			models.APIKeyEntry{Name: "valid", KeyHash: apikey.HashAPIKey(validKey), Enabled: true},
+			models.APIKeyEntry{Name: "disabled", KeyHash: apikey.HashAPIKey(disabledKey), Enabled: false},
+		), &models.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		authz.events = emitter
+	})
+
+	// check sends count requests with key from source
+	check := func(count int, key, source string) {
+		for i := 0; i < count; i++ {
+			_, err := authz.Check(context.Background(), newSourceCheckRequest(key, source))
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	// createdEvents waits for pending events and returns the created ones
+	createdEvents := func() []*unstructured.Unstructured {
+		emitter.wg.Wait()
+		var events []*unstructured.Unstructured
+		for _, action := range client.Actions() {
+			if create, ok := action.(k8stesting.CreateAction); ok && create.GetResource() == eventGVR {
+				events = append(events, create.GetObject().(*unstructured.Unstructured))
+			}
+		}
+		return events
+	}
+
+	It("should emit an event once a source crosses the failure threshold", func() {
+		check(eventFailureThreshold-1, "sk-unknown", "10.0.0.1")
+		Expect(createdEvents()).To(BeEmpty())
+
+		check(1, "sk-unknown", "10.0.0.1")
+		events := createdEvents()
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].GetNamespace()).To(Equal("batsign"))
+		Expect(events[0].Object).To(HaveKeyWithValue("reason", EventReasonRepeatedFailures))
+		Expect(events[0].Object).To(HaveKeyWithValue("type", "Warning"))
+		Expect(events[0].Object["message"]).To(ContainSubstring("from 10.0.0.1"))
+		Expect(events[0].Object["involvedObject"]).To(HaveKeyWithValue("name", "batsign-server-0"))
+	})
+
+	It("should only count consecutive failures", func() {
+		check(eventFailureThreshold-1, "sk-unknown", "10.0.0.1")
+		check(1, validKey, "10.0.0.1")
+		check(eventFailureThreshold-1, "sk-unknown", "10.0.0.1")
+		Expect(createdEvents()).To(BeEmpty())
+	})
+
+	It("should count failures per source", func() {
+		check(eventFailureThreshold-1, "sk-unknown", "10.0.0.1")
+		check(eventFailureThreshold-1, "sk-unknown", "10.0.0.2")
+		Expect(createdEvents()).To(BeEmpty())
+	})
+
+	It("should emit an event when a disabled key is used", func() {
+		check(1, disabledKey, "10.0.0.1")
+		events := createdEvents()
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Object).To(HaveKeyWithValue("reason", EventReasonDisabledKeyUsed))
+		Expect(events[0].Object["message"]).To(ContainSubstring("Disabled API key disabled used from 10.0.0.1"))
+	})
+
+	It("should rate limit events about the same subject", func() {
+		check(3, disabledKey, "10.0.0.1")
+		check(3*eventFailureThreshold, "sk-unknown", "10.0.0.2")
+		Expect(createdEvents()).To(HaveLen(2))
+
+		now = now.Add(eventInterval)
+		check(1, disabledKey, "10.0.0.1")
+		Expect(createdEvents()).To(HaveLen(3))
+	})
+
+	It("should bound the events created across subjects", func() {
+		for i := 0; i < 2*eventBurst; i++ {
+			emitter.disabledKeyUsed(string(rune('a'+i)), "10.0.0.1")
+		}
+		Expect(createdEvents()).To(HaveLen(eventBurst))
+	})
+})
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileReloadDelay groups the burst of events an editor or an atomic
+// replacement produces into a single reload
+const fileReloadDelay = 100 * time.Millisecond
+
+var _ KeyStore = (*FileStore)(nil)
+
+// FileStore is a KeyStore serving the keys of a local YAML/JSON file (see
+// LoadKeysFile), reloaded whenever the file changes. It lets the server run
+// standalone, e.g. on air-gapped or edge hosts without Kubernetes.
+type FileStore struct {
+	*InMemoryStore
+
+	path    string
+	watcher *fsnotify.Watcher
+	stopCh  chan struct{}
+
+	// startMu and started make Start idempotent; stopOnce makes Stop safe
+	// to call twice
+	startMu  sync.Mutex
+	started  bool
+	stopOnce sync.Once
+}
+
+// NewFileStore loads the keys file, failing if it is invalid
+func NewFileStore(path string) (*FileStore, error) {
+	entries, err := LoadKeysFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{
+		InMemoryStore: NewInMemoryStore(entries...),
+		path:          filepath.Clean(path),
+		stopCh:        make(chan struct{}),
+	}, nil
+}
+
+// Start watches the keys file for changes. The parent directory is watched
+// so that files replaced atomically (editors, mounted ConfigMaps) are followed.
+// Further calls do nothing once it succeeded.
+func (s *FileStore) Start(ctx context.Context) error {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+	if s.started {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch keys file: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch keys file: %w", err)
+	}
+	s.watcher = watcher
+	s.started = true
+
+	go s.watch(ctx)
+	return nil
+}
+
+// Stop stops watching the keys file; it is safe to call more than once
+func (s *FileStore) Stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
+// watch reloads the keys file after changes in its directory
+func (s *FileStore) watch(ctx context.Context) {
+	defer s.watcher.Close()
+
+	reload := time.NewTimer(fileReloadDelay)
+	reload.Stop()
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopCh:
+			return
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			reload.Reset(fileReloadDelay)
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			warnf("Warning: keys file watch error: %v", err)
+		case <-reload.C:
+			s.reload()
+		}
+	}
+}
+
+// reload replaces the keys with the file's content, keeping the current keys
+// when the file is missing or invalid
+func (s *FileStore) reload() {
+	entries, err := LoadKeysFile(s.path)
+	if err != nil {
+		warnf("Warning: keeping the current keys, failed to reload: %v", err)
+		return
+	}
+
+	s.replace(entries)
+	infof("Reloaded %d keys from %s", len(entries), s.path)
+}
@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/efortin/batsign/internal/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileStore", func() {
+	var (
+		dir   string
+		path  string
+		store *server.FileStore
+	)
+
+	writeKeys := func(path, content string) {
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		path = filepath.Join(dir, "keys.yaml")
+		writeKeys(path, "- email: user@example.com\n  keyHash: aaaa\n")
+
+		var err error
+		store, err = server.NewFileStore(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.Start(context.Background())).To(Succeed())
+		DeferCleanup(store.Stop)
+	})
+
+	It("should serve the keys of the file", func() {
+		Expect(store.ValidateKey("aaaa")).To(BeTrue())
+		Expect(store.LastSync()).ToNot(BeZero())
+	})
+
+	It("should reload the keys when the file changes", func() {
+		loaded := store.LastSync()
+		writeKeys(path, "- email: user@example.com\n  keyHash: aaaa\n  enabled: false\n- email: new@example.com\n  keyHash: bbbb\n")
+
+		Eventually(func() bool { return store.ValidateKey("bbbb") }).Should(BeTrue())
+		Expect(store.ValidateKey("aaaa")).To(BeFalse())
+		Expect(store.GetStats()).To(Equal(map[string]int{"total": 2, "enabled": 1, "disabled": 1}))
+		Expect(store.LastSync()).To(BeTemporally(">", loaded))
+	})
+
+	It("should follow a file replaced atomically", func() {
+		tmp := filepath.Join(dir, ".keys.yaml.tmp")
+		writeKeys(tmp, "- email: new@example.com\n  keyHash: bbbb\n")
+		Expect(os.Rename(tmp, path)).To(Succeed())
+
+		Eventually(func() bool { return store.ValidateKey("bbbb") }).Should(BeTrue())
+		Expect(store.ValidateKey("aaaa")).To(BeFalse())
+	})
+
+	It("should keep the current keys when the file becomes invalid", func() {
+		writeKeys(path, "- email: broken@example.com\n")
+		Consistently(func() bool { return store.ValidateKey("aaaa") }, 300*time.Millisecond).Should(BeTrue())
+
+		writeKeys(path, "- email: fixed@example.com\n  keyHash: cccc\n")
+		Eventually(func() bool { return store.ValidateKey("cccc") }).Should(BeTrue())
+	})
+
+	It("should tolerate being started and stopped twice", func() {
+		Expect(store.Start(context.Background())).To(Succeed())
+		Expect(func() {
+			store.Stop()
+			store.Stop()
+		}).ToNot(Panic())
+	})
+
+	It("should fail on an invalid file at creation", func() {
+		writeKeys(path, "- email: broken@example.com\n")
+		_, err := server.NewFileStore(path)
+		Expect(err).To(MatchError(ContainSubstring("no keyHash")))
+	})
+})
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/efortin/batsign/internal/models"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func FuzzExtractAPIKey(f *testing.F) {
+	f.Add("Bearer sk-abcdefghijklmnop", "")
+	f.Add("", "sk-abcdefghijklmnop")
+	f.Add("Basic dXNlcjpwYXNz", "sk-fallback")
+	f.Add("Bearer ", "")
+	f.Add("Bearer sk-\r\nx-injected: 1", "")
+	f.Add("", "sk-\x00\x7f")
+	f.Add("Bearer \xff\xfe", "")
+
+	f.Fuzz(func(t *testing.T, authorization, xAPIKey string) {
+		headers := map[string]string{}
+		if authorization != "" {
+			headers["authorization"] = authorization
+		}
+		if xAPIKey != "" {
+			headers["x-api-key"] = xAPIKey
+		}
+
+		key := extractAPIKey(headers, 64)
+		if key == "" {
+			return
+		}
+
+		if len(key) > 64 {
+			t.Fatalf("extractAPIKey() returned a key longer than the maximum: %d bytes", len(key))
+		}
+		if !utf8.ValidString(key) {
+			t.Fatalf("extractAPIKey() returned invalid UTF-8: %q", key)
+		}
+		if strings.IndexFunc(key, unicode.IsControl) >= 0 {
+			t.Fatalf("extractAPIKey() returned a key containing control characters: %q", key)
+		}
+		if !strings.Contains(authorization, key) && !strings.Contains(xAPIKey, key) {
+			t.Fatalf("extractAPIKey() returned %q which is not part of any header", key)
+		}
+	})
+}
+
+func FuzzParseAPIKey(f *testing.F) {
+	f.Add([]byte(`{"metadata":{"name":"user-at-example-com"},"spec":{"email":"user@example.com","keyHash":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa","keyHint":"sk-abc*************de","enabled":true}}`))
+	f.Add([]byte(`{"spec":{"enabled":"yes","keyHash":42}}`))
+	f.Add([]byte(`{"spec":"not-a-map"}`))
+	f.Add([]byte(`{"spec":null,"metadata":[]}`))
+	f.Add([]byte(`{"spec":{"email":["a","b"],"description":{"nested":true}}}`))
+	f.Add([]byte(`{}`))
+
+	store := newAPIKeyStore(nil, &models.Config{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var object map[string]interface{}
+		if err := json.Unmarshal(data, &object); err != nil || object == nil {
+			return
+		}
+
+		spec, hasSpec := object["spec"].(map[string]interface{})
+		entry, err := store.parseAPIKey(&unstructured.Unstructured{Object: object})
+		if err != nil {
+			return
+		}
+
+		if !hasSpec {
+			t.Fatalf("parseAPIKey() returned an entry for an object without a spec map: %v", object)
+		}
+		if entry.Source != models.SourceAPIKey {
+			t.Fatalf("parseAPIKey() entry source = %q, want %q", entry.Source, models.SourceAPIKey)
+		}
+		if entry.KeyHash == "" {
+			t.Fatalf("parseAPIKey() returned an entry without a keyHash: %v", object)
+		}
+		if keyHash, ok := spec["keyHash"].(string); ok && entry.KeyHash != keyHash {
+			t.Fatalf("parseAPIKey() keyHash = %q, want %q", entry.KeyHash, keyHash)
+		}
+		if enabled, ok := spec["enabled"].(bool); ok && entry.Enabled != enabled {
+			t.Fatalf("parseAPIKey() enabled = %v, want %v", entry.Enabled, enabled)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc/stats"
+)
+
+var _ stats.Handler = (*connStats)(nil)
+
+// connStats is a gRPC stats handler counting the open connections and the
+// in-flight RPCs, e.g. to tell whether Envoy is connected at all
+type connStats struct {
+	conns    atomic.Int64
+	inFlight atomic.Int64
+}
+
+// TagRPC implements stats.Handler
+func (c *connStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC counts RPCs between their Begin and End events
+func (c *connStats) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch s.(type) {
+	case *stats.Begin:
+		c.inFlight.Add(1)
+	case *stats.End:
+		c.inFlight.Add(-1)
+	}
+}
+
+// TagConn implements stats.Handler
+func (c *connStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn counts connections between their ConnBegin and ConnEnd events
+func (c *connStats) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		c.conns.Add(1)
+	case *stats.ConnEnd:
+		c.conns.Add(-1)
+	}
+}
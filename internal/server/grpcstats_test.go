@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/stats"
+)
+
+var _ = Describe("gRPC connection stats", func() {
+	var (
+		srv *Server
+		ctx context.Context
+	)
+
+	BeforeEach(func() {
+		var err error
+		srv, err = NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080}, NewInMemoryStore())
+		Expect(err).ToNot(HaveOccurred())
+		ctx = context.Background()
+	})
+
+	getStats := func() map[string]int64 {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/stats", nil)
+		srv.setupRouter().ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var body map[string]int64
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		return body
+	}
+
+	It("should count open connections and in-flight requests", func() {
+		srv.grpcStats.HandleConn(ctx, &stats.ConnBegin{})
+		srv.grpcStats.HandleConn(ctx, &stats.ConnBegin{})
+		srv.grpcStats.HandleRPC(ctx, &stats.Begin{})
+
+		body := getStats()
+		Expect(body["grpcConnections"]).To(Equal(int64(2)))
+		Expect(body["grpcRequestsInFlight"]).To(Equal(int64(1)))
+
+		srv.grpcStats.HandleRPC(ctx, &stats.End{})
+		srv.grpcStats.HandleConn(ctx, &stats.ConnEnd{})
+
+		body = getStats()
+		Expect(body["grpcConnections"]).To(Equal(int64(1)))
+		Expect(body["grpcRequestsInFlight"]).To(BeZero())
+	})
+
+	It("should ignore other events", func() {
+		srv.grpcStats.HandleRPC(ctx, &stats.InPayload{})
+		srv.grpcStats.HandleRPC(ctx, &stats.OutHeader{})
+
+		Expect(srv.grpcStats.inFlight.Load()).To(BeZero())
+		Expect(srv.grpcStats.conns.Load()).To(BeZero())
+	})
+})
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+
+	"github.com/efortin/batsign/internal/models"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+)
+
+// defaultPolicyDenyMessage is used when a hook denies without a reason
+const defaultPolicyDenyMessage = "Denied by policy"
+
+// DecisionHook layers custom policy (e.g. business hours, geo rules) on top
+// of key validation. It is called after a key validated successfully;
+// returning false denies the request with the given reason.
+type DecisionHook func(ctx context.Context, req *envoy_service_auth_v3.CheckRequest, entry models.APIKeyEntry) (allow bool, reason string)
+
+// AllowAllDecisionHook is the default hook, allowing every validated request
+func AllowAllDecisionHook(context.Context, *envoy_service_auth_v3.CheckRequest, models.APIKeyEntry) (bool, string) {
+	return true, ""
+}
+
+// SetDecisionHook installs the hook consulted after a key validated (nil
+// restores AllowAllDecisionHook). It must be set before serving requests.
+func (a *AuthorizationServer) SetDecisionHook(hook DecisionHook) {
+	if hook == nil {
+		hook = AllowAllDecisionHook
+	}
+	a.decisionHook = hook
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/efortin/batsign/internal/models"
+)
+
+// KeyQuery filters the entries returned by KeyStore.Search; empty fields
+// match every entry
+type KeyQuery struct {
+	// Email matches case-insensitively
+	Email string
+	// Hint matches the stored keyHint exactly
+	Hint string
+	// Enabled matches the enabled state when set
+	Enabled *bool
+}
+
+// matches reports whether an entry satisfies every field of the query
+func (q KeyQuery) matches(entry *models.APIKeyEntry) bool {
+	if q.Email != "" && !strings.EqualFold(entry.Email, q.Email) {
+		return false
+	}
+	if q.Hint != "" && entry.KeyHint != q.Hint {
+		return false
+	}
+	if q.Enabled != nil && entry.Enabled != *q.Enabled {
+		return false
+	}
+	return true
+}
+
+// hashSet is a set of key hashes
+type hashSet map[string]struct{}
+
+// searchIndex maps hints and lower-cased emails to key hashes so searches
+// do not scan every entry. It is guarded by the owning store's lock.
+type searchIndex struct {
+	byHint  map[string]hashSet
+	byEmail map[string]hashSet
+	// indexed remembers the entry each hash was indexed with, so updates
+	// and deletions can drop the stale hint and email
+	indexed map[string]*models.APIKeyEntry
+}
+
+// newSearchIndex creates an empty index
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		byHint:  make(map[string]hashSet),
+		byEmail: make(map[string]hashSet),
+		indexed: make(map[string]*models.APIKeyEntry),
+	}
+}
+
+// add indexes an entry, replacing any entry with the same hash
+func (i *searchIndex) add(entry *models.APIKeyEntry) {
+	i.remove(entry.KeyHash)
+	i.indexed[entry.KeyHash] = entry
+	addToSet(i.byHint, entry.KeyHint, entry.KeyHash)
+	addToSet(i.byEmail, strings.ToLower(entry.Email), entry.KeyHash)
+}
+
+// remove drops the entry with the given hash from the index
+func (i *searchIndex) remove(hash string) {
+	entry, ok := i.indexed[hash]
+	if !ok {
+		return
+	}
+	delete(i.indexed, hash)
+	removeFromSet(i.byHint, entry.KeyHint, hash)
+	removeFromSet(i.byEmail, strings.ToLower(entry.Email), hash)
+}
+
+// search returns the entries matching the query, sorted by name. Queries
+// on hint or email only visit the indexed candidates.
+func (i *searchIndex) search(q KeyQuery) []models.APIKeyEntry {
+	var candidates hashSet
+	switch {
+	case q.Hint != "":
+		candidates = i.byHint[q.Hint]
+	case q.Email != "":
+		candidates = i.byEmail[strings.ToLower(q.Email)]
+	}
+
+	entries := []models.APIKeyEntry{}
+	visit := func(entry *models.APIKeyEntry) {
+		if q.matches(entry) {
+			entries = append(entries, *entry)
+		}
+	}
+	if q.Hint != "" || q.Email != "" {
+		for hash := range candidates {
+			visit(i.indexed[hash])
+		}
+	} else {
+		for _, entry := range i.indexed {
+			visit(entry)
+		}
+	}
+
+	// Same-named keys of different namespaces are ordered by namespace
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Name != entries[b].Name {
+			return entries[a].Name < entries[b].Name
+		}
+		return entries[a].Namespace < entries[b].Namespace
+	})
+	return entries
+}
+
+// addToSet adds a hash to the set stored under key
+func addToSet(sets map[string]hashSet, key, hash string) {
+	if key == "" {
+		return
+	}
+	set, ok := sets[key]
+	if !ok {
+		set = make(hashSet)
+		sets[key] = set
+	}
+	set[hash] = struct{}{}
+}
+
+// removeFromSet removes a hash from the set stored under key
+func removeFromSet(sets map[string]hashSet, key, hash string) {
+	set, ok := sets[key]
+	if !ok {
+		return
+	}
+	delete(set, hash)
+	if len(set) == 0 {
+		delete(sets, key)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("searchIndex", func() {
+	var index *searchIndex
+
+	BeforeEach(func() {
+		index = newSearchIndex()
+		index.add(&models.APIKeyEntry{Name: "alice", Email: "Alice@example.com", KeyHash: "a", KeyHint: "sk-abc*************78", Enabled: true})
+		index.add(&models.APIKeyEntry{Name: "bob", Email: "bob@example.com", KeyHash: "b", KeyHint: "sk-abc*************78"})
+	})
+
+	names := func(entries []models.APIKeyEntry) []string {
+		out := []string{}
+		for _, entry := range entries {
+			out = append(out, entry.Name)
+		}
+		return out
+	}
+
+	It("should find every entry sharing a hint", func() {
+		Expect(names(index.search(KeyQuery{Hint: "sk-abc*************78"}))).To(Equal([]string{"alice", "bob"}))
+	})
+
+	It("should find entries by email case-insensitively", func() {
+		Expect(names(index.search(KeyQuery{Email: "ALICE@example.com"}))).To(Equal([]string{"alice"}))
+	})
+
+	It("should combine indexed and unindexed filters", func() {
+		enabled := true
+		Expect(names(index.search(KeyQuery{Hint: "sk-abc*************78", Enabled: &enabled}))).To(Equal([]string{"alice"}))
+	})
+
+	It("should drop stale hints and emails when an entry is replaced", func() {
+		index.add(&models.APIKeyEntry{Name: "alice", Email: "alice@corp.example", KeyHash: "a", KeyHint: "sk-xyz*************12"})
+
+		Expect(names(index.search(KeyQuery{Hint: "sk-abc*************78"}))).To(Equal([]string{"bob"}))
+		Expect(index.search(KeyQuery{Email: "alice@example.com"})).To(BeEmpty())
+		Expect(names(index.search(KeyQuery{Email: "alice@corp.example"}))).To(Equal([]string{"alice"}))
+	})
+
+	It("should forget removed entries", func() {
+		index.remove("a")
+		index.remove("b")
+		index.remove("unknown")
+
+		Expect(index.search(KeyQuery{})).To(BeEmpty())
+		Expect(index.byHint).To(BeEmpty())
+		Expect(index.byEmail).To(BeEmpty())
+	})
+})
+
+// newBenchmarkStore fills an in-memory store with n synthetic keys, sharing
+// each hint between 10 keys
+func newBenchmarkStore(n int) *InMemoryStore {
+	store := NewInMemoryStore()
+	for i := 0; i < n; i++ {
+		store.Add(models.APIKeyEntry{
+			Name:    fmt.Sprintf("key-%06d", i),
+			Email:   fmt.Sprintf("user-%06d@example.com", i),
+			KeyHash: fmt.Sprintf("%064d", i),
+			KeyHint: fmt.Sprintf("sk-%03d*************%02d", i%1000, (i/1000)%10),
+			Enabled: true,
+		})
+	}
+	return store
+}
+
+func BenchmarkSearchByHint(b *testing.B) {
+	store := newBenchmarkStore(100000)
+	query := KeyQuery{Hint: "sk-042*************07"}
+
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			store.Search(query)
+		}
+	})
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var matches []models.APIKeyEntry
+			for _, entry := range store.List() {
+				if query.matches(&entry) {
+					matches = append(matches, entry)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// OverflowKeyLabel labels the decisions of keys seen once the per-key
+// metrics cap was reached
+const OverflowKeyLabel = "__other__"
+
+// keyDecisions counts the decisions of one key label
+type keyDecisions struct {
+	Allowed int64 `json:"allowed"`
+	Denied  int64 `json:"denied"`
+}
+
+// keyMetrics counts allow and deny decisions per key name. The first maxKeys
+// names seen get their own label; later ones share OverflowKeyLabel, so a
+// large or churning key set cannot blow up the metrics cardinality.
+type keyMetrics struct {
+	mu      sync.Mutex
+	maxKeys int
+	counts  map[string]*keyDecisions
+	// other counts the keys beyond maxKeys (nil until one is seen)
+	other *keyDecisions
+}
+
+// newKeyMetrics creates counters labelling at most maxKeys key names
+func newKeyMetrics(maxKeys int) *keyMetrics {
+	return &keyMetrics{maxKeys: maxKeys, counts: make(map[string]*keyDecisions)}
+}
+
+// record counts one decision for the named key
+func (m *keyMetrics) record(name string, allowed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts, ok := m.counts[name]
+	switch {
+	case ok:
+	case len(m.counts) < m.maxKeys:
+		counts = &keyDecisions{}
+		m.counts[name] = counts
+	default:
+		if m.other == nil {
+			m.other = &keyDecisions{}
+		}
+		counts = m.other
+	}
+	if allowed {
+		counts.Allowed++
+	} else {
+		counts.Denied++
+	}
+}
+
+// snapshot returns a copy of the counts per key label
+func (m *keyMetrics) snapshot() map[string]keyDecisions {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]keyDecisions, len(m.counts)+1)
+	for name, counts := range m.counts {
+		snapshot[name] = *counts
+	}
+	if m.other != nil {
+		snapshot[OverflowKeyLabel] = *m.other
+	}
+	return snapshot
+}
+
+// sortedNames returns the labels of a snapshot in order
+func sortedNames(snapshot map[string]keyDecisions) []string {
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// writePlain renders the counts as key: value lines, keys sorted
+func (m *keyMetrics) writePlain(w io.Writer) {
+	snapshot := m.snapshot()
+	for _, name := range sortedNames(snapshot) {
+		fmt.Fprintf(w, "keyDecisions.%s.allowed: %d\nkeyDecisions.%s.denied: %d\n", name, snapshot[name].Allowed, name, snapshot[name].Denied)
+	}
+}
+
+// prometheusLabelEscaper escapes label values for the text format
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writePrometheus renders the counts as a labelled counter, keys sorted
+func (m *keyMetrics) writePrometheus(w io.Writer) {
+	snapshot := m.snapshot()
+	names := sortedNames(snapshot)
+
+	const metric = "batsign_key_decisions_total"
+	fmt.Fprintf(w, "# HELP %s Allow and deny decisions per API key name, keys beyond the cap labelled %s.\n# TYPE %s counter\n",
+		metric, OverflowKeyLabel, metric)
+	for _, name := range names {
+		label := prometheusLabelEscaper.Replace(name)
+		fmt.Fprintf(w, "%s{key=\"%s\",decision=\"allow\"} %d\n", metric, label, snapshot[name].Allowed)
+		fmt.Fprintf(w, "%s{key=\"%s\",decision=\"deny\"} %d\n", metric, label, snapshot[name].Denied)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("keyMetrics", func() {
+	It("should fold keys beyond the cap into the overflow label", func() {
+		metrics := newKeyMetrics(2)
+		metrics.record("alice", true)
+		metrics.record("bob", false)
+		metrics.record("carol", true)
+		metrics.record("dave", false)
+		metrics.record("dave", true)
+		// Keys labelled before the cap was reached keep their label
+		metrics.record("alice", false)
+
+		Expect(metrics.snapshot()).To(Equal(map[string]keyDecisions{
+			"alice":          {Allowed: 1, Denied: 1},
+			"bob":            {Denied: 1},
+			OverflowKeyLabel: {Allowed: 2, Denied: 1},
+		}))
+	})
+
+	It("should count the decisions of known keys only", func() {
+		store := NewInMemoryStore(
+			models.APIKeyEntry{Name: "valid", KeyHash: apikey.HashAPIKey("sk-valid"), Enabled: true},
+			models.APIKeyEntry{Name: "disabled", KeyHash: apikey.HashAPIKey("sk-disabled")},
+		)
+		authz, err := NewAuthorizationServer(store, &models.Config{PerKeyMetrics: true, PerKeyMetricsMaxKeys: 1})
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, key := range []string{"sk-valid", "sk-valid", "sk-disabled", "sk-unknown", ""} {
+			_, err := authz.Check(context.Background(), newSourceCheckRequest(key, "10.0.0.1"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(authz.keyMetrics.snapshot()).To(Equal(map[string]keyDecisions{
+			"valid":          {Allowed: 2},
+			OverflowKeyLabel: {Denied: 1},
+		}))
+	})
+
+	It("should be disabled by default", func() {
+		authz, err := NewAuthorizationServer(NewInMemoryStore(), &models.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authz.keyMetrics).To(BeNil())
+	})
+
+	It("should render a labelled Prometheus counter", func() {
+		metrics := newKeyMetrics(1)
+		metrics.record(`a"b`, true)
+		metrics.record("c", false)
+
+		var b strings.Builder
+		metrics.writePrometheus(&b)
+		Expect(b.String()).To(Equal("# HELP batsign_key_decisions_total Allow and deny decisions per API key name, keys beyond the cap labelled __other__.\n" +
+			"# TYPE batsign_key_decisions_total counter\n" +
+			`batsign_key_decisions_total{key="__other__",decision="allow"} 0` + "\n" +
+			`batsign_key_decisions_total{key="__other__",decision="deny"} 1` + "\n" +
+			`batsign_key_decisions_total{key="a\"b",decision="allow"} 1` + "\n" +
+			`batsign_key_decisions_total{key="a\"b",decision="deny"} 0` + "\n"))
+	})
+})
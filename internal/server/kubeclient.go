@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// kubeBackoffInitial is the first delay after a 429 response
+	kubeBackoffInitial = time.Second
+	// kubeBackoffJitter spreads retries of concurrent requests apart
+	kubeBackoffJitter = 0.5
+	// kubeBackoffID keys the single backoff entry shared by all requests
+	kubeBackoffID = "apiserver"
+)
+
+// configureRESTClient rate limits the Kubernetes client, backs it off while
+// the API server is throttling it, and logs server warnings
+func configureRESTClient(config *rest.Config, cfg *models.Config) {
+	if cfg.KubeQPS > 0 {
+		config.QPS = cfg.KubeQPS
+	}
+	if cfg.KubeBurst > 0 {
+		config.Burst = cfg.KubeBurst
+	}
+	qps, burst := config.QPS, config.Burst
+	if qps == 0 {
+		qps = rest.DefaultQPS
+	}
+	if burst == 0 {
+		burst = rest.DefaultBurst
+	}
+
+	limiter := newBackoffRateLimiter(flowcontrol.NewTokenBucketRateLimiter(qps, burst), cfg.KubeBackoffMax)
+	config.RateLimiter = limiter
+	config.Wrap(limiter.wrap)
+	config.WarningHandler = warningLogger{}
+}
+
+// backoffRateLimiter is a token bucket rate limiter that additionally holds
+// requests back, with bounded exponential jittered delays, after the API
+// server answered 429 Too Many Requests
+type backoffRateLimiter struct {
+	flowcontrol.RateLimiter
+
+	mu      sync.Mutex
+	backoff *flowcontrol.Backoff
+	until   time.Time
+	now     func() time.Time
+}
+
+// newBackoffRateLimiter wraps limiter with a backoff capped at max (0 = no backoff)
+func newBackoffRateLimiter(limiter flowcontrol.RateLimiter, max time.Duration) *backoffRateLimiter {
+	l := &backoffRateLimiter{RateLimiter: limiter, now: time.Now}
+	if max > 0 {
+		l.backoff = flowcontrol.NewBackOffWithJitter(min(kubeBackoffInitial, max), max, kubeBackoffJitter)
+	}
+	return l
+}
+
+// delay returns how long requests are still held back
+func (l *backoffRateLimiter) delay() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return max(l.until.Sub(l.now()), 0)
+}
+
+// observe moves the backoff forward on 429 responses and resets it otherwise
+func (l *backoffRateLimiter) observe(status int) {
+	if l.backoff == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if status != http.StatusTooManyRequests {
+		l.backoff.Reset(kubeBackoffID)
+		l.until = time.Time{}
+		return
+	}
+	now := l.now()
+	l.backoff.Next(kubeBackoffID, now)
+	l.until = now.Add(l.backoff.Get(kubeBackoffID))
+}
+
+// TryAccept returns false while backing off
+func (l *backoffRateLimiter) TryAccept() bool {
+	return l.delay() == 0 && l.RateLimiter.TryAccept()
+}
+
+// Accept blocks until the backoff has elapsed and a token is available
+func (l *backoffRateLimiter) Accept() {
+	time.Sleep(l.delay())
+	l.RateLimiter.Accept()
+}
+
+// Wait is Accept honoring ctx cancellation
+func (l *backoffRateLimiter) Wait(ctx context.Context) error {
+	if d := l.delay(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return l.RateLimiter.Wait(ctx)
+}
+
+// wrap returns a transport feeding response statuses to the backoff
+func (l *backoffRateLimiter) wrap(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := rt.RoundTrip(req)
+		if err == nil {
+			l.observe(resp.StatusCode)
+		}
+		return resp, err
+	})
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req)
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// warningLogger logs warnings returned by the Kubernetes API server, such as
+// deprecation notices
+type warningLogger struct{}
+
+// HandleWarningHeader logs a single warning
+func (warningLogger) HandleWarningHeader(code int, agent string, message string) {
+	if code != 299 || message == "" {
+		return
+	}
+	warnf("Kubernetes API warning: %s", message)
+}
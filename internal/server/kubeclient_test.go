@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+var _ = Describe("configureRESTClient", func() {
+	It("should set a rate limiter honoring the configured QPS", func() {
+		config := &rest.Config{}
+		configureRESTClient(config, &models.Config{KubeQPS: 20, KubeBurst: 40, KubeBackoffMax: 30 * time.Second})
+
+		Expect(config.RateLimiter).To(BeAssignableToTypeOf(&backoffRateLimiter{}))
+		Expect(config.RateLimiter.QPS()).To(BeNumerically("==", 20))
+		Expect(config.Burst).To(Equal(40))
+		Expect(config.WrapTransport).ToNot(BeNil())
+		Expect(config.WarningHandler).To(Equal(warningLogger{}))
+	})
+
+	It("should fall back to the client-go defaults", func() {
+		config := &rest.Config{}
+		configureRESTClient(config, &models.Config{})
+
+		Expect(config.RateLimiter.QPS()).To(BeNumerically("==", rest.DefaultQPS))
+	})
+})
+
+var _ = Describe("backoffRateLimiter", func() {
+	var (
+		limiter *backoffRateLimiter
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1700000000, 0)
+		limiter = newBackoffRateLimiter(flowcontrol.NewFakeAlwaysRateLimiter(), 10*time.Second)
+		limiter.now = func() time.Time { return now }
+	})
+
+	It("should back off after a 429 and stay within the bound", func() {
+		limiter.observe(http.StatusTooManyRequests)
+		first := limiter.delay()
+		Expect(first).To(BeNumerically(">=", kubeBackoffInitial))
+		Expect(first).To(BeNumerically("<=", time.Duration(float64(kubeBackoffInitial)*(1+kubeBackoffJitter))))
+		Expect(limiter.TryAccept()).To(BeFalse())
+
+		for i := 0; i < 10; i++ {
+			limiter.observe(http.StatusTooManyRequests)
+		}
+		Expect(limiter.delay()).To(BeNumerically(">", first))
+		Expect(limiter.delay()).To(BeNumerically("<=", 10*time.Second))
+	})
+
+	It("should reset once the API server recovers", func() {
+		limiter.observe(http.StatusTooManyRequests)
+		limiter.observe(http.StatusOK)
+
+		Expect(limiter.delay()).To(BeZero())
+		Expect(limiter.TryAccept()).To(BeTrue())
+	})
+
+	It("should not back off when disabled", func() {
+		limiter = newBackoffRateLimiter(flowcontrol.NewFakeAlwaysRateLimiter(), 0)
+		limiter.observe(http.StatusTooManyRequests)
+
+		Expect(limiter.delay()).To(BeZero())
+	})
+
+	It("should honor context cancellation while backing off", func() {
+		limiter.observe(http.StatusTooManyRequests)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(limiter.Wait(ctx)).To(MatchError(context.Canceled))
+	})
+
+	It("should observe responses through the wrapped transport", func() {
+		apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer apiServer.Close()
+
+		client := &http.Client{Transport: limiter.wrap(http.DefaultTransport)}
+		resp, err := client.Get(apiServer.URL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+
+		Expect(limiter.delay()).To(BeNumerically(">", 0))
+	})
+})
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// checkLimiter bounds the number of Check calls served at once, so a flood
+// is rejected early instead of piling up goroutines and memory
+type checkLimiter struct {
+	slots chan struct{}
+	// rejected counts the calls refused because every slot was taken
+	rejected atomic.Int64
+}
+
+// newCheckLimiter creates a limiter serving at most limit concurrent checks
+func newCheckLimiter(limit int) *checkLimiter {
+	return &checkLimiter{slots: make(chan struct{}, limit)}
+}
+
+// interceptor rejects Check calls with ResourceExhausted while the limit is
+// reached, without waiting for a slot. Other methods, e.g. health checks,
+// are never limited.
+func (l *checkLimiter) interceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if info.FullMethod != envoy_service_auth_v3.Authorization_Check_FullMethodName {
+		return handler(ctx, req)
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		defer func() { <-l.slots }()
+		return handler(ctx, req)
+	default:
+		l.rejected.Add(1)
+		return nil, grpcstatus.Error(codes.ResourceExhausted, "too many concurrent checks")
+	}
+}
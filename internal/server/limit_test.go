@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var _ = Describe("Concurrent check limit", func() {
+	const (
+		limit    = 2
+		validKey = "sk-valid-key-for-limits"
+	)
+
+	var (
+		srv     *Server
+		client  envoy_service_auth_v3.AuthorizationClient
+		conn    *grpc.ClientConn
+		entered chan struct{}
+		release chan struct{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		srv, err = NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080, MaxConcurrentChecks: limit},
+			NewInMemoryStore(models.APIKeyEntry{Name: "valid", KeyHash: apikey.HashAPIKey(validKey), Enabled: true}))
+		Expect(err).ToNot(HaveOccurred())
+
+		// Checks block in the decision hook until released
+		entered, release = make(chan struct{}, 10), make(chan struct{})
+		srv.SetDecisionHook(func(ctx context.Context, req *envoy_service_auth_v3.CheckRequest, entry models.APIKeyEntry) (bool, string) {
+			entered <- struct{}{}
+			<-release
+			return true, ""
+		})
+
+		lis := bufconn.Listen(1 << 20)
+		grpcServer := srv.newGRPCServer()
+		go func() { _ = grpcServer.Serve(lis) }()
+		DeferCleanup(grpcServer.Stop)
+
+		conn, err = grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(conn.Close)
+		client = envoy_service_auth_v3.NewAuthorizationClient(conn)
+	})
+
+	check := func() error {
+		_, err := client.Check(context.Background(), newSourceCheckRequest(validKey, "10.0.0.1"))
+		return err
+	}
+
+	It("should reject checks beyond the limit with ResourceExhausted", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < limit; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(check()).To(Succeed())
+			}()
+		}
+		for i := 0; i < limit; i++ {
+			Eventually(entered).Should(Receive())
+		}
+
+		err := check()
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		Expect(srv.checkLimiter.rejected.Load()).To(Equal(int64(1)))
+
+		// Health checks are never limited
+		_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		Expect(err).ToNot(HaveOccurred())
+
+		close(release)
+		wg.Wait()
+		Expect(check()).To(Succeed())
+	})
+
+	It("should report rejected checks in /stats", func() {
+		srv.checkLimiter.rejected.Store(3)
+
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		Expect(rec.Body.String()).To(ContainSubstring(`"checksRejected":3`))
+	})
+})
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// logLevels lists the supported log levels, most verbose first. Errors are
+// logged at every level, with log.Printf.
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// Indexes in logLevels
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelWarn
+)
+
+// currentLogLevel is the index in logLevels of the active level. It is
+// process wide, like the standard logger, so the store, the HTTP server and
+// the authorization server all follow changes immediately.
+var currentLogLevel atomic.Int32
+
+func init() {
+	currentLogLevel.Store(levelInfo)
+}
+
+// SetLogLevel changes the log level at runtime
+func SetLogLevel(level string) error {
+	index, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	currentLogLevel.Store(index)
+	return nil
+}
+
+// parseLogLevel returns the index in logLevels of a level name
+func parseLogLevel(level string) (int32, error) {
+	for i, name := range logLevels {
+		if name == level {
+			return int32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (debug, info, warn, error)", level)
+}
+
+// LogLevel returns the active log level
+func LogLevel() string {
+	return logLevels[currentLogLevel.Load()]
+}
+
+// debugEnabled reports whether debug logging is on
+func debugEnabled() bool {
+	return currentLogLevel.Load() == levelDebug
+}
+
+// debugf logs only at the debug level
+func debugf(format string, args ...any) {
+	if debugEnabled() {
+		log.Printf("Debug: "+format, args...)
+	}
+}
+
+// infof logs at the info level and below
+func infof(format string, args ...any) {
+	if currentLogLevel.Load() <= levelInfo {
+		log.Printf(format, args...)
+	}
+}
+
+// warnf logs at the warn level and below
+func warnf(format string, args ...any) {
+	if currentLogLevel.Load() <= levelWarn {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Log level", func() {
+	var (
+		srv  *Server
+		logs *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+		srv, err = NewWithStore(&models.Config{
+			GRPCPort:        9191,
+			HTTPPort:        8080,
+			LogLevel:        "info",
+			AdminAPIEnabled: true,
+			AdminToken:      "operator-token",
+		}, NewInMemoryStore())
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(SetLogLevel, "info")
+
+		logs = &bytes.Buffer{}
+		log.SetOutput(logs)
+		DeferCleanup(log.SetOutput, os.Stderr)
+	})
+
+	// putLevel sends PUT /loglevel with the admin token
+	putLevel := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer operator-token")
+		req.Header.Set("Content-Type", "application/json")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// check sends a request through the authorization server
+	check := func() {
+		_, err := srv.authz.Check(context.Background(), newSourceCheckRequest("sk-any", "10.0.0.1"))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("should log debug lines only once the level is debug", func() {
+		check()
+		Expect(logs.String()).ToNot(ContainSubstring("Debug: Check"))
+
+		rec := putLevel(`{"level":"debug"}`)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"level":"debug","previous":"info"}`))
+		Expect(LogLevel()).To(Equal("debug"))
+
+		check()
+		Expect(logs.String()).To(ContainSubstring("Debug: Check: GET  from 10.0.0.1"))
+
+		Expect(putLevel(`{"level":"info"}`).Code).To(Equal(http.StatusOK))
+		logs.Reset()
+		check()
+		Expect(logs.String()).ToNot(ContainSubstring("Debug:"))
+	})
+
+	It("should hide info lines from warn and warnings from error", func() {
+		Expect(putLevel(`{"level":"warn"}`).Code).To(Equal(http.StatusOK))
+		logs.Reset()
+		check()
+		warnf("WARNING: test warning")
+		Expect(logs.String()).ToNot(ContainSubstring("Denied:"))
+		Expect(logs.String()).To(ContainSubstring("WARNING: test warning"))
+
+		Expect(putLevel(`{"level":"error"}`).Code).To(Equal(http.StatusOK))
+		logs.Reset()
+		warnf("WARNING: test warning")
+		Expect(logs.String()).To(BeEmpty())
+
+		Expect(putLevel(`{"level":"info"}`).Code).To(Equal(http.StatusOK))
+		check()
+		Expect(logs.String()).To(ContainSubstring("Denied:"))
+	})
+
+	It("should report the active level", func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/loglevel", nil)
+		req.Header.Set("Authorization", "Bearer operator-token")
+		srv.Handler().ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"level":"info"}`))
+	})
+
+	DescribeTable("should reject invalid bodies",
+		func(body string) {
+			Expect(putLevel(body).Code).To(Equal(http.StatusBadRequest))
+			Expect(LogLevel()).To(Equal("info"))
+		},
+		Entry("unknown level", `{"level":"verbose"}`),
+		Entry("empty level", `{}`),
+		Entry("malformed JSON", `{"level":`),
+	)
+
+	It("should require the admin token", func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level":"debug"}`))
+		srv.Handler().ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(LogLevel()).To(Equal("info"))
+	})
+
+	It("should reject an unknown configured level", func() {
+		_, err := NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080, LogLevel: "verbose"}, NewInMemoryStore())
+		Expect(err).To(MatchError(ContainSubstring("invalid config: log-level")))
+	})
+})
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/efortin/batsign/internal/models"
+	"sigs.k8s.io/yaml"
+)
+
+var _ KeyStore = (*InMemoryStore)(nil)
+
+// InMemoryStore is a KeyStore that is not backed by Kubernetes.
+// It is intended for tests and local experimentation, not for production.
+type InMemoryStore struct {
+	mu        sync.RWMutex
+	keyHashes map[string]*models.APIKeyEntry
+	index     *searchIndex
+	loaded    time.Time
+}
+
+// NewInMemoryStore creates an in-memory store holding the given entries
+func NewInMemoryStore(entries ...models.APIKeyEntry) *InMemoryStore {
+	store := &InMemoryStore{
+		keyHashes: make(map[string]*models.APIKeyEntry, len(entries)),
+		index:     newSearchIndex(),
+		loaded:    time.Now(),
+	}
+	for _, entry := range entries {
+		store.Add(entry)
+	}
+	return store
+}
+
+// Add stores an entry, replacing any entry with the same hash. Its KeyID is
+// derived from its hash when unset.
+func (s *InMemoryStore) Add(entry models.APIKeyEntry) {
+	if entry.KeyID == "" && entry.KeyHash != "" {
+		entry.KeyID = apikey.KeyID(entry.KeyHash)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keyHashes[entry.KeyHash] = &entry
+	s.index.add(&entry)
+}
+
+// replace swaps all entries at once, e.g. when the keys file is reloaded
+func (s *InMemoryStore) replace(entries []models.APIKeyEntry) {
+	keyHashes := make(map[string]*models.APIKeyEntry, len(entries))
+	index := newSearchIndex()
+	for i := range entries {
+		entry := entries[i]
+		keyHashes[entry.KeyHash] = &entry
+		index.add(&entry)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keyHashes = keyHashes
+	s.index = index
+	s.loaded = time.Now()
+}
+
+// Remove deletes the entry with the given hash
+func (s *InMemoryStore) Remove(keyHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keyHashes, keyHash)
+	s.index.remove(keyHash)
+}
+
+// Start is a no-op, the entries are already loaded
+func (s *InMemoryStore) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop is a no-op
+func (s *InMemoryStore) Stop() {}
+
+// LastSync returns when the entries were last loaded
+func (s *InMemoryStore) LastSync() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.loaded
+}
+
+// ValidateKey checks if the provided API key hash is valid and enabled
+func (s *InMemoryStore) ValidateKey(keyHash string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, exists := s.keyHashes[keyHash]
+	if !exists {
+		return false
+	}
+
+	return entry.Enabled
+}
+
+// Lookup returns the entry for the provided API key hash, if any
+func (s *InMemoryStore) Lookup(keyHash string) (models.APIKeyEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, exists := s.keyHashes[keyHash]
+	if !exists {
+		return models.APIKeyEntry{}, false
+	}
+	return *entry, true
+}
+
+// List returns a copy of all entries
+func (s *InMemoryStore) List() []models.APIKeyEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]models.APIKeyEntry, 0, len(s.keyHashes))
+	for _, entry := range s.keyHashes {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// Search returns the entries matching the query, sorted by name
+func (s *InMemoryStore) Search(q KeyQuery) []models.APIKeyEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.search(q)
+}
+
+// GetStats returns statistics about the store
+func (s *InMemoryStore) GetStats() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	enabled := 0
+	disabled := 0
+
+	for _, entry := range s.keyHashes {
+		if entry.Enabled {
+			enabled++
+		} else {
+			disabled++
+		}
+	}
+
+	return map[string]int{
+		"total":    len(s.keyHashes),
+		"enabled":  enabled,
+		"disabled": disabled,
+	}
+}
+
+// keysFileEntry is a single key in a keys file
+type keysFileEntry struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	KeyHash     string `json:"keyHash"`
+	KeyHint     string `json:"keyHint"`
+	Description string `json:"description"`
+	Enabled     *bool  `json:"enabled"`
+	// AllowedMethods restricts the HTTP methods the key may be used with
+	AllowedMethods []string `json:"allowedMethods"`
+	Notes          string   `json:"notes"`
+	// ActiveWindows restricts when the key may be used
+	ActiveWindows []models.ActiveWindow `json:"activeWindows"`
+}
+
+// LoadKeysFile reads a YAML or JSON list of keys, e.g.
+//
+//   - email: user@example.com
+//     keyHash: <sha-256 hex of the key>
+//     keyHint: sk-abc*************de
+//     enabled: true
+//
+// Keys are enabled unless "enabled" is explicitly false.
+func LoadKeysFile(path string) ([]models.APIKeyEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys file: %w", err)
+	}
+
+	var items []keysFileEntry
+	if err := yaml.UnmarshalStrict(data, &items); err != nil {
+		return nil, fmt.Errorf("failed to parse keys file %s: %w", path, err)
+	}
+
+	entries := make([]models.APIKeyEntry, 0, len(items))
+	for i, item := range items {
+		if item.KeyHash == "" {
+			return nil, fmt.Errorf("keys file %s: entry %d has no keyHash", path, i)
+		}
+		entry := models.APIKeyEntry{
+			Name:           item.Name,
+			Email:          item.Email,
+			KeyHash:        item.KeyHash,
+			KeyID:          apikey.KeyID(item.KeyHash),
+			KeyHint:        item.KeyHint,
+			Description:    item.Description,
+			Enabled:        item.Enabled == nil || *item.Enabled,
+			Source:         models.SourceFile,
+			AllowedMethods: kube.NormalizeMethods(item.AllowedMethods),
+			Notes:          item.Notes,
+			ActiveWindows:  item.ActiveWindows,
+		}
+		for j, window := range entry.ActiveWindows {
+			if err := window.Validate(); err != nil {
+				return nil, fmt.Errorf("keys file %s: entry %d: active window %d: %w", path, i, j, err)
+			}
+		}
+		if entry.Name == "" {
+			entry.Name = entry.Email
+		}
+		warnMalformedHint(fmt.Sprintf("keys file %s: entry %d", path, i), entry.KeyHint)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
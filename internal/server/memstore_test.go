@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/efortin/batsign/internal/models"
+	"github.com/efortin/batsign/internal/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InMemoryStore", func() {
+	It("should report stats for its entries", func() {
+		store := server.NewInMemoryStore(
+			models.APIKeyEntry{KeyHash: "a", Enabled: true},
+			models.APIKeyEntry{KeyHash: "b", Enabled: true},
+			models.APIKeyEntry{KeyHash: "c", Enabled: false},
+		)
+
+		Expect(store.GetStats()).To(Equal(map[string]int{"total": 3, "enabled": 2, "disabled": 1}))
+
+		store.Remove("a")
+		Expect(store.GetStats()["total"]).To(Equal(2))
+	})
+
+	It("should replace an entry with the same hash", func() {
+		store := server.NewInMemoryStore(models.APIKeyEntry{KeyHash: "a", Enabled: true})
+		store.Add(models.APIKeyEntry{KeyHash: "a", Enabled: false})
+
+		Expect(store.ValidateKey("a")).To(BeFalse())
+		Expect(store.GetStats()["total"]).To(Equal(1))
+	})
+})
+
+var _ = Describe("LoadKeysFile", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	writeFile := func(content string) string {
+		path := filepath.Join(dir, "keys.yaml")
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		return path
+	}
+
+	It("should load entries and default enabled to true", func() {
+		path := writeFile(`
+- email: user@example.com
+  keyHash: aaaa
+  keyHint: sk-abc*************de
+- email: disabled@example.com
+  keyHash: bbbb
+  enabled: false
+`)
+		entries, err := server.LoadKeysFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Enabled).To(BeTrue())
+		Expect(entries[0].Name).To(Equal("user@example.com"))
+		Expect(entries[0].Source).To(Equal(models.SourceFile))
+		Expect(entries[1].Enabled).To(BeFalse())
+	})
+
+	It("should load allowed methods", func() {
+		entries, err := server.LoadKeysFile(writeFile("- keyHash: aaaa\n  allowedMethods: [get, head]\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[0].AllowedMethods).To(Equal([]string{"GET", "HEAD"}))
+		Expect(entries[0].AllowsMethod("POST")).To(BeFalse())
+	})
+
+	It("should load active windows", func() {
+		entries, err := server.LoadKeysFile(writeFile("- keyHash: aaaa\n  activeWindows:\n  - start: \"22:00\"\n    end: \"06:00\"\n    days: [Sat]\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[0].ActiveWindows).To(Equal([]models.ActiveWindow{{Start: "22:00", End: "06:00", Days: []string{"Sat"}}}))
+	})
+
+	It("should reject invalid active windows", func() {
+		_, err := server.LoadKeysFile(writeFile("- keyHash: aaaa\n  activeWindows:\n  - start: \"09:00\"\n    end: \"09:00\"\n"))
+		Expect(err).To(MatchError(ContainSubstring("active window 0")))
+	})
+
+	It("should reject entries without a keyHash", func() {
+		_, err := server.LoadKeysFile(writeFile("- email: user@example.com\n"))
+		Expect(err).To(MatchError(ContainSubstring("no keyHash")))
+	})
+
+	It("should reject unknown fields", func() {
+		_, err := server.LoadKeysFile(writeFile("- keyHash: aaaa\n  enabeld: false\n"))
+		Expect(err).To(HaveOccurred())
+	})
+})
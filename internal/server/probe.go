@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Probe performs a GET request against url, e.g. the /ready endpoint, and
+// returns an error unless it answers 200 OK. It lets the server binary act as
+// its own container healthcheck.
+func Probe(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build probe request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("probe failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("probe failed: %s returned %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/efortin/batsign/internal/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Probe", func() {
+	serve := func(status int) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/ready"))
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(srv.Close)
+		return srv.URL + "/ready"
+	}
+
+	It("should succeed when the server is ready", func() {
+		Expect(server.Probe(context.Background(), serve(http.StatusOK))).To(Succeed())
+	})
+
+	It("should fail when the server is not ready", func() {
+		err := server.Probe(context.Background(), serve(http.StatusServiceUnavailable))
+		Expect(err).To(MatchError(ContainSubstring("503")))
+	})
+
+	It("should fail when nothing is listening", func() {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+
+		Expect(server.Probe(context.Background(), srv.URL+"/ready")).ToNot(Succeed())
+	})
+})
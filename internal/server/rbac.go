@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// selfSubjectAccessReviewGVR identifies SelfSubjectAccessReviews, which any
+// authenticated client may create to ask what it is allowed to do
+var selfSubjectAccessReviewGVR = schema.GroupVersionResource{
+	Group:    "authorization.k8s.io",
+	Version:  "v1",
+	Resource: "selfsubjectaccessreviews",
+}
+
+// watchVerbs are the verbs an informer-style sync needs
+var watchVerbs = []string{"list", "watch"}
+
+// checkWatchAccess fails unless the client may list and watch every
+// resource the store syncs
+func checkWatchAccess(ctx context.Context, client dynamic.Interface, namespace, secretSelector string) error {
+	if err := checkAccess(ctx, client, apiKeyGVR, namespace, watchVerbs...); err != nil {
+		return err
+	}
+	if secretSelector != "" {
+		return checkAccess(ctx, client, secretGVR, namespace, watchVerbs...)
+	}
+	return nil
+}
+
+// checkAccess asks the API server whether the client may use each verb on
+// resource in namespace (empty = all namespaces) and returns an error naming
+// the denied ones
+func checkAccess(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, namespace string, verbs ...string) error {
+	var denied []string
+	for _, verb := range verbs {
+		allowed, reason, err := reviewAccess(ctx, client, gvr, namespace, verb)
+		if err != nil {
+			return fmt.Errorf("failed to check RBAC for %s %s: %w", verb, resourceName(gvr), err)
+		}
+		if !allowed {
+			if reason != "" {
+				verb += " (" + reason + ")"
+			}
+			denied = append(denied, verb)
+		}
+	}
+	if len(denied) > 0 {
+		scope := "all namespaces"
+		if namespace != "" {
+			scope = "namespace " + namespace
+		}
+		return fmt.Errorf("insufficient RBAC: the service account cannot %s %s in %s; grant the %s verbs in its Role",
+			strings.Join(denied, ", "), resourceName(gvr), scope, strings.Join(verbs, ", "))
+	}
+	return nil
+}
+
+// reviewAccess creates a SelfSubjectAccessReview for one verb
+func reviewAccess(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, namespace, verb string) (bool, string, error) {
+	review := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SelfSubjectAccessReview",
+		"spec": map[string]interface{}{
+			"resourceAttributes": map[string]interface{}{
+				"namespace": namespace,
+				"verb":      verb,
+				"group":     gvr.Group,
+				"version":   gvr.Version,
+				"resource":  gvr.Resource,
+			},
+		},
+	}}
+	result, err := client.Resource(selfSubjectAccessReviewGVR).Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	allowed, _, _ := unstructured.NestedBool(result.Object, "status", "allowed")
+	reason, _, _ := unstructured.NestedString(result.Object, "status", "reason")
+	return allowed, reason, nil
+}
+
+// resourceName returns resource.group, or resource for the core group
+func resourceName(gvr schema.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return gvr.Resource
+	}
+	return gvr.Resource + "." + gvr.Group
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// RouteRulesKey is the ConfigMap data key holding the route rules
+const RouteRulesKey = "rules"
+
+// configMapGVR identifies core ConfigMaps
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// routeRulesRetryInterval is the delay before re-establishing a failed
+// route rules watch
+var routeRulesRetryInterval = 5 * time.Second
+
+// routeRule tells whether requests to matching paths need an API key. Path
+// is either exact (/healthz) or a prefix ending with * (/public/*).
+type routeRule struct {
+	Path string `json:"path"`
+	// RequireKey is mandatory so a typo cannot make a route public
+	RequireKey *bool `json:"requireKey"`
+}
+
+// matches reports whether the rule applies to a cleaned request path
+func (r routeRule) matches(p string) bool {
+	if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+		return strings.HasPrefix(p, prefix)
+	}
+	return p == r.Path
+}
+
+// parseRouteRules parses the YAML list of rules stored in a ConfigMap
+func parseRouteRules(data string) ([]routeRule, error) {
+	var rules []routeRule
+	if err := yaml.UnmarshalStrict([]byte(data), &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse route rules: %w", err)
+	}
+	for i, rule := range rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("route rule %d: path %q must start with /", i, rule.Path)
+		}
+		if strings.Contains(strings.TrimSuffix(rule.Path, "*"), "*") {
+			return nil, fmt.Errorf("route rule %d: path %q may only end with *", i, rule.Path)
+		}
+		if rule.RequireKey == nil {
+			return nil, fmt.Errorf("route rule %d: requireKey must be set", i)
+		}
+	}
+	return rules, nil
+}
+
+// routeRules decides which request paths need an API key. The rules are
+// replaced as a whole whenever their source changes.
+type routeRules struct {
+	rules atomic.Pointer[[]routeRule]
+}
+
+// set replaces the rules
+func (r *routeRules) set(rules []routeRule) {
+	r.rules.Store(&rules)
+}
+
+// requiresKey reports whether a request to the path needs an API key. The
+// first matching rule decides and unmatched paths always need one. The query
+// is ignored, the path percent-decoded and dot segments resolved, so neither
+// /public/../admin nor /public/%2e%2e/admin is public. Paths that upstreams
+// could still decode differently, with a backslash or a percent sign left
+// after decoding, always need a key.
+func (r *routeRules) requiresKey(requestPath string) bool {
+	rules := r.rules.Load()
+	if rules == nil || len(*rules) == 0 {
+		return true
+	}
+	if i := strings.IndexAny(requestPath, "?#"); i >= 0 {
+		requestPath = requestPath[:i]
+	}
+	requestPath, err := url.PathUnescape(requestPath)
+	if err != nil || strings.ContainsAny(requestPath, `\%`) {
+		return true
+	}
+	cleaned := path.Clean("/" + requestPath)
+	// Clean drops the trailing slash, which prefix rules may rely on
+	if strings.HasSuffix(requestPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	for _, rule := range *rules {
+		if rule.matches(cleaned) {
+			return *rule.RequireKey
+		}
+	}
+	return true
+}
+
+// routeRulesWatcher keeps routeRules in sync with a ConfigMap
+type routeRulesWatcher struct {
+	client    dynamic.Interface
+	namespace string
+	name      string
+	rules     *routeRules
+}
+
+// newRouteRulesWatcher creates a watcher for the ConfigMap namespace/name
+func newRouteRulesWatcher(client dynamic.Interface, namespace, name string) *routeRulesWatcher {
+	return &routeRulesWatcher{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		rules:     &routeRules{},
+	}
+}
+
+// run watches the ConfigMap until ctx is done or stopCh is closed. Until it
+// is first seen, every route needs an API key. The ConfigMap is listed
+// before every watch, so changes made while no watch ran, such as its
+// deletion, are caught up.
+func (w *routeRulesWatcher) run(ctx context.Context, stopCh <-chan struct{}) {
+	opts := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", w.name).String()}
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		watcher, err := w.listAndWatch(ctx, opts)
+		if err != nil {
+			log.Printf("Failed to start route rules watch: %v, retrying...", err)
+			select {
+			case <-stopCh:
+				return
+			case <-ctx.Done():
+				return
+			case <-time.After(routeRulesRetryInterval):
+			}
+			continue
+		}
+
+		for event := range watcher.ResultChan() {
+			w.handleEvent(event)
+		}
+
+		watcher.Stop()
+	}
+}
+
+// listAndWatch applies the ConfigMap as currently listed, or clears the
+// rules if it is gone, then watches it from there
+func (w *routeRulesWatcher) listAndWatch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	resource := w.client.Resource(configMapGVR).Namespace(w.namespace)
+	list, err := resource.List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ConfigMap %s/%s: %w", w.namespace, w.name, err)
+	}
+
+	found := false
+	for i := range list.Items {
+		if list.Items[i].GetName() == w.name {
+			found = true
+			w.handleEvent(watch.Event{Type: watch.Added, Object: &list.Items[i]})
+		}
+	}
+	if rules := w.rules.rules.Load(); !found && rules != nil && len(*rules) > 0 {
+		w.rules.set(nil)
+		infof("ConfigMap %s/%s not found, every route requires an API key", w.namespace, w.name)
+	}
+
+	opts.ResourceVersion = list.GetResourceVersion()
+	return resource.Watch(ctx, opts)
+}
+
+// handleEvent applies a ConfigMap watch event. Invalid rules are ignored so
+// a bad edit keeps the previous ones rather than opening or closing routes.
+func (w *routeRulesWatcher) handleEvent(event watch.Event) {
+	obj, ok := event.Object.(*unstructured.Unstructured)
+	if !ok || obj.GetName() != w.name {
+		return
+	}
+
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		data, _, _ := unstructured.NestedString(obj.Object, "data", RouteRulesKey)
+		rules, err := parseRouteRules(data)
+		if err != nil {
+			warnf("Warning: ignoring ConfigMap %s/%s, keeping the previous route rules: %v", w.namespace, w.name, err)
+			return
+		}
+		w.rules.set(rules)
+		infof("Loaded %d route rules from ConfigMap %s/%s", len(rules), w.namespace, w.name)
+
+	case watch.Deleted:
+		w.rules.set(nil)
+		infof("ConfigMap %s/%s deleted, every route requires an API key", w.namespace, w.name)
+	}
+}
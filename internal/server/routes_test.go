@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"google.golang.org/grpc/codes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newPathCheckRequest builds a CheckRequest without a key for a path
+func newPathCheckRequest(path string) *envoy_service_auth_v3.CheckRequest {
+	return &envoy_service_auth_v3.CheckRequest{
+		Attributes: &envoy_service_auth_v3.AttributeContext{
+			Request: &envoy_service_auth_v3.AttributeContext_Request{
+				Http: &envoy_service_auth_v3.AttributeContext_HttpRequest{Method: "GET", Path: path},
+			},
+		},
+	}
+}
+
+// watchStarted reports whether a watch was requested from client
+func watchStarted(client *dynamicfake.FakeDynamicClient) bool {
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "watch" {
+			return true
+		}
+	}
+	return false
+}
+
+// newRouteRulesConfigMap builds an unstructured ConfigMap holding rules
+func newRouteRulesConfigMap(name, rules string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "gateway"},
+		"data":       map[string]interface{}{RouteRulesKey: rules},
+	}}
+}
+
+var _ = Describe("Route rules", func() {
+	// rulesFrom parses rules, failing the test on error
+	rulesFrom := func(data string) *routeRules {
+		parsed, err := parseRouteRules(data)
+		Expect(err).ToNot(HaveOccurred())
+		rules := &routeRules{}
+		rules.set(parsed)
+		return rules
+	}
+
+	DescribeTable("should decide which paths need a key",
+		func(path string, required bool) {
+			rules := rulesFrom(`
+- path: /public/admin/*
+  requireKey: true
+- path: /public/*
+  requireKey: false
+- path: /healthz
+  requireKey: false
+`)
+			Expect(rules.requiresKey(path)).To(Equal(required))
+		},
+		Entry("exact match", "/healthz", false),
+		Entry("exact match with a query", "/healthz?verbose=1", false),
+		Entry("exact rule is not a prefix", "/healthz/deep", true),
+		Entry("prefix match", "/public/docs/index.html", false),
+		Entry("earlier rule wins", "/public/admin/users", true),
+		Entry("prefix without its slash", "/public", true),
+		Entry("dot segments escaping a prefix", "/public/../v1/models", true),
+		Entry("dot segments within a prefix", "/public/a/../b", false),
+		Entry("encoded dot segments escaping a prefix", "/public/%2e%2e/admin", true),
+		Entry("encoded slashes escaping a prefix", "/public%2F..%2Fadmin", true),
+		Entry("encoded backslash", "/public/..%5cadmin", true),
+		Entry("double encoding", "/public/%252e%252e/admin", true),
+		Entry("invalid encoding", "/public/%zz", true),
+		Entry("encoded characters within a prefix", "/public/a%20b", false),
+		Entry("unmatched path", "/v1/models", true),
+	)
+
+	It("should require a key everywhere without rules", func() {
+		rules := &routeRules{}
+		Expect(rules.requiresKey("/healthz")).To(BeTrue())
+		rules.set(nil)
+		Expect(rules.requiresKey("/healthz")).To(BeTrue())
+	})
+
+	DescribeTable("should reject invalid rules",
+		func(data string) {
+			_, err := parseRouteRules(data)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("not a list", "path: /healthz"),
+		Entry("relative path", "- {path: healthz, requireKey: false}"),
+		Entry("inner wildcard", "- {path: /a/*/b, requireKey: false}"),
+		Entry("missing requireKey", "- {path: /healthz}"),
+		Entry("unknown field", "- {path: /healthz, requireKey: false, public: true}"),
+	)
+
+	It("should catch up with changes made while no watch ran", func() {
+		client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{configMapGVR: "ConfigMapList"},
+			newRouteRulesConfigMap("public-routes", "- {path: /docs, requireKey: false}"),
+		)
+		// Watches only end, and deliver no event, when the test says so
+		watches := make(chan *watch.FakeWatcher, 2)
+		client.PrependWatchReactor("configmaps", func(k8stesting.Action) (bool, watch.Interface, error) {
+			fake := watch.NewFake()
+			watches <- fake
+			return true, fake, nil
+		})
+		watcher := newRouteRulesWatcher(client, "gateway", "public-routes")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopCh := make(chan struct{})
+		DeferCleanup(func() {
+			cancel()
+			close(stopCh)
+		})
+		go watcher.run(ctx, stopCh)
+
+		// The ConfigMap is listed before the first watch
+		var first *watch.FakeWatcher
+		Eventually(watches).Should(Receive(&first))
+		Expect(watcher.rules.requiresKey("/docs")).To(BeFalse())
+
+		// Deleted between two watches, so no DELETED event is delivered
+		Expect(client.Resource(configMapGVR).Namespace("gateway").Delete(context.Background(), "public-routes", metav1.DeleteOptions{})).To(Succeed())
+		first.Stop()
+		Eventually(watches).Should(Receive())
+		Expect(watcher.rules.requiresKey("/docs")).To(BeTrue())
+	})
+
+	Describe("watched ConfigMap", func() {
+		var (
+			client  *dynamicfake.FakeDynamicClient
+			watcher *routeRulesWatcher
+			authz   *AuthorizationServer
+			logs    *gbytes.Buffer
+		)
+
+		BeforeEach(func() {
+			logs = gbytes.NewBuffer()
+			log.SetOutput(logs)
+			DeferCleanup(log.SetOutput, os.Stderr)
+
+			client = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{configMapGVR: "ConfigMapList"},
+			)
+			watcher = newRouteRulesWatcher(client, "gateway", "public-routes")
+
+			var err error
+			authz, err = NewAuthorizationServer(NewInMemoryStore(), &models.Config{})
+			Expect(err).ToNot(HaveOccurred())
+			authz.routes = watcher.rules
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopCh := make(chan struct{})
+			DeferCleanup(func() {
+				cancel()
+				close(stopCh)
+			})
+			go watcher.run(ctx, stopCh)
+			// The fake client only delivers events created after the watch
+			Eventually(func() bool { return watchStarted(client) }).Should(BeTrue())
+		})
+
+		// check returns the status code of a keyless request to path
+		check := func(path string) codes.Code {
+			resp, err := authz.Check(context.Background(), newPathCheckRequest(path))
+			Expect(err).ToNot(HaveOccurred())
+			return codes.Code(resp.GetStatus().GetCode())
+		}
+
+		It("should toggle a path's requirement at runtime", func() {
+			configMaps := client.Resource(configMapGVR).Namespace("gateway")
+			Expect(check("/docs")).To(Equal(codes.PermissionDenied))
+
+			_, err := configMaps.Create(context.Background(), newRouteRulesConfigMap("public-routes", "- {path: /docs, requireKey: false}"), metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(check).WithArguments("/docs").Should(Equal(codes.OK))
+			Expect(check("/v1/models")).To(Equal(codes.PermissionDenied))
+			Expect(authz.PublicAllows()).To(BeNumerically(">", 0))
+
+			_, err = configMaps.Update(context.Background(), newRouteRulesConfigMap("public-routes", "- {path: /docs, requireKey: true}"), metav1.UpdateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(check).WithArguments("/docs").Should(Equal(codes.PermissionDenied))
+
+			_, err = configMaps.Update(context.Background(), newRouteRulesConfigMap("public-routes", "- {path: /docs, requireKey: false}"), metav1.UpdateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(check).WithArguments("/docs").Should(Equal(codes.OK))
+
+			Expect(configMaps.Delete(context.Background(), "public-routes", metav1.DeleteOptions{})).To(Succeed())
+			Eventually(check).WithArguments("/docs").Should(Equal(codes.PermissionDenied))
+		})
+
+		It("should keep the previous rules when an update is invalid", func() {
+			configMaps := client.Resource(configMapGVR).Namespace("gateway")
+			_, err := configMaps.Create(context.Background(), newRouteRulesConfigMap("public-routes", "- {path: /docs, requireKey: false}"), metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(check).WithArguments("/docs").Should(Equal(codes.OK))
+
+			_, err = configMaps.Update(context.Background(), newRouteRulesConfigMap("public-routes", "- {path: /docs}"), metav1.UpdateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(logs).Should(gbytes.Say("keeping the previous route rules"))
+			Expect(check("/docs")).To(Equal(codes.OK))
+		})
+
+		It("should ignore other ConfigMaps", func() {
+			_, err := client.Resource(configMapGVR).Namespace("gateway").Create(context.Background(),
+				newRouteRulesConfigMap("other", "- {path: /docs, requireKey: false}"), metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(check, 50*time.Millisecond).WithArguments("/docs").Should(Equal(codes.PermissionDenied))
+		})
+	})
+})
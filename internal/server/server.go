@@ -3,57 +3,229 @@ package server
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/efortin/batsign/internal/apikey"
 	"github.com/efortin/batsign/internal/models"
+	"github.com/efortin/batsign/internal/version"
 	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
+// storeRetryInterval is the delay between key store start attempts when
+// failing open
+const storeRetryInterval = 5 * time.Second
+
 // Server represents the authorization server
 type Server struct {
 	config     *models.Config
-	store      *APIKeyStore
+	store      KeyStore
+	authz      *AuthorizationServer
 	grpcServer *grpc.Server
 	httpServer *http.Server
 	router     *gin.Engine
+	// health is the gRPC health service, NOT_SERVING until the keys are
+	// synced
+	health *health.Server
+
+	// adminToken is the bearer token required by the admin endpoints
+	adminToken string
+
+	// grpcOptions are extra gRPC server options, e.g. the tracing stats handler
+	grpcOptions []grpc.ServerOption
+	// grpcStats counts the gRPC connections and in-flight requests
+	grpcStats *connStats
+	// routeRules watches the paths needing no API key (nil = disabled)
+	routeRules *routeRulesWatcher
+	// adminLimiter rate limits the admin endpoints (nil when they are not
+	// served)
+	adminLimiter *adminLimiter
+	// checkLimiter bounds concurrent Check calls (nil = unlimited)
+	checkLimiter *checkLimiter
+	// shutdownTracing flushes pending spans (nil when tracing is disabled)
+	shutdownTracing func(context.Context) error
+	// stopCh stops background loops on shutdown
+	stopCh chan struct{}
 }
 
 // New creates a new server instance
 func New(config *models.Config) (*Server, error) {
+	// Validate configuration before touching Kubernetes or opening listeners
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if config.InMemory {
+		if config.KeysFile != "" {
+			store, err := NewFileStore(config.KeysFile)
+			if err != nil {
+				return nil, err
+			}
+			infof("Serving %d keys from %s without Kubernetes, reloaded on change", store.GetStats()["total"], config.KeysFile)
+			return newWithStore(config, store)
+		}
+		warnf("WARNING: using an empty in-memory key store, not intended for production")
+		return newWithStore(config, NewInMemoryStore())
+	}
+
 	// Create API key store
-	store, err := NewAPIKeyStore(config.Kubeconfig, config.Namespace)
+	store, err := NewAPIKeyStore(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create API key store: %w", err)
 	}
 
-	return &Server{
+	srv, err := newWithStore(config, store)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.EmitEvents {
+		namespace, pod := podIdentity()
+		srv.authz.events = newEventEmitter(store.client, namespace, pod)
+		infof("Emitting Kubernetes events for pod %s/%s", namespace, pod)
+	}
+
+	if config.RouteRulesConfigMap != "" {
+		namespace, name, _ := strings.Cut(config.RouteRulesConfigMap, "/")
+		srv.routeRules = newRouteRulesWatcher(store.client, namespace, name)
+		srv.authz.routes = srv.routeRules.rules
+		infof("Reading public routes from ConfigMap %s", config.RouteRulesConfigMap)
+	}
+
+	return srv, nil
+}
+
+// NewWithStore creates a new server instance backed by the given key store
+func NewWithStore(config *models.Config, store KeyStore) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newWithStore(config, store)
+}
+
+// newWithStore is NewWithStore for a config that was already validated
+func newWithStore(config *models.Config, store KeyStore) (*Server, error) {
+	if config.LogLevel != "" {
+		if err := SetLogLevel(config.LogLevel); err != nil {
+			return nil, fmt.Errorf("invalid config: log-level: %w", err)
+		}
+	}
+
+	authz, err := NewAuthorizationServer(store, config)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
 		config: config,
 		store:  store,
-	}, nil
+		authz:  authz,
+		stopCh: make(chan struct{}),
+		health: newHealthServer(),
+
+		grpcStats: &connStats{},
+	}
+	if config.MaxConcurrentChecks > 0 {
+		srv.checkLimiter = newCheckLimiter(config.MaxConcurrentChecks)
+	}
+
+	if config.BreakGlassKeyHash != "" {
+		warnf("WARNING: break-glass key configured (hash: %s...), it is allowed regardless of the key store", config.BreakGlassKeyHash[:12])
+	}
+
+	if config.TrustedKeyHeader != "" {
+		warnf("WARNING: reading API keys from the %s header first; only safe if the hop setting it overwrites client values", config.TrustedKeyHeader)
+	}
+
+	if config.AdminAPIEnabled {
+		srv.adminToken = config.AdminToken
+		if srv.adminToken == "" {
+			token, err := apikey.GenerateAPIKey()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate admin token: %w", err)
+			}
+			srv.adminToken = token
+			log.Printf("Generated admin API token (shown once): %s", token)
+		}
+
+		rate, burst := config.AdminRateLimit, config.AdminRateBurst
+		if rate == 0 {
+			rate = models.DefaultAdminRateLimit
+		}
+		if burst == 0 {
+			burst = models.DefaultAdminRateBurst
+		}
+		srv.adminLimiter = newAdminLimiter(rate, burst)
+	}
+
+	return srv, nil
+}
+
+// Handler returns the HTTP handler serving the health and stats endpoints
+func (s *Server) Handler() http.Handler {
+	if s.router == nil {
+		s.router = s.setupRouter()
+	}
+	return s.router
+}
+
+// SetDecisionHook installs the hook consulted after a key validated; call
+// it before Run
+func (s *Server) SetDecisionHook(hook DecisionHook) {
+	s.authz.SetDecisionHook(hook)
 }
 
 // Run starts the server
 func (s *Server) Run() error {
+	infof("Starting server %s", version.String())
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start watching APIKeys
-	if err := s.store.Start(ctx); err != nil {
-		return fmt.Errorf("failed to start API key store: %w", err)
+	// Export traces when a collector is configured
+	if s.config.TracingEndpoint != "" {
+		opts, shutdownTracing, err := setupTracing(ctx, s.config.TracingEndpoint)
+		if err != nil {
+			return err
+		}
+		s.grpcOptions = append(s.grpcOptions, opts...)
+		s.shutdownTracing = shutdownTracing
+		infof("Exporting traces to %s", s.config.TracingEndpoint)
+	}
+
+	// Sync the keys before any listener accepts traffic, so a new pod
+	// never answers checks from an empty cache during a rolling update
+	if err := s.startStore(ctx); err != nil {
+		return err
+	}
+
+	// Follow the public routes; until the ConfigMap is seen every path
+	// needs a key
+	if s.routeRules != nil {
+		go s.routeRules.run(ctx, s.stopCh)
+	}
+
+	// Log key counts periodically when asked to
+	if s.config.StatsLogInterval > 0 {
+		go logStats(ctx, s.stopCh, s.store, s.config.StatsLogInterval, newTicker)
 	}
 
-	// Start gRPC server
+	// Only then start the gRPC and HTTP servers
 	errChan := make(chan error, 2)
 	go func() {
 		if err := s.startGRPCServer(); err != nil {
@@ -76,11 +248,67 @@ func (s *Server) Run() error {
 	case err := <-errChan:
 		return err
 	case sig := <-sigChan:
-		log.Printf("Received signal %s, shutting down...", sig)
+		infof("Received signal %s, shutting down...", sig)
 		return s.shutdown()
 	}
 }
 
+// startStore syncs the keys and starts watching them, marking the gRPC
+// health SERVING once synced. With FailOpenUntilSynced a failed sync is
+// retried in the background, the health staying NOT_SERVING meanwhile.
+func (s *Server) startStore(ctx context.Context) error {
+	if err := s.store.Start(ctx); err != nil {
+		if !s.config.FailOpenUntilSynced {
+			return fmt.Errorf("failed to start API key store: %w", err)
+		}
+		warnf("WARNING: failed to start API key store, allowing all requests until it syncs: %v", err)
+		go s.retryStoreStart(ctx)
+		return nil
+	}
+	s.markSynced()
+	return nil
+}
+
+// newHealthServer creates a gRPC health service reporting NOT_SERVING, for
+// the server as a whole and the ext_authz service
+func newHealthServer() *health.Server {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus(authzServiceName, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	return healthServer
+}
+
+// authzServiceName is the gRPC service name of ext_authz
+var authzServiceName = envoy_service_auth_v3.Authorization_ServiceDesc.ServiceName
+
+// markSynced reports the gRPC health SERVING once the keys are synced
+func (s *Server) markSynced() {
+	s.health.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	s.health.SetServingStatus(authzServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+}
+
+// retryStoreStart retries starting the key store until it syncs, while
+// requests are allowed unchecked (FailOpenUntilSynced)
+func (s *Server) retryStoreStart(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopCh:
+			return
+		case <-time.After(storeRetryInterval):
+		}
+
+		if err := s.store.Start(ctx); err != nil {
+			warnf("WARNING: failed to start API key store, still allowing all requests: %v", err)
+			continue
+		}
+		infof("API key store synced, enforcing API keys")
+		s.markSynced()
+		return
+	}
+}
+
 // startGRPCServer starts the gRPC server for Envoy ext_authz
 func (s *Server) startGRPCServer() error {
 	addr := fmt.Sprintf(":%d", s.config.GRPCPort)
@@ -89,27 +317,64 @@ func (s *Server) startGRPCServer() error {
 		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
 
-	// Create gRPC server
-	s.grpcServer = grpc.NewServer()
+	s.grpcServer = s.newGRPCServer()
+
+	infof("gRPC server listening on %s", addr)
+	return s.grpcServer.Serve(lis)
+}
+
+// newGRPCServer creates the gRPC server and registers its services
+func (s *Server) newGRPCServer() *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{recoveryInterceptor}
+	if s.checkLimiter != nil {
+		interceptors = append(interceptors, s.checkLimiter.interceptor)
+	}
+	opts := append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.StatsHandler(s.grpcStats),
+	}, s.grpcOptions...)
+	grpcServer := grpc.NewServer(opts...)
 
 	// Register authorization service
-	authzServer := NewAuthorizationServer(s.store)
-	envoy_service_auth_v3.RegisterAuthorizationServer(s.grpcServer, authzServer)
+	envoy_service_auth_v3.RegisterAuthorizationServer(grpcServer, s.authz)
 
 	// Register health service
-	healthServer := health.NewServer()
-	grpc_health_v1.RegisterHealthServer(s.grpcServer, healthServer)
-	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(grpcServer, s.health)
 
-	// Register reflection service (useful for debugging)
-	reflection.Register(s.grpcServer)
+	// Register reflection service (useful for debugging, but it exposes the
+	// full service surface to anyone reaching the port)
+	if s.config.EnableReflection {
+		reflection.Register(grpcServer)
+	}
 
-	log.Printf("gRPC server listening on %s", addr)
-	return s.grpcServer.Serve(lis)
+	return grpcServer
+}
+
+// recoveryInterceptor turns a panic in a unary handler into an Internal
+// error, letting Envoy apply its failure mode instead of losing the process
+func recoveryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic in %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			resp, err = nil, grpcstatus.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
 }
 
 // startHTTPServer starts the HTTP server for health checks
 func (s *Server) startHTTPServer() error {
+	s.httpServer = &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.config.HTTPPort),
+		Handler: s.Handler(),
+	}
+
+	infof("HTTP server listening on %s", s.httpServer.Addr)
+	return s.httpServer.ListenAndServe()
+}
+
+// setupRouter creates the Gin router and registers the HTTP routes
+func (s *Server) setupRouter() *gin.Engine {
 	// Set Gin mode based on log level
 	if s.config.LogLevel == "debug" {
 		gin.SetMode(gin.DebugMode)
@@ -118,25 +383,46 @@ func (s *Server) startHTTPServer() error {
 	}
 
 	// Create Gin router
-	s.router = gin.New()
-	s.router.Use(gin.Recovery())
+	router := gin.New()
+	router.Use(gin.CustomRecoveryWithWriter(io.Discard, recoveryHandler))
 
-	if s.config.LogLevel == "debug" {
-		s.router.Use(gin.Logger())
-	}
+	// Requests are logged while the level is debug, which may change at
+	// runtime through PUT /loglevel
+	requestLogger := gin.Logger()
+	router.Use(func(c *gin.Context) {
+		if debugEnabled() {
+			requestLogger(c)
+			return
+		}
+		c.Next()
+	})
 
 	// Register routes
-	s.router.GET("/health", s.healthHandler)
-	s.router.GET("/ready", s.readyHandler)
-	s.router.GET("/stats", s.statsHandler)
+	router.GET("/health", s.healthHandler)
+	router.GET("/livez", s.livezHandler)
+	router.GET("/ready", s.readyHandler)
+	router.GET("/stats", s.statsHandler)
+	router.GET("/version", s.versionHandler)
+	router.GET("/denials", s.denialsHandler)
 
-	s.httpServer = &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.config.HTTPPort),
-		Handler: s.router,
+	if s.config.AdminAPIEnabled {
+		// Limited before authentication, so token guesses count too
+		admin := router.Group("/", s.adminLimiter.middleware, adminAuth(s.adminToken))
+		admin.GET("/keys", s.listKeysHandler)
+		admin.GET("/export", s.exportHandler)
+		admin.GET("/loglevel", s.getLogLevelHandler)
+		admin.PUT("/loglevel", s.setLogLevelHandler)
+		admin.POST("/debug/check", s.debugCheckHandler)
 	}
 
-	log.Printf("HTTP server listening on %s", s.httpServer.Addr)
-	return s.httpServer.ListenAndServe()
+	return router
+}
+
+// recoveryHandler logs a recovered panic and returns a minimal 500 so that
+// stack traces never reach the client, whatever the log level
+func recoveryHandler(c *gin.Context, err any) {
+	log.Printf("Recovered from panic in %s %s: %v\n%s", c.Request.Method, c.Request.URL.Path, err, debug.Stack())
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal"})
 }
 
 // healthHandler handles health check requests
@@ -144,6 +430,19 @@ func (s *Server) healthHandler(c *gin.Context) {
 	c.String(http.StatusOK, "OK")
 }
 
+// livezHandler fails once the store stopped updating its keys, e.g. because
+// a watch goroutine died, so the Pod is restarted instead of serving an
+// ever staler cache
+func (s *Server) livezHandler(c *gin.Context) {
+	if store, ok := s.store.(livenessReporter); ok {
+		if err := store.Alive(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.String(http.StatusOK, "OK")
+}
+
 // readyHandler handles readiness check requests
 func (s *Server) readyHandler(c *gin.Context) {
 	stats := s.store.GetStats()
@@ -156,19 +455,32 @@ func (s *Server) readyHandler(c *gin.Context) {
 	c.String(http.StatusOK, "Ready")
 }
 
-// statsHandler returns statistics about loaded API keys
-func (s *Server) statsHandler(c *gin.Context) {
-	stats := s.store.GetStats()
+// denialsHandler returns the number of denied requests per reason
+func (s *Server) denialsHandler(c *gin.Context) {
+	window := "cumulative"
+	if s.config.DenialWindow > 0 {
+		window = s.config.DenialWindow.String()
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"total":    stats["total"],
-		"enabled":  stats["enabled"],
-		"disabled": stats["disabled"],
+		"window": window,
+		"counts": s.authz.DenialCounts(),
 	})
 }
 
+// versionHandler returns the build information of the running server
+func (s *Server) versionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, version.Get())
+}
+
 // shutdown gracefully shuts down the server
 func (s *Server) shutdown() error {
-	log.Println("Shutting down servers...")
+	infof("Shutting down servers...")
+
+	// Stop background loops
+	close(s.stopCh)
+
+	// Report NOT_SERVING while draining
+	s.health.Shutdown()
 
 	// Stop the API key store
 	s.store.Stop()
@@ -187,6 +499,15 @@ func (s *Server) shutdown() error {
 		}
 	}
 
-	log.Println("Shutdown complete")
+	// Flush pending spans
+	if s.shutdownTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}
+
+	infof("Shutdown complete")
 	return nil
 }
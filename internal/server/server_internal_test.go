@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+	"github.com/efortin/batsign/internal/version"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// gatedStore is an InMemoryStore whose Start blocks until released, then
+// fails with err when set
+type gatedStore struct {
+	*InMemoryStore
+	release chan struct{}
+	err     error
+}
+
+// Start waits for the release, like a store syncing its keys
+func (s *gatedStore) Start(ctx context.Context) error {
+	<-s.release
+	if s.err != nil {
+		return s.err
+	}
+	return s.InMemoryStore.Start(ctx)
+}
+
+var _ = Describe("Server routes", func() {
+	var srv *Server
+
+	BeforeEach(func() {
+		srv = &Server{
+			config: &models.Config{LogLevel: "info"},
+			health: newHealthServer(),
+		}
+	})
+
+	Describe("Version Endpoint", func() {
+		var origVersion, origCommit, origBuildDate string
+
+		BeforeEach(func() {
+			origVersion, origCommit, origBuildDate = version.Version, version.Commit, version.BuildDate
+			version.Version = "v1.2.3"
+			version.Commit = "abc1234"
+			version.BuildDate = "2024-01-01T00:00:00Z"
+		})
+
+		AfterEach(func() {
+			version.Version, version.Commit, version.BuildDate = origVersion, origCommit, origBuildDate
+		})
+
+		It("should return the injected build information", func() {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/version", nil)
+			srv.setupRouter().ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			var info version.Info
+			Expect(json.Unmarshal(rec.Body.Bytes(), &info)).To(Succeed())
+			Expect(info.Version).To(Equal("v1.2.3"))
+			Expect(info.Commit).To(Equal("abc1234"))
+			Expect(info.BuildDate).To(Equal("2024-01-01T00:00:00Z"))
+			Expect(info.GoVersion).ToNot(BeEmpty())
+		})
+	})
+	Describe("gRPC server", func() {
+		const reflectionService = "grpc.reflection.v1.ServerReflection"
+
+		It("should not register reflection when disabled", func() {
+			services := srv.newGRPCServer().GetServiceInfo()
+			Expect(services).To(HaveKey("envoy.service.auth.v3.Authorization"))
+			Expect(services).ToNot(HaveKey(reflectionService))
+		})
+
+		It("should register reflection when enabled", func() {
+			srv.config.EnableReflection = true
+			Expect(srv.newGRPCServer().GetServiceInfo()).To(HaveKey(reflectionService))
+		})
+	})
+	Describe("gRPC health", func() {
+		var (
+			store *gatedStore
+			srv   *Server
+		)
+
+		BeforeEach(func() {
+			store = &gatedStore{InMemoryStore: NewInMemoryStore(), release: make(chan struct{})}
+			var err error
+			srv, err = NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080}, store)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		// health returns the status of a service, "" for the whole server
+		health := func(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+			resp, err := srv.health.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+			Expect(err).ToNot(HaveOccurred())
+			return resp.GetStatus()
+		}
+
+		It("should report NOT_SERVING until the keys are synced", func() {
+			started := make(chan error, 1)
+			go func() {
+				started <- srv.startStore(context.Background())
+			}()
+
+			Consistently(func() grpc_health_v1.HealthCheckResponse_ServingStatus { return health("") }, 50*time.Millisecond).
+				Should(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+			Expect(health(authzServiceName)).To(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+
+			close(store.release)
+			Eventually(started).Should(Receive(BeNil()))
+			Expect(health("")).To(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+			Expect(health(authzServiceName)).To(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		})
+
+		It("should stay NOT_SERVING when the sync fails", func() {
+			store.err = errors.New("etcd is down")
+			close(store.release)
+
+			Expect(srv.startStore(context.Background())).To(MatchError(ContainSubstring("etcd is down")))
+			Expect(health("")).To(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		})
+	})
+	Describe("Recovery", func() {
+		for _, level := range []string{"info", "debug"} {
+			It("should return a clean 500 without a stack trace at log level "+level, func() {
+				srv.config.LogLevel = level
+				router := srv.setupRouter()
+				router.GET("/panic", func(c *gin.Context) {
+					panic("secret internal state")
+				})
+
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+				Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+				Expect(rec.Body.String()).To(MatchJSON(`{"error":"internal"}`))
+				Expect(rec.Body.String()).ToNot(ContainSubstring("secret internal state"))
+				Expect(rec.Body.String()).ToNot(ContainSubstring("goroutine"))
+			})
+		}
+	})
+	Describe("Admin token", func() {
+		It("should generate a token when none is configured", func() {
+			srv, err := NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080, AdminAPIEnabled: true}, NewInMemoryStore())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(srv.adminToken).To(HavePrefix("sk-"))
+		})
+
+		It("should use the configured token", func() {
+			srv, err := NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080, AdminAPIEnabled: true, AdminToken: "operator-token"}, NewInMemoryStore())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(srv.adminToken).To(Equal("operator-token"))
+		})
+	})
+	Describe("gRPC recovery", func() {
+		info := &grpc.UnaryServerInfo{FullMethod: "/envoy.service.auth.v3.Authorization/Check"}
+
+		It("should turn a panic into a clean Internal error", func() {
+			resp, err := recoveryInterceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+				panic("nil pointer in malformed request")
+			})
+
+			Expect(resp).To(BeNil())
+			Expect(status.Code(err)).To(Equal(codes.Internal))
+			Expect(status.Convert(err).Message()).To(Equal("internal error"))
+		})
+
+		It("should pass through normal responses", func() {
+			resp, err := recoveryInterceptor(context.Background(), "request", info, func(ctx context.Context, req any) (any, error) {
+				return req, nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp).To(Equal("request"))
+		})
+	})
+})
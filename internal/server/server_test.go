@@ -1,27 +1,208 @@
 package server_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+	"github.com/efortin/batsign/internal/server"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
 )
 
+var _ = Describe("New", func() {
+	Context("with invalid port configuration", func() {
+		It("should reject equal gRPC and HTTP ports", func() {
+			_, err := server.New(&models.Config{GRPCPort: 8080, HTTPPort: 8080})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("must differ"))
+		})
+
+		DescribeTable("should reject out-of-range ports",
+			func(grpcPort, httpPort int, flag string) {
+				_, err := server.New(&models.Config{GRPCPort: grpcPort, HTTPPort: httpPort})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(flag))
+				Expect(err.Error()).To(ContainSubstring("out of range"))
+			},
+			Entry("zero gRPC port", 0, 8080, "grpc-port"),
+			Entry("negative gRPC port", -1, 8080, "grpc-port"),
+			Entry("gRPC port above 65535", 70000, 8080, "grpc-port"),
+			Entry("zero HTTP port", 9191, 0, "http-port"),
+			Entry("HTTP port above 65535", 9191, 65536, "http-port"),
+		)
+	})
+
+	Context("in in-memory mode", func() {
+		It("should load the keys file without Kubernetes", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "keys.yaml")
+			Expect(os.WriteFile(path, []byte("- email: user@example.com\n  keyHash: aaaa\n"), 0o600)).To(Succeed())
+
+			srv, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, KeysFile: path})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(srv).ToNot(BeNil())
+		})
+
+		It("should reject a keys file outside in-memory mode", func() {
+			_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, KeysFile: "keys.yaml"})
+			Expect(err).To(MatchError(ContainSubstring("in-memory")))
+		})
+	})
+
+	DescribeTable("should reject an out-of-range allow cache TTL",
+		func(ttl time.Duration) {
+			_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, AllowCacheTTL: ttl})
+			Expect(err).To(MatchError(ContainSubstring("allow-cache-ttl")))
+		},
+		Entry("negative", -time.Second),
+		Entry("above the maximum", models.MaxAllowCacheTTL+time.Second),
+	)
+
+	It("should reject a negative denial window", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, DenialWindow: -time.Minute})
+		Expect(err).To(MatchError(ContainSubstring("denial-window")))
+	})
+
+	DescribeTable("should reject negative Kubernetes client limits",
+		func(cfg models.Config, field string) {
+			cfg.GRPCPort, cfg.HTTPPort, cfg.InMemory = 9191, 8080, true
+			_, err := server.New(&cfg)
+			Expect(err).To(MatchError(ContainSubstring(field)))
+		},
+		Entry("QPS", models.Config{KubeQPS: -1}, "kube-qps"),
+		Entry("burst", models.Config{KubeBurst: -1}, "kube-burst"),
+		Entry("backoff", models.Config{KubeBackoffMax: -time.Second}, "kube-backoff-max"),
+	)
+
+	It("should reject an alert webhook URL that is not http(s)", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, AlertWebhookURL: "alerts.example.com/hook"})
+		Expect(err).To(MatchError(ContainSubstring("alert-webhook-url")))
+	})
+
+	It("should reject an external validator URL that is not http(s)", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, ExternalValidatorURL: "validator.example.com/check"})
+		Expect(err).To(MatchError(ContainSubstring("external-validator-url")))
+	})
+
+	It("should reject negative external validator durations", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, ExternalValidatorURL: "http://validator", ExternalValidatorCacheTTL: -time.Second})
+		Expect(err).To(MatchError(ContainSubstring("external-validator-cache-ttl")))
+	})
+
+	It("should reject a watch liveness timeout shorter than the heartbeats", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, WatchLivenessTimeout: 5 * time.Second})
+		Expect(err).To(MatchError(ContainSubstring("watch-liveness-timeout")))
+	})
+
+	It("should reject a negative admin rate limit", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, AdminRateBurst: -1})
+		Expect(err).To(MatchError(ContainSubstring("admin-rate-burst")))
+	})
+
+	It("should reject a break-glass key that is not a SHA-256 hash", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, BreakGlassKeyHash: "sk-raw-key"})
+		Expect(err).To(MatchError(ContainSubstring("break-glass-key-hash")))
+	})
+
+	It("should reject a negative maximum API key length", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, MaxAPIKeyLength: -1})
+		Expect(err).To(MatchError(ContainSubstring("max-api-key-length")))
+	})
+
+	DescribeTable("should reject allow response headers that could inject headers",
+		func(name, value string) {
+			_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, AllowResponseHeaders: map[string]string{name: value}})
+			Expect(err).To(MatchError(ContainSubstring("allow-response-headers")))
+		},
+		Entry("CRLF in value", "x-team", "a\r\nx-admin: true"),
+		Entry("LF in name", "x-team\nx-admin", "a"),
+		Entry("empty name", "", "a"),
+		Entry("pseudo-header", ":authority", "evil.example.com"),
+	)
+
+	DescribeTable("should reject a key ID header that is malformed or set twice",
+		func(header string, allowHeaders map[string]string) {
+			_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, KeyIDHeader: header, AllowResponseHeaders: allowHeaders})
+			Expect(err).To(MatchError(ContainSubstring("key-id-header")))
+		},
+		Entry("CRLF", "x-api-key-id\r\nx-admin: true", nil),
+		Entry("pseudo-header", ":authority", nil),
+		Entry("allow response header", "X-API-Key-ID", map[string]string{"x-api-key-id": "static"}),
+	)
+
+	DescribeTable("should reject a malformed or unusable route rules ConfigMap",
+		func(ref string, inMemory bool) {
+			_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: inMemory, RouteRulesConfigMap: ref})
+			Expect(err).To(MatchError(ContainSubstring("route-rules-configmap")))
+		},
+		Entry("missing namespace", "public-routes", false),
+		Entry("empty name", "gateway/", false),
+		Entry("extra segment", "gateway/public/routes", false),
+		Entry("in-memory mode", "gateway/public-routes", true),
+	)
+
+	DescribeTable("should reject an unusable deny redirect",
+		func(url string, status int) {
+			_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, DenyRedirectURL: url, DenyRedirectStatus: status})
+			Expect(err).To(MatchError(ContainSubstring("deny-redirect")))
+		},
+		Entry("relative URL", "docs/keys", 0),
+		Entry("other scheme", "javascript:alert(1)", 0),
+		Entry("not a redirect status", "https://docs.example.com/keys", 403),
+	)
+
+	It("should reject a trusted key header that is not a header name", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, TrustedKeyHeader: "x-mesh-key: sk"})
+		Expect(err).To(MatchError(ContainSubstring("trusted-key-header")))
+	})
+
+	It("should reject a negative stats log interval", func() {
+		_, err := server.New(&models.Config{GRPCPort: 9191, HTTPPort: 8080, InMemory: true, StatsLogInterval: -time.Minute})
+		Expect(err).To(MatchError(ContainSubstring("stats-log-interval")))
+	})
+})
+
 var _ = Describe("Server HTTP Handlers", func() {
+	var (
+		store  *server.InMemoryStore
+		config *models.Config
+	)
+
 	BeforeEach(func() {
-		// Setup test environment
-		GinkgoWriter.Println("Setting up test server")
+		store = server.NewInMemoryStore()
+		config = &models.Config{GRPCPort: 9191, HTTPPort: 8080, LogLevel: "info"}
 	})
 
-	AfterEach(func() {
-		// Cleanup
-		GinkgoWriter.Println("Cleaning up test server")
-	})
+	getWithHeaders := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		srv, err := server.NewWithStore(config, store)
+		Expect(err).ToNot(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		return getWithHeaders(path, nil)
+	}
 
 	Describe("Health Endpoint", func() {
 		Context("when called", func() {
 			It("should return 200 OK", func() {
-				// This is a placeholder - we'll implement proper testing
-				// after we can instantiate the server with mocked dependencies
-				Expect(true).To(BeTrue())
+				rec := get("/health")
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Body.String()).To(Equal("OK"))
 			})
 		})
 	})
@@ -29,15 +210,16 @@ var _ = Describe("Server HTTP Handlers", func() {
 	Describe("Ready Endpoint", func() {
 		Context("when no API keys are loaded", func() {
 			It("should return 503 Service Unavailable", func() {
-				// Placeholder for actual test
-				Expect(true).To(BeTrue())
+				rec := get("/ready")
+				Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
 			})
 		})
 
 		Context("when API keys are loaded", func() {
 			It("should return 200 OK", func() {
-				// Placeholder for actual test
-				Expect(true).To(BeTrue())
+				store.Add(models.APIKeyEntry{KeyHash: "hash", Enabled: true})
+				rec := get("/ready")
+				Expect(rec.Code).To(Equal(http.StatusOK))
 			})
 		})
 	})
@@ -45,13 +227,231 @@ var _ = Describe("Server HTTP Handlers", func() {
 	Describe("Stats Endpoint", func() {
 		Context("when called", func() {
 			It("should return JSON with statistics", func() {
-				// Placeholder for actual test
-				Expect(true).To(BeTrue())
+				rec := get("/stats")
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Header().Get("Content-Type")).To(HavePrefix("application/json"))
 			})
 
 			It("should include total, enabled, and disabled counts", func() {
-				// Placeholder for actual test
-				Expect(true).To(BeTrue())
+				store.Add(models.APIKeyEntry{KeyHash: "a", Enabled: true})
+				store.Add(models.APIKeyEntry{KeyHash: "b", Enabled: false})
+
+				var stats map[string]int
+				Expect(json.Unmarshal(get("/stats").Body.Bytes(), &stats)).To(Succeed())
+				Expect(stats).To(Equal(map[string]int{"total": 2, "enabled": 1, "disabled": 1, "grpcConnections": 0, "grpcRequestsInFlight": 0}))
+			})
+
+			DescribeTable("should honor the Accept header",
+				func(accept, contentType, body string) {
+					store.Add(models.APIKeyEntry{KeyHash: "a", Enabled: true})
+					store.Add(models.APIKeyEntry{KeyHash: "b", Enabled: false})
+
+					rec := getWithHeaders("/stats", map[string]string{"Accept": accept})
+					Expect(rec.Code).To(Equal(http.StatusOK))
+					Expect(rec.Header().Get("Content-Type")).To(Equal(contentType))
+					if strings.HasPrefix(contentType, "application/json") {
+						Expect(rec.Body.String()).To(MatchJSON(body))
+					} else {
+						Expect(rec.Body.String()).To(Equal(body))
+					}
+				},
+				Entry("no Accept header", "", "application/json; charset=utf-8",
+					`{"total": 2, "enabled": 1, "disabled": 1, "grpcConnections": 0, "grpcRequestsInFlight": 0}`),
+				Entry("JSON", "application/json", "application/json; charset=utf-8",
+					`{"total": 2, "enabled": 1, "disabled": 1, "grpcConnections": 0, "grpcRequestsInFlight": 0}`),
+				Entry("browser default", "text/html,application/xhtml+xml,*/*;q=0.8", "application/json; charset=utf-8",
+					`{"total": 2, "enabled": 1, "disabled": 1, "grpcConnections": 0, "grpcRequestsInFlight": 0}`),
+				Entry("plain text", "text/plain", "text/plain; charset=utf-8",
+					"total: 2\nenabled: 1\ndisabled: 1\ngrpcConnections: 0\ngrpcRequestsInFlight: 0\n"),
+				Entry("Prometheus scraper", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1",
+					"text/plain; version=0.0.4; charset=utf-8",
+					"# HELP batsign_keys Number of loaded API keys.\n# TYPE batsign_keys gauge\nbatsign_keys 2\n"+
+						"# HELP batsign_keys_enabled Number of loaded enabled API keys.\n# TYPE batsign_keys_enabled gauge\nbatsign_keys_enabled 1\n"+
+						"# HELP batsign_keys_disabled Number of loaded disabled API keys.\n# TYPE batsign_keys_disabled gauge\nbatsign_keys_disabled 1\n"+
+						"# HELP batsign_grpc_connections Number of open gRPC connections, e.g. from Envoy.\n# TYPE batsign_grpc_connections gauge\nbatsign_grpc_connections 0\n"+
+						"# HELP batsign_grpc_requests_in_flight Number of gRPC requests being served.\n# TYPE batsign_grpc_requests_in_flight gauge\nbatsign_grpc_requests_in_flight 0\n"),
+				Entry("unsupported type", "application/xml", "application/json; charset=utf-8",
+					`{"total": 2, "enabled": 1, "disabled": 1, "grpcConnections": 0, "grpcRequestsInFlight": 0}`),
+			)
+
+			It("should include the break-glass allow count when configured", func() {
+				config.BreakGlassKeyHash = strings.Repeat("a", 64)
+
+				var stats map[string]int
+				Expect(json.Unmarshal(get("/stats").Body.Bytes(), &stats)).To(Succeed())
+				Expect(stats).To(HaveKeyWithValue("breakGlassAllows", 0))
+			})
+
+			It("should include per-key decisions when enabled", func() {
+				config.PerKeyMetrics = true
+
+				Expect(get("/stats").Body.String()).To(ContainSubstring(`"keyDecisions":{}`))
+				rec := getWithHeaders("/stats", map[string]string{"Accept": "text/plain;version=0.0.4"})
+				Expect(rec.Body.String()).To(ContainSubstring("# TYPE batsign_key_decisions_total counter\n"))
+			})
+
+			It("should include the fail-open allow count when failing open", func() {
+				config.FailOpenUntilSynced = true
+
+				var stats map[string]int
+				Expect(json.Unmarshal(get("/stats").Body.Bytes(), &stats)).To(Succeed())
+				Expect(stats).To(HaveKeyWithValue("failOpenAllows", 0))
+			})
+		})
+	})
+	Describe("Denials Endpoint", func() {
+		It("should return zeroed cumulative counts", func() {
+			rec := get("/denials")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(`{
+				"window": "cumulative",
+				"counts": {"missing": 0, "invalid": 0, "disabled": 0, "method": 0}
+			}`))
+		})
+
+		It("should report the configured window", func() {
+			config.DenialWindow = 5 * time.Minute
+
+			var resp map[string]interface{}
+			Expect(json.Unmarshal(get("/denials").Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp["window"]).To(Equal("5m0s"))
+		})
+	})
+
+	Describe("Keys Endpoint", func() {
+		type keysResponse struct {
+			Keys []map[string]interface{} `json:"keys"`
+		}
+
+		const adminToken = "test-admin-token"
+
+		getAdmin := func(path string) *httptest.ResponseRecorder {
+			return getWithHeaders(path, map[string]string{"Authorization": "Bearer " + adminToken})
+		}
+
+		listKeys := func(path string) keysResponse {
+			rec := getAdmin(path)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			var resp keysResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+			return resp
+		}
+
+		BeforeEach(func() {
+			config.AdminAPIEnabled = true
+			config.AdminToken = adminToken
+			store.Add(models.APIKeyEntry{Name: "alice", Email: "alice@example.com", KeyHash: "a", KeyHint: "sk-abc*************78", Enabled: true})
+			store.Add(models.APIKeyEntry{Name: "bob", Email: "bob@example.com", KeyHash: "b", KeyHint: "sk-abc*************78", Enabled: false})
+			store.Add(models.APIKeyEntry{Name: "carol", Email: "carol@example.com", KeyHash: "c", KeyHint: "sk-xyz*************12", Enabled: true})
+		})
+
+		It("should not be served unless the admin API is enabled", func() {
+			config.AdminAPIEnabled = false
+			Expect(get("/keys").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should surface notes", func() {
+			store.Add(models.APIKeyEntry{Name: "dave", KeyHash: "d", Enabled: true, Notes: "Owned by the data team"})
+
+			resp := listKeys("/keys")
+			Expect(resp.Keys).To(ContainElement(HaveKeyWithValue("notes", "Owned by the data team")))
+		})
+
+		It("should surface when the keys were loaded, when tracked", func() {
+			loadedAt := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+			store.Add(models.APIKeyEntry{Name: "dave", KeyHash: "d", Enabled: true, LoadedAt: loadedAt})
+
+			resp := listKeys("/keys")
+			Expect(resp.Keys).To(ContainElement(HaveKeyWithValue("loadedAt", "2026-10-16T09:30:00Z")))
+			Expect(resp.Keys).To(ContainElement(And(HaveKeyWithValue("name", "alice"), Not(HaveKey("loadedAt")))))
+		})
+
+		It("should list all keys without exposing hashes", func() {
+			resp := listKeys("/keys")
+			Expect(resp.Keys).To(HaveLen(3))
+			for _, key := range resp.Keys {
+				Expect(key).ToNot(HaveKey("keyHash"))
+			}
+		})
+
+		It("should return every key matching an exact hint", func() {
+			resp := listKeys("/keys?hint=sk-abc*************78")
+			Expect(resp.Keys).To(HaveLen(2))
+			Expect(resp.Keys[0]["name"]).To(Equal("alice"))
+			Expect(resp.Keys[1]["name"]).To(Equal("bob"))
+		})
+
+		It("should return no keys for an unknown hint", func() {
+			Expect(listKeys("/keys?hint=sk-abc*************79").Keys).To(BeEmpty())
+		})
+
+		It("should combine the hint with the email and enabled filters", func() {
+			Expect(listKeys("/keys?hint=sk-abc*************78&enabled=true").Keys).To(HaveLen(1))
+			Expect(listKeys("/keys?hint=sk-abc*************78&email=BOB@example.com").Keys).To(HaveLen(1))
+		})
+
+		It("should reject an invalid enabled filter", func() {
+			Expect(getAdmin("/keys?enabled=maybe").Code).To(Equal(http.StatusBadRequest))
+		})
+
+		DescribeTable("should require the admin token",
+			func(headers map[string]string, code int) {
+				Expect(getWithHeaders("/keys", headers).Code).To(Equal(code))
+			},
+			Entry("missing", nil, http.StatusUnauthorized),
+			Entry("wrong", map[string]string{"Authorization": "Bearer wrong-token"}, http.StatusUnauthorized),
+			Entry("not a bearer token", map[string]string{"Authorization": adminToken}, http.StatusUnauthorized),
+			Entry("correct", map[string]string{"Authorization": "Bearer " + adminToken}, http.StatusOK),
+		)
+
+		It("should not require the admin token on public endpoints", func() {
+			Expect(get("/stats").Code).To(Equal(http.StatusOK))
+		})
+
+		Describe("export", func() {
+			It("should round-trip names, hashes and enabled flags as APIKey resources", func() {
+				store.Add(models.APIKeyEntry{
+					Name: "dave@example.com", Email: "dave@example.com", KeyHash: "d", Enabled: true,
+					Source: models.SourceFile, AllowedMethods: []string{"GET"},
+				})
+				store.Add(models.APIKeyEntry{
+					Name: "alice", Namespace: "team-b", Email: "alice@team-b.io", KeyHash: "e", Enabled: true,
+					Source: models.SourceAPIKey,
+				})
+
+				rec := getAdmin("/export")
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Header().Get("Content-Type")).To(Equal("application/yaml"))
+
+				var exported []models.APIKey
+				for _, doc := range strings.Split(strings.TrimPrefix(rec.Body.String(), "---\n"), "---\n") {
+					var resource models.APIKey
+					Expect(yaml.UnmarshalStrict([]byte(doc), &resource)).To(Succeed())
+					Expect(resource.Kind).To(Equal("APIKey"))
+					exported = append(exported, resource)
+				}
+
+				Expect(exported).To(HaveLen(5))
+				summary := make([]string, len(exported))
+				for i, resource := range exported {
+					summary[i] = fmt.Sprintf("%s/%s %s %v", resource.Namespace, resource.Name, resource.Spec.KeyHash, resource.Spec.Enabled)
+				}
+				// Entries are sorted by their in-memory name, then namespace;
+				// file keys are named after their email, and keys from the
+				// cluster keep their namespace
+				Expect(summary).To(Equal([]string{
+					"/alice a true",
+					"team-b/alice e true",
+					"/bob b false",
+					"/carol c true",
+					"/dave-at-example-com d true",
+				}))
+				Expect(exported[4].Spec.AllowedMethods).To(Equal([]string{"GET"}))
+			})
+
+			It("should require the admin token", func() {
+				Expect(get("/export").Code).To(Equal(http.StatusUnauthorized))
 			})
 		})
 	})
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// heartbeatInterval is how often a watching source records that its
+// goroutine still runs, even while no change arrives
+const heartbeatInterval = 10 * time.Second
+
+// listPageSize is the number of resources a list call returns at most, so
+// clusters with tens of thousands of keys are listed in chunks instead of
+// one giant response
+const listPageSize = 500
+
+// KeyEventType is the kind of change a KeyEvent reports
+type KeyEventType string
+
+// Key event types
+const (
+	KeyAdded    KeyEventType = "ADDED"
+	KeyModified KeyEventType = "MODIFIED"
+	KeyDeleted  KeyEventType = "DELETED"
+	// KeyStale reports that the source stopped receiving changes, e.g. its
+	// watch ended; its keys may be stale until its next KeyResync
+	KeyStale KeyEventType = "STALE"
+	// KeyResync replaces all the items of the source with Items, e.g. once
+	// it relisted them after a KeyStale
+	KeyResync KeyEventType = "RESYNC"
+)
+
+// KeyEvent is a change of an item of a KeySource, e.g. a resource
+type KeyEvent struct {
+	Type KeyEventType
+	// Key identifies the item within its source, e.g. the namespace/name of
+	// a resource; for KeyStale and KeyResync, it names the source in logs
+	Key string
+	// Entry is the key the item provides (nil when it provides none)
+	Entry *models.APIKeyEntry
+	// Err is why the item was skipped, reported on /stats
+	Err error
+	// Items holds the current items of the source, for KeyResync
+	Items []KeyEvent
+}
+
+// KeySource provides keys to a SourceStore
+type KeySource interface {
+	// Sync lists the current items of the source, as KeyAdded events
+	Sync(ctx context.Context) ([]KeyEvent, error)
+	// Start begins sending the changes of the source on Events, until the
+	// context is done. Sources that may miss changes send KeyStale, then
+	// KeyResync once they caught up.
+	Start(ctx context.Context) error
+	// Events returns the channel the changes are sent on
+	Events() <-chan KeyEvent
+}
+
+var _ KeySource = (*kubeSource)(nil)
+
+// kubeSource is the KeySource of a Kubernetes resource: APIKeys, or the
+// Secrets matching a label selector
+type kubeSource struct {
+	// kind and kinds name the keys in logs, e.g. "APIKey" and "APIKeys"
+	kind  string
+	kinds string
+
+	client    dynamic.Interface
+	gvr       schema.GroupVersionResource
+	namespace string
+	opts      metav1.ListOptions
+	// pageSize is the number of resources listed per call (0 = all at once)
+	pageSize int64
+	// parse returns the entry of a resource, nil when it holds no key, or
+	// why it is skipped
+	parse func(*unstructured.Unstructured) (*models.APIKeyEntry, error)
+	// breaker pauses re-watches while the API server keeps failing
+	breaker *watchBreaker
+	events  chan KeyEvent
+	// heartbeat is when the watch goroutine last ran (Unix nanoseconds, 0 =
+	// not started), so one that died unnoticed can be told apart
+	heartbeat atomic.Int64
+	now       func() time.Time
+}
+
+// resource returns the client for the resource, scoped to the watched
+// namespace
+func (k *kubeSource) resource() dynamic.ResourceInterface {
+	if k.namespace == "" {
+		// All namespaces
+		return k.client.Resource(k.gvr)
+	}
+	// Specific namespace
+	return k.client.Resource(k.gvr).Namespace(k.namespace)
+}
+
+// Sync lists the resources
+func (k *kubeSource) Sync(ctx context.Context) ([]KeyEvent, error) {
+	events, _, err := k.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for _, event := range events {
+		if event.Entry != nil {
+			count++
+			infof("Loaded %s %s: %s (enabled=%v, hint=%s)", k.kind, event.Key, event.Entry.Email, event.Entry.Enabled, event.Entry.KeyHint)
+		}
+	}
+
+	infof("Synced %d %s", count, k.kinds)
+	return events, nil
+}
+
+// list lists the resources as KeyAdded events, along with the resource
+// version of the list. Resources are fetched page by page, only their
+// parsed entries being kept. Should the snapshot being paged through expire,
+// e.g. because listing took longer than the API server keeps it, the
+// resources are listed again at once.
+func (k *kubeSource) list(ctx context.Context) ([]KeyEvent, string, error) {
+	opts := k.opts
+	opts.Limit = k.pageSize
+
+	var events []KeyEvent
+	for {
+		list, err := k.resource().List(ctx, opts)
+		if apierrors.IsResourceExpired(err) && opts.Continue != "" {
+			infof("Listing %s expired after %d resources, listing them at once", k.kinds, len(events))
+			events, opts.Limit, opts.Continue = nil, 0, ""
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list %s: %w", k.kinds, err)
+		}
+
+		for _, item := range list.Items {
+			entry, err := k.parse(&item)
+			events = append(events, KeyEvent{Type: KeyAdded, Key: resourceKey(&item), Entry: entry, Err: err})
+		}
+		if list.GetContinue() == "" {
+			return events, list.GetResourceVersion(), nil
+		}
+		opts.Continue = list.GetContinue()
+	}
+}
+
+// Start watches the resources in the background
+func (k *kubeSource) Start(ctx context.Context) error {
+	go k.watch(ctx)
+	return nil
+}
+
+// Events returns the channel the watch events are sent on
+func (k *kubeSource) Events() <-chan KeyEvent {
+	return k.events
+}
+
+// watch watches the resources until the context is done, re-establishing
+// the watch when it ends. Changes made while no watch runs are caught up by
+// relisting the resources before watching again.
+func (k *kubeSource) watch(ctx context.Context) {
+	// stale is set from the end of a watch until the resources were relisted
+	stale := false
+	for {
+		k.beat()
+		if wait := k.breaker.allow(); wait > 0 {
+			// Woken up at least every heartbeatInterval to beat
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(min(wait, heartbeatInterval)):
+			}
+			continue
+		}
+
+		opts := k.opts
+		var items []KeyEvent
+		if stale {
+			var err error
+			items, opts.ResourceVersion, err = k.list(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Failed to relist %s: %v, retrying...", k.gvr.Resource, err)
+				k.breaker.failure()
+				continue
+			}
+		}
+
+		watcher, err := k.resource().Watch(ctx, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to start %s watch: %v, retrying...", k.gvr.Resource, err)
+			k.breaker.failure()
+			if !stale && !k.send(ctx, KeyEvent{Type: KeyStale, Key: k.kinds}) {
+				return
+			}
+			stale = true
+			continue
+		}
+		k.breaker.success()
+		if stale && !k.send(ctx, KeyEvent{Type: KeyResync, Key: k.kinds, Items: items}) {
+			watcher.Stop()
+			return
+		}
+		stale = false
+
+		if !k.forward(ctx, watcher) || !k.send(ctx, KeyEvent{Type: KeyStale, Key: k.kinds}) {
+			return
+		}
+		stale = true
+	}
+}
+
+// forward sends the changes of a watch until it ends, beating meanwhile. It
+// reports false when the context was done first.
+func (k *kubeSource) forward(ctx context.Context, watcher watch.Interface) bool {
+	defer watcher.Stop()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return ctx.Err() == nil
+			}
+			k.beat()
+			keyEvent, ok := k.keyEvent(event)
+			if ok && !k.send(ctx, keyEvent) {
+				return false
+			}
+		case <-ticker.C:
+			k.beat()
+		}
+	}
+}
+
+// beat records that the watch goroutine runs
+func (k *kubeSource) beat() {
+	k.heartbeat.Store(k.now().UnixNano())
+}
+
+// alive returns an error once the watch goroutine has not run for longer
+// than timeout, e.g. because it died; nil before it started
+func (k *kubeSource) alive(timeout time.Duration) error {
+	beat := k.heartbeat.Load()
+	if beat == 0 {
+		return nil
+	}
+	if since := k.now().Sub(time.Unix(0, beat)); since > timeout {
+		return fmt.Errorf("%s watch has not run for %s", k.kinds, since.Round(time.Second))
+	}
+	return nil
+}
+
+// send sends an event, reporting false when the context was done first
+func (k *kubeSource) send(ctx context.Context, event KeyEvent) bool {
+	select {
+	case k.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// keyEvent converts a watch event, reporting false for events that do not
+// change a resource (bookmarks, errors)
+func (k *kubeSource) keyEvent(event watch.Event) (KeyEvent, bool) {
+	obj, ok := event.Object.(*unstructured.Unstructured)
+	if !ok {
+		return KeyEvent{}, false
+	}
+
+	var eventType KeyEventType
+	switch event.Type {
+	case watch.Added:
+		eventType = KeyAdded
+	case watch.Modified:
+		eventType = KeyModified
+	case watch.Deleted:
+		eventType = KeyDeleted
+	default:
+		return KeyEvent{}, false
+	}
+
+	debugf("%s watch event %s for %s", k.kind, event.Type, resourceKey(obj))
+	entry, err := k.parse(obj)
+	if entry != nil {
+		if eventType == KeyDeleted {
+			infof("%s deleted: %s", k.kind, entry.Email)
+		} else {
+			infof("%s %s %s (enabled=%v)", k.kind, event.Type, entry.Email, entry.Enabled)
+		}
+	}
+	return KeyEvent{Type: eventType, Key: resourceKey(obj), Entry: entry, Err: err}, true
+}
@@ -0,0 +1,442 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+)
+
+var _ KeyStore = (*SourceStore)(nil)
+
+// SourceStore is a KeyStore merging the keys of one or more KeySources.
+// When several sources provide the same hash, the first one listed takes
+// precedence; the others take over once it no longer provides it.
+type SourceStore struct {
+	mu sync.RWMutex
+	// keyHashes maps SHA-256 hash to APIKey metadata (merged view of all sources)
+	keyHashes map[string]*models.APIKeyEntry
+	// index serves searches on the merged view
+	index *searchIndex
+	// refs counts the hashes of the merged view mapping to each entry, and
+	// enabled and disabled count its entries, so stats are O(1)
+	refs     map[*models.APIKeyEntry]int
+	enabled  int
+	disabled int
+	// lastSync is when the first source was last listed (zero = never)
+	lastSync time.Time
+	// staleSince holds when each source stopped receiving changes, zero
+	// while it receives them
+	staleSince []time.Time
+
+	sources []KeySource
+	// states holds what each source provides, in the order of sources
+	states []*sourceState
+	stopCh chan struct{}
+	now    func() time.Time
+
+	// startMu and started make Start idempotent, so sources are never
+	// started twice; stopOnce makes Stop safe to call twice
+	startMu  sync.Mutex
+	started  bool
+	stopOnce sync.Once
+}
+
+// sourceState holds the keys of a source
+type sourceState struct {
+	// entries holds the entry of each item, by key
+	entries map[string]*models.APIKeyEntry
+	// hashes maps each hash of the entries to its entry
+	hashes map[string]*models.APIKeyEntry
+	// skipped holds why the items that could not be loaded were skipped,
+	// by key
+	skipped map[string]error
+}
+
+// newSourceState creates an empty source state
+func newSourceState() *sourceState {
+	return &sourceState{
+		entries: make(map[string]*models.APIKeyEntry),
+		hashes:  make(map[string]*models.APIKeyEntry),
+		skipped: make(map[string]error),
+	}
+}
+
+// NewSourceStore creates a store merging the given sources, by decreasing
+// precedence
+func NewSourceStore(sources ...KeySource) *SourceStore {
+	states := make([]*sourceState, len(sources))
+	for i := range states {
+		states[i] = newSourceState()
+	}
+	return &SourceStore{
+		keyHashes:  make(map[string]*models.APIKeyEntry),
+		index:      newSearchIndex(),
+		refs:       make(map[*models.APIKeyEntry]int),
+		staleSince: make([]time.Time, len(sources)),
+		sources:    sources,
+		states:     states,
+		stopCh:     make(chan struct{}),
+		now:        time.Now,
+	}
+}
+
+// Start syncs every source, then applies their changes until Stop is
+// called or the context is done. Once it succeeded, further calls do
+// nothing; after a failure it may be retried.
+func (s *SourceStore) Start(ctx context.Context) error {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+	if s.started {
+		return nil
+	}
+
+	for i := range s.sources {
+		if err := s.sync(ctx, i); err != nil {
+			return fmt.Errorf("failed initial sync: %w", err)
+		}
+	}
+	if err := s.watch(ctx); err != nil {
+		return err
+	}
+
+	s.started = true
+	return nil
+}
+
+// Stop stops applying the changes of the sources; it is safe to call more
+// than once
+func (s *SourceStore) Stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
+// sync replaces the keys of source i with its current items
+func (s *SourceStore) sync(ctx context.Context, i int) error {
+	events, err := s.sources[i].Sync(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceLocked(i, events)
+	return nil
+}
+
+// replaceLocked replaces the items of source i with the given ones,
+// returning how many of its entries changed
+func (s *SourceStore) replaceLocked(i int, events []KeyEvent) int {
+	state := s.states[i]
+	current := make(map[string]bool, len(events))
+	changed := 0
+	for _, event := range events {
+		current[event.Key] = true
+		if s.applyLocked(i, event) {
+			changed++
+		}
+	}
+	// Items the source no longer lists were deleted
+	var deleted []string
+	for key := range state.entries {
+		if !current[key] {
+			deleted = append(deleted, key)
+		}
+	}
+	for key := range state.skipped {
+		if !current[key] {
+			deleted = append(deleted, key)
+		}
+	}
+	for _, key := range deleted {
+		if s.applyLocked(i, KeyEvent{Type: KeyDeleted, Key: key}) {
+			changed++
+		}
+	}
+
+	if i == 0 {
+		s.lastSync = s.now()
+	}
+	return changed
+}
+
+// watch starts the sources and applies their changes in the background.
+// The sources stop along with the store.
+func (s *SourceStore) watch(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.stopCh:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	for i, source := range s.sources {
+		if err := source.Start(ctx); err != nil {
+			cancel()
+			return fmt.Errorf("failed to start key source %d: %w", i, err)
+		}
+		go s.consume(ctx, i, source.Events())
+	}
+	return nil
+}
+
+// consume applies the events of source i until the context is done
+func (s *SourceStore) consume(ctx context.Context, i int, events <-chan KeyEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			s.apply(i, event)
+		}
+	}
+}
+
+// apply applies an event of source i
+func (s *SourceStore) apply(i int, event KeyEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch event.Type {
+	case KeyStale:
+		if s.staleSince[i].IsZero() {
+			s.staleSince[i] = s.now()
+			warnf("%s stopped receiving changes, serving the cached keys", event.Key)
+		}
+	case KeyResync:
+		changed := s.replaceLocked(i, event.Items)
+		var gap time.Duration
+		if !s.staleSince[i].IsZero() {
+			gap = s.now().Sub(s.staleSince[i])
+		}
+		s.staleSince[i] = time.Time{}
+		infof("%s resynced after %s without updates: %d keys changed", event.Key, gap.Round(time.Millisecond), changed)
+	default:
+		s.applyLocked(i, event)
+	}
+}
+
+// applyLocked records an event of source i, then updates the merged view of
+// the hashes the item provided before and after it. It reports whether the
+// entry of the item changed.
+func (s *SourceStore) applyLocked(i int, event KeyEvent) bool {
+	state := s.states[i]
+
+	var hashes []string
+	previous, ok := state.entries[event.Key]
+	if ok {
+		hashes = previous.Hashes()
+		for _, hash := range hashes {
+			if state.hashes[hash] == previous {
+				delete(state.hashes, hash)
+			}
+		}
+		delete(state.entries, event.Key)
+	}
+	delete(state.skipped, event.Key)
+
+	switch {
+	case event.Type == KeyDeleted:
+	case event.Err != nil:
+		// An item that can no longer be loaded must not keep its keys
+		state.skipped[event.Key] = event.Err
+	case event.Entry != nil:
+		// Copied, so the source may reuse its entries
+		entry := *event.Entry
+		entry.LoadedAt = s.now()
+		if sameEntry(previous, &entry) {
+			// e.g. relisted unchanged: the cached version is still the same
+			entry.LoadedAt = previous.LoadedAt
+		}
+		state.entries[event.Key] = &entry
+		for _, hash := range entry.Hashes() {
+			state.hashes[hash] = &entry
+			hashes = append(hashes, hash)
+		}
+	}
+
+	for _, hash := range hashes {
+		s.resolveLocked(hash)
+	}
+	return !sameEntry(previous, state.entries[event.Key])
+}
+
+// sameEntry reports whether two entries, possibly nil, hold the same key
+// with the same metadata, wherever they were loaded
+func sameEntry(a, b *models.APIKeyEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	x, y := *a, *b
+	x.LoadedAt, y.LoadedAt = time.Time{}, time.Time{}
+	return reflect.DeepEqual(x, y)
+}
+
+// resolveLocked points a hash of the merged view to the entry of the first
+// source providing it, or removes it when none does
+func (s *SourceStore) resolveLocked(hash string) {
+	previous := s.keyHashes[hash]
+
+	var winner *models.APIKeyEntry
+	for _, state := range s.states {
+		entry, ok := state.hashes[hash]
+		if !ok {
+			continue
+		}
+		if winner == nil {
+			winner = entry
+			continue
+		}
+		if winner != previous {
+			warnf("%s %s overrides %s %s with the same key hash", winner.Source, winner.Name, entry.Source, entry.Name)
+		}
+		break
+	}
+
+	if winner != previous {
+		s.releaseLocked(previous)
+		s.retainLocked(winner)
+	}
+	if winner == nil {
+		delete(s.keyHashes, hash)
+		s.index.remove(hash)
+		return
+	}
+	s.keyHashes[hash] = winner
+	// Entries are only indexed under their KeyHash
+	if hash == winner.KeyHash {
+		s.index.add(winner)
+	} else {
+		s.index.remove(hash)
+	}
+}
+
+// retainLocked records a hash of the merged view mapping to entry, counting
+// the entry on its first hash. Entries are never modified once stored, so
+// their enabled state is the one they were counted with.
+func (s *SourceStore) retainLocked(entry *models.APIKeyEntry) {
+	if entry == nil {
+		return
+	}
+	s.refs[entry]++
+	if s.refs[entry] > 1 {
+		return
+	}
+	if entry.Enabled {
+		s.enabled++
+	} else {
+		s.disabled++
+	}
+}
+
+// releaseLocked records a hash of the merged view no longer mapping to
+// entry, uncounting the entry on its last hash
+func (s *SourceStore) releaseLocked(entry *models.APIKeyEntry) {
+	if entry == nil {
+		return
+	}
+	s.refs[entry]--
+	if s.refs[entry] > 0 {
+		return
+	}
+	delete(s.refs, entry)
+	if entry.Enabled {
+		s.enabled--
+	} else {
+		s.disabled--
+	}
+}
+
+// ValidateKey checks if the provided API key hash is valid and enabled
+func (s *SourceStore) ValidateKey(keyHash string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, exists := s.keyHashes[keyHash]
+	if !exists {
+		return false
+	}
+
+	return entry.Enabled
+}
+
+// Lookup returns the entry for the provided API key hash, if any
+func (s *SourceStore) Lookup(keyHash string) (models.APIKeyEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, exists := s.keyHashes[keyHash]
+	if !exists {
+		return models.APIKeyEntry{}, false
+	}
+	return *entry, true
+}
+
+// List returns a copy of all entries
+func (s *SourceStore) List() []models.APIKeyEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Each entry once: the hashes of a key family all map to the same entry
+	entries := make([]models.APIKeyEntry, 0, len(s.refs))
+	for entry := range s.refs {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// Search returns the entries matching the query, sorted by name
+func (s *SourceStore) Search(q KeyQuery) []models.APIKeyEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.search(q)
+}
+
+// GetStats returns statistics about the store
+func (s *SourceStore) GetStats() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.statsLocked()
+}
+
+// statsLocked returns the entry counts of the merged view, the number of
+// items skipped by the sources and for how long the keys may be stale
+func (s *SourceStore) statsLocked() map[string]int {
+	skipped := 0
+	for _, state := range s.states {
+		skipped += len(state.skipped)
+	}
+	// The keys are as stale as those of the source stale for the longest
+	var stale time.Duration
+	for _, since := range s.staleSince {
+		if !since.IsZero() {
+			stale = max(stale, s.now().Sub(since))
+		}
+	}
+
+	return map[string]int{
+		"total":             s.enabled + s.disabled,
+		"enabled":           s.enabled,
+		"disabled":          s.disabled,
+		"skipped":           skipped,
+		"cacheStaleSeconds": int(stale.Seconds()),
+	}
+}
+
+// LastSync returns when the first source was last listed (zero = never)
+func (s *SourceStore) LastSync() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastSync
+}
@@ -0,0 +1,188 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/efortin/batsign/internal/models"
+	"github.com/efortin/batsign/internal/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSource is a KeySource listing fixed items and sending the events
+// pushed by the test
+type fakeSource struct {
+	items   []server.KeyEvent
+	syncErr error
+	events  chan server.KeyEvent
+}
+
+func newFakeSource(entries ...*models.APIKeyEntry) *fakeSource {
+	source := &fakeSource{events: make(chan server.KeyEvent)}
+	for _, entry := range entries {
+		source.items = append(source.items, server.KeyEvent{Type: server.KeyAdded, Key: entry.Name, Entry: entry})
+	}
+	return source
+}
+
+func (f *fakeSource) Sync(context.Context) ([]server.KeyEvent, error) { return f.items, f.syncErr }
+func (f *fakeSource) Start(context.Context) error                     { return nil }
+func (f *fakeSource) Events() <-chan server.KeyEvent                  { return f.events }
+
+var _ = Describe("SourceStore", func() {
+	var (
+		primary   *fakeSource
+		secondary *fakeSource
+		store     *server.SourceStore
+	)
+
+	BeforeEach(func() {
+		primary = newFakeSource(&models.APIKeyEntry{Name: "alice", Email: "alice@example.com", KeyHash: "a", Enabled: true, Source: "primary"})
+		secondary = newFakeSource(
+			&models.APIKeyEntry{Name: "bob", KeyHash: "b", Enabled: true, Source: "secondary"},
+			&models.APIKeyEntry{Name: "shadowed", KeyHash: "a", Enabled: false, Source: "secondary"},
+		)
+		store = server.NewSourceStore(primary, secondary)
+		DeferCleanup(store.Stop)
+	})
+
+	// source returns the source of the entry for hash, "" when none
+	source := func(hash string) func() string {
+		return func() string {
+			entry, _ := store.Lookup(hash)
+			return entry.Source
+		}
+	}
+
+	It("should merge the synced sources", func() {
+		Expect(store.Start(context.Background())).To(Succeed())
+
+		Expect(store.ValidateKey("a")).To(BeTrue())
+		Expect(store.ValidateKey("b")).To(BeTrue())
+		Expect(store.GetStats()).To(Equal(map[string]int{"total": 2, "enabled": 2, "disabled": 0, "skipped": 0, "cacheStaleSeconds": 0}))
+		Expect(store.LastSync()).ToNot(BeZero())
+	})
+
+	It("should apply add, modify and delete events", func() {
+		Expect(store.Start(context.Background())).To(Succeed())
+
+		primary.events <- server.KeyEvent{Type: server.KeyAdded, Key: "carol", Entry: &models.APIKeyEntry{Name: "carol", Email: "carol@example.com", KeyHash: "c", Enabled: true}}
+		Eventually(func() bool { return store.ValidateKey("c") }).Should(BeTrue())
+
+		primary.events <- server.KeyEvent{Type: server.KeyModified, Key: "carol", Entry: &models.APIKeyEntry{Name: "carol", Email: "carol@example.com", KeyHash: "d", Enabled: true}}
+		Eventually(func() bool { return store.ValidateKey("d") }).Should(BeTrue())
+		Expect(store.ValidateKey("c")).To(BeFalse())
+		Expect(store.Search(server.KeyQuery{Email: "carol@example.com"})).To(HaveLen(1))
+
+		primary.events <- server.KeyEvent{Type: server.KeyDeleted, Key: "carol", Entry: &models.APIKeyEntry{Name: "carol", KeyHash: "d"}}
+		Eventually(func() bool { return store.ValidateKey("d") }).Should(BeFalse())
+		Expect(store.Search(server.KeyQuery{Email: "carol@example.com"})).To(BeEmpty())
+	})
+
+	It("should give the first source precedence on colliding hashes", func() {
+		Expect(store.Start(context.Background())).To(Succeed())
+		Expect(source("a")()).To(Equal("primary"))
+
+		primary.events <- server.KeyEvent{Type: server.KeyDeleted, Key: "alice"}
+		Eventually(source("a")).Should(Equal("secondary"))
+		Expect(store.ValidateKey("a")).To(BeFalse())
+
+		primary.events <- server.KeyEvent{Type: server.KeyAdded, Key: "alice", Entry: &models.APIKeyEntry{Name: "alice", KeyHash: "a", Enabled: true, Source: "primary"}}
+		Eventually(source("a")).Should(Equal("primary"))
+
+		secondary.events <- server.KeyEvent{Type: server.KeyDeleted, Key: "shadowed"}
+		Consistently(source("a")).Should(Equal("primary"))
+	})
+
+	It("should count skipped items until they are fixed or deleted", func() {
+		Expect(store.Start(context.Background())).To(Succeed())
+
+		primary.events <- server.KeyEvent{Type: server.KeyModified, Key: "alice", Err: errors.New("empty keyHash")}
+		Eventually(func() int { return store.GetStats()["skipped"] }).Should(Equal(1))
+		// The skipped item no longer provides its key
+		Expect(source("a")()).To(Equal("secondary"))
+
+		primary.events <- server.KeyEvent{Type: server.KeyDeleted, Key: "alice"}
+		Eventually(func() int { return store.GetStats()["skipped"] }).Should(BeZero())
+	})
+
+	It("should keep the counts right across add, modify and delete events", func() {
+		Expect(store.Start(context.Background())).To(Succeed())
+		counts := func() map[string]int {
+			stats := store.GetStats()
+			delete(stats, "skipped")
+			delete(stats, "cacheStaleSeconds")
+			return stats
+		}
+		// alice (enabled) shadows the disabled entry sharing her hash
+		Expect(counts()).To(Equal(map[string]int{"total": 2, "enabled": 2, "disabled": 0}))
+
+		family := &models.APIKeyEntry{Name: "family", KeyHash: "f1", KeyHashes: []string{"f2", "f3"}, Enabled: true}
+		secondary.events <- server.KeyEvent{Type: server.KeyAdded, Key: "family", Entry: family}
+		Eventually(counts).Should(Equal(map[string]int{"total": 3, "enabled": 3, "disabled": 0}))
+
+		disabled := *family
+		disabled.Enabled = false
+		secondary.events <- server.KeyEvent{Type: server.KeyModified, Key: "family", Entry: &disabled}
+		Eventually(counts).Should(Equal(map[string]int{"total": 3, "enabled": 2, "disabled": 1}))
+
+		// Deleting alice reveals the disabled entry with her hash
+		primary.events <- server.KeyEvent{Type: server.KeyDeleted, Key: "alice"}
+		Eventually(counts).Should(Equal(map[string]int{"total": 3, "enabled": 1, "disabled": 2}))
+
+		// The primary source takes over one hash of the family only
+		primary.events <- server.KeyEvent{Type: server.KeyAdded, Key: "thief", Entry: &models.APIKeyEntry{Name: "thief", KeyHash: "f2", Enabled: true}}
+		Eventually(counts).Should(Equal(map[string]int{"total": 4, "enabled": 2, "disabled": 2}))
+
+		secondary.events <- server.KeyEvent{Type: server.KeyDeleted, Key: "family"}
+		Eventually(counts).Should(Equal(map[string]int{"total": 3, "enabled": 2, "disabled": 1}))
+		Expect(store.List()).To(HaveLen(3))
+
+		secondary.events <- server.KeyEvent{Type: server.KeyModified, Key: "shadowed", Err: errors.New("empty keyHash")}
+		Eventually(counts).Should(Equal(map[string]int{"total": 2, "enabled": 2, "disabled": 0}))
+	})
+
+	It("should replace the items of a source resyncing after a gap", func() {
+		Expect(store.Start(context.Background())).To(Succeed())
+
+		secondary.events <- server.KeyEvent{Type: server.KeyStale, Key: "secondary"}
+		// bob was deleted and carol added while the source was stale
+		secondary.events <- server.KeyEvent{Type: server.KeyResync, Key: "secondary", Items: []server.KeyEvent{
+			{Type: server.KeyAdded, Key: "shadowed", Entry: &models.APIKeyEntry{Name: "shadowed", KeyHash: "a", Source: "secondary"}},
+			{Type: server.KeyAdded, Key: "carol", Entry: &models.APIKeyEntry{Name: "carol", KeyHash: "c", Enabled: true, Source: "secondary"}},
+		}}
+		Eventually(func() bool { return store.ValidateKey("c") }).Should(BeTrue())
+		Expect(store.ValidateKey("b")).To(BeFalse())
+		Expect(source("a")()).To(Equal("primary"))
+		Expect(store.GetStats()["cacheStaleSeconds"]).To(BeZero())
+	})
+
+	It("should fail to start when a source fails to sync", func() {
+		secondary.syncErr = errors.New("connection refused")
+		Expect(store.Start(context.Background())).To(MatchError(ContainSubstring("connection refused")))
+
+		secondary.syncErr = nil
+		Expect(store.Start(context.Background())).To(Succeed())
+		Expect(store.ValidateKey("b")).To(BeTrue())
+	})
+})
+
+func BenchmarkSourceStoreGetStats(b *testing.B) {
+	entries := make([]*models.APIKeyEntry, 10000)
+	for i := range entries {
+		entries[i] = &models.APIKeyEntry{Name: strconv.Itoa(i), KeyHash: strconv.Itoa(i), Enabled: i%10 != 0}
+	}
+	store := server.NewSourceStore(newFakeSource(entries...))
+	if err := store.Start(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(store.Stop)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		store.GetStats()
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stats formats served by /stats, selected with the Accept header
+const (
+	statsFormatJSON       = "json"
+	statsFormatPlain      = "plain"
+	statsFormatPrometheus = "prometheus"
+)
+
+// Content types of the /stats formats
+const (
+	contentTypePlain      = "text/plain; charset=utf-8"
+	contentTypePrometheus = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// statsField is a single /stats value with its Prometheus rendering
+type statsField struct {
+	name   string
+	value  int64
+	metric string
+	kind   string
+	help   string
+}
+
+// statsHandler returns statistics about loaded API keys as JSON (default),
+// plain "key: value" lines or the Prometheus text format, following Accept
+func (s *Server) statsHandler(c *gin.Context) {
+	fields := s.statsFields()
+
+	switch negotiateStatsFormat(c.GetHeader("Accept")) {
+	case statsFormatPlain:
+		var b strings.Builder
+		for _, f := range fields {
+			fmt.Fprintf(&b, "%s: %d\n", f.name, f.value)
+		}
+		if s.authz.keyMetrics != nil {
+			s.authz.keyMetrics.writePlain(&b)
+		}
+		c.Data(http.StatusOK, contentTypePlain, []byte(b.String()))
+
+	case statsFormatPrometheus:
+		var b strings.Builder
+		for _, f := range fields {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", f.metric, f.help, f.metric, f.kind, f.metric, f.value)
+		}
+		if s.authz.keyMetrics != nil {
+			s.authz.keyMetrics.writePrometheus(&b)
+		}
+		c.Data(http.StatusOK, contentTypePrometheus, []byte(b.String()))
+
+	default:
+		body := gin.H{}
+		for _, f := range fields {
+			body[f.name] = f.value
+		}
+		if s.authz.keyMetrics != nil {
+			body["keyDecisions"] = s.authz.keyMetrics.snapshot()
+		}
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+// statsFields collects the /stats values in display order
+func (s *Server) statsFields() []statsField {
+	stats := s.store.GetStats()
+	fields := []statsField{
+		{"total", int64(stats["total"]), "batsign_keys", "gauge", "Number of loaded API keys."},
+		{"enabled", int64(stats["enabled"]), "batsign_keys_enabled", "gauge", "Number of loaded enabled API keys."},
+		{"disabled", int64(stats["disabled"]), "batsign_keys_disabled", "gauge", "Number of loaded disabled API keys."},
+		{"grpcConnections", s.grpcStats.conns.Load(), "batsign_grpc_connections", "gauge", "Number of open gRPC connections, e.g. from Envoy."},
+		{"grpcRequestsInFlight", s.grpcStats.inFlight.Load(), "batsign_grpc_requests_in_flight", "gauge", "Number of gRPC requests being served."},
+	}
+	if skipped, ok := stats["skipped"]; ok {
+		fields = append(fields, statsField{"skipped", int64(skipped), "batsign_keys_skipped", "gauge", "Number of APIKey resources and Secrets skipped for lacking a well-formed keyHash."})
+	}
+	if malformed, ok := stats["malformedHashes"]; ok {
+		fields = append(fields, statsField{"malformedHashes", int64(malformed), "batsign_keys_malformed_hash", "gauge", "Number of APIKey resources and Secrets skipped for a keyHash that is not a hex encoded SHA-256 hash."})
+	}
+	if stale, ok := stats["cacheStaleSeconds"]; ok {
+		fields = append(fields, statsField{"cacheStaleSeconds", int64(stale), "batsign_cache_stale_seconds", "gauge", "Seconds since a key watch was lost, during which keys may be stale (0 = every watch runs)."})
+	}
+	if state, ok := stats["watchBreakerState"]; ok {
+		fields = append(fields,
+			statsField{"watchBreakerState", int64(state), "batsign_watch_breaker_state", "gauge", "State of the watch circuit breaker: 0 closed, 1 half-open, 2 open (serving a stale cache)."},
+			statsField{"watchBreakerTrips", int64(stats["watchBreakerTrips"]), "batsign_watch_breaker_trips_total", "counter", "Times the watch circuit breaker opened."},
+		)
+	}
+	if s.config.BreakGlassKeyHash != "" {
+		fields = append(fields, statsField{"breakGlassAllows", s.authz.BreakGlassAllows(), "batsign_break_glass_allows_total", "counter", "Requests allowed with the break-glass key."})
+	}
+	if s.config.RouteRulesConfigMap != "" {
+		fields = append(fields, statsField{"publicAllows", s.authz.PublicAllows(), "batsign_public_allows_total", "counter", "Requests allowed without an API key on public routes."})
+	}
+	if s.checkLimiter != nil {
+		fields = append(fields, statsField{"checksRejected", s.checkLimiter.rejected.Load(), "batsign_checks_rejected_total", "counter", "Checks rejected because max-concurrent-checks was reached."})
+	}
+	if s.authz.alerts != nil {
+		fields = append(fields, statsField{"alertsDropped", s.authz.alerts.dropped.Load(), "batsign_alerts_dropped_total", "counter", "Denial alerts not sent to the webhook because of its rate limit."})
+	}
+	if v := s.authz.validator; v != nil {
+		fields = append(fields,
+			statsField{"validatorCacheHits", v.cacheHits.Load(), "batsign_validator_cache_hits_total", "counter", "Keys validated from the cached decisions of the external validator."},
+			statsField{"validatorFailures", v.failures.Load(), "batsign_validator_failures_total", "counter", "Calls to the external validator that failed or timed out."},
+		)
+		if v.failOpen {
+			fields = append(fields, statsField{"validatorFailOpenAllows", v.failOpenAllows.Load(), "batsign_validator_fail_open_allows_total", "counter", "Requests allowed unauthenticated because the external validator failed."})
+		}
+	}
+	if s.adminLimiter != nil {
+		fields = append(fields, statsField{"adminRequestsRejected", s.adminLimiter.rejected.Load(), "batsign_admin_requests_rejected_total", "counter", "Admin requests rejected because a client exceeded admin-rate-limit."})
+	}
+	if s.config.FailOpenUntilSynced {
+		fields = append(fields, statsField{"failOpenAllows", s.authz.FailOpenAllows(), "batsign_fail_open_allows_total", "counter", "Requests allowed unauthenticated before the keys were synced."})
+	}
+	return fields
+}
+
+// negotiateStatsFormat picks the stats format for the first supported media
+// type of an Accept header. The Prometheus text format is recognized by its
+// version parameter, as sent by Prometheus scrapers; any other text/plain gets
+// key: value lines.
+func negotiateStatsFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json", "*/*", "application/*":
+			return statsFormatJSON
+		case "text/plain", "text/*":
+			if params["version"] == "0.0.4" {
+				return statsFormatPrometheus
+			}
+			return statsFormatPlain
+		}
+	}
+	return statsFormatJSON
+}
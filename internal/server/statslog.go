@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// tickerFunc starts a ticker firing every d, returning its channel and a
+// function stopping it
+type tickerFunc func(d time.Duration) (<-chan time.Time, func())
+
+// newTicker is the tickerFunc backed by time.Ticker
+func newTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// logStats logs the store statistics every interval, until ctx is done or
+// stop is closed
+func logStats(ctx context.Context, stop <-chan struct{}, store KeyStore, interval time.Duration, ticker tickerFunc) {
+	ticks, stopTicker := ticker(interval)
+	defer stopTicker()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticks:
+			stats := store.GetStats()
+			lastSync := "never"
+			if synced := store.LastSync(); !synced.IsZero() {
+				lastSync = synced.UTC().Format(time.RFC3339)
+			}
+			log.Printf("Key stats: total=%d enabled=%d disabled=%d last_sync=%s",
+				stats["total"], stats["enabled"], stats["disabled"], lastSync)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("logStats", func() {
+	var (
+		logs     *gbytes.Buffer
+		ticks    chan time.Time
+		interval time.Duration
+		stopped  chan struct{}
+		start    func(stop <-chan struct{}, store KeyStore) context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		logs = gbytes.NewBuffer()
+		log.SetOutput(logs)
+		DeferCleanup(log.SetOutput, os.Stderr)
+
+		ticks = make(chan time.Time)
+		stopped = make(chan struct{})
+		tickCh, stoppedCh := ticks, stopped
+		ticker := func(d time.Duration) (<-chan time.Time, func()) {
+			interval = d
+			return tickCh, func() { close(stoppedCh) }
+		}
+
+		// start runs the logger with the fake ticker until the spec ends
+		start = func(stop <-chan struct{}, store KeyStore) context.CancelFunc {
+			ctx, cancel := context.WithCancel(context.Background())
+			go logStats(ctx, stop, store, time.Minute, ticker)
+			DeferCleanup(func() {
+				cancel()
+				Eventually(stoppedCh).Should(BeClosed())
+			})
+			return cancel
+		}
+	})
+
+	It("should log the key counts on every tick", func() {
+		store := NewInMemoryStore(
+			models.APIKeyEntry{KeyHash: "a", Enabled: true},
+			models.APIKeyEntry{KeyHash: "b", Enabled: false},
+		)
+		start(nil, store)
+
+		ticks <- time.Now()
+		Eventually(logs).Should(gbytes.Say(`Key stats: total=2 enabled=1 disabled=1 last_sync=\d{4}-`))
+		Expect(interval).To(Equal(time.Minute))
+
+		store.Remove("b")
+		ticks <- time.Now()
+		Eventually(logs).Should(gbytes.Say(`Key stats: total=1 enabled=1 disabled=0`))
+	})
+
+	It("should report stores that never synced", func() {
+		start(nil, newAPIKeyStore(nil, &models.Config{}))
+
+		ticks <- time.Now()
+		Eventually(logs).Should(gbytes.Say(`last_sync=never`))
+	})
+
+	It("should exit when the context is done", func() {
+		cancel := start(nil, NewInMemoryStore())
+
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	It("should exit when stopped", func() {
+		stop := make(chan struct{})
+		start(stop, NewInMemoryStore())
+
+		close(stop)
+		Eventually(stopped).Should(BeClosed())
+	})
+})
@@ -2,225 +2,380 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/kube"
 	"github.com/efortin/batsign/internal/models"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
-// APIKeyStore manages the in-memory cache of API key hashes
-type APIKeyStore struct {
-	mu sync.RWMutex
-	// keyHashes maps SHA-256 hash to APIKey metadata
-	keyHashes map[string]*models.APIKeyEntry
+// KeyStore is the key lookup used by the server and the authorization service
+type KeyStore interface {
+	// Start loads the keys and begins watching for changes
+	Start(ctx context.Context) error
+	// Stop stops watching for changes
+	Stop()
+	// ValidateKey checks if the provided API key hash is valid and enabled
+	ValidateKey(keyHash string) bool
+	// Lookup returns the entry for the provided API key hash, if any
+	Lookup(keyHash string) (models.APIKeyEntry, bool)
+	// List returns a copy of all entries
+	List() []models.APIKeyEntry
+	// Search returns the entries matching the query, sorted by name
+	Search(q KeyQuery) []models.APIKeyEntry
+	// GetStats returns the total, enabled and disabled key counts, and the
+	// skipped count for stores that can skip invalid resources, and the
+	// watch circuit breaker state for stores that have one
+	GetStats() map[string]int
+	// LastSync returns when the keys were last fully loaded (zero = never)
+	LastSync() time.Time
+}
+
+// livenessReporter is implemented by stores updating their keys in
+// goroutines that may die silently, e.g. watches
+type livenessReporter interface {
+	// Alive returns why the store stopped updating its keys, nil while it
+	// updates them
+	Alive() error
+}
 
-	client    dynamic.Interface
-	namespace string
-	stopCh    chan struct{}
+var _ livenessReporter = (*APIKeyStore)(nil)
+
+var _ KeyStore = (*APIKeyStore)(nil)
+
+// APIKeyStore manages the in-memory cache of API key hashes, merging the
+// keys of APIKey resources and, when a selector is set, of Secrets
+type APIKeyStore struct {
+	*SourceStore
+
+	// apiKeys and secrets are the sources of the store, in this order:
+	// APIKey resources take precedence over Secrets sharing the same hash
+	apiKeys *kubeSource
+	secrets *kubeSource
+
+	client         dynamic.Interface
+	namespace      string
+	secretSelector string
+	crdWait        time.Duration
+	checkRBAC      bool
+	// breaker pauses re-watches while the API server keeps failing
+	breaker *watchBreaker
+	// livenessTimeout is how long a watch goroutine may go without running
+	// before Alive reports it
+	livenessTimeout time.Duration
 }
 
-var apiKeyGVR = schema.GroupVersionResource{
-	Group:    "auth.kgateway.dev",
-	Version:  "v1alpha1",
-	Resource: "apikeys",
+// Ranks of the sources of an APIKeyStore
+const (
+	apiKeyRank = 0
+	secretRank = 1
+)
+
+var (
+	apiKeyGVR = kube.APIKeyGVR
+	secretGVR = kube.SecretGVR
+)
+
+// crdRetryInterval is how often the initial sync is retried while waiting
+// for the APIKey CRD to be installed
+var crdRetryInterval = 5 * time.Second
+
+// errCRDNotInstalled reports that the API server does not serve APIKeys
+var errCRDNotInstalled = errors.New("APIKey CRD not installed; run `apikey-manager-client crd | kubectl apply -f -`")
+
+// isCRDMissing reports whether a list error means the APIKey resource is
+// not served. Listing a collection only returns NotFound for unknown
+// resources.
+func isCRDMissing(err error) bool {
+	return meta.IsNoMatchError(err) || apierrors.IsNotFound(err)
 }
 
 // NewAPIKeyStore creates a new API key store
-func NewAPIKeyStore(kubeconfig, namespace string) (*APIKeyStore, error) {
-	var config *rest.Config
-	var err error
+func NewAPIKeyStore(cfg *models.Config) (*APIKeyStore, error) {
+	client, err := newDynamicClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIKeyStore(client, cfg), nil
+}
 
-	if kubeconfig == "" {
-		// Use in-cluster config
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
-		}
-	} else {
-		// Use kubeconfig file
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			return nil, fmt.Errorf("failed to build config from kubeconfig: %w", err)
-		}
+// newDynamicClient creates the rate limited Kubernetes client of cfg
+func newDynamicClient(cfg *models.Config) (dynamic.Interface, error) {
+	config, err := kube.RESTConfig(cfg.Kubeconfig, cfg.KubeContext)
+	if err != nil {
+		return nil, err
 	}
 
+	configureRESTClient(config, cfg)
+
 	client, err := dynamic.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
-
-	store := &APIKeyStore{
-		keyHashes: make(map[string]*models.APIKeyEntry),
-		client:    client,
-		namespace: namespace,
-		stopCh:    make(chan struct{}),
-	}
-
-	return store, nil
+	return client, nil
 }
 
-// Start begins watching APIKey resources
-func (s *APIKeyStore) Start(ctx context.Context) error {
-	// Initial list to populate cache
-	if err := s.syncAPIKeys(ctx); err != nil {
-		return fmt.Errorf("failed initial sync: %w", err)
+// newAPIKeyStore creates a store backed by the given dynamic client
+func newAPIKeyStore(client dynamic.Interface, cfg *models.Config) *APIKeyStore {
+	cooldown := cfg.WatchBreakerCooldown
+	if cooldown == 0 {
+		cooldown = models.DefaultWatchBreakerCooldown
 	}
-
-	// Start watching for changes
-	go s.watchAPIKeys(ctx)
-
-	return nil
+	livenessTimeout := cfg.WatchLivenessTimeout
+	if livenessTimeout == 0 {
+		livenessTimeout = models.DefaultWatchLivenessTimeout
+	}
+	s := &APIKeyStore{
+		client:         client,
+		namespace:      cfg.Namespace,
+		secretSelector: cfg.SecretLabelSelector,
+		crdWait:        cfg.CRDWaitTimeout,
+		checkRBAC:      cfg.CheckRBAC,
+		breaker:        newWatchBreaker(cfg.WatchBreakerFailures, cooldown),
+
+		livenessTimeout: livenessTimeout,
+	}
+	s.apiKeys = s.newSource("APIKey", "APIKeys", apiKeyGVR, metav1.ListOptions{}, s.parseAPIKey)
+	sources := []KeySource{s.apiKeys}
+	if s.secretSelector != "" {
+		s.secrets = s.newSource("Secret key", "Secret keys", secretGVR, metav1.ListOptions{LabelSelector: s.secretSelector},
+			s.parseSecret)
+		sources = append(sources, s.secrets)
+	}
+	s.SourceStore = NewSourceStore(sources...)
+	return s
 }
 
-// Stop stops the watcher
-func (s *APIKeyStore) Stop() {
-	close(s.stopCh)
+// newSource creates the source of a resource in the watched namespace
+func (s *APIKeyStore) newSource(kind, kinds string, gvr schema.GroupVersionResource, opts metav1.ListOptions,
+	parse func(*unstructured.Unstructured) (*models.APIKeyEntry, error)) *kubeSource {
+	return &kubeSource{
+		kind:      kind,
+		kinds:     kinds,
+		client:    s.client,
+		gvr:       gvr,
+		namespace: s.namespace,
+		opts:      opts,
+		pageSize:  listPageSize,
+		parse:     parse,
+		breaker:   s.breaker,
+		events:    make(chan KeyEvent),
+		now:       time.Now,
+	}
 }
 
-// ValidateKey checks if the provided API key hash is valid and enabled
-func (s *APIKeyStore) ValidateKey(keyHash string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	entry, exists := s.keyHashes[keyHash]
-	if !exists {
-		return false
+// Alive returns why the keys stopped updating: a watch goroutine that has
+// not run for longer than the liveness timeout, e.g. because it died
+func (s *APIKeyStore) Alive() error {
+	for _, source := range []*kubeSource{s.apiKeys, s.secrets} {
+		if source == nil {
+			continue
+		}
+		if err := source.alive(s.livenessTimeout); err != nil {
+			return err
+		}
 	}
-
-	return entry.Enabled
+	return nil
 }
 
-// syncAPIKeys performs an initial list of all APIKey resources
-func (s *APIKeyStore) syncAPIKeys(ctx context.Context) error {
-	var list *unstructured.UnstructuredList
-	var err error
-
-	if s.namespace == "" {
-		// Watch all namespaces
-		list, err = s.client.Resource(apiKeyGVR).List(ctx, metav1.ListOptions{})
-	} else {
-		// Watch specific namespace
-		list, err = s.client.Resource(apiKeyGVR).Namespace(s.namespace).List(ctx, metav1.ListOptions{})
+// Start begins watching APIKey resources (and Secrets, when a selector is
+// set). Once it succeeded, further calls do nothing; after a failure it may
+// be retried.
+func (s *APIKeyStore) Start(ctx context.Context) error {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+	if s.started {
+		return nil
 	}
 
-	if err != nil {
-		return fmt.Errorf("failed to list APIKeys: %w", err)
+	// A Role granting list but not watch would let the initial sync pass
+	// and the watch fail forever, serving a stale cache
+	if s.checkRBAC {
+		if err := checkWatchAccess(ctx, s.client, s.namespace, s.secretSelector); err != nil {
+			return err
+		}
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Clear and repopulate
-	s.keyHashes = make(map[string]*models.APIKeyEntry)
-
-	for _, item := range list.Items {
-		if entry := s.parseAPIKey(&item); entry != nil {
-			s.keyHashes[entry.KeyHash] = entry
-			log.Printf("Loaded APIKey: %s (enabled=%v, hint=%s)", entry.Email, entry.Enabled, entry.KeyHint)
+	// Initial list to populate cache
+	if s.secrets != nil {
+		if err := s.syncSecrets(ctx); err != nil {
+			return fmt.Errorf("failed initial secret sync: %w", err)
 		}
 	}
+	if err := s.initialSyncAPIKeys(ctx); err != nil {
+		return fmt.Errorf("failed initial sync: %w", err)
+	}
+	s.checkCRDSchema(ctx)
 
-	log.Printf("Synced %d APIKeys", len(s.keyHashes))
+	// Start watching for changes
+	if err := s.watch(ctx); err != nil {
+		return err
+	}
+
+	s.started = true
 	return nil
 }
 
-// watchAPIKeys watches for changes to APIKey resources
-func (s *APIKeyStore) watchAPIKeys(ctx context.Context) {
+// initialSyncAPIKeys syncs the APIKeys, retrying for up to crdWait while
+// the APIKey CRD is not installed instead of failing right away
+func (s *APIKeyStore) initialSyncAPIKeys(ctx context.Context) error {
+	deadline := time.Now().Add(s.crdWait)
 	for {
-		select {
-		case <-s.stopCh:
-			return
-		case <-ctx.Done():
-			return
-		default:
-		}
-
-		var watcher watch.Interface
-		var err error
-
-		if s.namespace == "" {
-			watcher, err = s.client.Resource(apiKeyGVR).Watch(ctx, metav1.ListOptions{})
-		} else {
-			watcher, err = s.client.Resource(apiKeyGVR).Namespace(s.namespace).Watch(ctx, metav1.ListOptions{})
+		err := s.syncAPIKeys(ctx)
+		if err == nil || !errors.Is(err, errCRDNotInstalled) || !time.Now().Before(deadline) {
+			return err
 		}
 
-		if err != nil {
-			log.Printf("Failed to start watch: %v, retrying...", err)
-			continue
+		warnf("Warning: %v; waiting for it until %s", errCRDNotInstalled, deadline.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-s.stopCh:
+			return err
+		case <-time.After(crdRetryInterval):
 		}
+	}
+}
 
-		for event := range watcher.ResultChan() {
-			s.handleWatchEvent(event)
-		}
+// checkCRDSchema warns when the installed APIKey CRD lacks spec fields the
+// server reads, as happens when the server is upgraded without the CRD
+func (s *APIKeyStore) checkCRDSchema(ctx context.Context) {
+	missing, err := kube.MissingSpecFields(ctx, s.client)
+	if err != nil {
+		warnf("Warning: could not check the APIKey CRD schema: %v", err)
+		return
+	}
+	if len(missing) > 0 {
+		warnf("Warning: the installed APIKey CRD lacks spec fields %s, which the API server drops; "+
+			"update it with `apikey-manager-client crd | kubectl apply -f -`", strings.Join(missing, ", "))
+	}
+}
 
-		watcher.Stop()
+// syncAPIKeys performs an initial list of all APIKey resources
+func (s *APIKeyStore) syncAPIKeys(ctx context.Context) error {
+	err := s.sync(ctx, apiKeyRank)
+	if err != nil && isCRDMissing(err) {
+		return fmt.Errorf("%w: %w", errCRDNotInstalled, err)
 	}
+	return err
+}
+
+// syncSecrets performs an initial list of all Secrets matching the selector
+func (s *APIKeyStore) syncSecrets(ctx context.Context) error {
+	return s.sync(ctx, secretRank)
 }
 
-// handleWatchEvent processes watch events
+// handleWatchEvent applies an APIKey watch event
 func (s *APIKeyStore) handleWatchEvent(event watch.Event) {
-	obj, ok := event.Object.(*unstructured.Unstructured)
-	if !ok {
-		return
+	if keyEvent, ok := s.apiKeys.keyEvent(event); ok {
+		s.apply(apiKeyRank, keyEvent)
 	}
+}
 
-	entry := s.parseAPIKey(obj)
-	if entry == nil {
-		return
+// handleSecretEvent applies a watch event for API key Secrets
+func (s *APIKeyStore) handleSecretEvent(event watch.Event) {
+	if keyEvent, ok := s.secrets.keyEvent(event); ok {
+		s.apply(secretRank, keyEvent)
 	}
+}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// parseAPIKey extracts APIKeyEntry from unstructured object, returning why
+// resources without a spec or a well-formed keyHash are skipped
+func (s *APIKeyStore) parseAPIKey(obj *unstructured.Unstructured) (*models.APIKeyEntry, error) {
+	entry, problems, err := kube.CheckAPIKey(obj)
+	if err != nil {
+		warnf("Warning: skipping APIKey %s: %v", resourceKey(obj), err)
+		return nil, err
+	}
+	for _, problem := range problems {
+		warnf("Warning: APIKey %s %s", resourceKey(obj), problem)
+	}
+	return entry, nil
+}
 
-	switch event.Type {
-	case watch.Added, watch.Modified:
-		s.keyHashes[entry.KeyHash] = entry
-		log.Printf("APIKey %s %s (enabled=%v)", event.Type, entry.Email, entry.Enabled)
+// resourceKey returns the namespace/name of a resource
+func resourceKey(obj *unstructured.Unstructured) string {
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
 
-	case watch.Deleted:
-		delete(s.keyHashes, entry.KeyHash)
-		log.Printf("APIKey deleted: %s", entry.Email)
+// warnMalformedHint logs hints not shaped like generated ones, which would
+// mislead support looking keys up by hint. The key itself is never available
+// to the server, so only the format can be checked.
+func warnMalformedHint(source, hint string) {
+	if hint == "" {
+		return
+	}
+	if err := apikey.ValidateHint(hint); err != nil {
+		warnf("Warning: %s has a malformed keyHint %q: %v", source, hint, err)
 	}
 }
 
-// parseAPIKey extracts APIKeyEntry from unstructured object
-func (s *APIKeyStore) parseAPIKey(obj *unstructured.Unstructured) *models.APIKeyEntry {
-	spec, found, err := unstructured.NestedMap(obj.Object, "spec")
+// parseSecret extracts APIKeyEntry from a Secret. The Secret data holds the
+// hex encoded SHA-256 "keyHash" (required), and optionally "email",
+// "keyHint", "description" and "enabled" ("true"/"false", defaults to true).
+// Secrets without a well-formed keyHash are skipped, as APIKeys are.
+func (s *APIKeyStore) parseSecret(obj *unstructured.Unstructured) (*models.APIKeyEntry, error) {
+	data, found, err := unstructured.NestedStringMap(obj.Object, "data")
 	if err != nil || !found {
-		return nil
+		return nil, nil
 	}
 
-	entry := &models.APIKeyEntry{
-		Name: obj.GetName(),
+	field := func(name string) (string, bool) {
+		encoded, ok := data[name]
+		if !ok {
+			return "", false
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			warnf("Secret %s/%s: invalid base64 in %q", obj.GetNamespace(), obj.GetName(), name)
+			return "", false
+		}
+		return strings.TrimSpace(string(decoded)), true
 	}
 
-	if email, found, _ := unstructured.NestedString(spec, "email"); found {
-		entry.Email = email
-	}
-	if keyHash, found, _ := unstructured.NestedString(spec, "keyHash"); found {
-		entry.KeyHash = keyHash
+	keyHash, ok := field("keyHash")
+	if !ok || keyHash == "" {
+		warnf("Warning: skipping Secret %s: %v", resourceKey(obj), kube.ErrEmptyKeyHash)
+		return nil, kube.ErrEmptyKeyHash
 	}
-	if keyHint, found, _ := unstructured.NestedString(spec, "keyHint"); found {
-		entry.KeyHint = keyHint
+	if !kube.IsSHA256Hash(keyHash) {
+		warnf("Warning: skipping Secret %s: %v", resourceKey(obj), kube.ErrMalformedKeyHash)
+		return nil, kube.ErrMalformedKeyHash
 	}
-	if description, found, _ := unstructured.NestedString(spec, "description"); found {
-		entry.Description = description
+
+	entry := &models.APIKeyEntry{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+		KeyHash:   keyHash,
+		KeyID:     apikey.KeyID(keyHash),
+		Enabled:   true, // Default to enabled
+		Source:    models.SourceSecret,
 	}
-	if enabled, found, _ := unstructured.NestedBool(spec, "enabled"); found {
-		entry.Enabled = enabled
-	} else {
-		entry.Enabled = true // Default to enabled
+	entry.Email, _ = field("email")
+	entry.KeyHint, _ = field("keyHint")
+	warnMalformedHint("Secret "+obj.GetNamespace()+"/"+obj.GetName(), entry.KeyHint)
+	entry.Description, _ = field("description")
+	if enabled, ok := field("enabled"); ok {
+		parsed, err := strconv.ParseBool(enabled)
+		if err != nil {
+			warnf("Secret %s/%s: invalid enabled value %q, treating as disabled", obj.GetNamespace(), obj.GetName(), enabled)
+		}
+		entry.Enabled = parsed
 	}
 
-	return entry
+	return entry, nil
 }
 
 // GetStats returns statistics about the store
@@ -228,20 +383,18 @@ func (s *APIKeyStore) GetStats() map[string]int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	enabled := 0
-	disabled := 0
-
-	for _, entry := range s.keyHashes {
-		if entry.Enabled {
-			enabled++
-		} else {
-			disabled++
+	stats := s.statsLocked()
+	// Part of skipped
+	stats["malformedHashes"] = 0
+	for _, state := range s.states {
+		for _, err := range state.skipped {
+			if errors.Is(err, kube.ErrMalformedKeyHash) {
+				stats["malformedHashes"]++
+			}
 		}
 	}
-
-	return map[string]int{
-		"total":    len(s.keyHashes),
-		"enabled":  enabled,
-		"disabled": disabled,
+	if s.breaker.threshold > 0 {
+		stats["watchBreakerState"], stats["watchBreakerTrips"] = s.breaker.stats()
 	}
+	return stats
 }
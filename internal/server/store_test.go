@@ -0,0 +1,941 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeDynamicClient returns a fake dynamic client serving the given objects
+func newFakeDynamicClient(objects ...*unstructured.Unstructured) *dynamicfake.FakeDynamicClient {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			apiKeyGVR:   "APIKeyList",
+			secretGVR:   "SecretList",
+			kube.CRDGVR: "CustomResourceDefinitionList",
+		},
+	)
+	// Register objects explicitly: the tracker would otherwise guess the
+	// APIKey resource name as "apikeies"
+	for _, obj := range objects {
+		gvr := secretGVR
+		switch obj.GetKind() {
+		case "APIKey":
+			gvr = apiKeyGVR
+		case "CustomResourceDefinition":
+			gvr = kube.CRDGVR
+		}
+		Expect(client.Tracker().Create(gvr, obj, obj.GetNamespace())).To(Succeed())
+	}
+	return client
+}
+
+// answerAccessReviews answers the SelfSubjectAccessReviews of client, denying the
+// given "verb resource" pairs, e.g. "watch apikeys", and allowing the rest
+func answerAccessReviews(client *dynamicfake.FakeDynamicClient, denied ...string) {
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		verb, _, _ := unstructured.NestedString(review.Object, "spec", "resourceAttributes", "verb")
+		resource, _, _ := unstructured.NestedString(review.Object, "spec", "resourceAttributes", "resource")
+		allowed := !slices.Contains(denied, verb+" "+resource)
+		Expect(unstructured.SetNestedField(review.Object, allowed, "status", "allowed")).To(Succeed())
+		if !allowed {
+			Expect(unstructured.SetNestedField(review.Object, "no RBAC policy matched", "status", "reason")).To(Succeed())
+		}
+		return true, review, nil
+	})
+}
+
+// argon2Hash is a well-formed argon2id hash with small costs
+const argon2Hash = "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNoaGFzaA"
+
+// newAPIKeyObject builds an unstructured APIKey resource
+func newAPIKeyObject(name, keyHash string, enabled bool) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "auth.kgateway.dev/v1alpha1",
+		"kind":       "APIKey",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"email":   name + "@example.com",
+			"keyHash": keyHash,
+			"keyHint": "sk-abc*************de",
+			"enabled": enabled,
+		},
+	}}
+}
+
+// newSecretObject builds an unstructured Secret with base64 encoded data
+func newSecretObject(name string, labels map[string]interface{}, data map[string]string) *unstructured.Unstructured {
+	encoded := map[string]interface{}{}
+	for k, v := range data {
+		encoded[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+			"labels":    labels,
+		},
+		"data": encoded,
+	}}
+}
+
+// pagingClient is a fake dynamic client serving lists in pages of the
+// requested limit, which the fake itself ignores, and recording the options
+// of every list call
+type pagingClient struct {
+	*dynamicfake.FakeDynamicClient
+	lists *[]metav1.ListOptions
+	// expire fails the calls continuing a list as if the snapshot expired
+	expire bool
+}
+
+// Resource returns the paging client of a resource
+func (c pagingClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return pagingResource{c.FakeDynamicClient.Resource(gvr), c}
+}
+
+// pagingResource pages the lists of a fake resource by name
+type pagingResource struct {
+	dynamic.NamespaceableResourceInterface
+	client pagingClient
+}
+
+// List returns the page of resources selected by the limit and continue
+// token, the offset of the page
+func (r pagingResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	*r.client.lists = append(*r.client.lists, opts)
+	if r.client.expire && opts.Continue != "" {
+		return nil, apierrors.NewResourceExpired("the continue token has expired")
+	}
+	list, err := r.NamespaceableResourceInterface.List(ctx, opts)
+	if err != nil || opts.Limit == 0 {
+		return list, err
+	}
+	slices.SortFunc(list.Items, func(a, b unstructured.Unstructured) int { return strings.Compare(a.GetName(), b.GetName()) })
+	start, _ := strconv.Atoi(opts.Continue)
+	end := min(start+int(opts.Limit), len(list.Items))
+	if end < len(list.Items) {
+		list.SetContinue(strconv.Itoa(end))
+	}
+	list.Items = list.Items[start:end]
+	return list, nil
+}
+
+var _ = Describe("APIKeyStore", func() {
+	var (
+		ctx        context.Context
+		crdHash    string
+		secretHash string
+		sharedHash string
+		selected   map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		crdHash = apikey.HashAPIKey("sk-crd")
+		secretHash = apikey.HashAPIKey("sk-secret")
+		sharedHash = apikey.HashAPIKey("sk-shared")
+		selected = map[string]interface{}{"batsign.io/apikey": "true"}
+	})
+
+	Describe("Secret key source", func() {
+		var store *APIKeyStore
+
+		BeforeEach(func() {
+			client := newFakeDynamicClient(
+				newAPIKeyObject("crd-user", crdHash, true),
+				newAPIKeyObject("shared-user", sharedHash, false),
+				newSecretObject("secret-user", selected, map[string]string{
+					"keyHash": secretHash,
+					"email":   "secret-user@example.com",
+				}),
+				newSecretObject("shared-secret", selected, map[string]string{
+					"keyHash": sharedHash,
+					"enabled": "true",
+				}),
+				newSecretObject("unrelated", map[string]interface{}{"app": "other"}, map[string]string{
+					"keyHash": apikey.HashAPIKey("sk-unrelated"),
+				}),
+			)
+			store = newAPIKeyStore(client, &models.Config{SecretLabelSelector: "batsign.io/apikey=true"})
+
+			Expect(store.syncSecrets(ctx)).To(Succeed())
+			Expect(store.syncAPIKeys(ctx)).To(Succeed())
+		})
+
+		It("should load keys from both APIKeys and selected Secrets", func() {
+			Expect(store.ValidateKey(crdHash)).To(BeTrue())
+			Expect(store.ValidateKey(secretHash)).To(BeTrue())
+			Expect(store.keyHashes[secretHash].Email).To(Equal("secret-user@example.com"))
+			Expect(store.keyHashes[secretHash].Source).To(Equal(models.SourceSecret))
+		})
+
+		It("should ignore Secrets not matching the selector", func() {
+			Expect(store.ValidateKey(apikey.HashAPIKey("sk-unrelated"))).To(BeFalse())
+		})
+
+		It("should give APIKey resources precedence on hash collisions", func() {
+			Expect(store.keyHashes[sharedHash].Source).To(Equal(models.SourceAPIKey))
+			Expect(store.ValidateKey(sharedHash)).To(BeFalse())
+		})
+
+		It("should restore the Secret entry when the colliding APIKey is deleted", func() {
+			store.handleWatchEvent(watch.Event{
+				Type:   watch.Deleted,
+				Object: newAPIKeyObject("shared-user", sharedHash, false),
+			})
+
+			Expect(store.keyHashes[sharedHash].Source).To(Equal(models.SourceSecret))
+			Expect(store.ValidateKey(sharedHash)).To(BeTrue())
+		})
+
+		It("should not remove an APIKey entry when a colliding Secret is deleted", func() {
+			store.handleSecretEvent(watch.Event{
+				Type:   watch.Deleted,
+				Object: newSecretObject("shared-secret", selected, map[string]string{"keyHash": sharedHash}),
+			})
+
+			Expect(store.keyHashes[sharedHash].Source).To(Equal(models.SourceAPIKey))
+		})
+
+		It("should apply Secret watch events", func() {
+			addedHash := apikey.HashAPIKey("sk-added")
+			store.handleSecretEvent(watch.Event{
+				Type:   watch.Added,
+				Object: newSecretObject("added", selected, map[string]string{"keyHash": addedHash}),
+			})
+			Expect(store.ValidateKey(addedHash)).To(BeTrue())
+
+			store.handleSecretEvent(watch.Event{
+				Type:   watch.Modified,
+				Object: newSecretObject("added", selected, map[string]string{"keyHash": addedHash, "enabled": "false"}),
+			})
+			Expect(store.ValidateKey(addedHash)).To(BeFalse())
+
+			store.handleSecretEvent(watch.Event{
+				Type:   watch.Deleted,
+				Object: newSecretObject("added", selected, map[string]string{"keyHash": addedHash}),
+			})
+			Expect(store.keyHashes).ToNot(HaveKey(addedHash))
+		})
+
+		It("should keep Secret entries across an APIKey resync", func() {
+			Expect(store.syncAPIKeys(ctx)).To(Succeed())
+			Expect(store.ValidateKey(secretHash)).To(BeTrue())
+		})
+
+		It("should keep the search index consistent with watch events", func() {
+			Expect(store.Search(KeyQuery{Email: "crd-user@example.com"})).To(HaveLen(1))
+
+			modified := newAPIKeyObject("crd-user", crdHash, true)
+			Expect(unstructured.SetNestedField(modified.Object, "renamed@example.com", "spec", "email")).To(Succeed())
+			Expect(unstructured.SetNestedField(modified.Object, "sk-new*************hi", "spec", "keyHint")).To(Succeed())
+			store.handleWatchEvent(watch.Event{Type: watch.Modified, Object: modified})
+
+			Expect(store.Search(KeyQuery{Email: "crd-user@example.com"})).To(BeEmpty())
+			Expect(store.Search(KeyQuery{Email: "renamed@example.com"})).To(HaveLen(1))
+			Expect(store.Search(KeyQuery{Hint: "sk-new*************hi"})).To(HaveLen(1))
+
+			store.handleWatchEvent(watch.Event{Type: watch.Deleted, Object: modified})
+			Expect(store.Search(KeyQuery{Email: "renamed@example.com"})).To(BeEmpty())
+			Expect(store.Search(KeyQuery{Hint: "sk-new*************hi"})).To(BeEmpty())
+		})
+
+		It("should record when each version of a key was loaded", func() {
+			loaded, found := store.Lookup(crdHash)
+			Expect(found).To(BeTrue())
+			Expect(loaded.LoadedAt).ToNot(BeZero())
+
+			time.Sleep(time.Millisecond)
+			store.handleWatchEvent(watch.Event{Type: watch.Modified, Object: newAPIKeyObject("crd-user", crdHash, false)})
+			modified, _ := store.Lookup(crdHash)
+			Expect(modified.LoadedAt).To(BeTemporally(">", loaded.LoadedAt))
+
+			// Other keys keep theirs
+			secret, _ := store.Lookup(secretHash)
+			Expect(secret.LoadedAt).To(BeTemporally("<=", loaded.LoadedAt))
+		})
+
+		It("should index the restored Secret entry when the colliding APIKey is deleted", func() {
+			store.handleWatchEvent(watch.Event{
+				Type:   watch.Deleted,
+				Object: newAPIKeyObject("shared-user", sharedHash, false),
+			})
+
+			Expect(store.Search(KeyQuery{Email: "shared-user@example.com"})).To(BeEmpty())
+			restored := store.Search(KeyQuery{})
+			Expect(restored).To(ContainElement(HaveField("Name", "shared-secret")))
+		})
+	})
+
+	Describe("APIKey CRD not installed", func() {
+		var (
+			client *dynamicfake.FakeDynamicClient
+			logs   bytes.Buffer
+			lists  int
+		)
+
+		// failLists makes the first n APIKey lists fail with err
+		failLists := func(n int, err error) {
+			client.PrependReactor("list", "apikeys", func(k8stesting.Action) (bool, runtime.Object, error) {
+				lists++
+				if lists > n {
+					return false, nil, nil
+				}
+				return true, nil, err
+			})
+		}
+
+		BeforeEach(func() {
+			logs.Reset()
+			log.SetOutput(&logs)
+			DeferCleanup(log.SetOutput, os.Stderr)
+
+			lists = 0
+			client = newFakeDynamicClient(newAPIKeyObject("crd-user", crdHash, true))
+		})
+
+		DescribeTable("should fail with an actionable message by default",
+			func(err error) {
+				failLists(1, err)
+				store := newAPIKeyStore(client, &models.Config{})
+
+				startErr := store.Start(ctx)
+				Expect(startErr).To(MatchError(errCRDNotInstalled))
+				Expect(startErr).To(MatchError(ContainSubstring("apikey-manager-client crd | kubectl apply -f -")))
+				Expect(lists).To(Equal(1))
+			},
+			Entry("no resource match", &meta.NoResourceMatchError{PartialResource: apiKeyGVR}),
+			Entry("not found", apierrors.NewNotFound(apiKeyGVR.GroupResource(), "")),
+		)
+
+		It("should not mistake other list errors for a missing CRD", func() {
+			failLists(1, apierrors.NewForbidden(apiKeyGVR.GroupResource(), "", nil))
+			store := newAPIKeyStore(client, &models.Config{CRDWaitTimeout: time.Minute})
+
+			err := store.Start(ctx)
+			Expect(err).To(HaveOccurred())
+			Expect(err).ToNot(MatchError(errCRDNotInstalled))
+			Expect(lists).To(Equal(1))
+		})
+
+		Context("with a wait timeout", func() {
+			BeforeEach(func() {
+				DeferCleanup(func(interval time.Duration) { crdRetryInterval = interval }, crdRetryInterval)
+				crdRetryInterval = time.Millisecond
+			})
+
+			It("should wait for the CRD to be installed", func() {
+				failLists(2, &meta.NoResourceMatchError{PartialResource: apiKeyGVR})
+				store := newAPIKeyStore(client, &models.Config{CRDWaitTimeout: time.Minute})
+				DeferCleanup(store.Stop)
+
+				Expect(store.Start(ctx)).To(Succeed())
+				Expect(store.ValidateKey(crdHash)).To(BeTrue())
+				Expect(lists).To(Equal(3))
+				Expect(logs.String()).To(ContainSubstring("APIKey CRD not installed; run `apikey-manager-client crd | kubectl apply -f -`; waiting for it"))
+			})
+
+			It("should give up once the timeout elapsed", func() {
+				failLists(1000000, &meta.NoResourceMatchError{PartialResource: apiKeyGVR})
+				store := newAPIKeyStore(client, &models.Config{CRDWaitTimeout: 20 * time.Millisecond})
+
+				Expect(store.Start(ctx)).To(MatchError(errCRDNotInstalled))
+				Expect(lists).To(BeNumerically(">", 1))
+			})
+		})
+	})
+
+	Describe("CRD schema check", func() {
+		var logs bytes.Buffer
+
+		// newCRDObject builds an APIKey CRD declaring only the given spec fields
+		newCRDObject := func(fields ...string) *unstructured.Unstructured {
+			properties := map[string]interface{}{}
+			for _, field := range fields {
+				properties[field] = map[string]interface{}{"type": "string"}
+			}
+			return &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "apiextensions.k8s.io/v1",
+				"kind":       "CustomResourceDefinition",
+				"metadata":   map[string]interface{}{"name": kube.APIKeyCRDName},
+				"spec": map[string]interface{}{
+					"versions": []interface{}{map[string]interface{}{
+						"name": apiKeyGVR.Version,
+						"schema": map[string]interface{}{"openAPIV3Schema": map[string]interface{}{
+							"properties": map[string]interface{}{"spec": map[string]interface{}{
+								"type":       "object",
+								"properties": properties,
+							}},
+						}},
+					}},
+				},
+			}}
+		}
+
+		BeforeEach(func() {
+			logs.Reset()
+			log.SetOutput(&logs)
+			DeferCleanup(log.SetOutput, os.Stderr)
+		})
+
+		It("should warn about spec fields missing from the installed CRD", func() {
+			crd := newCRDObject("email", "keyHash", "keyHashes", "keyHashAlgo", "keyHint", "description", "notes", "enabled")
+			store := newAPIKeyStore(newFakeDynamicClient(crd, newAPIKeyObject("crd-user", crdHash, true)), &models.Config{})
+			DeferCleanup(store.Stop)
+
+			Expect(store.Start(ctx)).To(Succeed())
+			Expect(store.ValidateKey(crdHash)).To(BeTrue())
+			Expect(logs.String()).To(ContainSubstring(
+				"Warning: the installed APIKey CRD lacks spec fields allowedMethods, activeWindows, activeWindows.start, activeWindows.end, activeWindows.days"))
+		})
+
+		It("should only warn when the CRD cannot be read", func() {
+			store := newAPIKeyStore(newFakeDynamicClient(newAPIKeyObject("crd-user", crdHash, true)), &models.Config{})
+			DeferCleanup(store.Stop)
+
+			Expect(store.Start(ctx)).To(Succeed())
+			Expect(logs.String()).To(ContainSubstring("Warning: could not check the APIKey CRD schema"))
+			Expect(logs.String()).ToNot(ContainSubstring("lacks spec fields"))
+		})
+	})
+
+	Describe("lifecycle", func() {
+		var client *dynamicfake.FakeDynamicClient
+
+		BeforeEach(func() {
+			client = newFakeDynamicClient(newAPIKeyObject("crd-user", crdHash, true))
+		})
+
+		// countActions returns the number of actions of a verb on APIKeys
+		countActions := func(verb string) int {
+			n := 0
+			for _, action := range client.Actions() {
+				if action.GetVerb() == verb && action.GetResource() == apiKeyGVR {
+					n++
+				}
+			}
+			return n
+		}
+
+		It("should start watching only once when started twice", func() {
+			store := newAPIKeyStore(client, &models.Config{})
+			DeferCleanup(store.Stop)
+
+			Expect(store.Start(ctx)).To(Succeed())
+			Expect(store.Start(ctx)).To(Succeed())
+
+			Eventually(func() int { return countActions("watch") }).Should(Equal(1))
+			Consistently(func() int { return countActions("watch") }, 50*time.Millisecond).Should(Equal(1))
+			Expect(countActions("list")).To(Equal(1))
+		})
+
+		It("should allow retrying a failed start", func() {
+			failed := false
+			client.PrependReactor("list", "apikeys", func(k8stesting.Action) (bool, runtime.Object, error) {
+				if failed {
+					return false, nil, nil
+				}
+				failed = true
+				return true, nil, apierrors.NewServiceUnavailable("etcd is down")
+			})
+			store := newAPIKeyStore(client, &models.Config{})
+			DeferCleanup(store.Stop)
+
+			Expect(store.Start(ctx)).ToNot(Succeed())
+			Expect(store.Start(ctx)).To(Succeed())
+			Expect(store.ValidateKey(crdHash)).To(BeTrue())
+		})
+
+		It("should tolerate being stopped twice", func() {
+			store := newAPIKeyStore(client, &models.Config{})
+			Expect(store.Start(ctx)).To(Succeed())
+
+			Expect(func() {
+				store.Stop()
+				store.Stop()
+			}).ToNot(Panic())
+		})
+	})
+
+	Describe("RBAC check", func() {
+		var client *dynamicfake.FakeDynamicClient
+
+		BeforeEach(func() {
+			client = newFakeDynamicClient(newAPIKeyObject("crd-user", crdHash, true))
+		})
+
+		It("should fail fast when watching APIKeys is denied", func() {
+			answerAccessReviews(client, "watch apikeys")
+			store := newAPIKeyStore(client, &models.Config{CheckRBAC: true, Namespace: "gateway"})
+
+			err := store.Start(ctx)
+			Expect(err).To(MatchError(ContainSubstring("insufficient RBAC: the service account cannot watch (no RBAC policy matched) apikeys.auth.kgateway.dev in namespace gateway")))
+			Expect(store.ValidateKey(crdHash)).To(BeFalse())
+		})
+
+		It("should check Secrets when they are synced", func() {
+			answerAccessReviews(client, "list secrets")
+			store := newAPIKeyStore(client, &models.Config{CheckRBAC: true, SecretLabelSelector: "batsign.dev/key"})
+
+			Expect(store.Start(ctx)).To(MatchError(ContainSubstring("cannot list (no RBAC policy matched) secrets in all namespaces")))
+		})
+
+		It("should start when list and watch are allowed", func() {
+			answerAccessReviews(client)
+			store := newAPIKeyStore(client, &models.Config{CheckRBAC: true})
+			DeferCleanup(store.Stop)
+
+			Expect(store.Start(ctx)).To(Succeed())
+			Expect(store.ValidateKey(crdHash)).To(BeTrue())
+		})
+
+		It("should not check by default", func() {
+			answerAccessReviews(client, "watch apikeys")
+			store := newAPIKeyStore(client, &models.Config{})
+			DeferCleanup(store.Stop)
+
+			Expect(store.Start(ctx)).To(Succeed())
+		})
+	})
+
+	Describe("APIKeys without a keyHash", func() {
+		var (
+			store   *APIKeyStore
+			logs    bytes.Buffer
+			missing *unstructured.Unstructured
+			empty   *unstructured.Unstructured
+		)
+
+		BeforeEach(func() {
+			logs.Reset()
+			log.SetOutput(&logs)
+			DeferCleanup(log.SetOutput, os.Stderr)
+
+			missing = newAPIKeyObject("missing", crdHash, true)
+			unstructured.RemoveNestedField(missing.Object, "spec", "keyHash")
+			empty = newAPIKeyObject("empty", "", true)
+
+			store = newAPIKeyStore(newFakeDynamicClient(newAPIKeyObject("crd-user", crdHash, true), missing, empty), &models.Config{})
+			Expect(store.syncAPIKeys(ctx)).To(Succeed())
+		})
+
+		It("should skip them with a warning naming the resource", func() {
+			Expect(store.keyHashes).To(HaveLen(1))
+			Expect(store.keyHashes).ToNot(HaveKey(""))
+			Expect(store.GetStats()).To(Equal(map[string]int{"total": 1, "enabled": 1, "disabled": 0, "skipped": 2, "malformedHashes": 0, "cacheStaleSeconds": 0}))
+			Expect(logs.String()).To(ContainSubstring("skipping APIKey /missing: empty keyHash"))
+			Expect(logs.String()).To(ContainSubstring("skipping APIKey /empty: empty keyHash"))
+		})
+
+		It("should track them through watch events", func() {
+			store.handleWatchEvent(watch.Event{Type: watch.Modified, Object: newAPIKeyObject("empty", secretHash, true)})
+			Expect(store.ValidateKey(secretHash)).To(BeTrue())
+			Expect(store.GetStats()["skipped"]).To(Equal(1))
+
+			store.handleWatchEvent(watch.Event{Type: watch.Deleted, Object: missing})
+			Expect(store.GetStats()["skipped"]).To(Equal(0))
+
+			store.handleWatchEvent(watch.Event{Type: watch.Added, Object: newAPIKeyObject("late", "", true)})
+			Expect(store.GetStats()["skipped"]).To(Equal(1))
+			Expect(store.keyHashes).ToNot(HaveKey(""))
+		})
+
+		It("should skip and count malformed hashes", func() {
+			truncated := crdHash[:63]
+			store.handleWatchEvent(watch.Event{Type: watch.Added, Object: newAPIKeyObject("truncated", truncated, true)})
+			store.handleWatchEvent(watch.Event{Type: watch.Added, Object: newAPIKeyObject("pasted", "sk-raw-key-pasted", true)})
+
+			Expect(store.keyHashes).ToNot(HaveKey(truncated))
+			Expect(store.keyHashes).ToNot(HaveKey("sk-raw-key-pasted"))
+			Expect(store.GetStats()).To(HaveKeyWithValue("skipped", 4))
+			Expect(store.GetStats()).To(HaveKeyWithValue("malformedHashes", 2))
+			Expect(logs.String()).To(ContainSubstring("skipping APIKey /truncated: keyHash is not a hex encoded SHA-256 hash"))
+
+			// Fixing the hash loads the key
+			store.handleWatchEvent(watch.Event{Type: watch.Modified, Object: newAPIKeyObject("truncated", secretHash, true)})
+			Expect(store.ValidateKey(secretHash)).To(BeTrue())
+			Expect(store.GetStats()).To(HaveKeyWithValue("malformedHashes", 1))
+		})
+	})
+
+	Describe("watch reconnects", func() {
+		var logs bytes.Buffer
+
+		BeforeEach(func() {
+			logs.Reset()
+			log.SetOutput(&logs)
+			DeferCleanup(log.SetOutput, os.Stderr)
+		})
+
+		It("should report how stale the keys got and catch up on missed changes", func() {
+			client := newFakeDynamicClient(newAPIKeyObject("kept", crdHash, true), newAPIKeyObject("revoked", sharedHash, true))
+			var down atomic.Bool
+			watchers := make(chan *watch.FakeWatcher, 10)
+			client.PrependWatchReactor("apikeys", func(k8stesting.Action) (bool, watch.Interface, error) {
+				if down.Load() {
+					return true, nil, apierrors.NewServiceUnavailable("etcd is down")
+				}
+				watcher := watch.NewFake()
+				watchers <- watcher
+				return true, watcher, nil
+			})
+			store := newAPIKeyStore(client, &models.Config{WatchBreakerFailures: 1, WatchBreakerCooldown: 10 * time.Millisecond})
+			start := time.Now()
+			var elapsed atomic.Int64
+			store.now = func() time.Time { return start.Add(time.Duration(elapsed.Load())) }
+			DeferCleanup(store.Stop)
+
+			Expect(store.Start(ctx)).To(Succeed())
+			kept, _ := store.Lookup(crdHash)
+			Expect(store.GetStats()["cacheStaleSeconds"]).To(BeZero())
+
+			// The watch ends and the API server stays down while a key is deleted
+			watcher := <-watchers
+			down.Store(true)
+			watcher.Stop()
+			Eventually(func() bool {
+				store.mu.RLock()
+				defer store.mu.RUnlock()
+				return !store.staleSince[apiKeyRank].IsZero()
+			}).Should(BeTrue())
+			Expect(client.Tracker().Delete(apiKeyGVR, "", "revoked")).To(Succeed())
+			elapsed.Store(int64(90 * time.Second))
+			Expect(store.GetStats()["cacheStaleSeconds"]).To(Equal(90))
+			srv, err := NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080}, store)
+			Expect(err).ToNot(HaveOccurred())
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+			Expect(rec.Body.String()).To(ContainSubstring(`"cacheStaleSeconds":90`))
+			rec = httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+			req.Header.Set("Accept", "text/plain;version=0.0.4")
+			srv.Handler().ServeHTTP(rec, req)
+			Expect(rec.Body.String()).To(ContainSubstring("\nbatsign_cache_stale_seconds 90\n"))
+			Expect(store.ValidateKey(sharedHash)).To(BeTrue())
+
+			down.Store(false)
+			Eventually(func() bool { return store.ValidateKey(sharedHash) }).Should(BeFalse())
+			Expect(logs.String()).To(ContainSubstring("APIKeys resynced after 1m30s without updates: 1 keys changed"))
+			Expect(store.GetStats()["cacheStaleSeconds"]).To(BeZero())
+			// The unchanged key still is the version loaded at start
+			resynced, _ := store.Lookup(crdHash)
+			Expect(resynced.LoadedAt).To(Equal(kept.LoadedAt))
+		})
+	})
+
+	Describe("paginated sync", func() {
+		var (
+			client pagingClient
+			lists  []metav1.ListOptions
+		)
+
+		BeforeEach(func() {
+			lists = nil
+			var objects []*unstructured.Unstructured
+			for i := range 5 {
+				objects = append(objects, newAPIKeyObject(fmt.Sprintf("key-%d", i), apikey.HashAPIKey(fmt.Sprintf("sk-page-%d", i)), true))
+			}
+			client = pagingClient{FakeDynamicClient: newFakeDynamicClient(objects...), lists: &lists}
+		})
+
+		// start syncs a store listing APIKeys two by two
+		start := func() *APIKeyStore {
+			store := newAPIKeyStore(client, &models.Config{})
+			store.apiKeys.pageSize = 2
+			DeferCleanup(store.Stop)
+			Expect(store.Start(ctx)).To(Succeed())
+			return store
+		}
+
+		It("should load every page", func() {
+			store := start()
+			for i := range 5 {
+				Expect(store.ValidateKey(apikey.HashAPIKey(fmt.Sprintf("sk-page-%d", i)))).To(BeTrue())
+			}
+			Expect(store.GetStats()["total"]).To(Equal(5))
+
+			var continues []string
+			for _, opts := range lists[:3] {
+				Expect(opts.Limit).To(BeEquivalentTo(2))
+				continues = append(continues, opts.Continue)
+			}
+			Expect(continues).To(Equal([]string{"", "2", "4"}))
+		})
+
+		It("should list at once when the snapshot expires", func() {
+			client.expire = true
+			store := start()
+			Expect(store.GetStats()["total"]).To(Equal(5))
+			Expect(lists[2].Limit).To(BeZero())
+		})
+	})
+
+	Describe("watch liveness", func() {
+		It("should report a watch goroutine that stopped beating", func() {
+			client := newFakeDynamicClient(newAPIKeyObject("kept", crdHash, true))
+			store := newAPIKeyStore(client, &models.Config{})
+			start := time.Now()
+			var elapsed atomic.Int64
+			store.apiKeys.now = func() time.Time { return start.Add(time.Duration(elapsed.Load())) }
+			DeferCleanup(store.Stop)
+
+			srv, err := NewWithStore(&models.Config{GRPCPort: 9191, HTTPPort: 8080}, store)
+			Expect(err).ToNot(HaveOccurred())
+			livez := func() int {
+				rec := httptest.NewRecorder()
+				srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+				return rec.Code
+			}
+			// Not watching yet
+			Expect(livez()).To(Equal(http.StatusOK))
+
+			Expect(store.Start(ctx)).To(Succeed())
+			Eventually(store.apiKeys.heartbeat.Load).ShouldNot(BeZero())
+			Expect(store.Alive()).To(Succeed())
+
+			// The clock moves on while the goroutine does not beat
+			elapsed.Store(int64(models.DefaultWatchLivenessTimeout + time.Minute))
+			Expect(store.Alive()).To(MatchError("APIKeys watch has not run for 3m0s"))
+			Expect(livez()).To(Equal(http.StatusServiceUnavailable))
+
+			// Any change proves it runs again
+			Expect(client.Tracker().Create(apiKeyGVR, newAPIKeyObject("added", sharedHash, true), "")).To(Succeed())
+			Eventually(livez).Should(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("key families", func() {
+		var (
+			store      *APIKeyStore
+			family     *unstructured.Unstructured
+			euHash     string
+			usHash     string
+			familyKeys []string
+		)
+
+		BeforeEach(func() {
+			euHash = apikey.HashAPIKey("sk-eu")
+			usHash = apikey.HashAPIKey("sk-us")
+			familyKeys = []string{crdHash, euHash, usHash}
+
+			family = newAPIKeyObject("regional", crdHash, true)
+			Expect(unstructured.SetNestedStringSlice(family.Object, []string{euHash, usHash}, "spec", "keyHashes")).To(Succeed())
+
+			store = newAPIKeyStore(newFakeDynamicClient(family), &models.Config{})
+			Expect(store.syncAPIKeys(ctx)).To(Succeed())
+		})
+
+		It("should validate every hash to the same identity", func() {
+			for _, hash := range familyKeys {
+				Expect(store.ValidateKey(hash)).To(BeTrue())
+				entry, ok := store.Lookup(hash)
+				Expect(ok).To(BeTrue())
+				Expect(entry.Email).To(Equal("regional@example.com"))
+			}
+			Expect(store.List()).To(HaveLen(1))
+			Expect(store.Search(KeyQuery{})).To(HaveLen(1))
+			Expect(store.GetStats()).To(Equal(map[string]int{"total": 1, "enabled": 1, "disabled": 0, "skipped": 0, "malformedHashes": 0, "cacheStaleSeconds": 0}))
+		})
+
+		It("should clear every hash when the resource is deleted", func() {
+			store.handleWatchEvent(watch.Event{Type: watch.Deleted, Object: family})
+
+			for _, hash := range familyKeys {
+				Expect(store.ValidateKey(hash)).To(BeFalse())
+			}
+			Expect(store.keyHashes).To(BeEmpty())
+			Expect(store.Search(KeyQuery{})).To(BeEmpty())
+		})
+
+		It("should clear hashes dropped by an update", func() {
+			updated := family.DeepCopy()
+			Expect(unstructured.SetNestedStringSlice(updated.Object, []string{euHash}, "spec", "keyHashes")).To(Succeed())
+			store.handleWatchEvent(watch.Event{Type: watch.Modified, Object: updated})
+
+			Expect(store.ValidateKey(crdHash)).To(BeTrue())
+			Expect(store.ValidateKey(euHash)).To(BeTrue())
+			Expect(store.ValidateKey(usHash)).To(BeFalse())
+
+			// A deletion only carrying the final version still clears all
+			// hashes registered earlier
+			store.handleWatchEvent(watch.Event{Type: watch.Modified, Object: family})
+			store.handleWatchEvent(watch.Event{Type: watch.Deleted, Object: updated})
+			Expect(store.keyHashes).To(BeEmpty())
+		})
+
+		It("should keep a single search result when the primary hash changes", func() {
+			reordered := family.DeepCopy()
+			Expect(unstructured.SetNestedField(reordered.Object, euHash, "spec", "keyHash")).To(Succeed())
+			Expect(unstructured.SetNestedStringSlice(reordered.Object, []string{crdHash, usHash}, "spec", "keyHashes")).To(Succeed())
+			store.handleWatchEvent(watch.Event{Type: watch.Modified, Object: reordered})
+
+			Expect(store.Search(KeyQuery{})).To(HaveLen(1))
+			for _, hash := range familyKeys {
+				Expect(store.ValidateKey(hash)).To(BeTrue())
+			}
+		})
+	})
+
+	Describe("parseAPIKey", func() {
+		It("should load and normalize allowed methods", func() {
+			obj := newAPIKeyObject("read-only", crdHash, true)
+			Expect(unstructured.SetNestedStringSlice(obj.Object, []string{"get", " Head ", ""}, "spec", "allowedMethods")).To(Succeed())
+
+			entry, err := newAPIKeyStore(nil, &models.Config{}).parseAPIKey(obj)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.AllowedMethods).To(Equal([]string{"GET", "HEAD"}))
+		})
+
+		It("should leave methods unrestricted when unset", func() {
+			entry, err := newAPIKeyStore(nil, &models.Config{}).parseAPIKey(newAPIKeyObject("any", crdHash, true))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.AllowedMethods).To(BeEmpty())
+		})
+
+		It("should load active windows and warn about invalid ones", func() {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			DeferCleanup(log.SetOutput, os.Stderr)
+
+			obj := newAPIKeyObject("office-hours", crdHash, true)
+			Expect(unstructured.SetNestedSlice(obj.Object, []interface{}{
+				map[string]interface{}{"start": "09:00", "end": "18:00", "days": []interface{}{"Mon-Fri"}},
+				map[string]interface{}{"start": "9am", "end": "6pm"},
+			}, "spec", "activeWindows")).To(Succeed())
+
+			entry, err := newAPIKeyStore(nil, &models.Config{}).parseAPIKey(obj)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.ActiveWindows).To(Equal([]models.ActiveWindow{
+				{Start: "09:00", End: "18:00", Days: []string{"Mon-Fri"}},
+				{Start: "9am", End: "6pm"},
+			}))
+			Expect(logs.String()).To(ContainSubstring("invalid active window 1"))
+			Expect(logs.String()).ToNot(ContainSubstring("invalid active window 0"))
+		})
+
+		DescribeTable("should warn about malformed hints",
+			func(hint string, warned bool) {
+				var logs bytes.Buffer
+				log.SetOutput(&logs)
+				DeferCleanup(log.SetOutput, os.Stderr)
+
+				obj := newAPIKeyObject("hinted", crdHash, true)
+				Expect(unstructured.SetNestedField(obj.Object, hint, "spec", "keyHint")).To(Succeed())
+				entry, err := newAPIKeyStore(nil, &models.Config{}).parseAPIKey(obj)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(entry.KeyHint).To(Equal(hint))
+				if warned {
+					Expect(logs.String()).To(ContainSubstring("malformed keyHint"))
+				} else {
+					Expect(logs.String()).ToNot(ContainSubstring("malformed keyHint"))
+				}
+			},
+			Entry("generated hint", "sk-abc*************de", false),
+			Entry("empty hint", "", false),
+			Entry("pasted key", "sk-abcdefghijklmnopqrstuvwxyz", true),
+			Entry("truncated mask", "sk-abc****de", true),
+		)
+
+		DescribeTable("should warn about hashes that can never validate",
+			func(algo, hash, hint string, warned bool) {
+				var logs bytes.Buffer
+				log.SetOutput(&logs)
+				DeferCleanup(log.SetOutput, os.Stderr)
+
+				obj := newAPIKeyObject("hashed", hash, true)
+				Expect(unstructured.SetNestedField(obj.Object, algo, "spec", "keyHashAlgo")).To(Succeed())
+				Expect(unstructured.SetNestedField(obj.Object, hint, "spec", "keyHint")).To(Succeed())
+				entry, err := newAPIKeyStore(nil, &models.Config{}).parseAPIKey(obj)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(entry.KeyHashAlgo).To(Equal(algo))
+				if warned {
+					Expect(logs.String()).To(ContainSubstring("never validates"))
+				} else {
+					Expect(logs.String()).ToNot(ContainSubstring("never validates"))
+				}
+			},
+			Entry("SHA-256", models.HashAlgoSHA256, strings.Repeat("a", 64), "sk-abc*************de", false),
+			Entry("argon2id", models.HashAlgoArgon2id, argon2Hash, "sk-abc*************de", false),
+			Entry("argon2id without a hint", models.HashAlgoArgon2id, argon2Hash, "", true),
+			Entry("malformed argon2id", models.HashAlgoArgon2id, strings.Repeat("a", 64), "sk-abc*************de", true),
+			Entry("unknown algorithm", "bcrypt", "$2a$10$abcdefghijklmnopqrstuv", "sk-abc*************de", true),
+		)
+	})
+
+	Describe("parseSecret", func() {
+		It("should skip Secrets without a keyHash", func() {
+			store := newAPIKeyStore(newFakeDynamicClient(), &models.Config{})
+			entry, err := store.parseSecret(newSecretObject("empty", selected, map[string]string{"email": "a@b.co"}))
+			Expect(entry).To(BeNil())
+			Expect(err).To(MatchError(kube.ErrEmptyKeyHash))
+		})
+
+		DescribeTable("should skip Secrets with a malformed keyHash",
+			func(keyHash string) {
+				store := newAPIKeyStore(newFakeDynamicClient(), &models.Config{})
+				entry, err := store.parseSecret(newSecretObject("malformed", selected, map[string]string{"keyHash": keyHash}))
+				Expect(entry).To(BeNil())
+				Expect(err).To(MatchError(kube.ErrMalformedKeyHash))
+			},
+			Entry("truncated", apikey.HashAPIKey("sk-secret")[:63]),
+			Entry("upper-case", strings.ToUpper(apikey.HashAPIKey("sk-secret"))),
+			Entry("raw key pasted", "sk-raw-key-pasted"),
+		)
+
+		It("should count skipped Secrets in the stats", func() {
+			store := newAPIKeyStore(newFakeDynamicClient(
+				newSecretObject("secret-user", selected, map[string]string{"keyHash": secretHash}),
+				newSecretObject("truncated", selected, map[string]string{"keyHash": secretHash[:63]}),
+				newSecretObject("empty", selected, map[string]string{"email": "a@b.co"}),
+			), &models.Config{SecretLabelSelector: "batsign.io/apikey=true"})
+			Expect(store.syncSecrets(ctx)).To(Succeed())
+
+			Expect(store.ValidateKey(secretHash)).To(BeTrue())
+			Expect(store.GetStats()).To(HaveKeyWithValue("skipped", 2))
+			Expect(store.GetStats()).To(HaveKeyWithValue("malformedHashes", 1))
+		})
+
+		It("should treat an invalid enabled value as disabled", func() {
+			store := newAPIKeyStore(newFakeDynamicClient(), &models.Config{})
+			entry, err := store.parseSecret(newSecretObject("bad", selected, map[string]string{
+				"keyHash": secretHash,
+				"enabled": "maybe",
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry).ToNot(BeNil())
+			Expect(entry.Enabled).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/grpc"
+
+	"github.com/efortin/batsign/internal/version"
+)
+
+// tracerName is the instrumentation scope of spans created by the server
+const tracerName = "github.com/efortin/batsign/internal/server"
+
+// Span attributes recorded on Check spans
+const (
+	attrDecision   = "authz.decision"
+	attrDenyReason = "authz.deny_reason"
+)
+
+// setupTracing installs a global OTLP tracer provider exporting to endpoint.
+// It returns the gRPC server options propagating incoming trace context and
+// a function flushing pending spans on shutdown.
+func setupTracing(ctx context.Context, endpoint string) ([]grpc.ServerOption, func(context.Context) error, error) {
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	res, err := sdkresource.Merge(sdkresource.Default(), sdkresource.NewSchemaless(
+		semconv.ServiceName("batsign"),
+		semconv.ServiceVersion(version.Version),
+	))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	opts := []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}
+	return opts, provider.Shutdown, nil
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/efortin/batsign/internal/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// namespaceGVR identifies core Namespaces
+var namespaceGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// ConfigCheck is the outcome of one ValidateConfig check
+type ConfigCheck struct {
+	Name string
+	// Err is set when the server would fail to start or to serve keys
+	Err error
+	// Warning describes a problem the server starts despite
+	Warning string
+}
+
+// ConfigReport lists the checks run by ValidateConfig, in order
+type ConfigReport struct {
+	Checks []ConfigCheck
+}
+
+// OK reports whether every check passed, warnings aside
+func (r *ConfigReport) OK() bool {
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Write prints one line per check followed by a verdict
+func (r *ConfigReport) Write(w io.Writer) {
+	failed := 0
+	for _, check := range r.Checks {
+		switch {
+		case check.Err != nil:
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", check.Name, check.Err)
+		case check.Warning != "":
+			fmt.Fprintf(w, "WARN  %s: %s\n", check.Name, check.Warning)
+		default:
+			fmt.Fprintf(w, "ok    %s\n", check.Name)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "configuration is invalid: %d of %d checks failed\n", failed, len(r.Checks))
+		return
+	}
+	fmt.Fprintln(w, "configuration is valid")
+}
+
+// add records the outcome of a check
+func (r *ConfigReport) add(name string, err error) {
+	r.Checks = append(r.Checks, ConfigCheck{Name: name, Err: err})
+}
+
+// ValidateConfig runs the checks New does, plus those that would only fail
+// once the server runs (keys file, Kubernetes access, namespaces, RBAC,
+// APIKey CRD), without opening listeners or watching anything
+func ValidateConfig(ctx context.Context, config *models.Config) *ConfigReport {
+	return validateConfig(ctx, config, newDynamicClient)
+}
+
+// validateConfig is ValidateConfig with the Kubernetes client factory
+// injected
+func validateConfig(ctx context.Context, config *models.Config, newClient func(*models.Config) (dynamic.Interface, error)) *ConfigReport {
+	report := &ConfigReport{}
+
+	report.add("flags", validateFlags(config))
+	_, err := NewAuthorizationServer(NewInMemoryStore(), config)
+	report.add("authorization", err)
+
+	if config.InMemory {
+		if config.KeysFile != "" {
+			_, err := LoadKeysFile(config.KeysFile)
+			report.add("keys-file", err)
+		}
+		return report
+	}
+
+	client, err := newClient(config)
+	report.add("kubeconfig", err)
+	if err != nil {
+		return report
+	}
+
+	if config.Namespace != "" {
+		report.add("namespace", namespaceExists(ctx, client, config.Namespace))
+	}
+	if config.RouteRulesConfigMap != "" {
+		namespace, _, _ := strings.Cut(config.RouteRulesConfigMap, "/")
+		report.add("route-rules-configmap", namespaceExists(ctx, client, namespace))
+	}
+
+	report.add("rbac", checkWatchAccess(ctx, client, config.Namespace, config.SecretLabelSelector))
+
+	missing, err := kube.MissingSpecFields(ctx, client)
+	check := ConfigCheck{Name: "apikey-crd", Err: err}
+	if err == nil && len(missing) > 0 {
+		check.Warning = fmt.Sprintf("lacks spec fields %s, which the API server drops; "+
+			"update it with `apikey-manager-client crd | kubectl apply -f -`", strings.Join(missing, ", "))
+	}
+	report.Checks = append(report.Checks, check)
+
+	return report
+}
+
+// validateFlags checks the flags Config.Validate leaves to the components
+// consuming them
+func validateFlags(config *models.Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.LogLevel != "" {
+		if _, err := parseLogLevel(config.LogLevel); err != nil {
+			return fmt.Errorf("invalid config: log-level: %w", err)
+		}
+	}
+	if config.SecretLabelSelector != "" {
+		if _, err := labels.Parse(config.SecretLabelSelector); err != nil {
+			return fmt.Errorf("invalid config: secret-selector: %w", err)
+		}
+	}
+	if config.TracingEndpoint != "" {
+		// The exporter ignores unparsable URLs and falls back to localhost
+		endpoint, err := url.Parse(config.TracingEndpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("invalid config: tracing-endpoint %q must be an http(s) URL", config.TracingEndpoint)
+		}
+	}
+	return nil
+}
+
+// namespaceExists checks that the namespace can be read, which also proves
+// the API server is reachable with the configured credentials
+func namespaceExists(ctx context.Context, client dynamic.Interface, namespace string) error {
+	if _, err := client.Resource(namespaceGVR).Get(ctx, namespace, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("namespace %q: %w", namespace, err)
+	}
+	return nil
+}
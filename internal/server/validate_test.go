@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("ValidateConfig", func() {
+	var (
+		config *models.Config
+		client dynamic.Interface
+	)
+
+	// newNamespaceObject builds an unstructured Namespace
+	newNamespaceObject := func(name string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": name},
+		}}
+	}
+
+	BeforeEach(func() {
+		config = &models.Config{GRPCPort: 9191, HTTPPort: 8080, Namespace: "gateway", LogLevel: "info"}
+
+		crd := &unstructured.Unstructured{}
+		Expect(yaml.Unmarshal([]byte(apikey.CRDYAML()), &crd.Object)).To(Succeed())
+		fake := newFakeDynamicClient(crd)
+		Expect(fake.Tracker().Create(namespaceGVR, newNamespaceObject("gateway"), "")).To(Succeed())
+		answerAccessReviews(fake)
+		client = fake
+	})
+
+	// validate runs the checks against the fake cluster and returns the
+	// failed checks by name
+	validate := func() map[string]error {
+		report := validateConfig(context.Background(), config, func(*models.Config) (dynamic.Interface, error) {
+			return client, nil
+		})
+		failed := map[string]error{}
+		for _, check := range report.Checks {
+			if check.Err != nil {
+				failed[check.Name] = check.Err
+			}
+		}
+		Expect(report.OK()).To(Equal(len(failed) == 0))
+		return failed
+	}
+
+	It("should accept a valid configuration", func() {
+		Expect(validate()).To(BeEmpty())
+	})
+
+	It("should report every failure at once", func() {
+		config.HTTPPort = config.GRPCPort
+		config.DenyBodyTemplate = "{{"
+		config.Namespace = "missing"
+
+		failed := validate()
+		Expect(failed).To(HaveKey("flags"))
+		Expect(failed).To(HaveKey("authorization"))
+		Expect(failed).To(HaveKeyWithValue("namespace", MatchError(ContainSubstring(`namespace "missing"`))))
+	})
+
+	DescribeTable("should reject invalid flags",
+		func(mutate func(*models.Config), message string) {
+			mutate(config)
+			Expect(validate()).To(HaveKeyWithValue("flags", MatchError(ContainSubstring(message))))
+		},
+		Entry("log level", func(c *models.Config) { c.LogLevel = "verbose" }, "log-level"),
+		Entry("secret selector", func(c *models.Config) { c.SecretLabelSelector = "app in (" }, "secret-selector"),
+		Entry("tracing endpoint without a scheme", func(c *models.Config) { c.TracingEndpoint = "otel-collector:4317" }, "tracing-endpoint"),
+	)
+
+	It("should check the route rules namespace", func() {
+		config.RouteRulesConfigMap = "kgateway-system/public-routes"
+		Expect(validate()).To(HaveKey("route-rules-configmap"))
+	})
+
+	It("should report missing RBAC permissions", func() {
+		fake := newFakeDynamicClient()
+		answerAccessReviews(fake, "watch apikeys")
+		client = fake
+		config.Namespace = ""
+		Expect(validate()).To(HaveKeyWithValue("rbac", MatchError(ContainSubstring("cannot watch"))))
+	})
+
+	It("should fail when the APIKey CRD is not installed", func() {
+		client = newFakeDynamicClient()
+		config.Namespace = ""
+		Expect(validate()).To(HaveKey("apikey-crd"))
+	})
+
+	It("should stop at an unusable kubeconfig", func() {
+		report := validateConfig(context.Background(), config, func(*models.Config) (dynamic.Interface, error) {
+			return nil, errors.New("no route to host")
+		})
+		Expect(report.OK()).To(BeFalse())
+		Expect(report.Checks[len(report.Checks)-1].Name).To(Equal("kubeconfig"))
+	})
+
+	It("should load the keys file in in-memory mode", func() {
+		config.InMemory, config.Namespace = true, ""
+		config.KeysFile = filepath.Join(GinkgoT().TempDir(), "keys.yaml")
+		Expect(os.WriteFile(config.KeysFile, []byte("- email: [not, a, string]\n"), 0o600)).To(Succeed())
+		Expect(validate()).To(HaveKey("keys-file"))
+	})
+
+	It("should print a report", func() {
+		config.DenyBodyFormat = "xml"
+		var out bytes.Buffer
+		validateConfig(context.Background(), config, func(*models.Config) (dynamic.Interface, error) {
+			return client, nil
+		}).Write(&out)
+		Expect(out.String()).To(ContainSubstring("ok    flags\n"))
+		Expect(out.String()).To(ContainSubstring("FAIL  authorization: "))
+		Expect(out.String()).To(HaveSuffix("configuration is invalid: 1 of 6 checks failed\n"))
+	})
+})
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/kube"
+	"github.com/efortin/batsign/internal/models"
+)
+
+// validatorCacheSize bounds the decisions of the external validator cached
+const validatorCacheSize = 4096
+
+// validatorResponseLimit bounds the body read from the external validator
+const validatorResponseLimit = 64 << 10
+
+// validatorRequest is the JSON payload posted to the external validator
+type validatorRequest struct {
+	KeyHash string `json:"keyHash"`
+}
+
+// validatorResponse is the JSON decision of the external validator. The
+// metadata of allowed keys is used like that of an APIKey.
+type validatorResponse struct {
+	Allow          bool     `json:"allow"`
+	Name           string   `json:"name,omitempty"`
+	Email          string   `json:"email,omitempty"`
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+}
+
+// externalValidator validates keys against an external HTTP service, the
+// source of truth of teams not keeping keys in Kubernetes, instead of the
+// key store. Allow and deny decisions are cached by key hash; failures are
+// not.
+type externalValidator struct {
+	url    string
+	client *http.Client
+	ttl    time.Duration
+	// failOpen allows requests when the validator fails instead of denying
+	// them
+	failOpen bool
+
+	mu    sync.Mutex
+	cache map[string]validatorDecision
+	now   func() time.Time
+
+	// cacheHits and failures count the lookups answered from the cache and
+	// the validator calls that failed, and failOpenAllows the requests
+	// allowed because of those failures
+	cacheHits      atomic.Int64
+	failures       atomic.Int64
+	failOpenAllows atomic.Int64
+}
+
+// validatorDecision is a cached decision of the external validator
+type validatorDecision struct {
+	entry   models.APIKeyEntry
+	allow   bool
+	expires time.Time
+}
+
+// newExternalValidator creates a validator posting to url, waiting at most
+// timeout (0 = DefaultExternalValidatorTimeout) and caching decisions for
+// ttl (0 = DefaultExternalValidatorCacheTTL)
+func newExternalValidator(url string, timeout, ttl time.Duration, failOpen bool) *externalValidator {
+	if timeout == 0 {
+		timeout = models.DefaultExternalValidatorTimeout
+	}
+	if ttl == 0 {
+		ttl = models.DefaultExternalValidatorCacheTTL
+	}
+	return &externalValidator{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		ttl:      ttl,
+		failOpen: failOpen,
+		cache:    make(map[string]validatorDecision),
+		now:      time.Now,
+	}
+}
+
+// errValidatorDryRun fails dry runs needing a decision that is not cached
+var errValidatorDryRun = errors.New("external validator not called in a dry run")
+
+// lookupFirstValid returns the entry of the first key among the candidates
+// the validator allows, with its hash. It fails as soon as a call to the
+// validator does. Dry runs only read the cache, failing with
+// errValidatorDryRun on keys it does not hold.
+func (v *externalValidator) lookupFirstValid(ctx context.Context, apiKeys []string, dryRun bool) (models.APIKeyEntry, string, bool, error) {
+	for _, apiKey := range apiKeys {
+		keyHash := apikey.HashAPIKey(apiKey)
+		entry, allow, err := v.lookup(ctx, keyHash, dryRun)
+		if err != nil {
+			return models.APIKeyEntry{}, "", false, err
+		}
+		if allow {
+			return entry, keyHash, true, nil
+		}
+	}
+	return models.APIKeyEntry{}, "", false, nil
+}
+
+// lookup returns the decision for a key hash, from the cache or, unless it
+// is a dry run, the validator
+func (v *externalValidator) lookup(ctx context.Context, keyHash string, dryRun bool) (models.APIKeyEntry, bool, error) {
+	if decision, ok := v.cached(keyHash); ok {
+		if !dryRun {
+			v.cacheHits.Add(1)
+		}
+		return decision.entry, decision.allow, nil
+	}
+	if dryRun {
+		return models.APIKeyEntry{}, false, errValidatorDryRun
+	}
+
+	resp, err := v.post(ctx, keyHash)
+	if err != nil {
+		v.failures.Add(1)
+		return models.APIKeyEntry{}, false, err
+	}
+	decision := validatorDecision{allow: resp.Allow, expires: v.now().Add(v.ttl)}
+	if resp.Allow {
+		decision.entry = models.APIKeyEntry{
+			Name:           resp.Name,
+			Email:          resp.Email,
+			KeyHash:        keyHash,
+			KeyID:          apikey.KeyID(keyHash),
+			Enabled:        true,
+			Source:         models.SourceExternal,
+			AllowedMethods: kube.NormalizeMethods(resp.AllowedMethods),
+		}
+	}
+	v.store(keyHash, decision)
+	return decision.entry, decision.allow, nil
+}
+
+// cached returns the decision cached for a key hash, if not expired
+func (v *externalValidator) cached(keyHash string) (validatorDecision, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	decision, ok := v.cache[keyHash]
+	if !ok {
+		return validatorDecision{}, false
+	}
+	if !v.now().Before(decision.expires) {
+		delete(v.cache, keyHash)
+		return validatorDecision{}, false
+	}
+	return decision, true
+}
+
+// store caches a decision. A full cache first drops expired decisions, then
+// everything if none had expired, so unknown keys sprayed at the server
+// cannot grow it without bound.
+func (v *externalValidator) store(keyHash string, decision validatorDecision) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.cache) >= validatorCacheSize {
+		now := v.now()
+		for cached, d := range v.cache {
+			if !now.Before(d.expires) {
+				delete(v.cache, cached)
+			}
+		}
+		if len(v.cache) >= validatorCacheSize {
+			clear(v.cache)
+		}
+	}
+	v.cache[keyHash] = decision
+}
+
+// post asks the validator for its decision on a key hash
+func (v *externalValidator) post(ctx context.Context, keyHash string) (validatorResponse, error) {
+	body, err := json.Marshal(validatorRequest{KeyHash: keyHash})
+	if err != nil {
+		return validatorResponse{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, bytes.NewReader(body))
+	if err != nil {
+		return validatorResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return validatorResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return validatorResponse{}, fmt.Errorf("validator answered %s", resp.Status)
+	}
+	var decision validatorResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, validatorResponseLimit)).Decode(&decision); err != nil {
+		return validatorResponse{}, fmt.Errorf("invalid validator response: %w", err)
+	}
+	return decision, nil
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+)
+
+var _ = Describe("External validator", func() {
+	const (
+		allowedKey = "sk-allowed-by-validator"
+		// storedKey is only in the local store, which the validator replaces
+		storedKey = "sk-stored-locally"
+	)
+
+	var (
+		validator *httptest.Server
+		// calls counts the requests the validator received
+		calls atomic.Int32
+		// failing makes the validator answer 503
+		failing atomic.Bool
+		// delay is how long the validator waits before answering
+		delay time.Duration
+		authz *AuthorizationServer
+		now   time.Time
+	)
+
+	BeforeEach(func() {
+		calls.Store(0)
+		failing.Store(false)
+		delay = 0
+		validator = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			time.Sleep(delay)
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			defer GinkgoRecover()
+			var req validatorRequest
+			Expect(json.NewDecoder(r.Body).Decode(&req)).To(Succeed())
+			Expect(r.Method).To(Equal(http.MethodPost))
+			if req.KeyHash != apikey.HashAPIKey(allowedKey) {
+				Expect(json.NewEncoder(w).Encode(validatorResponse{Allow: false})).To(Succeed())
+				return
+			}
+			Expect(json.NewEncoder(w).Encode(validatorResponse{Allow: true, Name: "partner", Email: "partner@example.com", AllowedMethods: []string{"get"}})).To(Succeed())
+		}))
+		DeferCleanup(validator.Close)
+	})
+
+	// newAuthz creates an authorization server using the validator with cfg
+	newAuthz := func(cfg models.Config) {
+		cfg.ExternalValidatorURL = validator.URL
+		var err error
+		authz, err = NewAuthorizationServer(NewInMemoryStore(
+			models.APIKeyEntry{Name: "stored", KeyHash: apikey.HashAPIKey(storedKey), Enabled: true},
+		), &cfg)
+		Expect(err).ToNot(HaveOccurred())
+		now = time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+		authz.validator.now = func() time.Time { return now }
+	}
+
+	// check sends a GET request with key
+	check := func(key string) *envoy_service_auth_v3.CheckResponse {
+		resp, err := authz.Check(context.Background(), newSourceCheckRequest(key, "10.0.0.1"))
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	It("should allow the keys the validator allows, with its metadata", func() {
+		newAuthz(models.Config{AllowCacheTTL: 10 * time.Second})
+		resp := check(allowedKey)
+		Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		Expect(resp.GetDynamicMetadata().GetFields()).To(HaveKey(AllowCacheTTLMetadataKey))
+
+		entry, allow, err := authz.validator.lookup(context.Background(), apikey.HashAPIKey(allowedKey), false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(allow).To(BeTrue())
+		Expect(entry).To(And(
+			HaveField("Name", "partner"),
+			HaveField("Source", models.SourceExternal),
+			HaveField("AllowedMethods", []string{"GET"}),
+			HaveField("KeyID", apikey.KeyID(apikey.HashAPIKey(allowedKey))),
+		))
+	})
+
+	It("should deny the keys the validator refuses, ignoring the local store", func() {
+		newAuthz(models.Config{})
+		Expect(check(storedKey).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+		Expect(authz.DenialCounts()).To(HaveKeyWithValue(DenyReasonInvalid, 1))
+	})
+
+	It("should serve repeated keys from the cache until the TTL expires", func() {
+		newAuthz(models.Config{ExternalValidatorCacheTTL: time.Minute})
+		for range 3 {
+			Expect(check(allowedKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+			Expect(check("sk-unknown-to-validator").GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+		}
+		Expect(calls.Load()).To(BeEquivalentTo(2))
+		Expect(authz.validator.cacheHits.Load()).To(BeEquivalentTo(4))
+
+		now = now.Add(time.Minute)
+		check(allowedKey)
+		Expect(calls.Load()).To(BeEquivalentTo(3))
+	})
+
+	It("should deny on validator failures by default, without caching them", func() {
+		newAuthz(models.Config{})
+		failing.Store(true)
+		Expect(check(allowedKey).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+		Expect(authz.DenialCounts()).To(HaveKeyWithValue(DenyReasonValidator, 1))
+
+		failing.Store(false)
+		Expect(check(allowedKey).GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		Expect(calls.Load()).To(BeEquivalentTo(2))
+		Expect(authz.validator.failures.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should deny when the validator times out", func() {
+		newAuthz(models.Config{ExternalValidatorTimeout: 10 * time.Millisecond})
+		delay = 100 * time.Millisecond
+		Expect(check(allowedKey).GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+		Expect(authz.DenialCounts()).To(HaveKeyWithValue(DenyReasonValidator, 1))
+	})
+
+	It("should allow without caching on validator failures when failing open", func() {
+		newAuthz(models.Config{ExternalValidatorFailOpen: true, AllowCacheTTL: 10 * time.Second})
+		failing.Store(true)
+		resp := check("sk-unknown-to-validator")
+		Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.OK)))
+		Expect(resp.GetDynamicMetadata().GetFields()).ToNot(HaveKey(AllowCacheTTLMetadataKey))
+		Expect(authz.validator.failOpenAllows.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should still check the key's metadata", func() {
+		newAuthz(models.Config{})
+		req := newSourceCheckRequest(allowedKey, "10.0.0.1")
+		req.Attributes.Request.Http.Method = "DELETE"
+		resp, err := authz.Check(context.Background(), req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.GetStatus().GetCode()).To(Equal(int32(codes.PermissionDenied)))
+		Expect(authz.DenialCounts()).To(HaveKeyWithValue(DenyReasonMethod, 1))
+	})
+})
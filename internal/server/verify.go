@@ -0,0 +1,83 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/models"
+)
+
+// Bounds of the cache of verified slow hash keys
+const (
+	slowHashCacheTTL  = 5 * time.Minute
+	slowHashCacheSize = 1024
+)
+
+// VerifyKey reports whether the raw key matches the entry's hash, using the
+// algorithm the entry is flagged with. SHA-256 entries are normally found by
+// a map lookup instead; argon2id ones can only be verified this way.
+func VerifyKey(entry models.APIKeyEntry, rawKey string) bool {
+	ok, err := apikey.VerifyKeyHash(rawKey, entry.KeyHashAlgo, entry.KeyHash)
+	return err == nil && ok
+}
+
+// slowHashCache remembers which argon2id hash recently presented keys
+// verified against, by the SHA-256 of the key, so each key is only
+// re-hashed once per TTL. Only successes are cached.
+type slowHashCache struct {
+	mu      sync.Mutex
+	entries map[string]slowHashResult
+	now     func() time.Time
+}
+
+// slowHashResult is a cached verification
+type slowHashResult struct {
+	keyHash string
+	expires time.Time
+}
+
+// newSlowHashCache creates an empty cache
+func newSlowHashCache() *slowHashCache {
+	return &slowHashCache{
+		entries: make(map[string]slowHashResult),
+		now:     time.Now,
+	}
+}
+
+// get returns the stored hash the key with the given SHA-256 verified
+// against, if still cached
+func (c *slowHashCache) get(sha string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, ok := c.entries[sha]
+	if !ok {
+		return "", false
+	}
+	if !c.now().Before(result.expires) {
+		delete(c.entries, sha)
+		return "", false
+	}
+	return result.keyHash, true
+}
+
+// put caches a successful verification. A full cache first drops expired
+// results, then everything if none had expired.
+func (c *slowHashCache) put(sha, keyHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= slowHashCacheSize {
+		for cached, result := range c.entries {
+			if !now.Before(result.expires) {
+				delete(c.entries, cached)
+			}
+		}
+		if len(c.entries) >= slowHashCacheSize {
+			clear(c.entries)
+		}
+	}
+	c.entries[sha] = slowHashResult{keyHash: keyHash, expires: now.Add(slowHashCacheTTL)}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/efortin/batsign/internal/apikey"
+	"github.com/efortin/batsign/internal/apikey/apikeytest"
+	"github.com/efortin/batsign/internal/models"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+)
+
+var _ = Describe("Slow hash keys", func() {
+	const (
+		highValueKey = "sk-high-value-key-1"
+		// sameHintKey shares the hint of highValueKey
+		sameHintKey = "sk-higXXXXXXXXXXXX-1"
+	)
+
+	var (
+		store *InMemoryStore
+		authz *AuthorizationServer
+		entry models.APIKeyEntry
+	)
+
+	BeforeEach(func() {
+		hash, err := apikey.HashAPIKeyArgon2idWithParams(apikeytest.DeterministicReader(1), highValueKey,
+			apikey.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1})
+		Expect(err).ToNot(HaveOccurred())
+		entry = models.APIKeyEntry{
+			Name:        "vault",
+			KeyHash:     hash,
+			KeyHint:     apikey.GenerateHint(highValueKey),
+			KeyHashAlgo: models.HashAlgoArgon2id,
+			Enabled:     true,
+		}
+		Expect(apikey.GenerateHint(sameHintKey)).To(Equal(entry.KeyHint))
+
+		store = NewInMemoryStore(entry)
+		authz, err = NewAuthorizationServer(store, &models.Config{})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// check returns the status code and deny reason for a key
+	check := func(key string) (codes.Code, string) {
+		resp, err := authz.Check(context.Background(), newSourceCheckRequest(key, "10.0.0.1"))
+		Expect(err).ToNot(HaveOccurred())
+		return codes.Code(resp.GetStatus().GetCode()), resp.GetDynamicMetadata().GetFields()[DenyReasonMetadataKey].GetStringValue()
+	}
+
+	It("should verify keys against their argon2id hash", func() {
+		Expect(VerifyKey(entry, highValueKey)).To(BeTrue())
+		Expect(VerifyKey(entry, sameHintKey)).To(BeFalse())
+
+		code, _ := check(highValueKey)
+		Expect(code).To(Equal(codes.OK))
+
+		code, reason := check(sameHintKey)
+		Expect(code).To(Equal(codes.PermissionDenied))
+		Expect(reason).To(Equal(DenyReasonInvalid))
+	})
+
+	It("should never match the SHA-256 of an argon2id key", func() {
+		sha := entry
+		sha.KeyHashAlgo = ""
+		Expect(VerifyKey(sha, highValueKey)).To(BeFalse())
+	})
+
+	It("should cache successful verifications", func() {
+		code, _ := check(highValueKey)
+		Expect(code).To(Equal(codes.OK))
+
+		cached, ok := authz.slowHashes.get(apikey.HashAPIKey(highValueKey))
+		Expect(ok).To(BeTrue())
+		Expect(cached).To(Equal(entry.KeyHash))
+		_, ok = authz.slowHashes.get(apikey.HashAPIKey(sameHintKey))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should honour changes to a cached key", func() {
+		code, _ := check(highValueKey)
+		Expect(code).To(Equal(codes.OK))
+
+		disabled := entry
+		disabled.Enabled = false
+		store.Add(disabled)
+		code, reason := check(highValueKey)
+		Expect(code).To(Equal(codes.PermissionDenied))
+		Expect(reason).To(Equal(DenyReasonDisabled))
+
+		store.Remove(entry.KeyHash)
+		code, reason = check(highValueKey)
+		Expect(code).To(Equal(codes.PermissionDenied))
+		Expect(reason).To(Equal(DenyReasonInvalid))
+	})
+
+	It("should expire cached verifications", func() {
+		now := time.Now()
+		authz.slowHashes.now = func() time.Time { return now }
+		authz.slowHashes.put("sha", entry.KeyHash)
+
+		now = now.Add(slowHashCacheTTL - time.Second)
+		_, ok := authz.slowHashes.get("sha")
+		Expect(ok).To(BeTrue())
+
+		now = now.Add(time.Second)
+		_, ok = authz.slowHashes.get("sha")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should bound the cache", func() {
+		for i := range slowHashCacheSize + 1 {
+			authz.slowHashes.put(apikey.HashAPIKey(string(rune(i))), entry.KeyHash)
+		}
+		Expect(len(authz.slowHashes.entries)).To(BeNumerically("<=", slowHashCacheSize))
+	})
+})